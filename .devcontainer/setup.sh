@@ -12,6 +12,6 @@ cd /workspaces/paste/web && npm install && cd ..
 # Build Go WASM module
 echo "Compiling Go WASM module..."
 cd /workspaces/paste/wasm
-GOOS=js GOARCH=wasm go build -o /workspaces/paste/web/static/encryption.wasm wasm.go
+GOOS=js GOARCH=wasm go build -o /workspaces/paste/web/static/encryption.wasm .
 
 echo "Setup complete."
@@ -0,0 +1,338 @@
+// Package auth validates OpenID Connect tokens for the operator routes. It
+// speaks just enough OIDC for that: discovery, the issuer's JWKS, and
+// signed JWTs with the usual claims. Tokens are checked locally; the
+// issuer is only contacted for its keys.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonasbg/paste/m/v2/utils"
+)
+
+// Role is what a token lets its holder do.
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleViewer reads metrics and lists keys and namespaces.
+	RoleViewer
+	// RoleAdmin also changes them.
+	RoleAdmin
+)
+
+const (
+	// clockSkew is allowed between the issuer's clock and ours.
+	clockSkew = time.Minute
+	// keyRefreshInterval limits how often an unknown key ID triggers a
+	// JWKS fetch, so forged tokens can't make us hammer the issuer.
+	keyRefreshInterval = time.Minute
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Verifier checks tokens from one issuer.
+type Verifier struct {
+	issuer       string
+	audience     string
+	groupsClaim  string
+	adminGroups  []string
+	viewerGroups []string
+	client       *http.Client
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey
+	refreshed time.Time
+}
+
+// FromEnv returns a Verifier for OIDC_ISSUER, or nil if it isn't set.
+// Tokens must be issued for OIDC_AUDIENCE; the groups in OIDC_GROUPS_CLAIM
+// (default "groups") are matched against the comma-separated
+// OIDC_ADMIN_GROUPS and OIDC_VIEWER_GROUPS.
+func FromEnv() (*Verifier, error) {
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+	v := &Verifier{
+		issuer:       issuer,
+		audience:     os.Getenv("OIDC_AUDIENCE"),
+		groupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		adminGroups:  utils.SplitList(os.Getenv("OIDC_ADMIN_GROUPS")),
+		viewerGroups: utils.SplitList(os.Getenv("OIDC_VIEWER_GROUPS")),
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if v.audience == "" {
+		return nil, errors.New("OIDC_AUDIENCE must be set with OIDC_ISSUER")
+	}
+	if len(v.adminGroups) == 0 && len(v.viewerGroups) == 0 {
+		return nil, errors.New("OIDC_ADMIN_GROUPS or OIDC_VIEWER_GROUPS must be set with OIDC_ISSUER")
+	}
+	if v.groupsClaim == "" {
+		v.groupsClaim = "groups"
+	}
+	return v, nil
+}
+
+// Verify checks a raw JWT and returns the role its groups grant.
+func (v *Verifier) Verify(ctx context.Context, raw string) (Role, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return RoleNone, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return RoleNone, err
+	}
+	hash, ok := algorithms[header.Alg]
+	if !ok {
+		return RoleNone, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return RoleNone, ErrInvalidToken
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return RoleNone, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(key, header.Alg, hash, h.Sum(nil), sig) {
+		return RoleNone, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return RoleNone, err
+	}
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return RoleNone, err
+	}
+
+	groups := stringList(claims[v.groupsClaim])
+	switch {
+	case slices.ContainsFunc(groups, func(g string) bool { return slices.Contains(v.adminGroups, g) }):
+		return RoleAdmin, nil
+	case slices.ContainsFunc(groups, func(g string) bool { return slices.Contains(v.viewerGroups, g) }):
+		return RoleViewer, nil
+	}
+	return RoleNone, nil
+}
+
+func (v *Verifier) checkClaims(claims map[string]any, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.issuer {
+		return fmt.Errorf("%w: issuer %q", ErrInvalidToken, iss)
+	}
+	if !slices.Contains(stringList(claims["aud"]), v.audience) {
+		return fmt.Errorf("%w: not issued for %q", ErrInvalidToken, v.audience)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+// key returns the issuer's key kid, fetching the JWKS when it is unknown.
+// The fetch runs without v.mu held, so tokens with known keys aren't held
+// up by it; others with unknown keys meanwhile are refused as throttled.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	if key, ok := v.keys[kid]; ok {
+		v.mu.Unlock()
+		return key, nil
+	}
+	if time.Since(v.refreshed) < keyRefreshInterval {
+		v.mu.Unlock()
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	v.refreshed = time.Now()
+	jwksURL := v.jwksURL
+	v.mu.Unlock()
+
+	keys, jwksURL, err := v.fetchKeys(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.jwksURL, v.keys = jwksURL, keys
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// fetchKeys loads the JWKS from jwksURL, discovering the URL first when
+// it is empty, and returns the keys and the URL.
+func (v *Verifier) fetchKeys(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, string, error) {
+	if jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, "", fmt.Errorf("OIDC discovery: %w", err)
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != v.issuer || discovery.JWKSURI == "" {
+			return nil, "", fmt.Errorf("OIDC discovery: issuer %q doesn't match", discovery.Issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, "", fmt.Errorf("OIDC keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, jwksURL, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// jwk is one key of a JWKS; only RSA and EC signing keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := decodeInt(k.N)
+		e, err2 := decodeInt(k.E)
+		if err := errors.Join(err1, err2); err != nil || !e.IsInt64() {
+			return nil, errors.New("bad RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		x, err1 := decodeInt(k.X)
+		y, err2 := decodeInt(k.Y)
+		if err := errors.Join(err1, err2); err != nil || !ok {
+			return nil, errors.New("bad EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// ecdsaCurves is the one curve each ES algorithm may be used with (RFC
+// 7518, section 3.4).
+var ecdsaCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521(),
+}
+
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, digest, sig []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS carries r and s as fixed-size big-endian halves
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if ecdsaCurves[alg] != key.Curve || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(seg string, out any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("bad integer")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// stringList reads a claim that is a string or a list of strings.
+func stringList(claim any) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []any:
+		list := make([]string, 0, len(c))
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
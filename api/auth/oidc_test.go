@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testAudience = "paste"
+
+// testIssuer is an OIDC issuer serving discovery and a JWKS with one RSA
+// key ("rsa"), one P-256 key ("ec256") and one P-384 key ("ec384").
+type testIssuer struct {
+	*httptest.Server
+	rsaKey     *rsa.PrivateKey
+	ec256Key   *ecdsa.PrivateKey
+	ec384Key   *ecdsa.PrivateKey
+	jwksHits   atomic.Int32
+	extraKeyID string // served in the JWKS as a copy of "rsa" when set
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ec256Key: ec256Key, ec384Key: ec384Key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.jwksHits.Add(1)
+		keys := []map[string]string{
+			rsaJWK("rsa", &rsaKey.PublicKey),
+			ecJWK("ec256", "P-256", &ec256Key.PublicKey),
+			ecJWK("ec384", "P-384", &ec384Key.PublicKey),
+		}
+		if iss.extraKeyID != "" {
+			keys = append(keys, rsaJWK(iss.extraKeyID, &rsaKey.PublicKey))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *testIssuer) verifier() *Verifier {
+	return &Verifier{
+		issuer:       iss.URL,
+		audience:     testAudience,
+		groupsClaim:  "groups",
+		adminGroups:  []string{"paste-admins"},
+		viewerGroups: []string{"paste-viewers"},
+		client:       iss.Client(),
+	}
+}
+
+// claims returns valid claims for iss, with the given groups.
+func (iss *testIssuer) claims(groups ...string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":    iss.URL,
+		"aud":    testAudience,
+		"exp":    now.Add(time.Hour).Unix(),
+		"nbf":    now.Add(-time.Minute).Unix(),
+		"groups": groups,
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid, crv string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": crv, "x": b64(key.X.FillBytes(make([]byte, size))), "y": b64(key.Y.FillBytes(make([]byte, size)))}
+}
+
+// sign makes a JWT with the given header alg and kid, signed by key with
+// hash. The alg is not checked against the key, so tests can lie in it.
+func sign(t *testing.T, key crypto.Signer, alg, kid string, hash crypto.Hash, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func TestVerifyValidTokens(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"RS256", sign(t, iss.rsaKey, "RS256", "rsa", crypto.SHA256, iss.claims("paste-admins"))},
+		{"RS512", sign(t, iss.rsaKey, "RS512", "rsa", crypto.SHA512, iss.claims("paste-admins"))},
+		{"ES256", sign(t, iss.ec256Key, "ES256", "ec256", crypto.SHA256, iss.claims("paste-admins"))},
+		{"ES384", sign(t, iss.ec384Key, "ES384", "ec384", crypto.SHA384, iss.claims("paste-admins"))},
+	} {
+		role, err := v.Verify(context.Background(), tc.token)
+		if err != nil || role != RoleAdmin {
+			t.Errorf("%s: got role %d, err %v; want admin", tc.name, role, err)
+		}
+	}
+	if n := iss.jwksHits.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+}
+
+func TestVerifyRejectsBadSignature(t *testing.T) {
+	iss := newTestIssuer(t)
+	token := sign(t, iss.rsaKey, "RS256", "rsa", crypto.SHA256, iss.claims("paste-admins"))
+
+	// Same signature over different claims
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(iss.claims("paste-admins", "extra"))
+	tampered := parts[0] + "." + b64(forged) + "." + parts[2]
+	if _, err := iss.verifier().Verify(context.Background(), tampered); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("tampered payload: got %v, want ErrInvalidToken", err)
+	}
+
+	// Signed by a key the issuer doesn't have
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign := sign(t, other, "RS256", "rsa", crypto.SHA256, iss.claims("paste-admins"))
+	if _, err := iss.verifier().Verify(context.Background(), foreign); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("foreign key: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyRejectsAlgorithmKeyMismatch(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		// An RSA signature claimed to be for an EC key, and the reverse
+		{"RS256 on EC key", sign(t, iss.rsaKey, "RS256", "ec256", crypto.SHA256, iss.claims("paste-admins"))},
+		{"ES256 on RSA key", sign(t, iss.ec256Key, "ES256", "rsa", crypto.SHA256, iss.claims("paste-admins"))},
+		// A valid P-384 signature over a SHA-256 digest, sent as ES256
+		{"ES256 on P-384 key", sign(t, iss.ec384Key, "ES256", "ec384", crypto.SHA256, iss.claims("paste-admins"))},
+		{"ES384 on P-256 key", sign(t, iss.ec256Key, "ES384", "ec256", crypto.SHA384, iss.claims("paste-admins"))},
+		{"none", sign(t, iss.rsaKey, "none", "rsa", crypto.SHA256, iss.claims("paste-admins"))},
+	} {
+		if _, err := v.Verify(context.Background(), tc.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", tc.name, err)
+		}
+	}
+}
+
+func TestVerifyRejectsWrongIssuerOrAudience(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+
+	wrongIss := iss.claims("paste-admins")
+	wrongIss["iss"] = "https://elsewhere.example"
+	wrongAud := iss.claims("paste-admins")
+	wrongAud["aud"] = []string{"other-app"}
+	for name, claims := range map[string]map[string]any{"issuer": wrongIss, "audience": wrongAud} {
+		token := sign(t, iss.rsaKey, "RS256", "rsa", crypto.SHA256, claims)
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("wrong %s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	// One of several audiences is enough
+	multi := iss.claims("paste-admins")
+	multi["aud"] = []string{"other-app", testAudience}
+	if _, err := v.Verify(context.Background(), sign(t, iss.rsaKey, "RS256", "rsa", crypto.SHA256, multi)); err != nil {
+		t.Errorf("audience in list: %v", err)
+	}
+}
+
+func TestCheckClaimsTimes(t *testing.T) {
+	v := &Verifier{issuer: "https://issuer.example", audience: testAudience}
+	now := time.Unix(1_700_000_000, 0)
+	at := func(offset time.Duration) int64 { return now.Add(offset).Unix() }
+
+	for _, tc := range []struct {
+		name  string
+		exp   any
+		nbf   any
+		valid bool
+	}{
+		{"expired within skew", at(-clockSkew + time.Second), nil, true},
+		{"expired beyond skew", at(-clockSkew - time.Second), nil, false},
+		{"not yet valid within skew", at(time.Hour), at(clockSkew - time.Second), true},
+		{"not yet valid beyond skew", at(time.Hour), at(clockSkew + time.Second), false},
+		{"no exp", nil, nil, false},
+	} {
+		claims := map[string]any{"iss": v.issuer, "aud": testAudience}
+		// Claims arrive as JSON numbers
+		if tc.exp != nil {
+			claims["exp"] = float64(tc.exp.(int64))
+		}
+		if tc.nbf != nil {
+			claims["nbf"] = float64(tc.nbf.(int64))
+		}
+		err := v.checkClaims(claims, now)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", tc.name, err)
+		}
+	}
+}
+
+func TestUnknownKeyRefreshIsThrottled(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	ctx := context.Background()
+
+	if _, err := v.Verify(ctx, sign(t, iss.rsaKey, "RS256", "rsa", crypto.SHA256, iss.claims("paste-admins"))); err != nil {
+		t.Fatal(err)
+	}
+	// A stream of unknown key IDs must not each fetch the JWKS
+	for i := 0; i < 5; i++ {
+		token := sign(t, iss.rsaKey, "RS256", "rotated", crypto.SHA256, iss.claims("paste-admins"))
+		if _, err := v.Verify(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("unknown kid: got %v, want ErrInvalidToken", err)
+		}
+	}
+	if n := iss.jwksHits.Load(); n != 1 {
+		t.Fatalf("JWKS fetched %d times within the refresh interval, want 1", n)
+	}
+
+	// Once the interval has passed, a rotated-in key is picked up
+	iss.extraKeyID = "rotated"
+	v.mu.Lock()
+	v.refreshed = time.Now().Add(-keyRefreshInterval)
+	v.mu.Unlock()
+	token := sign(t, iss.rsaKey, "RS256", "rotated", crypto.SHA256, iss.claims("paste-admins"))
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatalf("rotated key after the interval: %v", err)
+	}
+	if n := iss.jwksHits.Load(); n != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", n)
+	}
+	// Known keys keep working without another fetch
+	if _, err := v.Verify(ctx, sign(t, iss.ec256Key, "ES256", "ec256", crypto.SHA256, iss.claims("paste-viewers"))); err != nil {
+		t.Fatal(err)
+	}
+	if n := iss.jwksHits.Load(); n != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", n)
+	}
+}
+
+func TestGroupRoles(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := iss.verifier()
+	for _, tc := range []struct {
+		name   string
+		groups any
+		want   Role
+	}{
+		{"admin", []string{"paste-admins"}, RoleAdmin},
+		{"viewer", []string{"staff", "paste-viewers"}, RoleViewer},
+		{"admin wins over viewer", []string{"paste-viewers", "paste-admins"}, RoleAdmin},
+		{"single string claim", "paste-viewers", RoleViewer},
+		{"no matching group", []string{"staff"}, RoleNone},
+		{"no groups", nil, RoleNone},
+	} {
+		claims := iss.claims()
+		claims["groups"] = tc.groups
+		role, err := v.Verify(context.Background(), sign(t, iss.rsaKey, "RS256", "rsa", crypto.SHA256, claims))
+		if err != nil || role != tc.want {
+			t.Errorf("%s: got role %d, err %v; want %d", tc.name, role, err, tc.want)
+		}
+	}
+
+	// A custom groups claim
+	v.groupsClaim = "roles"
+	claims := iss.claims()
+	claims["roles"] = []string{"paste-admins"}
+	if role, err := v.Verify(context.Background(), sign(t, iss.rsaKey, "RS256", "rsa", crypto.SHA256, claims)); err != nil || role != RoleAdmin {
+		t.Errorf("custom claim: got role %d, err %v; want admin", role, err)
+	}
+}
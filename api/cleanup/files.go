@@ -1,11 +1,18 @@
 package cleanup
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jonasbg/paste/m/v2/reporting"
+	"github.com/jonasbg/paste/m/v2/storage"
+	"github.com/jonasbg/paste/m/v2/telemetry"
 )
 
 func GetCleanupDays() int {
@@ -18,24 +25,167 @@ func GetCleanupDays() int {
 	return 7
 }
 
-func StartFileCleanup(uploadDir string) {
+// GetTrashRetention reads TRASH_RETENTION, how long files deleted by their
+// last download stay restorable. It is off by default.
+func GetTrashRetention() time.Duration {
+	value := os.Getenv("TRASH_RETENTION")
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("Invalid TRASH_RETENTION value, deleting files at once")
+		return 0
+	}
+	return d
+}
+
+// CheckEnv reports an invalid FILES_RETENTION_DAYS, SWEEP_INTERVAL or
+// TRASH_RETENTION, which the getters would otherwise replace with their
+// defaults, so a typo fails at startup instead of changing how long files
+// are kept.
+func CheckEnv() error {
+	if days := os.Getenv("FILES_RETENTION_DAYS"); days != "" {
+		if val, err := strconv.Atoi(days); err != nil || val <= 0 {
+			return fmt.Errorf("invalid FILES_RETENTION_DAYS. Must be a positive integer")
+		}
+	}
+	if value := os.Getenv("SWEEP_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err != nil || d < minSweepInterval {
+			return fmt.Errorf("invalid SWEEP_INTERVAL. Must be a duration of at least %s", minSweepInterval)
+		}
+	}
+	if value := os.Getenv("TRASH_RETENTION"); value != "" {
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid TRASH_RETENTION. Must be a duration such as 24h")
+		}
+	}
+	return nil
+}
+
+// defaultSweepInterval is how often the upload directory is scanned
+// unless SWEEP_INTERVAL says otherwise. Per-file expiry is also enforced
+// on access, so this only bounds disk reclamation.
+const defaultSweepInterval = time.Hour
+
+// minSweepInterval keeps a misconfigured interval from walking the upload
+// directory back to back.
+const minSweepInterval = time.Minute
+
+// GetSweepInterval reads SWEEP_INTERVAL, a duration such as 15m.
+func GetSweepInterval() time.Duration {
+	value := os.Getenv("SWEEP_INTERVAL")
+	if value == "" {
+		return defaultSweepInterval
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < minSweepInterval {
+		log.Printf("Invalid SWEEP_INTERVAL value, using default of %s", defaultSweepInterval)
+		return defaultSweepInterval
+	}
+	return d
+}
+
+// staleReservation is how long an upload's ID reservation marker may live
+// before it is assumed to belong to a crashed server process.
+const staleReservation = 24 * time.Hour
+
+// stalePartial is how long an interrupted resumable upload may wait for
+// its client to come back.
+const stalePartial = 24 * time.Hour
+
+// orphanGrace keeps a policy sidecar whose blob is missing for a while,
+// since uploads write the sidecar just before renaming the blob into place.
+const orphanGrace = time.Hour
+
+// Removal reasons and stray kinds reported for each sweep.
+const (
+	reasonRetention   = "retention"
+	reasonExpired     = "expired"
+	reasonOrphaned    = "orphaned_policy"
+	reasonPartial     = "stale_partial"
+	reasonReservation = "stale_reservation"
+	reasonReceipt     = "receipt"
+	reasonTrash       = "trash"
+
+	strayUnrecognized = "unrecognized"
+	strayDangling     = "dangling_policy"
+)
+
+// sweep tallies one pass over the upload directory.
+type sweep struct {
+	removed map[string]int64
+	stray   map[string]int64
+}
+
+func newSweep() *sweep {
+	return &sweep{
+		removed: map[string]int64{},
+		stray:   map[string]int64{strayUnrecognized: 0, strayDangling: 0},
+	}
+}
+
+func (s *sweep) String() string {
+	return fmt.Sprintf("removed %d retained, %d expired, %d orphaned policies, %d receipts, %d from the trash, %d stale partials, %d stale reservations; %d unrecognized files, %d policies awaiting their blob",
+		s.removed[reasonRetention], s.removed[reasonExpired], s.removed[reasonOrphaned], s.removed[reasonReceipt], s.removed[reasonTrash], s.removed[reasonPartial], s.removed[reasonReservation],
+		s.stray[strayUnrecognized], s.stray[strayDangling])
+}
+
+// StartFileCleanup sweeps every upload volume once at startup and then every
+// SWEEP_INTERVAL, reconciling it with the policy sidecars: blobs past their retention or expiry go along
+// with their sidecar, sidecars whose blob is gone are dropped after a
+// grace period, and files the server didn't write are reported but left.
+// Files in the trash go once their TRASH_RETENTION has passed.
+func StartFileCleanup(volumes storage.Volumes, metrics *telemetry.Provider) {
 	cleanupDays := GetCleanupDays()
 	log.Printf("File cleanup configured for %d days", cleanupDays)
+	storage.TrashRetention = GetTrashRetention()
+	if storage.TrashRetention > 0 {
+		log.Printf("Deleted files kept in the trash for %s", storage.TrashRetention)
+	}
 
-	ticker := time.NewTicker(24 * time.Hour)
+	interval := GetSweepInterval()
+	log.Printf("Storage sweep every %s", interval)
+
+	sweepOnce := func() {
+		s := newSweep()
+		for _, dir := range volumes {
+			if err := cleanOldFiles(s, dir, cleanupDays); err != nil {
+				log.Printf("Failed to clean old files in %s: %v", dir, err)
+				reporting.Errorf("cleanup", "Storage sweep failed: %v", err)
+			}
+		}
+		log.Printf("Storage sweep: %s", s)
+		metrics.RecordSweep(context.Background(), s.removed, s.stray)
+	}
 	go func() {
+		// A restarted server shouldn't keep expired files for another
+		// interval, so the first sweep runs right away.
+		sweepOnce()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 		for range ticker.C {
-			if err := cleanOldFiles(uploadDir, cleanupDays); err != nil {
-				log.Printf("Failed to clean old files: %v", err)
-			}
+			sweepOnce()
 		}
 	}()
 }
 
-func cleanOldFiles(uploadDir string, days int) error {
-	cutoff := time.Now().AddDate(0, 0, -days)
+// cleanOldFiles sweeps one upload volume, adding what it finds to s.
+func cleanOldFiles(s *sweep, uploadDir string, days int) error {
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -days)
+
+	trashed, err := storage.EmptyTrash(uploadDir, now)
+	s.removed[reasonTrash] += int64(trashed)
+	if err != nil {
+		return err
+	}
 
 	return filepath.Walk(uploadDir, func(path string, info os.FileInfo, err error) error {
+		// A sidecar removed along with its blob is still in the listing
+		if os.IsNotExist(err) {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -44,14 +194,95 @@ func cleanOldFiles(uploadDir string, days int) error {
 		if path == uploadDir {
 			return nil
 		}
+		// The trash is emptied on its own schedule
+		if info.IsDir() && path == storage.TrashDir(uploadDir) {
+			return filepath.SkipDir
+		}
+
+		if storage.IsReservationPath(path) {
+			if now.Sub(info.ModTime()) > staleReservation {
+				if err := os.Remove(path); err != nil {
+					log.Printf("Failed to remove stale reservation %s: %v", path, err)
+				} else {
+					s.removed[reasonReservation]++
+				}
+			}
+			return nil
+		}
+
+		if storage.IsPartialPath(path) {
+			if now.Sub(info.ModTime()) > stalePartial {
+				if err := storage.Remove(path); err != nil {
+					log.Printf("Failed to remove stale partial upload %s: %v", path, err)
+				} else {
+					s.removed[reasonPartial]++
+				}
+			}
+			return nil
+		}
+
+		// Sidecars are removed together with their blob; only orphans
+		// and receipts are handled here. Held files keep both.
+		if storage.IsPolicyPath(path) {
+			blob := strings.TrimSuffix(path, ".policy")
+			if _, err := os.Stat(blob); os.IsNotExist(err) {
+				if policy, err := storage.LoadPolicy(blob); err == nil && policy.Owner != "" {
+					// A receipt of a downloaded file, kept for its owner
+					// as long as the file itself could have been.
+					if policy.Expired(now) || policy.ExpiresAt.IsZero() && info.ModTime().Before(cutoff) {
+						if err := os.Remove(path); err != nil {
+							log.Printf("Failed to remove download receipt %s: %v", path, err)
+						} else {
+							s.removed[reasonReceipt]++
+						}
+					}
+				} else if now.Sub(info.ModTime()) <= orphanGrace {
+					s.stray[strayDangling]++
+				} else if err := os.Remove(path); err != nil {
+					log.Printf("Failed to remove orphaned policy %s: %v", path, err)
+				} else {
+					s.removed[reasonOrphaned]++
+				}
+			}
+			return nil
+		}
 
-		// Check if file is older than cutoff
-		if info.ModTime().Before(cutoff) {
-			if err := os.Remove(path); err != nil {
+		// Check if file is older than cutoff, unless its namespace's
+		// retention, recorded as its expiry, stands in for the server's
+		policy, policyErr := storage.LoadPolicy(path)
+		if policyErr == nil && policy.Held() {
+			return nil
+		}
+		ownRetention := policyErr == nil && policy.Namespace != "" && !policy.ExpiresAt.IsZero()
+		if info.ModTime().Before(cutoff) && !ownRetention {
+			if err := storage.Remove(path); err != nil {
 				log.Printf("Failed to remove old file %s: %v", path, err)
 				return err
 			}
+			s.removed[reasonRetention]++
 			log.Printf("Removed old file: %s (age: %v days)", path, time.Since(info.ModTime()).Hours()/24)
+			return nil
+		}
+
+		// Left for an operator to look at until retention takes it
+		if !info.Mode().IsRegular() || !storage.IsBlobPath(path) {
+			log.Printf("Unrecognized file in upload directory: %s", path)
+			s.stray[strayUnrecognized]++
+			return nil
+		}
+
+		// Honour a shorter lifetime requested at upload time
+		if policyErr != nil {
+			log.Printf("Failed to read policy for %s: %v", path, policyErr)
+			return nil
+		}
+		if policy.Expired(now) {
+			if err := storage.Remove(path); err != nil {
+				log.Printf("Failed to remove expired file %s: %v", path, err)
+				return err
+			}
+			s.removed[reasonExpired]++
+			log.Printf("Removed expired file: %s", path)
 		}
 
 		return nil
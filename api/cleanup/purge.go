@@ -0,0 +1,123 @@
+package cleanup
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jonasbg/paste/m/v2/reporting"
+	"github.com/jonasbg/paste/m/v2/storage"
+)
+
+// PurgeFilter selects the stored files a purge removes. Set filters must
+// all match; All must be set to purge without any, so an empty request
+// never empties the server by accident.
+type PurgeFilter struct {
+	All        bool
+	OlderThan  time.Duration
+	LargerThan int64
+	Namespace  string
+	// DryRun counts what would be removed without removing it.
+	DryRun bool
+}
+
+// PurgeResult reports one purge.
+type PurgeResult struct {
+	ID     string `json:"id"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+	Failed int    `json:"failed,omitempty"`
+	// Held counts matching files left alone for their legal hold.
+	Held   int  `json:"held,omitempty"`
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+var ErrNoFilter = errors.New("a purge needs at least one filter, or all")
+
+func (f PurgeFilter) empty() bool {
+	return f.OlderThan <= 0 && f.LargerThan <= 0 && f.Namespace == ""
+}
+
+func (f PurgeFilter) matches(info os.FileInfo, policy storage.Policy, now time.Time) bool {
+	if f.OlderThan > 0 && now.Sub(info.ModTime()) < f.OlderThan {
+		return false
+	}
+	if f.LargerThan > 0 && info.Size() <= f.LargerThan {
+		return false
+	}
+	return f.Namespace == "" || policy.Namespace == f.Namespace
+}
+
+// Purge removes every stored file matching f, with its sidecar. Uploads
+// still in progress, download receipts and held files are left alone;
+// files in the trash are purged like the rest. Each purge is logged under
+// an ID, once when it starts and once with its outcome.
+func Purge(volumes storage.Volumes, f PurgeFilter) (PurgeResult, error) {
+	if f.empty() && !f.All {
+		return PurgeResult{}, ErrNoFilter
+	}
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	r := PurgeResult{ID: hex.EncodeToString(idBytes), DryRun: f.DryRun}
+	log.Printf("Purge %s started: all=%t older_than=%s larger_than=%d namespace=%q dry_run=%t",
+		r.ID, f.All, f.OlderThan, f.LargerThan, f.Namespace, f.DryRun)
+
+	now := time.Now()
+	var err error
+	for _, uploadDir := range volumes {
+		if err = purgeVolume(uploadDir, f, &r, now); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		reporting.Errorf("cleanup", "Purge %s stopped early: %v", r.ID, err)
+	} else if r.Failed > 0 {
+		reporting.Errorf("cleanup", "Purge %s failed to remove %d files", r.ID, r.Failed)
+	}
+	log.Printf("Purge %s finished: %d files, %d bytes, %d failed, %d held, dry_run=%t", r.ID, r.Files, r.Bytes, r.Failed, r.Held, f.DryRun)
+	return r, err
+}
+
+// purgeVolume purges the files in one upload volume, counting them in r.
+func purgeVolume(uploadDir string, f PurgeFilter, r *PurgeResult, now time.Time) error {
+	return filepath.Walk(uploadDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Sidecars, partial uploads and reservations aren't named like blobs
+		if path == uploadDir || !info.Mode().IsRegular() || !storage.IsBlobPath(path) {
+			return nil
+		}
+		policy, err := storage.LoadPolicy(path)
+		// Without the sidecar there's no telling whether the file is held
+		if err != nil {
+			log.Printf("Purge %s: failed to read policy for %s: %v", r.ID, path, err)
+			r.Failed++
+			return nil
+		}
+		if !f.matches(info, policy, now) {
+			return nil
+		}
+		if policy.Held() {
+			r.Held++
+			return nil
+		}
+		if !f.DryRun {
+			if err := storage.Remove(path); err != nil {
+				log.Printf("Purge %s: failed to remove %s: %v", r.ID, path, err)
+				r.Failed++
+				return nil
+			}
+		}
+		r.Files++
+		r.Bytes += info.Size()
+		return nil
+	})
+}
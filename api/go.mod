@@ -3,7 +3,7 @@ module github.com/jonasbg/paste/m/v2
 go 1.26
 
 require (
-	github.com/gin-contrib/gzip v1.2.6
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/gin-gonic/gin v1.12.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/prometheus/client_golang v1.23.2
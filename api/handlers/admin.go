@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/cleanup"
+	"github.com/jonasbg/paste/m/v2/namespaces"
+	"github.com/jonasbg/paste/m/v2/storage"
+	"github.com/jonasbg/paste/m/v2/telemetry"
+)
+
+// HandleListKeys lists the API keys, without the keys themselves.
+func HandleListKeys(keys *namespaces.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list := []gin.H{}
+		for _, k := range keys.Keys() {
+			list = append(list, gin.H{"id": k.ID, "namespace": k.Namespace, "created_at": k.CreatedAt})
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// HandleCreateKey issues an API key for a namespace. The key is only ever
+// returned here.
+func HandleCreateKey(keys *namespaces.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Namespace string `json:"namespace"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		key, k, err := keys.CreateKey(req.Namespace)
+		if errors.Is(err, namespaces.ErrInvalidNamespace) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error: Failed to create API key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"id": k.ID, "namespace": k.Namespace, "key": key})
+	}
+}
+
+// HandleRevokeKey deletes an API key. Files uploaded with it stay.
+func HandleRevokeKey(keys *namespaces.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := keys.RevokeKey(c.Param("id"))
+		if errors.Is(err, namespaces.ErrUnknownKey) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown key"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error: Failed to revoke API key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// HandleListNamespaces lists the namespaces that have their own limits.
+func HandleListNamespaces(keys *namespaces.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, keys.Namespaces())
+	}
+}
+
+// HandleSetNamespace sets a namespace's limits. Both are optional and use
+// the same formats as MAX_FILE_SIZE and MIN_EXPIRY; unset ones follow the
+// server's.
+func HandleSetNamespace(keys *namespaces.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MaxFileSize string `json:"max_file_size"`
+			Retention   string `json:"retention"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		ns := namespaces.Namespace{Name: c.Param("name")}
+		if req.MaxFileSize != "" {
+			size, err := parseFileSize(req.MaxFileSize)
+			if err != nil || size <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max_file_size"})
+				return
+			}
+			ns.MaxFileSizeBytes = size
+		}
+		if req.Retention != "" {
+			retention, err := time.ParseDuration(req.Retention)
+			if err != nil || retention < time.Duration(GlobalConfig.MinExpirySeconds)*time.Second {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid retention"})
+				return
+			}
+			ns.RetentionSeconds = int64(retention / time.Second)
+		}
+
+		err := keys.SetNamespace(ns)
+		if errors.Is(err, namespaces.ErrInvalidNamespace) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error: Failed to save namespace: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		c.JSON(http.StatusOK, ns)
+	}
+}
+
+// HandlePurge deletes stored files in bulk, for incidents and full disks.
+// Filters use the same formats as MAX_FILE_SIZE and MIN_EXPIRY.
+func HandlePurge(volumes storage.Volumes, metrics *telemetry.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			All        bool   `json:"all"`
+			OlderThan  string `json:"older_than"`
+			LargerThan string `json:"larger_than"`
+			Namespace  string `json:"namespace"`
+			DryRun     bool   `json:"dry_run"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		filter := cleanup.PurgeFilter{All: req.All, Namespace: req.Namespace, DryRun: req.DryRun}
+		if req.OlderThan != "" {
+			d, err := time.ParseDuration(req.OlderThan)
+			if err != nil || d <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid older_than"})
+				return
+			}
+			filter.OlderThan = d
+		}
+		if req.LargerThan != "" {
+			size, err := parseFileSize(req.LargerThan)
+			if err != nil || size <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid larger_than"})
+				return
+			}
+			filter.LargerThan = size
+		}
+
+		result, err := cleanup.Purge(volumes, filter)
+		if errors.Is(err, cleanup.ErrNoFilter) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !filter.DryRun {
+			metrics.RecordSweep(c.Request.Context(), map[string]int64{"purge": int64(result.Files)}, nil)
+		}
+		if err != nil {
+			log.Printf("Error: Purge %s stopped early: %v", result.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Purge stopped early", "purge": result})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// HandleListHolds lists the files on legal hold.
+func HandleListHolds(volumes storage.Volumes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		held, err := storage.Holds(volumes)
+		if err != nil {
+			log.Printf("Error: Failed to list held files: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		c.JSON(http.StatusOK, held)
+	}
+}
+
+// HandleHold puts a file on legal hold, so neither the sweep nor its last
+// download deletes it, for example while it is evidence in an abuse case.
+// Only the ID is needed; operators don't have the download token.
+func HandleHold(volumes storage.Volumes) gin.HandlerFunc {
+	return holdHandler(volumes, storage.Hold, "placed on")
+}
+
+// HandleRelease lifts a legal hold. A file that is past its download
+// allowance is deleted then.
+func HandleRelease(volumes storage.Volumes) gin.HandlerFunc {
+	return holdHandler(volumes, storage.Release, "released from")
+}
+
+func holdHandler(volumes storage.Volumes, update func(string) (storage.Policy, error), verb string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if len(id) != 16 && len(id) != 24 && len(id) != 32 || !validateID(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		blob, err := storage.FindBlob(volumes, id)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		if err == nil {
+			var p storage.Policy
+			if p, err = update(blob); err == nil {
+				log.Printf("File %s %s legal hold", id, verb)
+				resp := gin.H{"id": id, "held": p.Held(), "downloads": p.Downloads}
+				if p.Held() {
+					resp["held_at"] = p.HeldAt
+				}
+				c.JSON(http.StatusOK, resp)
+				return
+			}
+		}
+		log.Printf("Error: Failed to update hold on %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+	}
+}
+
+// HandleListTrash lists the files deleted within TRASH_RETENTION, which
+// can still be restored.
+func HandleListTrash(volumes storage.Volumes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trashed, err := storage.Trash(volumes)
+		if err != nil {
+			log.Printf("Error: Failed to list the trash: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+			return
+		}
+		c.JSON(http.StatusOK, trashed)
+	}
+}
+
+// HandleRestore brings a file back from the trash with one download left,
+// undoing an accidental one-time download or delete. The recipient needs
+// the original link again.
+func HandleRestore(volumes storage.Volumes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if len(id) != 16 && len(id) != 24 && len(id) != 32 || !validateID(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		p, err := storage.Restore(volumes, id)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		case errors.Is(err, storage.ErrIDInUse):
+			c.JSON(http.StatusConflict, gin.H{"error": "ID taken by another file"})
+		case err != nil:
+			log.Printf("Error: Failed to restore %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
+		default:
+			log.Printf("File %s restored from the trash", id)
+			c.JSON(http.StatusOK, gin.H{"id": id, "remaining": p.Remaining()})
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"bufio"
+	"time"
+
+	"github.com/jonasbg/paste/m/v2/telemetry"
+)
+
+// An upload is saturating the disk when writing its chunks takes up more
+// than diskBusyHigh of the time between acks: the network delivers faster
+// than the disk takes it. It counts as recovered below diskBusyLow, so the
+// window doesn't flap around one threshold.
+const (
+	diskBusyHigh = 0.8
+	diskBusyLow  = 0.5
+)
+
+// diskPacer applies backpressure to an upload the disk can't keep up with.
+// While saturated, each ack waits until the buffered chunks are written
+// out and the window offered to the client is halved, down to one chunk;
+// once the disk catches up it grows back by a chunk per ack to the window
+// granted at init. Saturated uploads are counted in telemetry.Activity.
+type diskPacer struct {
+	granted int
+	window  int
+	busy    time.Duration // writing since the last ack
+	since   time.Time
+	release func() // uncounts a saturated upload; nil while not saturated
+}
+
+func newDiskPacer(window int) *diskPacer {
+	window = max(1, window)
+	return &diskPacer{granted: window, window: window, since: time.Now()}
+}
+
+// write writes chunk through w, timing how long the disk holds it up.
+func (p *diskPacer) write(w *bufio.Writer, chunk []byte) error {
+	started := time.Now()
+	_, err := w.Write(chunk)
+	p.busy += time.Since(started)
+	return err
+}
+
+// settle runs before each ack. It adjusts the window to how busy the disk
+// was since the last ack and, while saturated, flushes w so the ack is
+// only sent once the data has reached the file.
+func (p *diskPacer) settle(w *bufio.Writer) error {
+	elapsed := time.Since(p.since)
+	busy := 0.0
+	if elapsed > 0 {
+		busy = p.busy.Seconds() / elapsed.Seconds()
+	}
+	switch {
+	case busy > diskBusyHigh:
+		p.window = max(1, p.window/2)
+		if p.release == nil {
+			p.release = telemetry.Activity.StartSaturated("upload")
+		}
+	case busy < diskBusyLow && p.window < p.granted:
+		p.window++
+	}
+	if p.window == p.granted && busy < diskBusyLow {
+		p.close()
+	}
+
+	// The flush counts towards the next interval
+	p.since, p.busy = time.Now(), 0
+	if p.release == nil {
+		return nil
+	}
+	err := w.Flush()
+	p.busy = time.Since(p.since)
+	return err
+}
+
+// close stops counting the upload as saturated.
+func (p *diskPacer) close() {
+	if p.release != nil {
+		p.release()
+		p.release = nil
+	}
+}
@@ -8,8 +8,11 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/cleanup"
+	"github.com/jonasbg/paste/m/v2/namespaces"
 )
 
 // Passphrase-mode entropy floor. Passphrase-derived shares turn the passphrase
@@ -23,6 +26,18 @@ const (
 	maxPassphraseWords     = 8
 )
 
+// minMetadataSize is the smallest MAX_METADATA_SIZE, which still fits the
+// metadata of a file with a long name.
+const minMetadataSize = 1024
+
+// expiryPresets are the lifetimes offered to pickers, in seconds, where
+// they fall within the server's expiry range.
+var expiryPresets = []int64{10 * 60, 60 * 60, 24 * 60 * 60, 3 * 24 * 60 * 60, 7 * 24 * 60 * 60, 30 * 24 * 60 * 60}
+
+// chunkSizeLimit caps CHUNK_SIZE and MAX_CHUNK_SIZE (in MB). Clients that
+// decrypt a saved blob without its metadata try chunk sizes up to this.
+const chunkSizeLimit = 64
+
 var GlobalConfig Config
 
 // Config represents the application configuration.
@@ -34,6 +49,52 @@ type Config struct {
 	ChunkSize        int    `json:"chunk_size"`
 	TokenMinLength   int    `json:"token_min_length"`
 	PassphraseWords  int    `json:"passphrase_words"`
+	MinExpirySeconds int64  `json:"min_expiry_seconds"`
+	MaxExpirySeconds int64  `json:"max_expiry_seconds"`
+	MaxDownloads     int    `json:"max_downloads"`
+	// FilesRetentionDays is how long a file lives without a requested
+	// lifetime, rounded up to whole days.
+	FilesRetentionDays int `json:"files_retention_days"`
+	// ExpiryOptions suggests lifetimes in seconds for an expiry picker,
+	// ending with the longest allowed. Uploads may ask for any lifetime
+	// between MinExpirySeconds and MaxExpirySeconds.
+	ExpiryOptions []int64 `json:"expiry_options"`
+	// BurnAfterDownload advertises that a file is deleted once its
+	// downloads are used up, which is after one unless the upload asks
+	// for up to MaxDownloads.
+	BurnAfterDownload bool `json:"burn_after_download"`
+	// Cipher names the authenticated encryption clients seal chunks with,
+	// such as AES-256-GCM for the configured KeySize.
+	Cipher string `json:"cipher"`
+	// StreamingUploads advertises that init may set "streaming" and omit
+	// the size; the byte limit is then enforced on the running total.
+	StreamingUploads bool `json:"streaming_uploads"`
+	// MaxUploadWindow is how many chunks a client may send ahead of the
+	// acks. Frames are still handled strictly in order; TCP backpressure
+	// bounds what the server buffers.
+	MaxUploadWindow int `json:"max_upload_window"`
+	// ResumableUploads advertises the init "resumable" flag and the
+	// "resume" handshake for continuing interrupted uploads.
+	ResumableUploads bool `json:"resumable_uploads"`
+	// MinChunkSize and MaxChunkSize (in MB) bound the "chunkSize" an
+	// upload may ask for in init instead of ChunkSize.
+	MinChunkSize int `json:"min_chunk_size"`
+	MaxChunkSize int `json:"max_chunk_size"`
+	// MaxMetadataSize is the most bytes of sealed metadata an upload's
+	// header may carry, after the 16-byte IV and length prefix.
+	MaxMetadataSize int `json:"max_metadata_size"`
+	// MinProtocolVersion and ProtocolVersion bound the WebSocket protocol
+	// versions the upload and download handshakes negotiate.
+	MinProtocolVersion int `json:"min_protocol_version"`
+	ProtocolVersion    int `json:"protocol_version"`
+	// WSCompression enables permessage-deflate for the WebSocket JSON
+	// messages; encrypted frames are always sent as they are.
+	WSCompression bool `json:"ws_compression"`
+	// RequireAPIKey turns away uploads without an X-API-Key.
+	RequireAPIKey bool `json:"require_api_key"`
+	// Namespace is set in the config returned for an API key, whose
+	// namespace's limits replace the size and expiry limits above.
+	Namespace string `json:"namespace,omitempty"`
 }
 
 func InitConfig() error {
@@ -66,12 +127,68 @@ func InitConfig() error {
 
 	// Convert Chunk Size
 	chunkSize, err := strconv.Atoi(chunkSizeStr)
-	if err != nil {
-		return fmt.Errorf("invalid CHUNK_SIZE. Must be an integer")
+	if err != nil || chunkSize < 1 || chunkSize > chunkSizeLimit {
+		return fmt.Errorf("invalid CHUNK_SIZE. Must be an integer between 1 and %d", chunkSizeLimit)
+	}
+
+	// Uploads may pick their own chunk size within these bounds, which
+	// always include the default.
+	minChunkSize, err := strconv.Atoi(getEnv("MIN_CHUNK_SIZE", "1"))
+	if err != nil || minChunkSize < 1 {
+		return fmt.Errorf("invalid MIN_CHUNK_SIZE. Must be a positive integer")
+	}
+	maxChunkSize, err := strconv.Atoi(getEnv("MAX_CHUNK_SIZE", "16"))
+	if err != nil || maxChunkSize < 1 || maxChunkSize > chunkSizeLimit {
+		return fmt.Errorf("invalid MAX_CHUNK_SIZE. Must be an integer between 1 and %d", chunkSizeLimit)
+	}
+	if minChunkSize > maxChunkSize {
+		return fmt.Errorf("invalid MIN_CHUNK_SIZE. Must not be above MAX_CHUNK_SIZE (%d)", maxChunkSize)
+	}
+	minChunkSize = min(minChunkSize, chunkSize)
+	maxChunkSize = max(maxChunkSize, chunkSize)
+
+	// Bundles carry a manifest in their metadata, which can outgrow the
+	// default; readers of stored files accept up to maxMetadataSize.
+	maxMetadata, err := parseFileSize(getEnv("MAX_METADATA_SIZE", "64KB"))
+	if err != nil || maxMetadata < minMetadataSize || maxMetadata > maxMetadataSize {
+		return fmt.Errorf("invalid MAX_METADATA_SIZE. Must be a size between %dB and %dMB", minMetadataSize, maxMetadataSize>>20)
 	}
 
 	passphraseWords := parsePassphraseWords(getEnv("PASSPHRASE_WORDS", strconv.Itoa(defaultPassphraseWords)))
 
+	// Uploads may ask for a shorter lifetime than the retention sweep, never
+	// a longer one.
+	minExpiry, err := time.ParseDuration(getEnv("MIN_EXPIRY", "5m"))
+	if err != nil || minExpiry <= 0 {
+		return fmt.Errorf("invalid MIN_EXPIRY. Must be a positive duration such as 5m or 1h")
+	}
+	maxExpiry := time.Duration(cleanup.GetCleanupDays()) * 24 * time.Hour
+	if minExpiry > maxExpiry {
+		minExpiry = maxExpiry
+	}
+
+	// Upper bound for the per-upload download allowance; 1 keeps every
+	// file strictly one-time.
+	maxDownloads, err := strconv.Atoi(getEnv("MAX_DOWNLOADS", "10"))
+	if err != nil || maxDownloads < 1 {
+		return fmt.Errorf("invalid MAX_DOWNLOADS. Must be a positive integer")
+	}
+
+	maxUploadWindow, err := strconv.Atoi(getEnv("MAX_UPLOAD_WINDOW", "8"))
+	if err != nil || maxUploadWindow < 1 {
+		return fmt.Errorf("invalid MAX_UPLOAD_WINDOW. Must be a positive integer")
+	}
+
+	wsCompression, err := strconv.ParseBool(getEnv("WS_COMPRESSION", "false"))
+	if err != nil {
+		return fmt.Errorf("invalid WS_COMPRESSION. Must be true or false")
+	}
+
+	requireAPIKey, err := strconv.ParseBool(getEnv("REQUIRE_API_KEY", "false"))
+	if err != nil {
+		return fmt.Errorf("invalid REQUIRE_API_KEY. Must be true or false")
+	}
+
 	GlobalConfig = Config{
 		MaxFileSize:      maxFileSize,
 		MaxFileSizeBytes: int(maxFileSizeBytes),
@@ -80,7 +197,28 @@ func InitConfig() error {
 		ChunkSize:        chunkSize,
 		TokenMinLength:   calculateTokenMinLength(keySize),
 		PassphraseWords:  passphraseWords,
+		MinExpirySeconds: int64(minExpiry / time.Second),
+		MaxExpirySeconds: int64(maxExpiry / time.Second),
+		MaxDownloads:     maxDownloads,
+		StreamingUploads: true,
+
+		FilesRetentionDays: cleanup.GetCleanupDays(),
+		ExpiryOptions:      expiryOptions(int64(minExpiry/time.Second), int64(maxExpiry/time.Second)),
+		BurnAfterDownload:  true,
+		Cipher:             fmt.Sprintf("AES-%d-GCM", keySize),
+
+		MaxUploadWindow:  maxUploadWindow,
+		ResumableUploads: true,
+		MinChunkSize:     minChunkSize,
+		MaxChunkSize:     maxChunkSize,
+		MaxMetadataSize:  int(maxMetadata),
+
+		MinProtocolVersion: minProtocolVersion,
+		ProtocolVersion:    maxProtocolVersion,
+		WSCompression:      wsCompression,
+		RequireAPIKey:      requireAPIKey,
 	}
+	upgrader.EnableCompression = wsCompression
 
 	return nil
 }
@@ -106,6 +244,18 @@ func parsePassphraseWords(s string) int {
 	return n
 }
 
+// expiryOptions returns the presets between minSeconds and maxSeconds,
+// followed by maxSeconds itself if it isn't one.
+func expiryOptions(minSeconds, maxSeconds int64) []int64 {
+	options := []int64{}
+	for _, s := range expiryPresets {
+		if s >= minSeconds && s < maxSeconds {
+			options = append(options, s)
+		}
+	}
+	return append(options, maxSeconds)
+}
+
 func calculateTokenMinLength(keyBits int) int {
 	keyBytes := keyBits / 8
 	if keyBytes == 0 {
@@ -154,13 +304,55 @@ func parseFileSize(size string) (int64, error) {
 	return bytes, nil
 }
 
-// GetConfig returns a handler function that returns the current configuration
-func GetConfig() gin.HandlerFunc {
+// GetConfig returns a handler function that returns the current configuration.
+// Given an API key, it reports the limits of the key's namespace.
+func GetConfig(keys *namespaces.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, GlobalConfig)
+		if c.GetHeader(apiKeyHeader) == "" {
+			c.JSON(http.StatusOK, GlobalConfig)
+			return
+		}
+		ns, err := keys.Lookup(c.GetHeader(apiKeyHeader))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+		config := GlobalConfig
+		config.Namespace = ns.Name
+		if ns.MaxFileSizeBytes > 0 {
+			config.MaxFileSizeBytes = int(ns.MaxFileSizeBytes)
+			config.MaxFileSize = strconv.FormatInt(ns.MaxFileSizeBytes, 10) + "B"
+		}
+		if ns.RetentionSeconds > 0 {
+			config.MaxExpirySeconds = ns.RetentionSeconds
+			config.MinExpirySeconds = min(config.MinExpirySeconds, ns.RetentionSeconds)
+			config.FilesRetentionDays = int((ns.RetentionSeconds + 86399) / 86400)
+			config.ExpiryOptions = expiryOptions(config.MinExpirySeconds, config.MaxExpirySeconds)
+		}
+		c.JSON(http.StatusOK, config)
 	}
 }
 
+// apiKeyHeader carries an API key on /api/config and the upload WebSocket.
+const apiKeyHeader = "X-API-Key"
+
+// uploadNamespace resolves the request's API key. Without one the upload
+// is anonymous, unless the server requires a key.
+func uploadNamespace(c *gin.Context, keys *namespaces.Store) (*namespaces.Namespace, error) {
+	key := c.GetHeader(apiKeyHeader)
+	if key == "" {
+		if GlobalConfig.RequireAPIKey {
+			return nil, namespaces.ErrUnknownKey
+		}
+		return nil, nil
+	}
+	ns, err := keys.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ns, nil
+}
+
 func parseBitSize(size string, allowedSizes []int) (int, error) {
 	// Remove "bit" suffix if present
 	size = strings.TrimSuffix(strings.TrimSpace(strings.ToLower(size)), "bit")
@@ -203,4 +395,3 @@ func isValidFileSize(s string) bool {
 	}
 	return false
 }
-
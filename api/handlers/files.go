@@ -2,23 +2,26 @@ package handlers
 
 import (
 	"crypto/rand"
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/storage"
 )
 
 const (
-	headerSize      = 16          // Size of metadata header
-	maxMetadataSize = 1024 * 1024 // 1MB max metadata size
+	headerSize = 16 // Size of metadata header
+	// maxMetadataSize caps MAX_METADATA_SIZE. Stored files are read up to
+	// it whatever the current setting, which may have been lowered since
+	// they were uploaded.
+	maxMetadataSize = 1024 * 1024
 )
 
 // generateID generates a cryptographically secure random ID with a specified bit length.
@@ -55,7 +58,7 @@ func generateID(bitLength ...int) (string, error) {
 	}
 }
 
-func HandleMetadata(uploadDir string) gin.HandlerFunc {
+func HandleMetadata(volumes storage.Volumes) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		if len(id) != 16 && len(id) != 24 && len(id) != 32 {
@@ -76,63 +79,44 @@ func HandleMetadata(uploadDir string) gin.HandlerFunc {
 		}
 
 		// Look for file with token in name
-		filePath := filepath.Join(uploadDir, id+"."+token)
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		filePath, policy, ok := lookupFile(volumes, id, token)
+		if !ok {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 			return
 		}
 
-		file, err := os.Open(filePath)
-		if err != nil {
-			log.Printf("Error: Failed to open file: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-			return
-		}
-		defer file.Close()
-
-		// Get file info for size
-		fileInfo, err := file.Stat()
+		fileInfo, err := os.Stat(filePath)
 		if err != nil {
 			log.Printf("Error: Failed to get file info: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
 			return
 		}
 
-		// Read header to get metadata length
-		header := make([]byte, 16)
-		if _, err = io.ReadFull(file, header); err != nil {
-			log.Printf("Error: Failed to read header: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-			return
-		}
-
-		metadataLen := binary.LittleEndian.Uint32(header[12:16])
-		if metadataLen > maxMetadataSize {
-			log.Printf("Error: Metadata size exceeds limit")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
-			return
-		}
-
-		// Read full metadata including header
-		fullMetadata := make([]byte, 16+int(metadataLen))
-		copy(fullMetadata[:16], header)
-
-		if _, err = io.ReadFull(file, fullMetadata[16:]); err != nil {
+		metadata, err := readMetadata(filePath, fileInfo)
+		if err != nil {
 			log.Printf("Error: Failed to read metadata: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
 			return
 		}
 
-		// Add size to response headers
-		c.Header("Content-Type", "application/octet-stream")
+		// Add size to response headers. The policy headers go out on a 304
+		// too, since the remaining downloads change under the same ETag.
 		c.Header("Cache-Control", "no-cache")
+		c.Header("Vary", "X-HMAC-Token")
+		c.Header("ETag", metadata.etag)
 		c.Header("X-File-Size", strconv.FormatInt(fileInfo.Size(), 10))
+		setPolicyHeaders(c, policy, fileInfo)
 
-		c.Writer.Write(fullMetadata)
+		if c.GetHeader("If-None-Match") == metadata.etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Header("Content-Type", "application/octet-stream")
+		c.Writer.Write(metadata.data)
 	}
 }
 
-func HandleDelete(uploadDir string) gin.HandlerFunc {
+func HandleDelete(volumes storage.Volumes) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		if len(id) != 16 && len(id) != 24 && len(id) != 32 {
@@ -147,24 +131,34 @@ func HandleDelete(uploadDir string) gin.HandlerFunc {
 		}
 
 		// Look for file with token
-		filePath := filepath.Join(uploadDir, id+"."+token)
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		filePath, _, ok := lookupFile(volumes, id, token)
+		if !ok {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 			return
 		}
 
-		// Delete the file
-		if err := os.Remove(filePath); err != nil {
+		// Clients call this after a completed download. Files uploaded with
+		// a download allowance survive until it is used up.
+		remaining, err := storage.Consume(filePath)
+		if err != nil {
 			log.Printf("Error: Failed to delete file: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+		if remaining > 0 {
+			c.JSON(http.StatusOK, gin.H{"message": "Download recorded", "remaining": remaining})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully", "remaining": 0})
 	}
 }
 
-func HandleDownload(uploadDir string) gin.HandlerFunc {
+// HandleDownload serves the stored blob. It also answers HEAD, with the
+// size, ETag and lifecycle headers but no body, so a client can check the
+// file is there and how large it is before committing to a download.
+// Neither counts against the download allowance; DELETE does.
+func HandleDownload(volumes storage.Volumes) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		if len(id) != 16 && len(id) != 24 && len(id) != 32 {
@@ -179,14 +173,19 @@ func HandleDownload(uploadDir string) gin.HandlerFunc {
 		}
 
 		// Look for file with token
-		filePath := filepath.Join(uploadDir, id+"."+token)
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		filePath, policy, ok := lookupFile(volumes, id, token)
+		if !ok {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 			return
 		}
 
-		// Serve file and delete after download
-		file, err := os.Stat(filePath)
+		blob, err := os.Open(filePath)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+		defer blob.Close()
+		file, err := blob.Stat()
 		if err != nil {
 			log.Printf("Error: Failed to get file info: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Server error"})
@@ -194,12 +193,121 @@ func HandleDownload(uploadDir string) gin.HandlerFunc {
 		}
 
 		c.Header("Content-Type", "application/octet-stream")
-		c.Header("Content-Length", strconv.FormatInt(file.Size(), 10))
+		setPolicyHeaders(c, policy, file)
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
+		// Blobs never change once written, so size and mtime identify the
+		// content, and a resuming client can send If-Range.
+		c.Header("ETag", fmt.Sprintf("\"%x-%x\"", file.ModTime().UnixNano(), file.Size()))
+
+		// ServeContent honours Range requests, which the CLI uses to resume
+		// an interrupted download, and sets Content-Length for the part it
+		// sends; for HEAD it stops after the headers. The zero modtime
+		// leaves out Last-Modified. The compression middleware passes
+		// octet-stream bodies through, so it goes out as stored.
+		http.ServeContent(sendfileWriter{c.Writer}, c.Request, "", time.Time{}, blob)
+	}
+}
+
+// sendfileWriter hands response bodies to the connection's ReadFrom, which
+// copies an *os.File with sendfile, instead of through gin's writer and a
+// userspace buffer.
+type sendfileWriter struct {
+	gin.ResponseWriter
+}
+
+func (w sendfileWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.WriteHeaderNow()
+	if u, ok := w.ResponseWriter.(interface{ Unwrap() http.ResponseWriter }); ok {
+		if rf, ok := u.Unwrap().(io.ReaderFrom); ok {
+			return rf.ReadFrom(r)
+		}
+	}
+	return io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+}
+
+// HandleStats tells the uploader how often their file was downloaded. It
+// needs the owner token from the upload's completion message as well as
+// the download token, and keeps answering after the last download removed
+// the file. Only the count is kept: nothing about who downloaded.
+func HandleStats(volumes storage.Volumes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if len(id) != 16 && len(id) != 24 && len(id) != 32 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		token := c.GetHeader("X-HMAC-Token")
+		if !validateID(id) || !validateToken(token) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		// A missing file and a wrong owner token look the same
+		filePath := volumes.Locate(id + "." + token)
+		policy, err := storage.LoadPolicy(filePath)
+		if err != nil || !policy.OwnedBy(c.GetHeader("X-Owner-Token")) || policy.Expired(time.Now()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		// A held file stays on disk after its last download
+		remaining := 0
+		if _, err := os.Stat(filePath); err == nil {
+			remaining = max(0, policy.Remaining())
+		}
+		available := remaining > 0
+		c.JSON(http.StatusOK, gin.H{
+			"downloads": policy.Downloads,
+			"remaining": remaining,
+			"available": available,
+		})
+	}
+}
+
+// lookupFile resolves the blob for id+token and loads its policy. Expired
+// files are removed on access and reported as missing, so the retention
+// sweep's granularity never extends a requested lifetime. Held files stay
+// on disk but are reported missing all the same.
+func lookupFile(volumes storage.Volumes, id, token string) (string, storage.Policy, bool) {
+	if !validateID(id) {
+		return "", storage.Policy{}, false
+	}
+	filePath := volumes.Locate(id + "." + token)
+	if _, err := os.Stat(filePath); err != nil {
+		return "", storage.Policy{}, false
+	}
+
+	policy, err := storage.LoadPolicy(filePath)
+	if err != nil {
+		log.Printf("Error: Failed to read file policy: %v", err)
+		return "", storage.Policy{}, false
+	}
+	if policy.Held() && !policy.Servable(time.Now()) {
+		return "", storage.Policy{}, false
+	}
+	if policy.Expired(time.Now()) {
+		if err := storage.Remove(filePath); err != nil {
+			log.Printf("Failed to remove expired file: %v", err)
+		}
+		return "", storage.Policy{}, false
+	}
+	return filePath, policy, true
+}
 
-		c.File(filePath)
+// setPolicyHeaders exposes the file's lifecycle to clients. These headers
+// reveal nothing beyond what the uploader chose and are only sent to callers
+// holding a valid token. Files without a requested lifetime report when the
+// retention sweep will remove them.
+func setPolicyHeaders(c *gin.Context, p storage.Policy, blob os.FileInfo) {
+	expiresAt := p.ExpiresAt
+	if expiresAt.IsZero() {
+		// MaxExpirySeconds is the retention period.
+		expiresAt = blob.ModTime().Add(time.Duration(GlobalConfig.MaxExpirySeconds) * time.Second)
 	}
+	c.Header("X-Expires-At", expiresAt.UTC().Format(time.RFC3339))
+	c.Header("X-Downloads-Remaining", strconv.Itoa(p.Remaining()))
 }
 
 func validateToken(token string) bool {
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/telemetry"
+)
+
+// liveInterval is how often the live metrics feed reports.
+const liveInterval = time.Second
+
+// HandleWSMetrics streams the server's current activity to an operator's
+// dashboard, so it doesn't have to poll the aggregate metrics. Every
+// liveInterval it sends the open transfers by operation, how many of them
+// are held back because the disk can't keep up, the throughput since the
+// previous message in bytes a second, and any errors reported to clients
+// meanwhile; the first message carries the recent errors.
+// Mount it behind admin auth.
+func HandleWSMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ws, err := upgradeWS(c)
+		if err != nil {
+			log.Printf("WebSocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		ws.SetReadLimit(controlMessageLimit)
+		policeMessages(ws)
+		ws.SetReadDeadline(time.Now().Add(pongWait))
+		ws.SetPongHandler(func(string) error {
+			ws.SetReadDeadline(time.Now().Add(pongWait))
+			return nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		startPingLoop(ctx, ws)
+		// The client sends nothing; reading handles its pongs and notices
+		// when it goes away.
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		prev := telemetry.Activity.Snapshot()
+		if err := wsWriteJSON(ws, liveMessage(prev, prev, 0)); err != nil {
+			return
+		}
+		ticker := time.NewTicker(liveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			snap := telemetry.Activity.Snapshot()
+			var seen uint64
+			if n := len(prev.Errors); n > 0 {
+				seen = prev.Errors[n-1].Seq
+			}
+			if err := wsWriteJSON(ws, liveMessage(snap, prev, seen)); err != nil {
+				return
+			}
+			prev = snap
+		}
+	}
+}
+
+// liveMessage reports snap, with throughput measured since prev and the
+// errors after seen.
+func liveMessage(snap, prev telemetry.LiveSnapshot, seen uint64) gin.H {
+	throughput := map[string]int64{}
+	if elapsed := snap.Time.Sub(prev.Time).Seconds(); elapsed > 0 {
+		for op, total := range snap.Bytes {
+			throughput[op] = int64(float64(total-prev.Bytes[op]) / elapsed)
+		}
+	}
+	errors := []telemetry.LiveError{}
+	for _, e := range snap.Errors {
+		if e.Seq > seen {
+			errors = append(errors, e)
+		}
+	}
+	sessions := snap.Sessions
+	if sessions == nil {
+		sessions = map[string]int{}
+	}
+	saturated := snap.Saturated
+	if saturated == nil {
+		saturated = map[string]int{}
+	}
+	return gin.H{
+		"type":       "activity",
+		"time":       snap.Time,
+		"sessions":   sessions,
+		"saturated":  saturated,
+		"throughput": throughput,
+		"errors":     errors,
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// metadataCacheBytes bounds the memory held by cached metadata. Most
+// entries are a few hundred bytes, but a file may carry up to
+// maxMetadataSize.
+const metadataCacheBytes = 16 * 1024 * 1024
+
+// cachedMetadata is the header and encrypted metadata of one file, as
+// HandleMetadata sends it. size and modTime tie it to the blob it was read
+// from, so a file replaced under the same name is read again.
+type cachedMetadata struct {
+	data    []byte
+	etag    string
+	size    int64
+	modTime time.Time
+}
+
+// metadataCache keeps file metadata in memory by blob path. The SPA
+// fetches it before every download and on each page refresh, and it never
+// changes once a file is written.
+var (
+	metadataCache     = make(map[string]*cachedMetadata)
+	metadataCacheSize int
+	metadataCacheMu   sync.Mutex
+)
+
+// readMetadata returns the metadata of the blob at path, from the cache
+// when it still matches the blob's size and modification time.
+func readMetadata(path string, info os.FileInfo) (*cachedMetadata, error) {
+	metadataCacheMu.Lock()
+	m, ok := metadataCache[path]
+	metadataCacheMu.Unlock()
+	if ok && m.size == info.Size() && m.modTime.Equal(info.ModTime()) {
+		return m, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Read header to get metadata length
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	metadataLen := binary.LittleEndian.Uint32(header[12:16])
+	if metadataLen > maxMetadataSize {
+		return nil, fmt.Errorf("metadata size %d exceeds limit", metadataLen)
+	}
+
+	// Read full metadata including header
+	data := make([]byte, headerSize+int(metadataLen))
+	copy(data, header)
+	if _, err := io.ReadFull(file, data[headerSize:]); err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+
+	m = &cachedMetadata{
+		data:    data,
+		etag:    fmt.Sprintf("\"%x\"", sha1.Sum(data)),
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+	storeMetadata(path, m)
+	return m, nil
+}
+
+// storeMetadata caches m, dropping other entries while the cache is over
+// metadataCacheBytes. Deleted files leave their entries behind until then.
+func storeMetadata(path string, m *cachedMetadata) {
+	metadataCacheMu.Lock()
+	defer metadataCacheMu.Unlock()
+	if old, ok := metadataCache[path]; ok {
+		metadataCacheSize -= len(old.data)
+	}
+	metadataCache[path] = m
+	metadataCacheSize += len(m.data)
+	for p, e := range metadataCache {
+		if metadataCacheSize <= metadataCacheBytes {
+			break
+		}
+		if p != path {
+			delete(metadataCache, p)
+			metadataCacheSize -= len(e.data)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/cleanup"
+	"github.com/jonasbg/paste/m/v2/middleware"
+	"github.com/jonasbg/paste/m/v2/storage"
+	"github.com/jonasbg/paste/m/v2/utils"
+)
+
+// Settings is the server's effective configuration: what it resolved
+// from the environment, with defaults applied. Secrets are only reported
+// as set or not.
+type Settings struct {
+	// Config is what clients see on /api/config.
+	Config            Config   `json:"config"`
+	UploadDirs        []string `json:"upload_dirs"`
+	WebDir            string   `json:"web_dir"`
+	APIKeysFile       string   `json:"api_keys_file"`
+	SweepInterval     string   `json:"sweep_interval"`
+	TrashRetention    string   `json:"trash_retention"`
+	TrustedProxies    []string `json:"trusted_proxies"`
+	RealIPHeader      string   `json:"real_ip_header"`
+	ProxyHops         int      `json:"proxy_hops"`
+	AllowedOrigins    string   `json:"allowed_origins"`
+	RateLimit         string   `json:"rate_limit"`
+	TransferRateLimit string   `json:"transfer_rate_limit"`
+	RequestLogExclude string   `json:"request_log_exclude"`
+	RequestLogSample  string   `json:"request_log_sample"`
+	AdminToken        bool     `json:"admin_token"`
+	OIDCIssuer        string   `json:"oidc_issuer"`
+	ErrorReporting    bool     `json:"error_reporting"`
+}
+
+// CurrentSettings collects the effective configuration. Call it after
+// InitConfig and cleanup.StartFileCleanup, which resolve part of it.
+func CurrentSettings(volumes storage.Volumes, webDir string) Settings {
+	header, hops := utils.RealIPSource()
+	if header == "" {
+		header = "none"
+	}
+	// Both were checked at startup
+	apiRate, apiBurst, _ := middleware.APILimit()
+	transferRate, transferBurst, _ := middleware.TransferLimit()
+	return Settings{
+		Config:            GlobalConfig,
+		UploadDirs:        volumes,
+		WebDir:            webDir,
+		APIKeysFile:       utils.GetEnv("API_KEYS_FILE", "keys.json"),
+		SweepInterval:     cleanup.GetSweepInterval().String(),
+		TrashRetention:    storage.TrashRetention.String(),
+		TrustedProxies:    utils.GetTrustedProxies(),
+		RealIPHeader:      header,
+		ProxyHops:         hops,
+		AllowedOrigins:    os.Getenv("ALLOWED_ORIGINS"),
+		RateLimit:         fmt.Sprintf("%g/s, burst %d", apiRate, apiBurst),
+		TransferRateLimit: fmt.Sprintf("%g/s, burst %d", transferRate, transferBurst),
+		RequestLogExclude: os.Getenv("REQUEST_LOG_EXCLUDE"),
+		RequestLogSample:  os.Getenv("REQUEST_LOG_SAMPLE"),
+		AdminToken:        os.Getenv("ADMIN_TOKEN") != "",
+		OIDCIssuer:        strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ErrorReporting:    strings.TrimSpace(os.Getenv("SENTRY_DSN")) != "",
+	}
+}
+
+// HandleSettings reports the effective configuration, so an operator can
+// check what a running instance resolved without reading its environment.
+func HandleSettings(volumes storage.Volumes, webDir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, CurrentSettings(volumes, webDir))
+	}
+}
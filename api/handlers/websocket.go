@@ -5,16 +5,23 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/jonasbg/paste/m/v2/namespaces"
+	"github.com/jonasbg/paste/m/v2/reporting"
+	"github.com/jonasbg/paste/m/v2/storage"
 	"github.com/jonasbg/paste/m/v2/telemetry"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -28,15 +35,93 @@ const (
 	writeWait = 10 * time.Second
 )
 
+// Inbound message policing. Until the upload stream starts, and for the
+// whole of a download, clients only send small JSON messages; encrypted
+// chunks may be as large as the upload's sealed chunk size and no larger.
+// Chunks beyond the message rate are read more slowly, which only slows
+// clients that send undersized ones; pings beyond it close the connection.
+const (
+	controlMessageLimit = 64 * 1024
+	maxMessageRate      = 500 // per second
+	messageBurst        = 64
+)
+
+// WebSocket protocol versions this server speaks. Clients send the highest
+// they speak in init or download_init and the server answers with the
+// version it picked; one that sends none speaks version 1, the framing in
+// use before versions were negotiated. Changes to framing or chunk
+// encryption raise maxProtocolVersion, and raising minProtocolVersion drops
+// clients that can't follow.
+//
+// Version 2 windows uploads: init asks for a window, the id (or resume)
+// reply grants one, and acks are cumulative, sent once per half window.
+// An ack may shrink the window, down to one chunk, while the server's disk
+// falls behind, and grows it back up to the grant afterwards.
+const (
+	minProtocolVersion = 1
+	maxProtocolVersion = 2
+)
+
+// negotiateProtocol returns the highest version both sides speak, or 0 if
+// there is none.
+func negotiateProtocol(client int) int {
+	if client == 0 {
+		client = 1
+	}
+	v := min(client, maxProtocolVersion)
+	if v < minProtocolVersion {
+		return 0
+	}
+	return v
+}
+
+// sendProtocolError refuses a client whose protocol versions don't overlap
+// the server's.
+func sendProtocolError(ws *websocket.Conn, client int) {
+	sendWSErrorCode(ws, errCodeProtocol, fmt.Sprintf("Unsupported protocol version %d (server speaks %d to %d)", client, minProtocolVersion, maxProtocolVersion))
+}
+
 var upgrader = websocket.Upgrader{
 	// Larger buffers reduce syscall overhead for large binary frames (default 1KB -> 64KB)
 	ReadBufferSize:  64 * 1024,
 	WriteBufferSize: 64 * 1024,
+	// Write buffers are only held while a message is being written, so
+	// connections waiting on the client share them.
+	WriteBufferPool: &sync.Pool{},
 	CheckOrigin: func(r *http.Request) bool {
 		return true // TODO: tighten this with origin checks if exposed publicly
 	},
 }
 
+// upgradeWS upgrades the request to a WebSocket. Compression, when
+// negotiated, starts off: wsWriteJSON turns it on for JSON messages only.
+func upgradeWS(c *gin.Context) (*websocket.Conn, error) {
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return nil, err
+	}
+	ws.EnableWriteCompression(false)
+	return ws, nil
+}
+
+// policeMessages installs a ping handler that answers at most
+// maxMessageRate pings a second, and returns the connection's message
+// limiter for the handler to throttle data frames with.
+func policeMessages(ws *websocket.Conn) *rate.Limiter {
+	limiter := rate.NewLimiter(maxMessageRate, messageBurst)
+	ws.SetPingHandler(func(data string) error {
+		if !limiter.Allow() {
+			return errors.New("too many pings")
+		}
+		err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+	return limiter
+}
+
 // startPingLoop sends periodic WebSocket pings so reverse proxies do not cut
 // idle connections during large uploads/downloads. The goroutine exits when ctx
 // is cancelled or a write fails. The caller must configure ws.SetPongHandler to
@@ -68,23 +153,48 @@ func startPingLoop(ctx context.Context, ws *websocket.Conn) {
 	}()
 }
 
-// wsWriteJSON sets a write deadline and then serialises v as JSON to ws.
+// sendBlobChunk sends the next n bytes of r as one binary message. The
+// frame writer reads them from r straight into the connection's pooled write
+// buffer, so a download holds no chunk-sized buffer of its own.
+func sendBlobChunk(ws *websocket.Conn, r io.Reader, n int64) (int64, error) {
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	w, err := ws.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return 0, err
+	}
+	sent, err := io.Copy(w, io.LimitReader(r, n))
+	if err == nil && sent < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return sent, err
+}
+
+// wsWriteJSON sets a write deadline and then serialises v as JSON to ws,
+// compressed if the connection negotiated it. Encrypted chunks wouldn't
+// shrink, so compression is off again for whatever follows.
 func wsWriteJSON(ws *websocket.Conn, v any) error {
 	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	ws.EnableWriteCompression(true)
+	defer ws.EnableWriteCompression(false)
 	return ws.WriteJSON(v)
 }
 
-func HandleWSDownload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFunc {
+func HandleWSDownload(volumes storage.Volumes, metrics *telemetry.Provider) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		ws, err := upgradeWS(c)
 		if err != nil {
 			log.Printf("WebSocket upgrade failed: %v", err)
 			return
 		}
 		defer ws.Close()
+		defer telemetry.Activity.StartSession("download")()
 
 		// Download clients only send small JSON control messages.
-		ws.SetReadLimit(64 * 1024)
+		ws.SetReadLimit(controlMessageLimit)
+		policeMessages(ws)
 
 		// Keepalive: extend read deadline on every pong.
 		ws.SetReadDeadline(time.Now().Add(pongWait))
@@ -106,9 +216,10 @@ func HandleWSDownload(uploadDir string, metrics *telemetry.Provider) gin.Handler
 		ws.SetReadDeadline(time.Now().Add(pongWait))
 
 		var request struct {
-			Type   string `json:"type"`
-			FileId string `json:"fileId"`
-			Token  string `json:"token"`
+			Type            string `json:"type"`
+			FileId          string `json:"fileId"`
+			Token           string `json:"token"`
+			ProtocolVersion int    `json:"protocolVersion"`
 		}
 
 		if err := json.Unmarshal(msg, &request); err != nil {
@@ -121,6 +232,12 @@ func HandleWSDownload(uploadDir string, metrics *telemetry.Provider) gin.Handler
 			return
 		}
 
+		protocol := negotiateProtocol(request.ProtocolVersion)
+		if protocol == 0 {
+			sendProtocolError(ws, request.ProtocolVersion)
+			return
+		}
+
 		// Validate fileId format
 		if len(request.FileId) != 16 && len(request.FileId) != 24 && len(request.FileId) != 32 {
 			sendWSError(ws, "Invalid file ID format")
@@ -140,8 +257,8 @@ func HandleWSDownload(uploadDir string, metrics *telemetry.Provider) gin.Handler
 
 		// Locate file with the exact token - this is the security check
 		// The file name MUST match fileId.token exactly
-		filePath := filepath.Join(uploadDir, request.FileId+"."+request.Token)
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		filePath, _, ok := lookupFile(volumes, request.FileId, request.Token)
+		if !ok {
 			// Return generic error to prevent token enumeration
 			sendWSError(ws, "Access denied")
 			return
@@ -165,8 +282,9 @@ func HandleWSDownload(uploadDir string, metrics *telemetry.Provider) gin.Handler
 
 		// Send file size info
 		if err := wsWriteJSON(ws, gin.H{
-			"type": "file_info",
-			"size": fileInfo.Size(),
+			"type":            "file_info",
+			"size":            fileInfo.Size(),
+			"protocolVersion": protocol,
 		}); err != nil {
 			log.Printf("Failed to send file info: %v", err)
 			return
@@ -189,105 +307,96 @@ func HandleWSDownload(uploadDir string, metrics *telemetry.Provider) gin.Handler
 			return
 		}
 
-		// Stream file in chunks
-		// Use the configured chunk size (+16 tag) to match upload pipeline; fall back to 1MB if unset
-		configuredChunkBytes := GlobalConfig.ChunkSize * 1024 * 1024
-		if configuredChunkBytes <= 0 {
-			configuredChunkBytes = 1 * 1024 * 1024
+		// Stream the blob in messages of the configured chunk size (+16 tag)
+		// to match the upload pipeline; fall back to 1MB if unset
+		chunkBytes := int64(GlobalConfig.ChunkSize)*1024*1024 + 16
+		if GlobalConfig.ChunkSize <= 0 {
+			chunkBytes = 1*1024*1024 + 16
 		}
-		buffer := make([]byte, configuredChunkBytes+16)
+		size := fileInfo.Size()
 		var totalSent int64 = 0
 		var isComplete = false
 		// Ack batching: require client to ack every batchAckInterval chunks instead of every chunk
 		const batchAckInterval = 8 // tuneable; higher reduces round trips
 		chunksSinceAck := 0
 
-		for {
-			n, err := file.Read(buffer)
-			if n > 0 {
-				ws.SetWriteDeadline(time.Now().Add(writeWait))
-				if err := ws.WriteMessage(websocket.BinaryMessage, buffer[:n]); err != nil {
-					log.Printf("Error sending chunk: %v", err)
-					return
-				}
-				totalSent += int64(n)
-				chunksSinceAck++
-
-				// Only wait for an ACK every batchAckInterval chunks to improve throughput
-				if chunksSinceAck >= batchAckInterval {
-					_, ackMsg, err := ws.ReadMessage()
-					if err != nil {
-						log.Printf("Error receiving ack: %v", err)
-						return
-					}
-					ws.SetReadDeadline(time.Now().Add(pongWait))
-					var ack struct {
-						Type string `json:"type"`
-						Size int    `json:"size"`
-					}
-					if err := json.Unmarshal(ackMsg, &ack); err != nil || ack.Type != "ack" || ack.Size != n {
-						log.Printf("Invalid ack: %v (expected size %d, got %d)", err, n, ack.Size)
-						return
-					}
-					chunksSinceAck = 0
-				}
+		for totalSent < size {
+			n, err := sendBlobChunk(ws, file, min(chunkBytes, size-totalSent))
+			if err != nil {
+				log.Printf("Error sending chunk: %v", err)
+				return
 			}
+			totalSent += n
+			telemetry.Activity.AddBytes("download", n)
+			chunksSinceAck++
 
-			if err == io.EOF {
-				// Flush final ack if there are outstanding unacked chunks
-				if chunksSinceAck > 0 {
-					_, ackMsg, err := ws.ReadMessage()
-					if err != nil {
-						log.Printf("Error receiving final batch ack: %v", err)
-						return
-					}
-					ws.SetReadDeadline(time.Now().Add(pongWait))
-					var ack struct {
-						Type string `json:"type"`
-					}
-					if err := json.Unmarshal(ackMsg, &ack); err != nil || ack.Type != "ack" {
-						log.Printf("Invalid final batch ack: %v", err)
-						return
-					}
-				}
-
-				if err := wsWriteJSON(ws, gin.H{"type": "complete", "size": totalSent}); err != nil {
-					log.Printf("Failed to send complete message: %v", err)
-					return
-				}
-				_, completeMsg, err := ws.ReadMessage()
+			// Only wait for an ACK every batchAckInterval chunks to improve throughput
+			if chunksSinceAck >= batchAckInterval {
+				_, ackMsg, err := ws.ReadMessage()
 				if err != nil {
-					log.Printf("Error receiving final ack: %v", err)
+					log.Printf("Error receiving ack: %v", err)
 					return
 				}
 				ws.SetReadDeadline(time.Now().Add(pongWait))
-				var complete struct {
-					Type     string `json:"type"`
-					Complete bool   `json:"complete"`
+				var ack struct {
+					Type string `json:"type"`
+					Size int64  `json:"size"`
 				}
-				if err := json.Unmarshal(completeMsg, &complete); err != nil || complete.Type != "complete_ack" || !complete.Complete {
-					log.Printf("Invalid complete ack")
+				if err := json.Unmarshal(ackMsg, &ack); err != nil || ack.Type != "ack" || ack.Size != n {
+					log.Printf("Invalid ack: %v (expected size %d, got %d)", err, n, ack.Size)
 					return
 				}
-				isComplete = true
-				break
+				chunksSinceAck = 0
 			}
+		}
 
+		// Flush final ack if there are outstanding unacked chunks
+		if chunksSinceAck > 0 {
+			_, ackMsg, err := ws.ReadMessage()
 			if err != nil {
-				log.Printf("Error reading file: %v", err)
-				sendWSError(ws, "Error reading file")
+				log.Printf("Error receiving final batch ack: %v", err)
 				return
 			}
-			if n == 0 {
-				break
+			ws.SetReadDeadline(time.Now().Add(pongWait))
+			var ack struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(ackMsg, &ack); err != nil || ack.Type != "ack" {
+				log.Printf("Invalid final batch ack: %v", err)
+				return
 			}
 		}
 
+		if err := wsWriteJSON(ws, gin.H{"type": "complete", "size": totalSent}); err != nil {
+			log.Printf("Failed to send complete message: %v", err)
+			return
+		}
+		_, completeMsg, err := ws.ReadMessage()
+		if err != nil {
+			log.Printf("Error receiving final ack: %v", err)
+			return
+		}
+		ws.SetReadDeadline(time.Now().Add(pongWait))
+		var complete struct {
+			Type     string `json:"type"`
+			Complete bool   `json:"complete"`
+		}
+		if err := json.Unmarshal(completeMsg, &complete); err != nil || complete.Type != "complete_ack" || !complete.Complete {
+			log.Printf("Invalid complete ack")
+			return
+		}
+		isComplete = true
+
 		// Calculate duration of download
 		// Only delete file if download was completed successfully
 		if isComplete {
-			if err := os.Remove(filePath); err != nil {
+			remaining, err := storage.Consume(filePath)
+			if err != nil {
 				log.Printf("Failed to remove file: %v", err)
+			} else {
+				// Tell the client how many downloads are left, as DELETE
+				// /api/delete does for HTTP downloads.
+				_ = wsWriteJSON(ws, gin.H{"type": "consumed", "remaining": remaining})
 			}
 
 			metrics.RecordTransfer(c.Request.Context(), "download", totalSent, true, "websocket")
@@ -297,20 +406,30 @@ func HandleWSDownload(uploadDir string, metrics *telemetry.Provider) gin.Handler
 	}
 }
 
-func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFunc {
+func HandleWSUpload(volumes storage.Volumes, keys *namespaces.Store, metrics *telemetry.Provider) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		// The API key is checked before the upgrade, so a bad one is a
+		// plain 401 rather than an error frame.
+		ns, err := uploadNamespace(c, keys)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing API key"})
+			return
+		}
+
+		ws, err := upgradeWS(c)
 		if err != nil {
 			log.Printf("WebSocket upgrade failed: %v", err)
 			return
 		}
 		defer ws.Close()
+		defer telemetry.Activity.StartSession("upload")()
 
-		// Limit inbound frame size to one encrypted chunk + GCM tag + small headroom.
-		// This prevents a malicious client from forcing gorilla to allocate a huge buffer
-		// before the application-level chunk-size validation can fire.
-		maxFrameBytes := int64(GlobalConfig.ChunkSize)*1024*1024 + 16 + 1024
-		ws.SetReadLimit(maxFrameBytes)
+		// Limit inbound frame size for each stage of the upload, so a
+		// malicious client can't force gorilla to allocate a huge buffer
+		// before the application-level size checks fire. The handshake is
+		// JSON; the limit grows for the header and again for the chunks.
+		ws.SetReadLimit(controlMessageLimit)
+		limiter := policeMessages(ws)
 
 		// Keepalive: extend read deadline whenever a pong arrives.
 		ws.SetReadDeadline(time.Now().Add(pongWait))
@@ -331,11 +450,7 @@ func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFu
 		}
 		ws.SetReadDeadline(time.Now().Add(pongWait))
 
-		var init struct {
-			Type   string `json:"type"`
-			Size   int64  `json:"size"`
-			FileID string `json:"fileId,omitempty"` // Optional: for passphrase-based uploads
-		}
+		var init uploadInit
 		if err := json.Unmarshal(msg, &init); err != nil {
 			sendWSError(ws, "Invalid initial message format")
 			return
@@ -345,14 +460,56 @@ func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFu
 			sendWSError(ws, "Invalid message type: expected 'init'")
 			return
 		}
+		init.namespace = ns
 
-		if init.Size > int64(GlobalConfig.MaxFileSizeBytes) {
+		protocol := negotiateProtocol(init.ProtocolVersion)
+		if protocol == 0 {
+			sendProtocolError(ws, init.ProtocolVersion)
+			return
+		}
+		init.ProtocolVersion = protocol
+		if protocol >= 2 {
+			init.Window = max(1, min(init.Window, GlobalConfig.MaxUploadWindow))
+		} else {
+			init.Window = 1
+		}
+
+		if init.Streaming && init.Size != 0 {
+			sendWSError(ws, "Size must be omitted for streaming uploads")
+			return
+		}
+		if init.Size < 0 {
+			sendWSError(ws, "Invalid size")
+			return
+		}
+		if init.Size > init.maxFileSize() {
 			sendWSError(ws, "File too large")
 			return
 		}
 
-		// 2. Generate or Use Provided ID
+		// Requested lifetime must fall inside the advertised range. The
+		// expiry clock starts when the upload completes.
+		if init.ExpiresIn != 0 && (init.ExpiresIn < GlobalConfig.MinExpirySeconds || init.ExpiresIn > init.maxExpiry()) {
+			sendWSError(ws, "Invalid expiry")
+			return
+		}
+		if init.MaxDownloads < 0 || init.MaxDownloads > GlobalConfig.MaxDownloads {
+			sendWSError(ws, "Invalid download limit")
+			return
+		}
+		if init.ChunkSize != 0 && (init.ChunkSize < GlobalConfig.MinChunkSize || init.ChunkSize > GlobalConfig.MaxChunkSize) {
+			sendWSError(ws, "Invalid chunk size")
+			return
+		}
+
+		if init.Resume {
+			resumeUpload(c, ws, volumes, &init, limiter, metrics)
+			return
+		}
+
+		// 2. Generate or Use Provided ID, reserving it for this upload
 		var id string
+		var release func()
 		if init.FileID != "" {
 			// Client provided a custom fileID (passphrase mode)
 			// Validate format
@@ -365,28 +522,40 @@ func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFu
 				return
 			}
 
-			// Check for collision: reject if any file with this ID already exists
-			matches, err := filepath.Glob(filepath.Join(uploadDir, init.FileID+".*"))
-			if err != nil {
-				sendWSError(ws, "Failed to check for existing files")
+			// Reject if the ID is stored or another upload is claiming it;
+			// the client answers id_in_use with a fresh passphrase.
+			release, err = storage.Reserve(volumes, init.FileID)
+			if errors.Is(err, storage.ErrIDInUse) {
+				sendWSErrorCode(ws, errCodeIDInUse, "Share code already in use, please try again with a different passphrase")
 				return
 			}
-			if len(matches) > 0 {
-				sendWSError(ws, "Share code already in use, please try again with a different passphrase")
+			if err != nil {
+				sendWSError(ws, "Failed to check for existing files")
 				return
 			}
 
 			id = init.FileID
 		} else {
-			// Generate random ID as usual
-			id, err = generateID(GlobalConfig.IDSize)
+			// Generate random ID as usual; a collision is astronomically
+			// unlikely but cheap to handle.
+			for attempt := 0; attempt < 3; attempt++ {
+				id, err = generateID(GlobalConfig.IDSize)
+				if err != nil {
+					sendWSError(ws, "Failed to generate ID")
+					return
+				}
+				if release, err = storage.Reserve(volumes, id); !errors.Is(err, storage.ErrIDInUse) {
+					break
+				}
+			}
 			if err != nil {
 				sendWSError(ws, "Failed to generate ID")
 				return
 			}
 		}
+		defer release()
 
-		if err := wsWriteJSON(ws, gin.H{"type": "id", "id": id}); err != nil {
+		if err := wsWriteJSON(ws, init.withGrant(gin.H{"type": "id", "id": id})); err != nil {
 			sendWSError(ws, "Failed to send ID")
 			return
 		}
@@ -421,13 +590,22 @@ func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFu
 		}
 
 		// 4. Create File (with token)
-		finalPath := filepath.Join(uploadDir, id+"."+tokenData.Token)
-		tmpPath := finalPath + ".tmp" // Use a temporary file
+		// Each upload goes to the volume with the most room
+		finalPath := filepath.Join(volumes.Pick(), id+"."+tokenData.Token)
+		tmpPath := storage.PartialPath(finalPath) // Use a temporary file
 		file, err := os.Create(tmpPath)
 		if err != nil {
 			sendWSError(ws, "Failed to create file")
 			return
 		}
+		// A resume must use the chunk size the partial was written with.
+		if init.Resumable {
+			if err := storage.SavePolicy(tmpPath, storage.Policy{ChunkSize: init.chunkMB()}); err != nil {
+				file.Close()
+				wsCleanup(ws, tmpPath, "Failed to create file")
+				return
+			}
+		}
 		// 512 KB buffer is enough to smooth out syscall bursts; the OS page cache
 		// handles larger sequential writes efficiently without a huge userspace buffer.
 		bufWriter := bufio.NewWriterSize(file, 512*1024)
@@ -437,6 +615,7 @@ func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFu
 		}()
 
 		// 5. Read and Validate Encrypted Metadata Header
+		ws.SetReadLimit(int64(headerSize + GlobalConfig.MaxMetadataSize + 1024))
 		_, header, err := ws.ReadMessage()
 		if err != nil || len(header) < headerSize {
 			wsCleanup(ws, tmpPath, "Invalid header: incorrect size")
@@ -459,7 +638,7 @@ func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFu
 			return
 		}
 
-		if metadataLength > 65535 { // Example limit: 64KB metadata
+		if int64(metadataLength) > int64(GlobalConfig.MaxMetadataSize) {
 			wsCleanup(ws, tmpPath, "Metadata size too large")
 			return
 		}
@@ -495,98 +674,443 @@ func HandleWSUpload(uploadDir string, metrics *telemetry.Provider) gin.HandlerFu
 		}
 
 		// 7. Chunk Processing Loop
-		var totalBytes int64 = int64(len(header) + len(iv)) // Initialize with header + IV
-		for {
-			_, chunk, err := ws.ReadMessage()
-			if err != nil {
-				wsCleanup(ws, tmpPath, "Failed to read chunk")
-				return
-			}
-			ws.SetReadDeadline(time.Now().Add(pongWait))
+		receiveChunks(c, ws, &uploadTarget{
+			id:         id,
+			finalPath:  finalPath,
+			tmpPath:    tmpPath,
+			file:       file,
+			w:          bufWriter,
+			totalBytes: int64(len(header) + len(iv)), // header + IV
+			init:       &init,
+			limiter:    limiter,
+		}, metrics)
+	}
+}
 
-			// End signal (single byte 0)
-			if len(chunk) == 1 && chunk[0] == 0 {
-				break
-			}
+// uploadInit is the first frame of an upload.
+type uploadInit struct {
+	Type      string `json:"type"`
+	Size      int64  `json:"size"`
+	FileID    string `json:"fileId,omitempty"`    // Optional: for passphrase-based uploads
+	ExpiresIn int64  `json:"expiresIn,omitempty"` // Optional: lifetime in seconds
+	// Optional: completed downloads before deletion (default 1)
+	MaxDownloads int `json:"maxDownloads,omitempty"`
+	// Optional: size is unknown up front (piped input). The
+	// running total is still held to MaxFileSizeBytes below.
+	Streaming bool `json:"streaming,omitempty"`
+	// Optional: keep the partial file if the connection drops, so the
+	// client can continue with a resume request.
+	Resumable bool `json:"resumable,omitempty"`
+	// Resume continues an interrupted resumable upload of FileID; Token
+	// proves the client owns it.
+	Resume bool   `json:"resume,omitempty"`
+	Token  string `json:"token,omitempty"`
+	// Optional: plaintext chunk size in MB, within [MinChunkSize,
+	// MaxChunkSize]. A resume must repeat the size the upload began with.
+	ChunkSize int `json:"chunkSize,omitempty"`
+	// Optional: the highest protocol version the client speaks. Once the
+	// handshake has read it, it holds the negotiated version.
+	ProtocolVersion int `json:"protocolVersion,omitempty"`
+	// Protocol 2: chunks the client wants in flight before it waits for an
+	// ack. Once read, it holds the granted window.
+	Window int `json:"window,omitempty"`
+
+	// namespace is where the upload's API key puts it; nil for anonymous
+	// uploads.
+	namespace *namespaces.Namespace
+}
 
-			// Validate size
-			if len(chunk) > (GlobalConfig.ChunkSize*1024*1024 + 16) {
-				wsCleanup(ws, tmpPath, "Chunk size exceeds maximum")
-				return
-			}
-			if len(chunk) < 16 { // must at least contain GCM tag
-				wsCleanup(ws, tmpPath, "Chunk size too small")
-				return
-			}
+// maxFileSize returns the upload's byte limit: its namespace's if set,
+// otherwise MAX_FILE_SIZE.
+func (i *uploadInit) maxFileSize() int64 {
+	if i.namespace != nil && i.namespace.MaxFileSizeBytes > 0 {
+		return i.namespace.MaxFileSizeBytes
+	}
+	return int64(GlobalConfig.MaxFileSizeBytes)
+}
 
-			chunkSize := int64(len(chunk))
-			projectedTotal := totalBytes + chunkSize
-			if projectedTotal > int64(GlobalConfig.MaxFileSizeBytes) {
-				wsCleanup(ws, tmpPath, "File too large")
-				return
-			}
+// maxExpiry returns the longest lifetime the upload may ask for, which is
+// its namespace's retention if set.
+func (i *uploadInit) maxExpiry() int64 {
+	if i.namespace != nil && i.namespace.RetentionSeconds > 0 {
+		return i.namespace.RetentionSeconds
+	}
+	return GlobalConfig.MaxExpirySeconds
+}
 
-			// Persist chunk to disk BEFORE acknowledging.
-			// An ACK sent before the write succeeds would make a disk error look like a
-			// sudden connection drop to the client, because the server close frame races
-			// the in-flight next chunk from the client.
-			if _, err := bufWriter.Write(chunk); err != nil {
-				wsCleanup(ws, tmpPath, "Failed to write chunk")
-				return
-			}
-			totalBytes = projectedTotal
+// namespaceName returns the upload's namespace, or "" if it has none.
+func (i *uploadInit) namespaceName() string {
+	if i.namespace == nil {
+		return ""
+	}
+	return i.namespace.Name
+}
 
-			// ACK only after the chunk is safely written to the buffer
-			if err := wsWriteJSON(ws, gin.H{"type": "ack", "ack": chunkSize}); err != nil {
-				log.Printf("Failed to send acknowledgement: %v", err)
-				wsCleanup(ws, tmpPath, "Failed to send acknowledgement")
-				return
-			}
+// withGrant adds the negotiated protocol version, and from version 2 the
+// granted window, to the reply that accepts the upload.
+func (i *uploadInit) withGrant(reply gin.H) gin.H {
+	reply["protocolVersion"] = i.ProtocolVersion
+	if i.ProtocolVersion >= 2 {
+		reply["window"] = i.Window
+	}
+	return reply
+}
+
+// ackInterval returns how many chunks the server reads between acks with
+// the given window. Acking once per half window keeps a window's worth in
+// flight; version 1 clients expect an ack for every chunk.
+func (i *uploadInit) ackInterval(window int) int64 {
+	if i.ProtocolVersion < 2 {
+		return 1
+	}
+	return int64(max(1, window/2))
+}
+
+// sealedChunkBytes returns the size of a full encrypted chunk of this
+// upload: the requested or default chunk size plus the GCM tag.
+func (i *uploadInit) sealedChunkBytes() int64 {
+	return int64(i.chunkMB())*1024*1024 + 16
+}
+
+// chunkMB returns the requested or default plaintext chunk size in MB.
+func (i *uploadInit) chunkMB() int {
+	if i.ChunkSize == 0 {
+		return GlobalConfig.ChunkSize
+	}
+	return i.ChunkSize
+}
+
+// uploadTarget is an upload past its handshake: the partial file being
+// written and where it lands on completion.
+type uploadTarget struct {
+	id         string
+	finalPath  string
+	tmpPath    string
+	file       *os.File
+	w          *bufio.Writer
+	totalBytes int64 // bytes already in the partial file
+	init       *uploadInit
+	limiter    *rate.Limiter // throttles inbound chunks
+}
+
+// receiveChunks reads encrypted chunks until the end marker, then moves the
+// finished file into place and reports completion.
+func receiveChunks(c *gin.Context, ws *websocket.Conn, t *uploadTarget, metrics *telemetry.Provider) {
+	// A dropped connection keeps the partial file of a resumable upload.
+	dropped := func(message string) {
+		if t.init.Resumable || t.init.Resume {
+			sendWSError(ws, message)
+			return
 		}
+		wsCleanup(ws, t.tmpPath, message)
+	}
 
-		// 8. Finalization
-		// Ensure all buffered data is flushed before closing/renaming
-		if err := bufWriter.Flush(); err != nil {
-			wsCleanup(ws, tmpPath, "Error flushing buffer")
+	totalBytes := t.totalBytes
+	pacer := newDiskPacer(t.init.Window)
+	defer pacer.close()
+	ackEvery := t.init.ackInterval(pacer.window)
+	var received, sinceAck int64
+	ws.SetReadLimit(t.init.sealedChunkBytes())
+	for {
+		if err := t.limiter.Wait(context.Background()); err != nil {
+			dropped("Failed to read chunk")
 			return
 		}
-		if err := file.Close(); err != nil { // Close before rename
-			wsCleanup(ws, tmpPath, "Error closing file")
+		kind, chunk, err := ws.ReadMessage()
+		if err != nil {
+			dropped("Failed to read chunk")
+			return
+		}
+		ws.SetReadDeadline(time.Now().Add(pongWait))
+		if kind != websocket.BinaryMessage {
+			wsCleanup(ws, t.tmpPath, "Expected a binary chunk")
 			return
 		}
 
-		if err := os.Rename(tmpPath, finalPath); err != nil {
-			os.Remove(tmpPath) // Clean up temp file if rename fails
-			sendWSError(ws, "Failed to save file")
+		// End signal (single byte 0)
+		if len(chunk) == 1 && chunk[0] == 0 {
+			break
+		}
+
+		// Validate size
+		if int64(len(chunk)) > t.init.sealedChunkBytes() {
+			wsCleanup(ws, t.tmpPath, "Chunk size exceeds maximum")
+			return
+		}
+		if len(chunk) < 16 { // must at least contain GCM tag
+			wsCleanup(ws, t.tmpPath, "Chunk size too small")
 			return
 		}
 
-		metrics.RecordTransfer(c.Request.Context(), "upload", totalBytes, true, "websocket")
-		metrics.RecordUpload(c.Request.Context(), totalBytes, true, "websocket")
+		chunkSize := int64(len(chunk))
+		projectedTotal := totalBytes + chunkSize
+		if projectedTotal > t.init.maxFileSize() {
+			wsCleanup(ws, t.tmpPath, "File too large")
+			return
+		}
 
-		// 10. Send Completion Message
-		if err := wsWriteJSON(ws, gin.H{
-			"type": "complete",
-			"id":   id,
-			"size": totalBytes,
-		}); err != nil {
-			log.Printf("Failed to send complete message: %v", err)
+		// Persist chunk to disk BEFORE acknowledging.
+		// An ACK sent before the write succeeds would make a disk error look like a
+		// sudden connection drop to the client, because the server close frame races
+		// the in-flight next chunk from the client.
+		if err := pacer.write(t.w, chunk); err != nil {
+			uploadFailed("Failed to write chunk", err)
+			wsCleanup(ws, t.tmpPath, "Failed to write chunk")
+			return
+		}
+		totalBytes = projectedTotal
+		telemetry.Activity.AddBytes("upload", int64(len(chunk)))
+		received++
+		sinceAck++
+		if sinceAck < ackEvery {
+			continue
+		}
+		sinceAck = 0
+
+		// A disk that can't keep up holds back the ack, and the client
+		// with it, rather than have chunks pile up in memory.
+		if err := pacer.settle(t.w); err != nil {
+			uploadFailed("Failed to write chunk", err)
+			wsCleanup(ws, t.tmpPath, "Failed to write chunk")
+			return
 		}
+		ackEvery = t.init.ackInterval(pacer.window)
+
+		// ACK only after the chunk is safely written to the buffer.
+		// Cumulative acks count the chunks of this connection, give the
+		// offset the stored blob has reached and the window the client
+		// may now keep in flight.
+		ack := gin.H{"type": "ack", "ack": chunkSize}
+		if t.init.ProtocolVersion >= 2 {
+			ack = gin.H{"type": "ack", "chunks": received, "offset": totalBytes, "window": pacer.window}
+		}
+		if err := wsWriteJSON(ws, ack); err != nil {
+			log.Printf("Failed to send acknowledgement: %v", err)
+			dropped("Failed to send acknowledgement")
+			return
+		}
+	}
+
+	// 8. Finalization
+	// Ensure all buffered data is flushed before closing/renaming
+	if err := t.w.Flush(); err != nil {
+		uploadFailed("Error flushing buffer", err)
+		wsCleanup(ws, t.tmpPath, "Error flushing buffer")
+		return
+	}
+	if err := t.file.Close(); err != nil { // Close before rename
+		uploadFailed("Error closing file", err)
+		wsCleanup(ws, t.tmpPath, "Error closing file")
+		return
+	}
+
+	// Write the policy first so the blob is never visible without it.
+	// A namespace's retention replaces the sweep's for its files.
+	policy := storage.Policy{Namespace: t.init.namespaceName()}
+	expiresIn := t.init.ExpiresIn
+	if expiresIn == 0 && t.init.namespace != nil {
+		expiresIn = t.init.namespace.RetentionSeconds
+	}
+	if expiresIn > 0 {
+		policy.ExpiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second).UTC()
+	}
+	if t.init.MaxDownloads > 1 {
+		policy.MaxDownloads = t.init.MaxDownloads
+	}
+	// The owner token is only ever sent in the completion message; the
+	// sidecar keeps its hash.
+	ownerToken, err := generateID(128)
+	if err != nil {
+		uploadFailed("Failed to generate owner token", err)
+		wsCleanup(ws, t.tmpPath, "Failed to save file")
+		return
+	}
+	policy.Owner = storage.HashOwnerToken(ownerToken)
+	if err := storage.SavePolicy(t.finalPath, policy); err != nil {
+		uploadFailed("Failed to write file policy", err)
+		wsCleanup(ws, t.tmpPath, "Failed to save file")
+		return
+	}
+
+	if err := os.Rename(t.tmpPath, t.finalPath); err != nil {
+		uploadFailed("Failed to move upload into place", err)
+		storage.Remove(t.tmpPath) // Clean up temp file if rename fails
+		storage.Remove(t.finalPath)
+		sendWSError(ws, "Failed to save file")
+		return
+	}
+	os.Remove(storage.PolicyPath(t.tmpPath))
+
+	metrics.RecordTransfer(c.Request.Context(), "upload", totalBytes, true, "websocket")
+	metrics.RecordUpload(c.Request.Context(), totalBytes, true, "websocket", policy.Namespace)
+
+	// 10. Send Completion Message
+	complete := gin.H{
+		"type": "complete",
+		"id":   t.id,
+		"size": totalBytes,
+	}
+	if !policy.ExpiresAt.IsZero() {
+		complete["expiresAt"] = policy.ExpiresAt.Format(time.RFC3339)
+	}
+	complete["maxDownloads"] = max(1, policy.MaxDownloads)
+	complete["ownerToken"] = ownerToken
+	if err := wsWriteJSON(ws, complete); err != nil {
+		log.Printf("Failed to send complete message: %v", err)
+	}
+}
+
+// resumeUpload continues an interrupted resumable upload. The partial file
+// is cut back to its last whole chunk and the client is told how many
+// chunks the server already has; from there the upload proceeds like a
+// fresh one.
+func resumeUpload(c *gin.Context, ws *websocket.Conn, volumes storage.Volumes, init *uploadInit, limiter *rate.Limiter, metrics *telemetry.Provider) {
+	if !validateID(init.FileID) || !validateToken(init.Token) {
+		sendWSError(ws, "Invalid resume request")
+		return
+	}
+	finalPath := volumes.Locate(init.FileID + "." + init.Token)
+	tmpPath := storage.PartialPath(finalPath)
+
+	// The partial itself must not count as a conflict, but a second
+	// client resuming the same upload must.
+	release, err := storage.Reserve(volumes, init.FileID, tmpPath)
+	if errors.Is(err, storage.ErrIDInUse) && partialIdle(tmpPath) {
+		// A live upload writes its partial at least every pongWait, so
+		// the reservation outlived the server process that held it.
+		log.Printf("Breaking stale reservation of %s", init.FileID)
+		storage.BreakReservation(volumes, init.FileID)
+		release, err = storage.Reserve(volumes, init.FileID, tmpPath)
+	}
+	if errors.Is(err, storage.ErrIDInUse) {
+		sendWSErrorCode(ws, errCodeIDInUse, "Upload is already in progress")
+		return
+	}
+	if err != nil {
+		sendWSError(ws, "Failed to check for existing files")
+		return
 	}
+	defer release()
+
+	file, err := os.OpenFile(tmpPath, os.O_RDWR, 0)
+	if err != nil {
+		sendWSErrorCode(ws, errCodeNotResumable, "Nothing to resume")
+		return
+	}
+	defer file.Close()
+
+	// Chunks start after the metadata header and the stream IV.
+	var header [16]byte
+	info, err := file.Stat()
+	if err != nil {
+		sendWSError(ws, "Failed to read partial upload")
+		return
+	}
+	dataStart := int64(-1)
+	if _, err := io.ReadFull(file, header[:]); err == nil {
+		dataStart = int64(16 + binary.LittleEndian.Uint32(header[12:16]) + 12)
+	}
+	if dataStart < 0 || info.Size() < dataStart {
+		// Dropped before the stream began; nothing worth keeping.
+		file.Close()
+		storage.Remove(tmpPath)
+		sendWSErrorCode(ws, errCodeNotResumable, "Nothing to resume")
+		return
+	}
+
+	// Partials from before the chunk size was recorded have no sidecar
+	// and are trusted to the client.
+	recorded, err := storage.LoadPolicy(tmpPath)
+	if err != nil {
+		sendWSError(ws, "Failed to read partial upload")
+		return
+	}
+	if recorded.ChunkSize != 0 && recorded.ChunkSize != init.chunkMB() {
+		sendWSError(ws, fmt.Sprintf("Chunk size must be %d MB, as when the upload began", recorded.ChunkSize))
+		return
+	}
+
+	chunkBytes := init.sealedChunkBytes()
+	chunks := (info.Size() - dataStart) / chunkBytes
+	size := dataStart + chunks*chunkBytes
+	if err := file.Truncate(size); err != nil {
+		sendWSError(ws, "Failed to prepare partial upload")
+		return
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		sendWSError(ws, "Failed to prepare partial upload")
+		return
+	}
+
+	if err := wsWriteJSON(ws, init.withGrant(gin.H{"type": "resume", "id": init.FileID, "chunks": chunks})); err != nil {
+		sendWSError(ws, "Failed to acknowledge resume")
+		return
+	}
+
+	bufWriter := bufio.NewWriterSize(file, 512*1024)
+	defer bufWriter.Flush()
+
+	receiveChunks(c, ws, &uploadTarget{
+		id:         init.FileID,
+		finalPath:  finalPath,
+		tmpPath:    tmpPath,
+		file:       file,
+		w:          bufWriter,
+		totalBytes: size,
+		init:       init,
+		limiter:    limiter,
+	}, metrics)
+}
+
+// partialIdle reports whether the partial upload at path exists and has not
+// been written to for longer than any live upload may stay silent.
+func partialIdle(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) > pongWait
 }
 
+// Machine-readable codes for error frames clients are expected to act on.
+const (
+	errCodeIDInUse      = "id_in_use"
+	errCodeNotResumable = "not_resumable"
+	errCodeProtocol     = "unsupported_protocol"
+)
+
 // sendWSError sends a typed error JSON frame and closes the connection.
 func sendWSError(ws *websocket.Conn, message string) {
 	log.Printf("WebSocket error: %s", message)
+	telemetry.Activity.RecordError("websocket", message)
 	_ = wsWriteJSON(ws, gin.H{"type": "error", "error": message})
 	ws.Close()
 }
 
+// sendWSErrorCode is sendWSError with a stable code clients can branch on.
+func sendWSErrorCode(ws *websocket.Conn, code, message string) {
+	log.Printf("WebSocket error: %s", message)
+	telemetry.Activity.RecordError("websocket", message)
+	_ = wsWriteJSON(ws, gin.H{"type": "error", "error": message, "code": code})
+	ws.Close()
+}
+
+// uploadFailed logs a failure on the server's side of an upload and
+// reports it. Rejections a client brings on itself are only logged, by
+// sendWSError. The error goes in a tag so one failing disk is one report,
+// whatever the paths involved.
+func uploadFailed(message string, err error) {
+	log.Printf("Error: %s: %v", message, err)
+	reporting.Report(reporting.Event{
+		Level:   reporting.LevelError,
+		Source:  "websocket",
+		Message: message,
+		Tags:    map[string]string{"error": err.Error()},
+	})
+}
+
 // wsCleanup sends an error, closes the connection, and removes any partial temp file.
 func wsCleanup(ws *websocket.Conn, tmpPath string, message string) {
 	sendWSError(ws, message)
 	if _, err := os.Stat(tmpPath); err == nil {
-		if err := os.Remove(tmpPath); err != nil {
+		if err := storage.Remove(tmpPath); err != nil {
 			log.Printf("Failed to remove temporary file: %v", err)
 		}
 	}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jonasbg/paste/m/v2/storage"
+)
+
+// uploadServer serves HandleWSUpload over dir. Each connection's handler
+// signals done when it returns, so a test can wait for a dropped upload's
+// partial and reservation to settle.
+type uploadServer struct {
+	*httptest.Server
+	dir  string
+	done chan struct{}
+}
+
+func newUploadServer(t *testing.T) *uploadServer {
+	t.Helper()
+	if err := InitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	s := &uploadServer{dir: t.TempDir(), done: make(chan struct{}, 1)}
+	upload := HandleWSUpload(storage.Volumes{s.dir}, nil, nil)
+	r := gin.New()
+	r.GET("/ws/upload", func(c *gin.Context) {
+		upload(c)
+		s.done <- struct{}{}
+	})
+	s.Server = httptest.NewServer(r)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *uploadServer) dial(t *testing.T) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ws/upload", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// reply reads the next JSON message and fails unless it has the given type.
+func reply(t *testing.T, ws *websocket.Conn, want string) map[string]any {
+	t.Helper()
+	var msg map[string]any
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatalf("waiting for %s: %v", want, err)
+	}
+	if msg["type"] != want {
+		t.Fatalf("got %v, want %s", msg, want)
+	}
+	return msg
+}
+
+func send(t *testing.T, ws *websocket.Conn, kind int, data []byte) {
+	t.Helper()
+	if err := ws.WriteMessage(kind, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// interruptedUpload starts a resumable upload with 1 MB chunks, sends one
+// whole chunk and the start of a second, and drops the connection. It
+// returns the upload's ID and token and everything the server has kept.
+func interruptedUpload(t *testing.T, s *uploadServer) (id, token string, kept []byte) {
+	t.Helper()
+	ws := s.dial(t)
+	if err := ws.WriteJSON(gin.H{"type": "init", "streaming": true, "resumable": true, "chunkSize": 1}); err != nil {
+		t.Fatal(err)
+	}
+	id = reply(t, ws, "id")["id"].(string)
+	token = strings.Repeat("a", GlobalConfig.TokenMinLength)
+	if err := ws.WriteJSON(gin.H{"type": "token", "token": token}); err != nil {
+		t.Fatal(err)
+	}
+	reply(t, ws, "token_accepted")
+
+	// A header carrying 8 bytes of metadata, then the stream IV.
+	header := make([]byte, 16+8)
+	binary.LittleEndian.PutUint32(header[12:16], 8)
+	send(t, ws, websocket.BinaryMessage, header)
+	reply(t, ws, "ready")
+	iv := bytes.Repeat([]byte{1}, 12)
+	send(t, ws, websocket.BinaryMessage, iv)
+
+	chunk := bytes.Repeat([]byte{2}, 1<<20+16)
+	send(t, ws, websocket.BinaryMessage, chunk)
+	reply(t, ws, "ack")
+	send(t, ws, websocket.BinaryMessage, bytes.Repeat([]byte{3}, 1000))
+	reply(t, ws, "ack")
+	ws.Close()
+	<-s.done
+
+	return id, token, append(append(header, iv...), chunk...)
+}
+
+func TestResumeUpload(t *testing.T) {
+	s := newUploadServer(t)
+	id, token, kept := interruptedUpload(t, s)
+	partial := storage.PartialPath(filepath.Join(s.dir, id+"."+token))
+	if p, err := storage.LoadPolicy(partial); err != nil || p.ChunkSize != 1 {
+		t.Fatalf("partial's sidecar %+v, %v, want chunk size 1", p, err)
+	}
+
+	ws := s.dial(t)
+	if err := ws.WriteJSON(gin.H{"type": "init", "resume": true, "fileId": id, "token": token, "chunkSize": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// The half-received second chunk is dropped.
+	if chunks := reply(t, ws, "resume")["chunks"]; chunks != 1.0 {
+		t.Fatalf("resume at chunk %v, want 1", chunks)
+	}
+	last := bytes.Repeat([]byte{4}, 5000)
+	send(t, ws, websocket.BinaryMessage, last)
+	reply(t, ws, "ack")
+	send(t, ws, websocket.BinaryMessage, []byte{0})
+	complete := reply(t, ws, "complete")
+	want := append(kept, last...)
+	if complete["size"] != float64(len(want)) {
+		t.Errorf("completed with size %v, want %d", complete["size"], len(want))
+	}
+	<-s.done
+
+	got, err := os.ReadFile(filepath.Join(s.dir, id+"."+token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("stored %d bytes, not the kept part and the resumed chunk (%d bytes)", len(got), len(want))
+	}
+	for _, leftover := range []string{partial, storage.PolicyPath(partial)} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", filepath.Base(leftover))
+		}
+	}
+}
+
+func TestResumeUploadChunkSizeMismatch(t *testing.T) {
+	s := newUploadServer(t)
+	id, token, kept := interruptedUpload(t, s)
+
+	for _, chunkSize := range []int{0, 2} {
+		ws := s.dial(t)
+		init := gin.H{"type": "init", "resume": true, "fileId": id, "token": token}
+		if chunkSize != 0 {
+			init["chunkSize"] = chunkSize
+		}
+		if err := ws.WriteJSON(init); err != nil {
+			t.Fatal(err)
+		}
+		if msg := reply(t, ws, "error"); !strings.Contains(msg["error"].(string), "Chunk size") {
+			t.Errorf("chunk size %d: error %q", chunkSize, msg["error"])
+		}
+		<-s.done
+	}
+
+	// The partial is left as it was for a resume that gets it right.
+	partial := storage.PartialPath(filepath.Join(s.dir, id+"."+token))
+	info, err := os.Stat(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(kept))+1000 {
+		t.Errorf("partial is %d bytes after rejected resumes, want %d", info.Size(), len(kept)+1000)
+	}
+}
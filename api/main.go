@@ -1,82 +1,181 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/auth"
 	"github.com/jonasbg/paste/m/v2/cleanup"
 	"github.com/jonasbg/paste/m/v2/handlers"
 	"github.com/jonasbg/paste/m/v2/middleware"
+	"github.com/jonasbg/paste/m/v2/namespaces"
+	"github.com/jonasbg/paste/m/v2/reporting"
+	"github.com/jonasbg/paste/m/v2/storage"
 	"github.com/jonasbg/paste/m/v2/telemetry"
 	"github.com/jonasbg/paste/m/v2/utils"
-	"golang.org/x/time/rate"
 )
 
 const (
-	requestsPerSecond = 60
-	burstSize         = 120
+	// maxRequestBody caps the bodies of plain HTTP requests; file data only
+	// travels over WebSockets.
+	maxRequestBody = 64 << 10
+	// readHeaderTimeout drops clients that trickle their request headers.
+	// There is no read or write timeout for whole requests: downloads may
+	// run for hours, and WebSocket handlers set their own deadlines.
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 120 * time.Second
 )
 
-func getUploadDir() string {
-	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
-		return dir
+// getUploadDirs reads UPLOAD_DIRS, a comma-separated list of directories
+// to spread uploads across, such as one per disk. Without it uploads go
+// to UPLOAD_DIR alone.
+func getUploadDirs() (storage.Volumes, error) {
+	value := os.Getenv("UPLOAD_DIRS")
+	if value == "" {
+		return storage.Volumes{utils.GetEnv("UPLOAD_DIR", "./uploads")}, nil
 	}
-	return "./uploads"
+	var volumes storage.Volumes
+	for _, dir := range strings.Split(value, ",") {
+		dir = filepath.Clean(strings.TrimSpace(dir))
+		if dir == "." || slices.Contains(volumes, dir) {
+			return nil, fmt.Errorf("invalid UPLOAD_DIRS. Must list distinct directories separated by commas")
+		}
+		volumes = append(volumes, dir)
+	}
+	return volumes, nil
 }
 
 func main() {
-	uploadDir := getUploadDir()
-	if err := os.MkdirAll(uploadDir, 0750); err != nil {
-		log.Fatalf("Failed to create upload directory: %v", err)
+	volumes, err := getUploadDirs()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	for _, dir := range volumes {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			log.Fatalf("Failed to create upload directory: %v", err)
+		}
+	}
+	spaDirectory := filepath.Clean(utils.GetEnv("WEB_DIR", "../web"))
 
 	telemetryProvider, err := telemetry.Init(context.Background())
 	if err != nil {
 		log.Fatalf("Failed to initialize telemetry: %v", err)
 	}
 
-	handlers.InitConfig()
+	if err := reporting.Init(); err != nil {
+		log.Fatalf("Failed to initialize error reporting: %v", err)
+	}
+
+	if err := handlers.InitConfig(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := cleanup.CheckEnv(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := utils.CheckRealIPEnv(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	requestLogger, err := middleware.PrivacyLogger(os.Getenv("REQUEST_LOG_EXCLUDE"), os.Getenv("REQUEST_LOG_SAMPLE"))
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	keys, err := namespaces.Open(utils.GetEnv("API_KEYS_FILE", "keys.json"))
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
 
-	limiter := middleware.NewIPRateLimiter(rate.Limit(requestsPerSecond), burstSize)
+	// The cheap endpoints and transfers have separate allowances, so
+	// polling the one can't hold up the other
+	apiRate, apiBurst, err := middleware.APILimit()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	transferRate, transferBurst, err := middleware.TransferLimit()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	limiter := middleware.NewIPRateLimiter(apiRate, apiBurst)
+	transferLimiter := middleware.NewIPRateLimiter(transferRate, transferBurst)
 
 	r := gin.New()
+	// Client addresses come from utils.GetRealIP, which follows
+	// REAL_IP_HEADER and PROXY_HOPS, not from gin's ClientIP.
 	r.SetTrustedProxies(utils.GetTrustedProxies())
-	r.TrustedPlatform = "X-Forwarded-For"
 
-	r.Use(middleware.PrivacyLogger(), gin.Recovery())
+	r.Use(requestLogger, middleware.Recovery())
+	r.Use(middleware.CORS(os.Getenv("ALLOWED_ORIGINS"), os.Getenv("ALLOWED_HEADERS")))
+	r.Use(middleware.BodyLimit(maxRequestBody))
 	r.Use(telemetryProvider.Middleware())
 
-	// Add compression middleware with custom options
-	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedExtensions([]string{".pdf", ".mp4", ".avi", ".mov"}),
-		// Exclude websocket endpoints and raw download endpoint (already encrypted/compressed data)
-		gzip.WithExcludedPaths([]string{"/api/ws", "/api/download"})))
+	// Compression follows the response's content type, so ciphertext,
+	// WebSockets and the self-compressing metrics endpoint pass through
+	r.Use(middleware.Compress(gzip.DefaultCompression))
 
-	api := r.Group("/api")
-	api.Use(middleware.RateLimit(limiter))
+	api := r.Group("/api", middleware.RateLimit(limiter))
+	transfers := r.Group("/api", middleware.RateLimit(transferLimiter))
 	{
-		api.GET("/config", handlers.GetConfig())
-		api.GET("/metadata/:id", handlers.HandleMetadata(uploadDir))
-		api.GET("/download/:id", handlers.HandleDownload(uploadDir))
-		api.DELETE("/delete/:id", handlers.HandleDelete(uploadDir))
+		api.GET("/config", handlers.GetConfig(keys))
+		api.GET("/metadata/:id", handlers.HandleMetadata(volumes))
+		api.DELETE("/delete/:id", handlers.HandleDelete(volumes))
+		api.GET("/stats/:id", handlers.HandleStats(volumes))
+
+		transfers.GET("/download/:id", handlers.HandleDownload(volumes))
+		transfers.HEAD("/download/:id", handlers.HandleDownload(volumes))
+		transfers.GET("/ws/upload", handlers.HandleWSUpload(volumes, keys, telemetryProvider))
+		transfers.GET("/ws/download", handlers.HandleWSDownload(volumes, telemetryProvider))
+	}
 
-		api.GET("/ws/upload", handlers.HandleWSUpload(uploadDir, telemetryProvider))
-		api.GET("/ws/download", handlers.HandleWSDownload(uploadDir, telemetryProvider))
+	// Keys and namespaces are managed through the admin API, which only
+	// exists with an ADMIN_TOKEN or an OIDC issuer. Viewers may list,
+	// admins may also change.
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	oidc, err := auth.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid OIDC configuration: %v", err)
+	}
+	if adminToken != "" || oidc != nil {
+		view := middleware.AdminAuth(adminToken, oidc, auth.RoleViewer)
+		manage := middleware.AdminAuth(adminToken, oidc, auth.RoleAdmin)
+		admin := api.Group("/admin")
+		admin.GET("/keys", view, handlers.HandleListKeys(keys))
+		admin.POST("/keys", manage, handlers.HandleCreateKey(keys))
+		admin.DELETE("/keys/:id", manage, handlers.HandleRevokeKey(keys))
+		admin.GET("/namespaces", view, handlers.HandleListNamespaces(keys))
+		admin.PUT("/namespaces/:name", manage, handlers.HandleSetNamespace(keys))
+		admin.POST("/purge", manage, handlers.HandlePurge(volumes, telemetryProvider))
+		admin.GET("/holds", view, handlers.HandleListHolds(volumes))
+		admin.PUT("/holds/:id", manage, handlers.HandleHold(volumes))
+		admin.DELETE("/holds/:id", manage, handlers.HandleRelease(volumes))
+		admin.GET("/config", view, handlers.HandleSettings(volumes, spaDirectory))
+		admin.GET("/trash", view, handlers.HandleListTrash(volumes))
+		admin.POST("/trash/:id/restore", manage, handlers.HandleRestore(volumes))
+		// Next to the other WebSockets
+		api.GET("/ws/metrics", view, handlers.HandleWSMetrics())
 	}
 
-	if err := telemetry.MountPrometheusRoute(r, telemetryProvider.PrometheusHandler()); err != nil {
+	// With OIDC the metrics need a viewer too; otherwise they stay open,
+	// for a proxy or the network to restrict.
+	var metricsGuard []gin.HandlerFunc
+	if oidc != nil {
+		metricsGuard = append(metricsGuard, middleware.AdminAuth(adminToken, oidc, auth.RoleViewer))
+	}
+	if err := telemetry.MountPrometheusRoute(r, telemetryProvider.PrometheusHandler(), metricsGuard...); err != nil {
 		log.Fatalf("Failed to mount telemetry endpoint: %v", err)
 	}
 
-	spaDirectory := utils.GetEnv("WEB_DIR", "../web")
-	spaDirectory = filepath.Clean(spaDirectory)
-
 	if _, err := os.Stat(spaDirectory); os.IsNotExist(err) {
 		log.Fatalf("Static files directory does not exist: %s", spaDirectory)
 	}
@@ -91,7 +190,11 @@ func main() {
 
 	r.Use(middleware.Middleware("/", spaDirectory))
 
-	cleanup.StartFileCleanup(uploadDir)
+	cleanup.StartFileCleanup(volumes, telemetryProvider)
+
+	if settings, err := json.Marshal(handlers.CurrentSettings(volumes, spaDirectory)); err == nil {
+		log.Printf("Effective configuration: %s", settings)
+	}
 
 	go func() {
 		c := make(chan os.Signal, 1)
@@ -104,10 +207,19 @@ func main() {
 		if err := telemetryProvider.Shutdown(shutdownCtx); err != nil {
 			log.Printf("Telemetry shutdown failed: %v", err)
 		}
+		if err := reporting.Flush(shutdownCtx); err != nil {
+			log.Printf("Error reports not sent: %v", err)
+		}
 		log.Println("Server shutdown complete")
 		os.Exit(0)
 	}()
 
-	log.Printf("Starting server on :8080 with upload directory: %s", uploadDir)
-	log.Fatal(r.Run(":8080"))
+	srv := &http.Server{
+		Addr:              ":8080",
+		Handler:           r.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	log.Printf("Starting server on :8080 with upload directories: %s", strings.Join(volumes, ", "))
+	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/auth"
+)
+
+// AdminAuth admits requests whose bearer token grants at least role need:
+// the static admin token, which grants everything, or an OIDC token whose
+// groups map to a sufficient role. Either may be unset.
+func AdminAuth(token string, oidc *auth.Verifier, need auth.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || given == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			c.Next()
+			return
+		}
+		if oidc == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		role, err := oidc.Verify(c.Request.Context(), given)
+		if err != nil {
+			log.Printf("Rejected OIDC token: %v", err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if role < need {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -2,83 +2,165 @@ package middleware
 
 import (
 	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
 	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/gin-gonic/gin"
 )
 
-// staticFile represents a cached immutable asset kept fully in memory.
+// staticCacheMaxFile is the largest asset kept in memory. Bigger files
+// are served from disk by the SPA middleware.
+const staticCacheMaxFile = 8 << 20
+
+// staticFile represents an asset kept fully in memory.
 type staticFile struct {
-	content      []byte
-	contentType  string
-	etag         string
-	modTime      time.Time
-	lastModified string
+	content     []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+	// immutable is set for fingerprinted build output, whose name changes
+	// with its content.
+	immutable bool
 }
 
-// in-memory cache (only for fingerprinted immutable assets)
+// in-memory cache of the SPA's files, by path relative to the web
+// directory. watchStatic drops entries as files change on disk.
 var (
 	staticCache   = make(map[string]*staticFile)
 	staticCacheMu sync.RWMutex
 )
 
-// Load and cache a static immutable file. Returns nil if not suitable for caching or error.
-func getOrLoadStatic(spaDir, relPath string) *staticFile {
-	// Normalize
+// fingerprinted reports whether relPath is build output named after its
+// content hash: Vite's assets/ directory, or SvelteKit's _app/immutable/.
+func fingerprinted(relPath string) bool {
+	return strings.HasPrefix(relPath, "assets/") || strings.HasPrefix(relPath, "_app/immutable/")
+}
+
+// getOrLoadStatic returns the cached file at relPath, loading it on first
+// use. It reports false if there is no regular file there, and a nil file
+// for one too large to cache.
+func getOrLoadStatic(spaDir, relPath string) (*staticFile, bool) {
 	relPath = strings.TrimPrefix(relPath, "/")
-	// Only cache files with a fingerprint-like name (simple heuristic: contains a dot and a hash-looking segment)
-	if !strings.Contains(relPath, ".") {
-		return nil
-	}
-	ext := filepath.Ext(relPath)
-	switch ext {
-	case ".js", ".css", ".wasm", ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".ico", ".ttf", ".woff", ".woff2":
-	default:
-		return nil
-	}
 
 	staticCacheMu.RLock()
-	if f, ok := staticCache[relPath]; ok {
-		staticCacheMu.RUnlock()
-		return f
-	}
+	f, ok := staticCache[relPath]
 	staticCacheMu.RUnlock()
+	if ok {
+		return f, true
+	}
 
 	// Load file from disk
-	fullPath := filepath.Join(spaDir, relPath)
+	fullPath := filepath.Join(spaDir, filepath.FromSlash(relPath))
 	info, err := os.Stat(fullPath)
-	if err != nil || info.IsDir() {
-		return nil
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	if info.Size() > staticCacheMaxFile {
+		return nil, true
 	}
 	data, err := os.ReadFile(fullPath)
 	if err != nil {
-		return nil
+		return nil, false
 	}
 	h := sha1.Sum(data)
+	immutable := fingerprinted(relPath)
 	etag := fmt.Sprintf("\"%x\"", h[:])
-	modTime := info.ModTime().UTC().Truncate(time.Second)
-	sf := &staticFile{
-		content:      data,
-		contentType:  mimeTypeByExtension(ext),
-		etag:         etag,
-		modTime:      modTime,
-		lastModified: modTime.Format(http.TimeFormat),
+	if !immutable {
+		// Only meant to tell versions apart, not to allow range requests
+		// across them
+		etag = "W/" + etag
+	}
+	f = &staticFile{
+		content:     data,
+		contentType: mimeTypeByExtension(filepath.Ext(relPath)),
+		etag:        etag,
+		modTime:     info.ModTime().UTC().Truncate(time.Second),
+		immutable:   immutable,
 	}
 
 	staticCacheMu.Lock()
-	staticCache[relPath] = sf
+	staticCache[relPath] = f
 	staticCacheMu.Unlock()
-	return sf
+	return f, true
+}
+
+// forgetStatic drops relPath, and anything cached below it if it was a
+// directory, from the cache.
+func forgetStatic(relPath string) {
+	staticCacheMu.Lock()
+	defer staticCacheMu.Unlock()
+	delete(staticCache, relPath)
+	for p := range staticCache {
+		if strings.HasPrefix(p, relPath+"/") {
+			delete(staticCache, p)
+		}
+	}
+}
+
+// watchStatic keeps the cache in step with spaDir, so a deploy that
+// replaces files in place is served without a restart. fsnotify watches
+// single directories, so each subdirectory is added, including ones
+// created later.
+func watchStatic(spaDir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	add := func(dir string) error {
+		return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			return watcher.Add(path)
+		})
+	}
+	if err := add(spaDir); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				rel, err := filepath.Rel(spaDir, ev.Name)
+				if err != nil {
+					continue
+				}
+				forgetStatic(filepath.ToSlash(rel))
+				if ev.Has(fsnotify.Create) {
+					if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+						if err := add(ev.Name); err != nil {
+							log.Printf("Failed to watch %s: %v", ev.Name, err)
+						}
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// Events may have been lost; start over from disk
+				log.Printf("Static file watcher: %v", err)
+				staticCacheMu.Lock()
+				clear(staticCache)
+				staticCacheMu.Unlock()
+			}
+		}
+	}()
+	return nil
 }
 
 func mimeTypeByExtension(ext string) string {
@@ -91,11 +173,14 @@ func mimeTypeByExtension(ext string) string {
 	return "application/octet-stream"
 }
 
-// CacheHeaders sets caching headers based on request path / extension.
+// CacheHeaders serves the SPA's files from memory with caching headers.
+// Fingerprinted build output is cached for a year; everything else,
+// index.html and wasm_exec.js included, is revalidated on each use
+// against a weak ETag and Last-Modified. Paths that aren't files get
+// index.html, as from the SPA middleware.
 func CacheHeaders(spaDir string) gin.HandlerFunc {
-	immutableExt := map[string]struct{}{
-		".js": {}, ".css": {}, ".wasm": {}, ".png": {}, ".jpg": {}, ".jpeg": {},
-		".gif": {}, ".svg": {}, ".webp": {}, ".ico": {}, ".ttf": {}, ".woff": {}, ".woff2": {},
+	if err := watchStatic(spaDir); err != nil {
+		log.Printf("Failed to watch %s, changed web files need a restart: %v", spaDir, err)
 	}
 
 	return func(c *gin.Context) {
@@ -107,56 +192,48 @@ func CacheHeaders(spaDir string) gin.HandlerFunc {
 			c.Header("Cache-Control", "no-store")
 			return
 		}
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			return
+		}
 
-		// SPA entry points
-		if p == "/" || p == "/index.html" {
+		rel := strings.TrimPrefix(path.Clean(p), "/")
+		if rel == "" {
+			rel = "index.html"
+		}
+		f, ok := getOrLoadStatic(spaDir, rel)
+		if !ok {
+			// Client-side route
+			f, ok = getOrLoadStatic(spaDir, "index.html")
+		}
+		if !ok {
+			return
+		}
+		if f == nil {
+			// Too large to cache; the SPA middleware serves it from disk,
+			// with Last-Modified
 			c.Header("Cache-Control", "no-cache")
-			// Optional weak ETag for index.html (best-effort)
-			if f, err := http.Dir(spaDir).Open("index.html"); err == nil {
-				defer f.Close()
-				h := sha1.New()
-				io.CopyN(h, f, 32*1024) // partial hash is enough
-				c.Header("ETag", `W/"`+hex.EncodeToString(h.Sum(nil))+`"`)
-			}
 			return
 		}
 
-		// Static immutable assets (assumes fingerprinted names in builds)
-		ext := filepath.Ext(p)
-		if _, ok := immutableExt[ext]; ok {
-			if f := getOrLoadStatic(spaDir, p); f != nil {
-				c.Header("Cache-Control", "public,max-age=31536000,immutable")
-				c.Header("ETag", f.etag)
-				c.Header("Last-Modified", f.lastModified)
-
-				// Conditional request handling
-				inm := c.GetHeader("If-None-Match")
-				ims := c.GetHeader("If-Modified-Since")
-				if (inm != "" && inm == f.etag) || (ims != "" && ims == f.lastModified) {
-					c.Status(http.StatusNotModified)
-					c.Abort()
-					return
-				}
-
-				// Serve from memory directly; writer not yet written by other middleware.
-				c.Header("Content-Type", f.contentType)
-				br := &bytesReader{b: f.content}
-				http.ServeContent(c.Writer, c.Request, p, f.modTime, br)
-				c.Abort()
-				return
-			}
-			// Fallback: still mark as immutable even if not cached
+		if f.immutable {
 			c.Header("Cache-Control", "public,max-age=31536000,immutable")
-			return
+		} else {
+			c.Header("Cache-Control", "no-cache")
 		}
-
-		// Fallback: short cache
-		c.Header("Cache-Control", "public,max-age=300")
+		c.Header("ETag", f.etag)
+		c.Header("Content-Type", f.contentType)
+		// ServeContent answers If-None-Match and If-Modified-Since with a
+		// 304 and handles Range requests.
+		http.ServeContent(c.Writer, c.Request, rel, f.modTime, &bytesReader{b: f.content})
+		c.Abort()
 	}
 }
 
 // bytesReader implements io.ReadSeeker for a byte slice without copying.
-type bytesReader struct{ b []byte; off int64 }
+type bytesReader struct {
+	b   []byte
+	off int64
+}
 
 func (r *bytesReader) Read(p []byte) (int, error) {
 	if r.off >= int64(len(r.b)) {
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Compress gzips responses whose content type compresses well: text,
+// JSON, JavaScript, XML, SVG and WebAssembly. The decision is made when
+// the handler starts writing, from the headers it set, so new routes
+// need no exclusion list:
+//
+//   - ciphertext goes out as application/octet-stream and passes through,
+//     as does any other type not known to compress;
+//   - responses the handler encoded itself, such as the Prometheus
+//     endpoint's, keep their Content-Encoding;
+//   - WebSocket upgrades and HEAD requests are never wrapped.
+func Compress(level int) gin.HandlerFunc {
+	pool := sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, level)
+		return gz
+	}}
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead ||
+			!strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") ||
+			c.GetHeader("Upgrade") != "" {
+			return
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, pool: &pool}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// compressWriter starts compressing on the first write if the response
+// qualifies, and otherwise writes straight through.
+type compressWriter struct {
+	gin.ResponseWriter
+	pool    *sync.Pool
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide picks compression once the handler's headers are final.
+func (w *compressWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	h := w.Header()
+	status := w.Status()
+	// A partial response is a byte range of the uncompressed body
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	// The compressed body is no longer byte-identical to the entity
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.gz = w.pool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.gz == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	w.decide()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) Flush() {
+	w.decide()
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap exposes the connection's writer, for http.ResponseController and
+// sendfile, only while the body isn't being compressed.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	if w.gz != nil {
+		return struct{ http.ResponseWriter }{w}
+	}
+	if u, ok := w.ResponseWriter.(interface{ Unwrap() http.ResponseWriter }); ok {
+		return u.Unwrap()
+	}
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(io.Discard)
+	w.pool.Put(w.gz)
+	w.gz = nil
+}
+
+// compressible reports whether a body of contentType is worth gzipping.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml",
+		"application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
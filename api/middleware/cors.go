@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/utils"
+)
+
+// corsDefaultHeaders are the request headers the API reads.
+var corsDefaultHeaders = []string{"Content-Type", "X-HMAC-Token", "X-Owner-Token", "X-API-Key"}
+
+// corsExposedHeaders are the response headers clients need for downloads.
+var corsExposedHeaders = []string{"Content-Length", "Content-Range", "X-File-Size", "X-Expires-At", "X-Downloads-Remaining"}
+
+// CORS lets web apps on other origins call the API under /api. Origins is
+// a comma-separated list, or "*" for any; without any, only the server's
+// own pages can, as before. Headers replaces the request headers allowed
+// in addition to simple ones, which default to those the API reads.
+//
+// It must run on the engine rather than the /api group: preflight
+// requests are OPTIONS, which no route matches.
+func CORS(origins, headers string) gin.HandlerFunc {
+	allowed := utils.SplitList(origins)
+	if len(allowed) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	anyOrigin := slices.Contains(allowed, "*")
+	allowHeaders := strings.Join(corsDefaultHeaders, ", ")
+	if list := utils.SplitList(headers); len(list) > 0 {
+		allowHeaders = strings.Join(list, ", ")
+	}
+	exposeHeaders := strings.Join(corsExposedHeaders, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !anyOrigin && !slices.Contains(allowed, origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			// The browser withholds the response without our headers
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+		}
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", exposeHeaders)
+			c.Next()
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE")
+		h.Set("Access-Control-Allow-Headers", allowHeaders)
+		h.Set("Access-Control-Max-Age", "600")
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -2,21 +2,71 @@ package middleware
 
 import (
 	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/utils"
 )
 
 // PrivacyLogger replaces gin.Logger to avoid printing raw client IPs to stdout.
-func PrivacyLogger() gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+//
+// Busy instances can thin the log out. exclude is a comma-separated list
+// of path prefixes never logged, such as static assets. sample is a
+// comma-separated list of prefix=rate pairs: successful requests under the
+// prefix are logged at that rate, between 0 and 1. The longest matching
+// prefix applies. Failed requests on sampled paths are always logged.
+// An invalid sample list is an error.
+func PrivacyLogger(exclude, sample string) (gin.HandlerFunc, error) {
+	excluded := utils.SplitList(exclude)
+	rates, err := parseSampleRates(sample)
+	if err != nil {
+		return nil, err
+	}
 
-		return fmt.Sprintf("[GIN] %s | %3d | %15s | %-7s %s\n",
-			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
-			param.StatusCode,
-			param.Latency.Truncate(time.Microsecond),
-			param.Method,
-			param.Path,
-		)
-	})
+	return gin.LoggerWithConfig(gin.LoggerConfig{
+		Formatter: func(param gin.LogFormatterParams) string {
+			return fmt.Sprintf("[GIN] %s | %3d | %15s | %-7s %s\n",
+				param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+				param.StatusCode,
+				param.Latency.Truncate(time.Microsecond),
+				param.Method,
+				param.Path,
+			)
+		},
+		Skip: func(c *gin.Context) bool {
+			// The SPA fallback rewrites URL.Path by now
+			path, _, _ := strings.Cut(c.Request.RequestURI, "?")
+			for _, prefix := range excluded {
+				if strings.HasPrefix(path, prefix) {
+					return true
+				}
+			}
+			if c.Writer.Status() >= 400 {
+				return false
+			}
+			rate, match := 1.0, ""
+			for prefix, r := range rates {
+				if strings.HasPrefix(path, prefix) && len(prefix) > len(match) {
+					rate, match = r, prefix
+				}
+			}
+			return rand.Float64() >= rate
+		},
+	}), nil
+}
+
+func parseSampleRates(s string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	for _, pair := range utils.SplitList(s) {
+		prefix, value, _ := strings.Cut(pair, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid REQUEST_LOG_SAMPLE entry %q. Must be prefix=rate with a rate between 0 and 1", pair)
+		}
+		rates[strings.TrimSpace(prefix)] = rate
+	}
+	return rates, nil
 }
@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"fmt"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/utils"
 	"golang.org/x/time/rate"
 )
 
@@ -31,6 +35,48 @@ func NewIPRateLimiter(r rate.Limit, b int) *IPRateLimiter {
 	return i
 }
 
+// Default per-client limits, in requests per second and burst, for the
+// cheap endpoints (config, metadata, stats, admin) and for transfers
+// (downloads and the upload and download WebSockets).
+const (
+	defaultRate          = 60
+	defaultBurst         = 120
+	defaultTransferRate  = 10
+	defaultTransferBurst = 30
+)
+
+// APILimit is the limit for the cheap endpoints, from RATE_LIMIT and
+// RATE_LIMIT_BURST.
+func APILimit() (rate.Limit, int, error) {
+	return LimitFromEnv("RATE_LIMIT", defaultRate, defaultBurst)
+}
+
+// TransferLimit is the limit for transfers, from TRANSFER_RATE_LIMIT and
+// TRANSFER_RATE_LIMIT_BURST.
+func TransferLimit() (rate.Limit, int, error) {
+	return LimitFromEnv("TRANSFER_RATE_LIMIT", defaultTransferRate, defaultTransferBurst)
+}
+
+// LimitFromEnv reads a rate in requests per second from name and its
+// burst from name+"_BURST", falling back to r and b for unset ones.
+func LimitFromEnv(name string, r rate.Limit, b int) (rate.Limit, int, error) {
+	if value := os.Getenv(name); value != "" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 {
+			return 0, 0, fmt.Errorf("invalid %s. Must be a positive number of requests per second", name)
+		}
+		r = rate.Limit(f)
+	}
+	if value := os.Getenv(name + "_BURST"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid %s_BURST. Must be a positive integer", name)
+		}
+		b = n
+	}
+	return r, b, nil
+}
+
 func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
 	i.mu.Lock()
 	defer i.mu.Unlock()
@@ -63,7 +109,7 @@ func (i *IPRateLimiter) cleanupLoop() {
 
 func RateLimit(limiter *IPRateLimiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !limiter.GetLimiter(c.ClientIP()).Allow() {
+		if !limiter.GetLimiter(utils.GetRealIP(c)).Allow() {
 			c.JSON(429, gin.H{
 				"error":       "Too many requests",
 				"retry_after": "1s",
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jonasbg/paste/m/v2/reporting"
+)
+
+// Recovery replaces gin.Recovery: it still logs the panic and answers
+// 500, and also reports it with its stack.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		reporting.Panic("http", recovered, map[string]string{
+			"http.method": c.Request.Method,
+			"http.route":  c.FullPath(),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
@@ -0,0 +1,206 @@
+// Package namespaces holds the optional API keys and the team namespaces
+// they upload into. Both are kept in one JSON file, written atomically on
+// every change; keys are stored as their SHA-256 only, so the file doesn't
+// grant access by itself.
+package namespaces
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keyPrefix marks API keys, so they are recognisable in configs and logs.
+const keyPrefix = "pk_"
+
+var (
+	ErrUnknownKey       = errors.New("unknown API key")
+	ErrInvalidNamespace = errors.New("namespace names are 1-32 lowercase letters, digits, '-' or '_'")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Namespace holds one team's limits. Zero values fall back to the server's
+// own MAX_FILE_SIZE and retention.
+type Namespace struct {
+	Name             string `json:"name"`
+	MaxFileSizeBytes int64  `json:"max_file_size_bytes,omitempty"`
+	RetentionSeconds int64  `json:"retention_seconds,omitempty"`
+}
+
+// Key is an API key as stored and listed. ID identifies it to the admin
+// API; the key itself is only shown when created.
+type Key struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Namespace string    `json:"namespace"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store is the key and namespace file. A nil *Store has no keys.
+type Store struct {
+	mu   sync.RWMutex
+	path string
+	data storeFile
+}
+
+type storeFile struct {
+	Namespaces map[string]Namespace `json:"namespaces"`
+	Keys       map[string]Key       `json:"keys"` // by hash
+}
+
+// Open loads the store at path. A missing file is an empty store, created
+// on the first change.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: storeFile{Namespaces: map[string]Namespace{}, Keys: map[string]Key{}}}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Namespaces == nil {
+		s.data.Namespaces = map[string]Namespace{}
+	}
+	if s.data.Keys == nil {
+		s.data.Keys = map[string]Key{}
+	}
+	return s, nil
+}
+
+// Lookup resolves an API key to its namespace. A namespace without stored
+// limits comes back with just its name.
+func (s *Store) Lookup(key string) (Namespace, error) {
+	if s == nil || key == "" {
+		return Namespace{}, ErrUnknownKey
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.data.Keys[hashKey(key)]
+	if !ok {
+		return Namespace{}, ErrUnknownKey
+	}
+	if ns, ok := s.data.Namespaces[k.Namespace]; ok {
+		return ns, nil
+	}
+	return Namespace{Name: k.Namespace}, nil
+}
+
+// CreateKey issues a key for namespace and returns it along with its
+// stored form.
+func (s *Store) CreateKey(namespace string) (string, Key, error) {
+	if !namePattern.MatchString(namespace) {
+		return "", Key{}, ErrInvalidNamespace
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", Key{}, err
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	hash := hashKey(key)
+	k := Key{ID: hash[:12], Hash: hash, Namespace: namespace, CreatedAt: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Keys[hash] = k
+	if err := s.save(); err != nil {
+		delete(s.data.Keys, hash)
+		return "", Key{}, err
+	}
+	return key, k, nil
+}
+
+// RevokeKey removes the key with the given ID.
+func (s *Store) RevokeKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, k := range s.data.Keys {
+		if k.ID == id {
+			delete(s.data.Keys, hash)
+			if err := s.save(); err != nil {
+				s.data.Keys[hash] = k
+				return err
+			}
+			return nil
+		}
+	}
+	return ErrUnknownKey
+}
+
+// Keys lists the keys, oldest first.
+func (s *Store) Keys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]Key, 0, len(s.data.Keys))
+	for _, k := range s.data.Keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// SetNamespace stores the limits of ns.Name, replacing earlier ones.
+func (s *Store) SetNamespace(ns Namespace) error {
+	if !namePattern.MatchString(ns.Name) {
+		return ErrInvalidNamespace
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, existed := s.data.Namespaces[ns.Name]
+	s.data.Namespaces[ns.Name] = ns
+	if err := s.save(); err != nil {
+		if existed {
+			s.data.Namespaces[ns.Name] = prev
+		} else {
+			delete(s.data.Namespaces, ns.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Namespaces lists the namespaces with stored limits, by name.
+func (s *Store) Namespaces() []Namespace {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Namespace, 0, len(s.data.Namespaces))
+	for _, ns := range s.data.Namespaces {
+		list = append(list, ns)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// save writes the store; the caller holds the write lock.
+func (s *Store) save() error {
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,111 @@
+// Package reporting passes errors that need an operator's attention to an
+// error tracker, so panics and failing transfers surface somewhere other
+// than the log. Callers still log; without a tracker configured, reports
+// go nowhere else.
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Level is how serious a report is, in Sentry's terms.
+type Level string
+
+const (
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+	LevelFatal   Level = "fatal"
+)
+
+// Event is one report.
+type Event struct {
+	Level   Level
+	Message string
+	// Source is the part of the server that reported it: "http",
+	// "websocket" or "cleanup".
+	Source string
+	Tags   map[string]string
+	// Frames is the stack of a panic, innermost call first.
+	Frames []runtime.Frame
+}
+
+// Reporter sends events somewhere. Report must not block the caller.
+type Reporter interface {
+	Report(Event)
+	// Flush waits until the events reported so far are sent, or ctx ends.
+	Flush(ctx context.Context) error
+}
+
+var current Reporter = nopReporter{}
+
+// Init picks the reporter from the environment: Sentry, or a compatible
+// service such as GlitchTip, when SENTRY_DSN is set. SENTRY_ENVIRONMENT
+// and SENTRY_RELEASE tag its events.
+func Init() error {
+	dsn := strings.TrimSpace(os.Getenv("SENTRY_DSN"))
+	if dsn == "" {
+		return nil
+	}
+	r, err := NewSentry(dsn, os.Getenv("SENTRY_ENVIRONMENT"), os.Getenv("SENTRY_RELEASE"))
+	if err != nil {
+		return err
+	}
+	current = r
+	return nil
+}
+
+// Set replaces the reporter, for servers embedding their own.
+func Set(r Reporter) {
+	current = r
+}
+
+// Report sends e through the configured reporter.
+func Report(e Event) {
+	current.Report(e)
+}
+
+// Errorf reports an error-level message from source.
+func Errorf(source, format string, args ...any) {
+	current.Report(Event{Level: LevelError, Source: source, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf reports a warning from source.
+func Warnf(source, format string, args ...any) {
+	current.Report(Event{Level: LevelWarning, Source: source, Message: fmt.Sprintf(format, args...)})
+}
+
+// Panic reports a recovered panic with the stack it unwound. Call it from
+// the deferred function that recovered.
+func Panic(source string, recovered any, tags map[string]string) {
+	pcs := make([]uintptr, 64)
+	// Skip runtime.Callers and Panic itself
+	n := runtime.Callers(2, pcs)
+	var frames []runtime.Frame
+	it := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := it.Next()
+		frames = append(frames, f)
+		// Start the stack where the panic was raised, not in the recovery
+		if f.Function == "runtime.gopanic" {
+			frames = frames[:0]
+		}
+		if !more {
+			break
+		}
+	}
+	current.Report(Event{Level: LevelFatal, Source: source, Message: fmt.Sprintf("panic: %v", recovered), Tags: tags, Frames: frames})
+}
+
+// Flush waits for reports in flight, for use before the process exits.
+func Flush(ctx context.Context) error {
+	return current.Flush(ctx)
+}
+
+type nopReporter struct{}
+
+func (nopReporter) Report(Event)                    {}
+func (nopReporter) Flush(ctx context.Context) error { return nil }
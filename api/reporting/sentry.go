@@ -0,0 +1,206 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sentryQueue bounds the events waiting to be sent; more are dropped
+	// rather than holding up requests while the tracker is slow.
+	sentryQueue = 100
+	// sentryRepeatWindow sends one of a run of identical events, so a
+	// failing disk or a misbehaving client can't use up the quota.
+	sentryRepeatWindow = time.Minute
+)
+
+// Sentry sends events to a Sentry-compatible envelope endpoint. It posts
+// the envelopes itself; there is no SDK behind it.
+type Sentry struct {
+	endpoint    string
+	auth        string
+	environment string
+	release     string
+	serverName  string
+	client      *http.Client
+
+	queue   chan Event
+	pending sync.WaitGroup
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// NewSentry returns a reporter for dsn, of the form
+// https://<public key>@<host>/<project ID>.
+func NewSentry(dsn, environment, release string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, errors.New("invalid SENTRY_DSN")
+	}
+	// A DSN may carry a path prefix before the project ID
+	path := strings.Trim(u.Path, "/")
+	prefix, project := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		prefix, project = path[:i], path[i+1:]
+	}
+	if project == "" {
+		return nil, errors.New("invalid SENTRY_DSN: no project ID")
+	}
+	endpoint := u.Scheme + "://" + u.Host + "/"
+	if prefix != "" {
+		endpoint += prefix + "/"
+	}
+	endpoint += "api/" + project + "/envelope/"
+
+	host, _ := os.Hostname()
+	s := &Sentry{
+		endpoint:    endpoint,
+		auth:        "Sentry sentry_version=7, sentry_client=paste-api/1.0, sentry_key=" + u.User.Username(),
+		environment: environment,
+		release:     release,
+		serverName:  host,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan Event, sentryQueue),
+		sent:        map[string]time.Time{},
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *Sentry) Report(e Event) {
+	if s.repeated(e) {
+		return
+	}
+	s.pending.Add(1)
+	select {
+	case s.queue <- e:
+	default:
+		s.pending.Done()
+		log.Printf("Error reporting queue full, dropping: %s", e.Message)
+	}
+}
+
+func (s *Sentry) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// repeated reports whether an identical event went out within
+// sentryRepeatWindow, and otherwise notes this one.
+func (s *Sentry) repeated(e Event) bool {
+	key := string(e.Level) + "\x00" + e.Source + "\x00" + e.Message
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.sent[key]; ok && now.Sub(last) < sentryRepeatWindow {
+		return true
+	}
+	for k, t := range s.sent {
+		if now.Sub(t) >= sentryRepeatWindow {
+			delete(s.sent, k)
+		}
+	}
+	s.sent[key] = now
+	return false
+}
+
+func (s *Sentry) run() {
+	for e := range s.queue {
+		if err := s.send(e); err != nil {
+			log.Printf("Failed to report error: %v", err)
+		}
+		s.pending.Done()
+	}
+}
+
+func (s *Sentry) send(e Event) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+	eventID := hex.EncodeToString(id)
+	now := time.Now().UTC()
+
+	event := map[string]any{
+		"event_id":    eventID,
+		"timestamp":   now.Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       e.Level,
+		"logger":      e.Source,
+		"server_name": s.serverName,
+		"message":     map[string]string{"formatted": e.Message},
+	}
+	if s.environment != "" {
+		event["environment"] = s.environment
+	}
+	if s.release != "" {
+		event["release"] = s.release
+	}
+	if len(e.Tags) > 0 {
+		event["tags"] = e.Tags
+	}
+	if len(e.Frames) > 0 {
+		// Sentry lists frames outermost first
+		frames := make([]map[string]any, 0, len(e.Frames))
+		for i := len(e.Frames) - 1; i >= 0; i-- {
+			f := e.Frames[i]
+			frames = append(frames, map[string]any{
+				"function": f.Function,
+				"abs_path": f.File,
+				"lineno":   f.Line,
+				"in_app":   strings.Contains(f.Function, "jonasbg/paste"),
+			})
+		}
+		event["exception"] = map[string]any{
+			"values": []map[string]any{{
+				"type":       "panic",
+				"value":      e.Message,
+				"stacktrace": map[string]any{"frames": frames},
+			}},
+		}
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]string{"event_id": eventID, "sent_at": now.Format(time.RFC3339Nano)})
+	enc.Encode(map[string]string{"type": "event"})
+	if err := enc.Encode(event); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", s.endpoint, resp.Status)
+	}
+	return nil
+}
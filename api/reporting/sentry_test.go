@@ -0,0 +1,233 @@
+package reporting
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewSentryDSN(t *testing.T) {
+	for _, tc := range []struct {
+		dsn      string
+		endpoint string // empty when the DSN must be rejected
+	}{
+		{"https://key@sentry.example.com/42", "https://sentry.example.com/api/42/envelope/"},
+		{"https://key@example.com/sentry/42", "https://example.com/sentry/api/42/envelope/"},
+		{"https://key@example.com/a/b/42/", "https://example.com/a/b/api/42/envelope/"},
+		{"http://key@localhost:9000/1", "http://localhost:9000/api/1/envelope/"},
+		{"https://sentry.example.com/42", ""},
+		{"https://key@sentry.example.com/", ""},
+		{"https://key@/42", ""},
+		{"::not a url", ""},
+	} {
+		s, err := NewSentry(tc.dsn, "", "")
+		if tc.endpoint == "" {
+			if err == nil {
+				t.Errorf("%s: accepted, want an error", tc.dsn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.dsn, err)
+			continue
+		}
+		if s.endpoint != tc.endpoint {
+			t.Errorf("%s: endpoint %s, want %s", tc.dsn, s.endpoint, tc.endpoint)
+		}
+		if !strings.HasSuffix(s.auth, "sentry_key=key") {
+			t.Errorf("%s: auth %q doesn't carry the public key", tc.dsn, s.auth)
+		}
+	}
+}
+
+// envelopeRecorder is a Sentry endpoint keeping the requests it receives.
+type envelopeRecorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func newEnvelopeRecorder(t *testing.T) *envelopeRecorder {
+	t.Helper()
+	rec := &envelopeRecorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, string(body))
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// sentry returns a reporter posting to rec.
+func (rec *envelopeRecorder) sentry(t *testing.T) *Sentry {
+	t.Helper()
+	s, err := NewSentry(strings.Replace(rec.URL, "://", "://key@", 1)+"/7", "staging", "1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (rec *envelopeRecorder) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.requests)
+}
+
+func flush(t *testing.T, s *Sentry) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSentryEnvelope(t *testing.T) {
+	rec := newEnvelopeRecorder(t)
+	s := rec.sentry(t)
+	s.Report(Event{
+		Level:   LevelFatal,
+		Source:  "http",
+		Message: "panic: boom",
+		Tags:    map[string]string{"path": "/api/upload"},
+		Frames: []runtime.Frame{
+			{Function: "github.com/jonasbg/paste/m/v2/handlers.inner", File: "handlers/a.go", Line: 10},
+			{Function: "net/http.HandlerFunc.ServeHTTP", File: "server.go", Line: 20},
+		},
+	})
+	flush(t, s)
+
+	if rec.count() != 1 {
+		t.Fatalf("sent %d requests, want 1", rec.count())
+	}
+	r := rec.requests[0]
+	if r.Method != http.MethodPost || r.URL.Path != "/api/7/envelope/" {
+		t.Errorf("request %s %s, want POST /api/7/envelope/", r.Method, r.URL.Path)
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/x-sentry-envelope" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if auth := r.Header.Get("X-Sentry-Auth"); !strings.HasPrefix(auth, "Sentry sentry_version=7,") || !strings.Contains(auth, "sentry_key=key") {
+		t.Errorf("X-Sentry-Auth %q", auth)
+	}
+
+	// An envelope is a header, an item header and the item, one JSON
+	// document per line.
+	var lines []string
+	sc := bufio.NewScanner(strings.NewReader(rec.bodies[0]))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if len(lines) != 3 {
+		t.Fatalf("envelope has %d lines, want 3:\n%s", len(lines), rec.bodies[0])
+	}
+	var header struct {
+		EventID string `json:"event_id"`
+		SentAt  string `json:"sent_at"`
+	}
+	var item struct {
+		Type string `json:"type"`
+	}
+	var event struct {
+		EventID     string            `json:"event_id"`
+		Level       string            `json:"level"`
+		Logger      string            `json:"logger"`
+		Platform    string            `json:"platform"`
+		Environment string            `json:"environment"`
+		Release     string            `json:"release"`
+		Tags        map[string]string `json:"tags"`
+		Message     struct {
+			Formatted string `json:"formatted"`
+		} `json:"message"`
+		Exception struct {
+			Values []struct {
+				Type       string `json:"type"`
+				Stacktrace struct {
+					Frames []struct {
+						Function string `json:"function"`
+						InApp    bool   `json:"in_app"`
+					} `json:"frames"`
+				} `json:"stacktrace"`
+			} `json:"values"`
+		} `json:"exception"`
+	}
+	for i, v := range []any{&header, &item, &event} {
+		if err := json.Unmarshal([]byte(lines[i]), v); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+	}
+
+	if len(header.EventID) != 32 || header.EventID != event.EventID {
+		t.Errorf("event IDs %q and %q, want one 32-digit ID", header.EventID, event.EventID)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, header.SentAt); err != nil {
+		t.Errorf("sent_at: %v", err)
+	}
+	if item.Type != "event" {
+		t.Errorf("item type %q, want event", item.Type)
+	}
+	if event.Level != "fatal" || event.Logger != "http" || event.Platform != "go" || event.Message.Formatted != "panic: boom" {
+		t.Errorf("event %+v", event)
+	}
+	if event.Environment != "staging" || event.Release != "1.2.3" {
+		t.Errorf("environment %q and release %q", event.Environment, event.Release)
+	}
+	if event.Tags["path"] != "/api/upload" {
+		t.Errorf("tags %v", event.Tags)
+	}
+	if len(event.Exception.Values) != 1 {
+		t.Fatalf("%d exceptions, want 1", len(event.Exception.Values))
+	}
+	frames := event.Exception.Values[0].Stacktrace.Frames
+	if len(frames) != 2 || frames[0].Function != "net/http.HandlerFunc.ServeHTTP" || frames[0].InApp || !frames[1].InApp {
+		t.Errorf("frames %+v, want the outermost first and only ours in app", frames)
+	}
+}
+
+func TestSentryDropsRepeats(t *testing.T) {
+	rec := newEnvelopeRecorder(t)
+	s := rec.sentry(t)
+	e := Event{Level: LevelError, Source: "websocket", Message: "Failed to write chunk"}
+
+	for range 3 {
+		s.Report(e)
+	}
+	flush(t, s)
+	if n := rec.count(); n != 1 {
+		t.Fatalf("sent %d of a repeated event, want 1", n)
+	}
+
+	// Level, source and message each make an event distinct
+	s.Report(Event{Level: LevelWarning, Source: e.Source, Message: e.Message})
+	s.Report(Event{Level: e.Level, Source: "cleanup", Message: e.Message})
+	s.Report(Event{Level: e.Level, Source: e.Source, Message: "Error flushing buffer"})
+	flush(t, s)
+	if n := rec.count(); n != 4 {
+		t.Fatalf("sent %d events, want 4", n)
+	}
+
+	// Once the window has passed, the event goes out again
+	s.mu.Lock()
+	for k := range s.sent {
+		s.sent[k] = time.Now().Add(-sentryRepeatWindow)
+	}
+	s.mu.Unlock()
+	s.Report(e)
+	flush(t, s)
+	if n := rec.count(); n != 5 {
+		t.Fatalf("sent %d events, want 5 after the repeat window", n)
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Files waiting for the deleter beyond deleteQueueSize stay in the trash
+// for the sweep. A file the filesystem won't let go of is tried
+// deleteAttempts times, waiting twice as long after each failure.
+const (
+	deleteQueueSize    = 1024
+	deleteAttempts     = 5
+	deleteRetryBackoff = time.Second
+)
+
+var (
+	deleteQueue  = make(chan string, deleteQueueSize)
+	startDeleter sync.Once
+)
+
+// deleteLater queues a trashed blob, and its sidecar, for deletion in the
+// background. Whatever the deleter doesn't manage is left to EmptyTrash.
+func deleteLater(trashedPath string) {
+	startDeleter.Do(func() { go deleter() })
+	select {
+	case deleteQueue <- trashedPath:
+	default:
+		log.Printf("Deletion queue full, leaving %s to the sweep", trashedPath)
+	}
+}
+
+// deleter removes queued files one at a time, retrying those the
+// filesystem is too busy to delete.
+func deleter() {
+	for path := range deleteQueue {
+		wait := deleteRetryBackoff
+		for attempt := 1; ; attempt++ {
+			err := Remove(path)
+			if err == nil || errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			if attempt == deleteAttempts {
+				log.Printf("Failed to delete %s, leaving it to the sweep: %v", path, err)
+				break
+			}
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package storage
+
+import "errors"
+
+// freeSpace can't be read on this platform, so new uploads always go to
+// the primary volume.
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space not supported on this platform")
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no stored file has the requested ID.
+var ErrNotFound = errors.New("file not found")
+
+// FindBlob returns the stored blob for id, whatever its token and
+// whichever volume holds it.
+func FindBlob(v Volumes, id string) (string, error) {
+	for _, dir := range v {
+		blob, err := findBlob(dir, id)
+		if !errors.Is(err, ErrNotFound) {
+			return blob, err
+		}
+	}
+	return "", ErrNotFound
+}
+
+// findBlob returns the blob for id in dir.
+func findBlob(dir, id string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, id+".*"))
+	if err != nil {
+		return "", err
+	}
+	for _, m := range matches {
+		if !IsBlobPath(m) {
+			continue
+		}
+		if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+			return m, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Hold puts blobPath on legal hold. Holding a held file keeps its
+// original HeldAt.
+func Hold(blobPath string) (Policy, error) {
+	consumeMu.Lock()
+	defer consumeMu.Unlock()
+
+	p, err := LoadPolicy(blobPath)
+	if err != nil || p.Held() {
+		return p, err
+	}
+	p.HeldAt = time.Now().UTC()
+	return p, SavePolicy(blobPath, p)
+}
+
+// Release lifts the hold on blobPath. A file that used up its downloads
+// while held is deleted now, as its last download would have; an expired
+// one is left to lookups and the sweep.
+func Release(blobPath string) (Policy, error) {
+	consumeMu.Lock()
+	defer consumeMu.Unlock()
+
+	p, err := LoadPolicy(blobPath)
+	if err != nil || !p.Held() {
+		return p, err
+	}
+	p.HeldAt = time.Time{}
+	if p.Remaining() <= 0 {
+		return p, retire(blobPath, p)
+	}
+	return p, SavePolicy(blobPath, p)
+}
+
+// HeldFile describes a file on hold, without its token.
+type HeldFile struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	HeldAt    time.Time `json:"held_at"`
+	Downloads int       `json:"downloads"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Holds lists the files on hold, oldest hold first.
+func Holds(v Volumes) ([]HeldFile, error) {
+	held := []HeldFile{}
+	for _, dir := range v {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if !e.Type().IsRegular() || !IsBlobPath(path) {
+				continue
+			}
+			p, err := LoadPolicy(path)
+			if err != nil || !p.Held() {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			id, _, _ := strings.Cut(e.Name(), ".")
+			held = append(held, HeldFile{ID: id, Size: info.Size(), HeldAt: p.HeldAt, Downloads: p.Downloads, ExpiresAt: p.ExpiresAt})
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].HeldAt.Before(held[j].HeldAt) })
+	return held, nil
+}
@@ -0,0 +1,185 @@
+// Package storage manages the per-file policy sidecar stored next to each
+// uploaded blob. The blob itself stays an opaque `<id>.<token>` file; the
+// sidecar `<id>.<token>.policy` holds server-enforced lifecycle rules.
+package storage
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const policySuffix = ".policy"
+
+// Policy holds the lifecycle rules for one uploaded file. A file without a
+// sidecar has the zero Policy and is only subject to the retention sweep.
+type Policy struct {
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// MaxDownloads is how many completed downloads the file survives;
+	// zero means the historical default of one.
+	MaxDownloads int `json:"max_downloads,omitempty"`
+	Downloads    int `json:"downloads,omitempty"`
+	// Owner is the SHA-256 of the owner token handed to the uploader,
+	// which lets them read Downloads. Owned sidecars outlive their blob as
+	// a receipt until the file would have expired.
+	Owner string `json:"owner,omitempty"`
+	// Namespace is the API key namespace the file was uploaded into. Its
+	// ExpiresAt, if any, replaces the retention sweep's cutoff.
+	Namespace string `json:"namespace,omitempty"`
+	// HeldAt is when an operator put the file on legal hold. A held file
+	// is never deleted, by the sweep or by its last download, until the
+	// hold is released; it is still not served past its limits.
+	HeldAt time.Time `json:"held_at,omitzero"`
+	// TrashedAt is set on the copy kept in the trash: when the file's
+	// last download deleted it.
+	TrashedAt time.Time `json:"trashed_at,omitzero"`
+	// ChunkSize is set only on the sidecar of a resumable upload's
+	// partial: the chunk size in MB it began with, which a resume must
+	// repeat for the partial to be cut at a chunk boundary.
+	ChunkSize int `json:"chunk_size,omitempty"`
+}
+
+// consumeMu serialises download accounting and holds so concurrent
+// updates cannot both read the same sidecar.
+var consumeMu sync.Mutex
+
+// PolicyPath returns the sidecar path for a blob.
+func PolicyPath(blobPath string) string {
+	return blobPath + policySuffix
+}
+
+// IsPolicyPath reports whether path is a sidecar rather than a blob.
+func IsPolicyPath(path string) bool {
+	return len(path) > len(policySuffix) && path[len(path)-len(policySuffix):] == policySuffix
+}
+
+// LoadPolicy reads the sidecar for blobPath. A missing sidecar yields the
+// zero Policy.
+func LoadPolicy(blobPath string) (Policy, error) {
+	var p Policy
+	data, err := os.ReadFile(PolicyPath(blobPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// SavePolicy atomically writes the sidecar for blobPath. A zero Policy
+// removes any existing sidecar instead.
+func SavePolicy(blobPath string, p Policy) error {
+	if p.IsZero() {
+		err := os.Remove(PolicyPath(blobPath))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	tmp := PolicyPath(blobPath) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, PolicyPath(blobPath)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// IsZero reports whether p carries no rules.
+func (p Policy) IsZero() bool {
+	return p.ExpiresAt.IsZero() && p.MaxDownloads == 0 && p.Downloads == 0 && p.Owner == "" && p.Namespace == "" && p.HeldAt.IsZero() && p.TrashedAt.IsZero() && p.ChunkSize == 0
+}
+
+// Held reports whether the file is on legal hold.
+func (p Policy) Held() bool {
+	return !p.HeldAt.IsZero()
+}
+
+// Servable reports whether the file may still be downloaded. Only held
+// files outlive their expiry or download allowance on disk.
+func (p Policy) Servable(now time.Time) bool {
+	return !p.Expired(now) && p.Remaining() > 0
+}
+
+// HashOwnerToken returns the form of an owner token kept in the sidecar.
+func HashOwnerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// OwnedBy reports whether token is the file's owner token.
+func (p Policy) OwnedBy(token string) bool {
+	return p.Owner != "" && subtle.ConstantTimeCompare([]byte(p.Owner), []byte(HashOwnerToken(token))) == 1
+}
+
+// Remaining returns how many more completed downloads the file allows.
+func (p Policy) Remaining() int {
+	return max(1, p.MaxDownloads) - p.Downloads
+}
+
+// Consume records one completed download of blobPath and deletes the file
+// once its download allowance is used up. It returns the downloads left.
+// An owned file's sidecar is kept as a receipt of the final count.
+func Consume(blobPath string) (int, error) {
+	consumeMu.Lock()
+	defer consumeMu.Unlock()
+
+	p, err := LoadPolicy(blobPath)
+	if err != nil {
+		return 0, err
+	}
+
+	p.Downloads++
+	if p.Remaining() <= 0 {
+		return 0, retire(blobPath, p)
+	}
+	return p.Remaining(), SavePolicy(blobPath, p)
+}
+
+// retire deletes a file whose download allowance is used up, keeping an
+// owned file's sidecar as a receipt. A held file is only updated. The file
+// is renamed into the trash, so the last download isn't held up by
+// deleting gigabytes; without TrashRetention it is deleted from there in
+// the background.
+func retire(blobPath string, p Policy) error {
+	if p.Held() {
+		return SavePolicy(blobPath, p)
+	}
+	if err := moveToTrash(blobPath, p); err != nil {
+		return err
+	}
+	if TrashRetention == 0 {
+		deleteLater(filepath.Join(filepath.Dir(blobPath), trashDir, filepath.Base(blobPath)))
+	}
+	return nil
+}
+
+// Expired reports whether the file has outlived its requested lifetime.
+func (p Policy) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && now.After(p.ExpiresAt)
+}
+
+// Remove deletes a blob together with its sidecar.
+func Remove(blobPath string) error {
+	err := os.Remove(blobPath)
+	if perr := os.Remove(PolicyPath(blobPath)); perr != nil && !errors.Is(perr, os.ErrNotExist) && err == nil {
+		err = perr
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	reservationSuffix = ".reserved"
+	partialSuffix     = ".tmp"
+)
+
+// ErrIDInUse is returned when another upload holds or has stored the ID.
+var ErrIDInUse = errors.New("file ID already in use")
+
+// Reserve atomically claims id for the duration of an upload. The marker is
+// created with O_EXCL in the primary volume, so two uploads racing for the
+// same passphrase-derived ID cannot both pass; stored blobs are checked in
+// every volume only after the claim is held.
+// The returned release func must be called once the upload finishes or fails.
+// Paths in allow (the partial file of a resumed upload) do not count as a
+// conflict.
+func Reserve(v Volumes, id string, allow ...string) (func(), error) {
+	marker := filepath.Join(v.Primary(), id+reservationSuffix)
+	f, err := os.OpenFile(marker, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrIDInUse
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	release := func() { os.Remove(marker) }
+
+	for _, dir := range v {
+		matches, err := filepath.Glob(filepath.Join(dir, id+".*"))
+		if err != nil {
+			release()
+			return nil, err
+		}
+		for _, m := range matches {
+			// A receipt left by a downloaded file doesn't hold the ID; a
+			// new upload replaces it.
+			if IsPolicyPath(m) {
+				continue
+			}
+			if m != marker && !slices.Contains(allow, m) {
+				release()
+				return nil, ErrIDInUse
+			}
+		}
+	}
+	return release, nil
+}
+
+// BreakReservation removes id's reservation marker. It is only for markers
+// left behind by a server that stopped mid-upload.
+func BreakReservation(v Volumes, id string) error {
+	err := os.Remove(filepath.Join(v.Primary(), id+reservationSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// PartialPath returns where an in-progress upload of blob is written. A
+// resumable upload leaves it behind when the connection drops.
+func PartialPath(blob string) string {
+	return blob + partialSuffix
+}
+
+// IsPartialPath reports whether path is an in-progress upload.
+func IsPartialPath(path string) bool {
+	return strings.HasSuffix(path, partialSuffix)
+}
+
+// IsReservationPath reports whether path is an upload reservation marker.
+func IsReservationPath(path string) bool {
+	return strings.HasSuffix(path, reservationSuffix)
+}
+
+// IsBlobPath reports whether path is named like a stored blob, a hex ID and
+// a token joined by a dot. Anything else in the upload directory wasn't
+// written by the server.
+func IsBlobPath(path string) bool {
+	id, token, ok := strings.Cut(filepath.Base(path), ".")
+	if !ok || id == "" || token == "" {
+		return false
+	}
+	notHex := func(r rune) bool { return !strings.ContainsRune("0123456789abcdefABCDEF", r) }
+	notToken := func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}
+	return strings.IndexFunc(id, notHex) < 0 && strings.IndexFunc(token, notToken) < 0
+}
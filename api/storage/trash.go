@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashDir holds files deleted by their last download until TrashRetention
+// passes, or until the background deleter gets to them. It lives in the
+// upload directory, so moving a file there is a rename; the leading dot
+// keeps it clear of hex file IDs.
+const trashDir = ".trash"
+
+// TrashRetention keeps files deleted by their last download, or by DELETE,
+// in the trash for this long, so an admin can restore one taken by
+// mistake. With zero they are deleted as soon as the deleter can.
+var TrashRetention time.Duration
+
+// TrashDir returns the trash directory of uploadDir.
+func TrashDir(uploadDir string) string {
+	return filepath.Join(uploadDir, trashDir)
+}
+
+// moveToTrash moves a used-up file into the trash with a copy of its
+// policy. An owned file's receipt stays in place, as on deletion.
+func moveToTrash(blobPath string, p Policy) error {
+	dir, name := filepath.Split(blobPath)
+	trashed := filepath.Join(dir, trashDir, name)
+	if err := os.MkdirAll(filepath.Dir(trashed), 0750); err != nil {
+		return err
+	}
+	tp := p
+	tp.TrashedAt = time.Now().UTC()
+	if err := SavePolicy(trashed, tp); err != nil {
+		return err
+	}
+	if err := os.Rename(blobPath, trashed); err != nil {
+		os.Remove(PolicyPath(trashed))
+		return err
+	}
+	if p.Owner != "" {
+		return SavePolicy(blobPath, p)
+	}
+	if err := os.Remove(PolicyPath(blobPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Restore moves the file with id back out of the trash of its volume,
+// allowing one more download. It fails with ErrIDInUse if a new upload has
+// taken the ID.
+func Restore(v Volumes, id string) (Policy, error) {
+	var dir, trashed string
+	err := ErrNotFound
+	for _, dir = range v {
+		if trashed, err = findBlob(TrashDir(dir), id); !errors.Is(err, ErrNotFound) {
+			break
+		}
+	}
+	if err != nil {
+		return Policy{}, err
+	}
+	release, err := Reserve(v, id)
+	if err != nil {
+		return Policy{}, err
+	}
+	defer release()
+
+	consumeMu.Lock()
+	defer consumeMu.Unlock()
+	p, err := LoadPolicy(trashed)
+	if err != nil {
+		return Policy{}, err
+	}
+	p.TrashedAt = time.Time{}
+	p.Downloads = max(1, p.MaxDownloads) - 1
+
+	blobPath := filepath.Join(dir, filepath.Base(trashed))
+	if err := SavePolicy(blobPath, p); err != nil {
+		return Policy{}, err
+	}
+	if err := os.Rename(trashed, blobPath); err != nil {
+		return Policy{}, err
+	}
+	os.Remove(PolicyPath(trashed))
+	return p, nil
+}
+
+// TrashedFile describes a file in the trash, without its token.
+type TrashedFile struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	Downloads int       `json:"downloads"`
+	TrashedAt time.Time `json:"trashed_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// Trash lists the files in the trash of every volume, most recently
+// deleted first.
+func Trash(v Volumes) ([]TrashedFile, error) {
+	files := []TrashedFile{}
+	for _, dir := range v {
+		entries, err := os.ReadDir(TrashDir(dir))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			path := filepath.Join(TrashDir(dir), e.Name())
+			if !e.Type().IsRegular() || !IsBlobPath(path) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			p, err := LoadPolicy(path)
+			if err != nil {
+				continue
+			}
+			id, _, _ := strings.Cut(e.Name(), ".")
+			files = append(files, TrashedFile{ID: id, Size: info.Size(), Downloads: p.Downloads, TrashedAt: p.TrashedAt, PurgeAt: p.TrashedAt.Add(TrashRetention)})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].TrashedAt.After(files[j].TrashedAt) })
+	return files, nil
+}
+
+// EmptyTrash deletes the files whose TrashRetention has passed, and any
+// other leftovers older than that, and returns how many files it removed.
+func EmptyTrash(uploadDir string, now time.Time) (int, error) {
+	entries, err := os.ReadDir(TrashDir(uploadDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		path := filepath.Join(TrashDir(uploadDir), e.Name())
+		if IsPolicyPath(path) {
+			// Removed with their blob, or below once orphaned
+			if _, err := os.Stat(strings.TrimSuffix(path, policySuffix)); err == nil {
+				continue
+			}
+		}
+		deletedAt := time.Time{}
+		if p, err := LoadPolicy(path); err == nil && !IsPolicyPath(path) {
+			deletedAt = p.TrashedAt
+		}
+		if deletedAt.IsZero() {
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			deletedAt = info.ModTime()
+		}
+		if now.Sub(deletedAt) < TrashRetention {
+			continue
+		}
+		if err := Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		if !IsPolicyPath(path) {
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Volumes are the directories uploads are spread across, such as one per
+// disk. New uploads go to the one with the most free space and stored
+// files are found by looking in each. The first, the primary, also holds
+// upload reservations, so an ID is claimed once across all of them.
+type Volumes []string
+
+// Primary returns the volume holding upload reservations.
+func (v Volumes) Primary() string {
+	return v[0]
+}
+
+// Pick returns the volume a new upload should be written to: the one with
+// the most free space. Volumes whose free space can't be read are passed
+// over, and without any the primary is used.
+func (v Volumes) Pick() string {
+	best, bestFree := v.Primary(), uint64(0)
+	for _, dir := range v {
+		free, err := freeSpace(dir)
+		if err != nil {
+			log.Printf("Failed to read free space of %s: %v", dir, err)
+			continue
+		}
+		if free > bestFree {
+			best, bestFree = dir, free
+		}
+	}
+	return best
+}
+
+// Locate returns the path of the stored file name, a blob's `<id>.<token>`,
+// in the volume that has it. A finished blob is preferred over a partial
+// upload, and either over a sidecar left as a download receipt. A name no
+// volume has is placed in the primary, where it doesn't exist either.
+func (v Volumes) Locate(name string) string {
+	for _, suffix := range []string{"", partialSuffix, policySuffix} {
+		for _, dir := range v {
+			path := filepath.Join(dir, name)
+			if _, err := os.Lstat(path + suffix); err == nil {
+				return path
+			}
+		}
+	}
+	return filepath.Join(v.Primary(), name)
+}
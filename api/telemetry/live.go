@@ -0,0 +1,119 @@
+package telemetry
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// liveErrorLimit is how many recent errors Activity keeps.
+const liveErrorLimit = 50
+
+// Activity is what the server is doing right now, for the live metrics
+// feed. Unlike the OTel instruments it isn't aggregated: it holds the
+// open transfers, running byte totals and the last few errors. Like the
+// OTel meter provider, there is one per process.
+var Activity = &Live{}
+
+// Live tracks open transfer sessions, bytes moved and recent errors. The
+// zero value is ready to use.
+type Live struct {
+	mu        sync.Mutex
+	sessions  map[string]int
+	saturated map[string]int
+	bytes     map[string]int64
+	errors    []LiveError
+	seq       uint64
+}
+
+// LiveError is one error reported to a client. Seq increases by one for
+// each, so a reader can tell which it has already seen.
+type LiveError struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+}
+
+// LiveSnapshot is a copy of the state at one moment.
+type LiveSnapshot struct {
+	Time      time.Time
+	Sessions  map[string]int
+	Saturated map[string]int
+	Bytes     map[string]int64
+	Errors    []LiveError
+}
+
+// StartSession counts a transfer of the given operation as open until
+// the returned func is called.
+func (l *Live) StartSession(operation string) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions == nil {
+		l.sessions = map[string]int{}
+	}
+	l.sessions[operation]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.sessions[operation]--
+		})
+	}
+}
+
+// StartSaturated counts a transfer of the given operation as held back by
+// the disk until the returned func is called.
+func (l *Live) StartSaturated(operation string) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.saturated == nil {
+		l.saturated = map[string]int{}
+	}
+	l.saturated[operation]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.saturated[operation]--
+		})
+	}
+}
+
+// AddBytes adds n bytes moved by an operation, from which readers derive
+// the current throughput.
+func (l *Live) AddBytes(operation string, n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bytes == nil {
+		l.bytes = map[string]int64{}
+	}
+	l.bytes[operation] += n
+}
+
+// RecordError keeps an error for the feed, dropping the oldest beyond
+// liveErrorLimit.
+func (l *Live) RecordError(source, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.errors = append(l.errors, LiveError{Seq: l.seq, Time: time.Now().UTC(), Source: source, Message: message})
+	if len(l.errors) > liveErrorLimit {
+		l.errors = l.errors[len(l.errors)-liveErrorLimit:]
+	}
+}
+
+// Snapshot copies the current state.
+func (l *Live) Snapshot() LiveSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LiveSnapshot{
+		Time:      time.Now().UTC(),
+		Sessions:  maps.Clone(l.sessions),
+		Saturated: maps.Clone(l.saturated),
+		Bytes:     maps.Clone(l.bytes),
+		Errors:    append([]LiveError(nil), l.errors...),
+	}
+}
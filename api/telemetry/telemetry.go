@@ -1,8 +1,10 @@
 package telemetry
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"strings"
@@ -21,6 +23,15 @@ import (
 
 const serviceName = "paste-api"
 
+// latencyBoundsMS spans config fetches through multi-gigabyte WebSocket
+// transfers, whose request lasts as long as the transfer. The OTel default
+// tops out at ten seconds.
+var latencyBoundsMS = []float64{
+	1, 5, 10, 25, 50, 100, 250, 500,
+	1000, 2500, 5000, 10000, 30000, 60000,
+	300000, 900000, 1800000, 3600000,
+}
+
 type Provider struct {
 	meterProvider *sdkmetric.MeterProvider
 	promHandler   http.Handler
@@ -31,6 +42,8 @@ type Provider struct {
 	uploadSize    metric.Int64Histogram
 	uploadBytes   metric.Int64Counter
 	uploadFiles   metric.Int64Counter
+	removed       metric.Int64Counter
+	stray         metric.Int64Gauge
 }
 
 func Init(ctx context.Context) (*Provider, error) {
@@ -71,7 +84,8 @@ func Init(ctx context.Context) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
-	latency, err := meter.Float64Histogram("http.server.request.duration", metric.WithUnit("ms"))
+	latency, err := meter.Float64Histogram("http.server.request.duration", metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries(latencyBoundsMS...))
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +109,14 @@ func Init(ctx context.Context) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
+	removed, err := meter.Int64Counter("paste.storage.removed")
+	if err != nil {
+		return nil, err
+	}
+	stray, err := meter.Int64Gauge("paste.storage.stray")
+	if err != nil {
+		return nil, err
+	}
 
 	return &Provider{
 		meterProvider: mp,
@@ -106,6 +128,8 @@ func Init(ctx context.Context) (*Provider, error) {
 		uploadSize:    uploadSize,
 		uploadBytes:   uploadBytes,
 		uploadFiles:   uploadFiles,
+		removed:       removed,
+		stray:         stray,
 	}, nil
 }
 
@@ -130,7 +154,7 @@ func (p *Provider) Middleware() gin.HandlerFunc {
 		start := time.Now()
 		c.Next()
 
-		durationMs := float64(time.Since(start).Milliseconds())
+		durationMs := float64(time.Since(start)) / float64(time.Millisecond)
 		attrs := []attribute.KeyValue{
 			attribute.String("http.request.method", strings.ToLower(c.Request.Method)),
 			attribute.String("http.route", routeLabel(c)),
@@ -139,14 +163,17 @@ func (p *Provider) Middleware() gin.HandlerFunc {
 
 		p.requests.Add(c.Request.Context(), 1, metric.WithAttributes(attrs...))
 		p.latency.Record(c.Request.Context(), durationMs, metric.WithAttributes(attrs...))
+		if status := c.Writer.Status(); status >= 500 {
+			Activity.RecordError("http", fmt.Sprintf("%s %s: %d", c.Request.Method, cmp.Or(routeLabel(c), "(unmatched)"), status))
+		}
 	}
 }
 
+// routeLabel is the matched route pattern. Unmatched requests share an
+// empty label rather than one series, with its latency histogram, per
+// path scanned.
 func routeLabel(c *gin.Context) string {
-	if path := c.FullPath(); path != "" {
-		return path
-	}
-	return c.Request.URL.Path
+	return c.FullPath()
 }
 
 func (p *Provider) RecordTransfer(ctx context.Context, operation string, size int64, success bool, protocol string) {
@@ -164,13 +191,16 @@ func (p *Provider) RecordTransfer(ctx context.Context, operation string, size in
 	}
 }
 
-func (p *Provider) RecordUpload(ctx context.Context, size int64, success bool, protocol string) {
+// RecordUpload records an upload's size. namespace is the API key
+// namespace it went into, or empty for anonymous uploads.
+func (p *Provider) RecordUpload(ctx context.Context, size int64, success bool, protocol, namespace string) {
 	if p == nil || size < 0 {
 		return
 	}
 	attrs := []attribute.KeyValue{
 		attribute.Bool("paste.success", success),
 		attribute.String("network.protocol.name", protocol),
+		attribute.String("paste.namespace", namespace),
 	}
 	p.uploadSize.Record(ctx, size, metric.WithAttributes(attrs...))
 	if success {
@@ -179,16 +209,40 @@ func (p *Provider) RecordUpload(ctx context.Context, size int64, success bool, p
 	}
 }
 
-func MountPrometheusRoute(r *gin.Engine, handler http.Handler) error {
+// RecordSweep reports one pass over the upload directory: files removed,
+// by reason, and files left in place that don't belong there, by kind.
+// Every kind is recorded, so a gauge drops back to zero once resolved.
+func (p *Provider) RecordSweep(ctx context.Context, removed, stray map[string]int64) {
+	if p == nil {
+		return
+	}
+	for reason, n := range removed {
+		if n > 0 {
+			p.removed.Add(ctx, n, metric.WithAttributes(attribute.String("paste.reason", reason)))
+		}
+	}
+	for kind, n := range stray {
+		p.stray.Record(ctx, n, metric.WithAttributes(attribute.String("paste.kind", kind)))
+	}
+}
+
+// PrometheusPath is where the Prometheus endpoint is mounted.
+func PrometheusPath() string {
+	return utils.GetEnv("OTEL_PROMETHEUS_PATH", "/metrics")
+}
+
+// MountPrometheusRoute serves handler at PrometheusPath behind the given
+// middleware, if any.
+func MountPrometheusRoute(r *gin.Engine, handler http.Handler, guard ...gin.HandlerFunc) error {
 	if handler == nil {
 		return nil
 	}
 
-	path := utils.GetEnv("OTEL_PROMETHEUS_PATH", "/metrics")
+	path := PrometheusPath()
 	if path == "" || path[0] != '/' {
 		return errors.New("OTEL_PROMETHEUS_PATH must start with '/'")
 	}
 
-	r.GET(path, gin.WrapH(handler))
+	r.GET(path, append(guard, gin.WrapH(handler))...)
 	return nil
 }
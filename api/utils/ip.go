@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +28,87 @@ func GetTrustedProxies() []string {
 	return proxies
 }
 
+// SplitList splits a comma-separated setting into its items, trimmed and
+// without empty ones.
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// realIPPolicy says which header carries the client address and how to
+// read it. It is taken from the environment once:
+//
+//   - REAL_IP_HEADER names the one header to trust (default
+//     X-Forwarded-For; CF-Connecting-IP and X-Real-IP are the usual
+//     alternatives), or "none" to always use the peer address.
+//   - PROXY_HOPS is how many proxies append to it. The client is then that
+//     many entries from the right. Zero (the default) skips entries from
+//     the right while they are trusted proxies.
+type realIPPolicy struct {
+	trusted []*net.IPNet
+	header  string
+	hops    int
+}
+
+var loadRealIPPolicy = sync.OnceValues(func() (realIPPolicy, error) {
+	p := realIPPolicy{header: http.CanonicalHeaderKey(GetEnv("REAL_IP_HEADER", "X-Forwarded-For"))}
+	if strings.EqualFold(p.header, "none") {
+		p.header = ""
+	}
+	if hops := os.Getenv("PROXY_HOPS"); hops != "" {
+		n, err := strconv.Atoi(hops)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid PROXY_HOPS. Must be a non-negative integer")
+		}
+		p.hops = n
+	}
+	for _, proxy := range GetTrustedProxies() {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			p.trusted = append(p.trusted, ipNet)
+		}
+	}
+	return p, nil
+})
+
+// CheckRealIPEnv reports an invalid PROXY_HOPS, so the server refuses to
+// start rather than take the wrong header entry for the client's address.
+// The policy's users then never see the error.
+func CheckRealIPEnv() error {
+	_, err := loadRealIPPolicy()
+	return err
+}
+
+// RealIPSource reports the header client addresses are read from, empty
+// for none, and the configured PROXY_HOPS.
+func RealIPSource() (header string, hops int) {
+	p, _ := loadRealIPPolicy()
+	return p.header, p.hops
+}
+
+func (p realIPPolicy) isTrusted(ip net.IP) bool {
+	for _, ipNet := range p.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRealIP returns the client's address. Headers are only read when the
+// peer is a trusted proxy, and then only REAL_IP_HEADER; anything else a
+// client sends is ignored.
 func GetRealIP(c *gin.Context) string {
 	// Get the immediate client IP
 	remoteAddr := c.Request.RemoteAddr
@@ -38,49 +123,41 @@ func GetRealIP(c *gin.Context) string {
 		return clientIP // Return immediate client IP if we can't parse it
 	}
 
-	// Only proceed with header checking if the immediate client is a trusted proxy
-	trustedProxies := GetTrustedProxies()
-	isTrusted := false
-
-	for _, proxyRange := range trustedProxies {
-		_, ipNet, err := net.ParseCIDR(proxyRange)
-		if err == nil && ipNet.Contains(clientIPParsed) {
-			isTrusted = true
-			break
-		} else if proxyRange == clientIP {
-			isTrusted = true
-			break
-		}
-	}
-
-	if !isTrusted {
-		return clientIP // If not from a trusted proxy, return the immediate client IP
-	}
-
-	// From trusted proxy, check headers in order of preference
-	if cfIP := c.GetHeader("CF-Connecting-IP"); cfIP != "" {
-		return cfIP // Cloudflare-specific header
-	}
-
-	// For X-Forwarded-For, use the leftmost value as it's the original client
-	if forwardedFor := c.GetHeader("X-Forwarded-For"); forwardedFor != "" {
-		ips := strings.Split(forwardedFor, ",")
-		return strings.TrimSpace(ips[0])
+	policy, _ := loadRealIPPolicy()
+	if policy.header == "" || !policy.isTrusted(clientIPParsed) {
+		return clientIP
 	}
 
-	if realIP := c.GetHeader("X-Real-IP"); realIP != "" {
-		return realIP
+	// Single-address headers are a list of one
+	var entries []string
+	for _, value := range c.Request.Header.Values(policy.header) {
+		for _, entry := range strings.Split(value, ",") {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
 	}
-
-	// Fallback to other headers
-	if clientIP := c.GetHeader("X-Client-IP"); clientIP != "" {
+	if len(entries) == 0 {
 		return clientIP
 	}
 
-	if trueClientIP := c.GetHeader("True-Client-IP"); trueClientIP != "" {
-		return trueClientIP
+	var candidate string
+	switch {
+	case policy.hops > 0:
+		// Fewer entries than proxies means the request skipped some; the
+		// leftmost was still written by one of ours.
+		candidate = entries[max(0, len(entries)-policy.hops)]
+	default:
+		candidate = entries[0]
+		for i := len(entries) - 1; i >= 0; i-- {
+			ip := net.ParseIP(entries[i])
+			if ip == nil || !policy.isTrusted(ip) {
+				candidate = entries[i]
+				break
+			}
+		}
 	}
 
-	// If we get here, just return the immediate client IP
+	if ip := net.ParseIP(candidate); ip != nil {
+		return ip.String()
+	}
 	return clientIP
 }
@@ -18,8 +18,9 @@ import (
 
 // v2 format constants. Bumping these strings is a wire-format break.
 const (
-	chunkAAD    = "paste-v2-chunk"
-	metadataAAD = "paste-v2-metadata"
+	chunkAAD     = "paste-v2-chunk"
+	metadataAAD  = "paste-v2-metadata"
+	thumbnailAAD = "paste-v2-thumbnail"
 
 	argon2Salt   = "paste-v2-argon2id"
 	argon2Time   = 3
@@ -30,6 +31,7 @@ const (
 	hkdfFileIDInfo = "paste-v2-file-id"
 	hkdfKeyInfo    = "paste-v2-encryption-key"
 	hkdfHMACInfo   = "paste:hmac-token"
+	hkdfChunkInfo  = "paste-v2-chunk-key"
 
 	streamFinalBit    uint32 = 0x80000000
 	streamCounterMask uint32 = 0x7FFFFFFF
@@ -140,36 +142,75 @@ func buildChunkNonce(dst []byte, iv []byte, chunkIdx uint32, isFinal bool) {
 type StreamCipher struct {
 	aead     cipher.AEAD
 	iv       []byte
-	chunkNum uint32
+	chunkNum uint64
+
+	// perChunkKeys enables HKDF subkey derivation; key is only retained
+	// in that mode, since every chunk needs it to derive its own subkey.
+	perChunkKeys bool
+	key          []byte
 }
 
-// NewStreamCipher creates a new streaming cipher for encryption with a fresh IV.
-func NewStreamCipher(key []byte) (*StreamCipher, error) {
+// StreamOption configures optional StreamCipher behaviour. Options change the
+// wire format, so the encryptor and decryptor must be created with the same set.
+type StreamOption func(*StreamCipher)
+
+// WithPerChunkKeys derives a fresh AES key for every chunk with
+// HKDF-SHA256(key, salt=IV, info=label||chunkIndex). Because no two chunks
+// share a key, the 31-bit nonce counter no longer bounds the stream length and
+// the chunk-counter guard is lifted.
+func WithPerChunkKeys() StreamOption {
+	return func(sc *StreamCipher) {
+		sc.perChunkKeys = true
+	}
+}
+
+func newStreamCipher(key, iv []byte, opts []StreamOption) (*StreamCipher, error) {
 	aead, err := newAEAD(key)
 	if err != nil {
 		return nil, err
 	}
+	sc := &StreamCipher{aead: aead, iv: iv}
+	for _, opt := range opts {
+		opt(sc)
+	}
+	if sc.perChunkKeys {
+		sc.key = make([]byte, len(key))
+		copy(sc.key, key)
+	}
+	return sc, nil
+}
 
+// NewStreamCipher creates a new streaming cipher for encryption with a fresh IV.
+func NewStreamCipher(key []byte, opts ...StreamOption) (*StreamCipher, error) {
 	iv := make([]byte, IVSize)
 	if _, err := rand.Read(iv); err != nil {
 		return nil, err
 	}
-
-	return &StreamCipher{aead: aead, iv: iv}, nil
+	return newStreamCipher(key, iv, opts)
 }
 
 // NewStreamDecryptor creates a new streaming cipher for decryption.
-func NewStreamDecryptor(key []byte, iv []byte) (*StreamCipher, error) {
-	aead, err := newAEAD(key)
-	if err != nil {
-		return nil, err
-	}
+func NewStreamDecryptor(key []byte, iv []byte, opts ...StreamOption) (*StreamCipher, error) {
 	if len(iv) != IVSize {
 		return nil, errors.New("invalid IV size")
 	}
 	ivCopy := make([]byte, IVSize)
 	copy(ivCopy, iv)
-	return &StreamCipher{aead: aead, iv: ivCopy}, nil
+	return newStreamCipher(key, ivCopy, opts)
+}
+
+// NewStreamCipherAt recreates the cipher of an existing stream positioned
+// at chunk index next, so an interrupted transfer can continue where it
+// stopped. When encrypting, the caller must feed exactly the plaintext the
+// original stream would have seen from that chunk on: sealing different
+// data under the same key, IV and index reuses a GCM nonce.
+func NewStreamCipherAt(key []byte, iv []byte, next uint64, opts ...StreamOption) (*StreamCipher, error) {
+	sc, err := NewStreamDecryptor(key, iv, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sc.chunkNum = next
+	return sc, nil
 }
 
 // IV returns the initialization vector.
@@ -177,16 +218,56 @@ func (sc *StreamCipher) IV() []byte {
 	return sc.iv
 }
 
+// chunkAEAD returns the AEAD and nonce for the current chunk.
+func (sc *StreamCipher) chunkAEAD(isFinal bool) (cipher.AEAD, []byte, error) {
+	nonce := make([]byte, IVSize)
+	if !sc.perChunkKeys {
+		if sc.chunkNum >= uint64(streamCounterMask) {
+			return nil, nil, errors.New("chunk counter exhausted")
+		}
+		buildChunkNonce(nonce, sc.iv, uint32(sc.chunkNum), isFinal)
+		return sc.aead, nonce, nil
+	}
+
+	subkey, err := ChunkKey(sc.key, sc.iv, sc.chunkNum)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zero(subkey)
+	aead, err := newAEAD(subkey)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The index is already bound by the subkey; the nonce still carries the
+	// final bit so truncation is detected exactly as in the default mode.
+	buildChunkNonce(nonce, sc.iv, uint32(sc.chunkNum), isFinal)
+	return aead, nonce, nil
+}
+
+// ChunkKey derives the key that chunk index of a WithPerChunkKeys stream
+// is sealed with, for implementations of the stream outside this package.
+// The caller should zero it after use.
+func ChunkKey(key, iv []byte, index uint64) ([]byte, error) {
+	info := make([]byte, len(hkdfChunkInfo)+8)
+	copy(info, hkdfChunkInfo)
+	binary.BigEndian.PutUint64(info[len(hkdfChunkInfo):], index)
+
+	subkey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, iv, info), subkey); err != nil {
+		return nil, err
+	}
+	return subkey, nil
+}
+
 // EncryptChunk encrypts a single chunk. isFinal must be true for the final
 // chunk and false otherwise — this is bound into the STREAM nonce, so a
 // mismatch on decryption will fail GCM auth.
 func (sc *StreamCipher) EncryptChunk(plaintext []byte, isFinal bool) ([]byte, error) {
-	if sc.chunkNum >= streamCounterMask {
-		return nil, errors.New("chunk counter exhausted")
+	aead, nonce, err := sc.chunkAEAD(isFinal)
+	if err != nil {
+		return nil, err
 	}
-	nonce := make([]byte, IVSize)
-	buildChunkNonce(nonce, sc.iv, sc.chunkNum, isFinal)
-	ciphertext := sc.aead.Seal(nil, nonce, plaintext, []byte(chunkAAD))
+	ciphertext := aead.Seal(nil, nonce, plaintext, []byte(chunkAAD))
 	sc.chunkNum++
 	return ciphertext, nil
 }
@@ -194,12 +275,11 @@ func (sc *StreamCipher) EncryptChunk(plaintext []byte, isFinal bool) ([]byte, er
 // DecryptChunk decrypts a single chunk. isFinal must match the value the
 // sender passed to EncryptChunk; otherwise the GCM tag fails to verify.
 func (sc *StreamCipher) DecryptChunk(ciphertext []byte, isFinal bool) ([]byte, error) {
-	if sc.chunkNum >= streamCounterMask {
-		return nil, errors.New("chunk counter exhausted")
+	aead, nonce, err := sc.chunkAEAD(isFinal)
+	if err != nil {
+		return nil, err
 	}
-	nonce := make([]byte, IVSize)
-	buildChunkNonce(nonce, sc.iv, sc.chunkNum, isFinal)
-	plaintext, err := sc.aead.Open(nil, nonce, ciphertext, []byte(chunkAAD))
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(chunkAAD))
 	if err != nil {
 		return nil, err
 	}
@@ -210,12 +290,35 @@ func (sc *StreamCipher) DecryptChunk(ciphertext []byte, isFinal bool) ([]byte, e
 // Clear securely clears the cipher's sensitive data.
 func (sc *StreamCipher) Clear() {
 	zero(sc.iv)
+	zero(sc.key)
 	sc.chunkNum = 0
 }
 
 // EncryptMetadata encrypts metadata using AES-GCM with the v2 metadata AAD.
 // Wire format: [IV(12)][Length(4 LE)][AES-GCM(metadata)].
 func EncryptMetadata(key []byte, metadata []byte) ([]byte, error) {
+	return sealBlob(key, metadata, metadataAAD)
+}
+
+// DecryptMetadata decrypts metadata from the v2 header format.
+func DecryptMetadata(key []byte, data []byte) ([]byte, error) {
+	return openBlob(key, data, metadataAAD)
+}
+
+// EncryptThumbnail seals a preview image with the file key. It uses the
+// metadata header layout under its own AAD, so a thumbnail can never be
+// swapped in for the metadata block or vice versa.
+func EncryptThumbnail(key []byte, image []byte) ([]byte, error) {
+	return sealBlob(key, image, thumbnailAAD)
+}
+
+// DecryptThumbnail reverses EncryptThumbnail.
+func DecryptThumbnail(key []byte, data []byte) ([]byte, error) {
+	return openBlob(key, data, thumbnailAAD)
+}
+
+// sealBlob produces [IV 12][len 4 LE][GCM(plaintext, aad)].
+func sealBlob(key, plaintext []byte, aad string) ([]byte, error) {
 	aead, err := newAEAD(key)
 	if err != nil {
 		return nil, err
@@ -226,7 +329,7 @@ func EncryptMetadata(key []byte, metadata []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	encrypted := aead.Seal(nil, iv, metadata, []byte(metadataAAD))
+	encrypted := aead.Seal(nil, iv, plaintext, []byte(aad))
 
 	header := make([]byte, 16)
 	copy(header[:12], iv)
@@ -234,25 +337,23 @@ func EncryptMetadata(key []byte, metadata []byte) ([]byte, error) {
 	return append(header, encrypted...), nil
 }
 
-// DecryptMetadata decrypts metadata from the v2 header format.
-func DecryptMetadata(key []byte, data []byte) ([]byte, error) {
+func openBlob(key, data []byte, aad string) ([]byte, error) {
 	aead, err := newAEAD(key)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(data) < 16 {
-		return nil, errors.New("invalid metadata format: too short")
+		return nil, errors.New("invalid header format: too short")
 	}
 
 	iv := data[:12]
-	metadataLen := binary.LittleEndian.Uint32(data[12:16])
-	if uint64(len(data)) < uint64(16)+uint64(metadataLen) {
-		return nil, errors.New("incomplete metadata")
+	blobLen := binary.LittleEndian.Uint32(data[12:16])
+	if uint64(len(data)) < uint64(16)+uint64(blobLen) {
+		return nil, errors.New("incomplete header blob")
 	}
-	encryptedMetadata := data[16 : 16+metadataLen]
 
-	return aead.Open(nil, iv, encryptedMetadata, []byte(metadataAAD))
+	return aead.Open(nil, iv, data[16:16+blobLen], []byte(aad))
 }
 
 // DeriveFromPassphrase derives both a file ID and encryption key from a passphrase
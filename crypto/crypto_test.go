@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"testing"
 )
 
@@ -55,6 +56,83 @@ func TestMetadataAADIsolation(t *testing.T) {
 	}
 }
 
+func TestThumbnailNotInterchangeableWithMetadata(t *testing.T) {
+	key, err := GenerateKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumb := []byte("\xff\xd8\xff fake jpeg")
+	enc, err := EncryptThumbnail(key, thumb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptThumbnail(key, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, thumb) {
+		t.Fatalf("roundtrip mismatch: got %q want %q", got, thumb)
+	}
+
+	if _, err := DecryptMetadata(key, enc); err == nil {
+		t.Fatal("thumbnail blob unexpectedly decrypted as metadata")
+	}
+	meta, err := EncryptMetadata(key, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptThumbnail(key, meta); err == nil {
+		t.Fatal("metadata blob unexpectedly decrypted as thumbnail")
+	}
+}
+
+func TestMetadataVersioning(t *testing.T) {
+	type v1 struct {
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+	}
+
+	raw, err := MarshalMetadata(struct {
+		v1
+		Digest string `json:"digest"`
+	}{v1{"a.txt", 3}, "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got v1
+	version, err := UnmarshalMetadata(raw, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != MetadataVersion || got != (v1{"a.txt", 3}) {
+		t.Fatalf("got version %d, %+v", version, got)
+	}
+
+	// Metadata from before versioning is version 1.
+	version, err = UnmarshalMetadata([]byte(`{"filename":"old.bin","size":7}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || got != (v1{"old.bin", 7}) {
+		t.Fatalf("got version %d, %+v", version, got)
+	}
+
+	// Newer versions decode as far as this reader understands them.
+	if version, err = UnmarshalMetadata([]byte(`{"v":9,"filename":"new","later":{"x":1}}`), &got); err != nil || version != 9 {
+		t.Fatalf("newer version: %d, %v", version, err)
+	}
+
+	for _, bad := range []string{`[]`, `null`, `"x"`, `{"v":0}`, `{"v":"2"}`} {
+		if _, err := UnmarshalMetadata([]byte(bad), &got); !errors.Is(err, ErrInvalidMetadata) {
+			t.Errorf("%s: got %v, want ErrInvalidMetadata", bad, err)
+		}
+	}
+	if _, err := StampMetadata([]byte(`[1]`)); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("stamping an array: got %v", err)
+	}
+}
+
 func TestStreamRoundtripExactMultiple(t *testing.T) {
 	// Regression: file size that is an exact multiple of the chunk size.
 	// The final chunk is full-size and must still be marked as final.
@@ -69,7 +147,11 @@ func TestStreamRoundtripSingleChunk(t *testing.T) {
 	testStreamRoundtrip(t, 1, 1024, 1)
 }
 
-func testStreamRoundtrip(t *testing.T, fullChunks, chunkSize, lastSize int) {
+func TestStreamRoundtripPerChunkKeys(t *testing.T) {
+	testStreamRoundtrip(t, 3, 1024, 600, WithPerChunkKeys())
+}
+
+func testStreamRoundtrip(t *testing.T, fullChunks, chunkSize, lastSize int, opts ...StreamOption) {
 	t.Helper()
 	key, err := GenerateKey(32)
 	if err != nil {
@@ -81,7 +163,7 @@ func testStreamRoundtrip(t *testing.T, fullChunks, chunkSize, lastSize int) {
 		t.Fatal(err)
 	}
 
-	enc, err := NewStreamCipher(key)
+	enc, err := NewStreamCipher(key, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -97,7 +179,7 @@ func testStreamRoundtrip(t *testing.T, fullChunks, chunkSize, lastSize int) {
 		ciphertexts = append(ciphertexts, ct)
 	}
 
-	dec, err := NewStreamDecryptor(key, iv)
+	dec, err := NewStreamDecryptor(key, iv, opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -126,6 +208,47 @@ func splitFixed(data []byte, chunk int) [][]byte {
 	return out
 }
 
+func TestStreamCipherAtContinuesStream(t *testing.T) {
+	key, _ := GenerateKey(32)
+	plaintext := make([]byte, 4000)
+	rand.Read(plaintext)
+
+	enc, _ := NewStreamCipher(key)
+	iv := append([]byte(nil), enc.IV()...)
+	var sealed [][]byte
+	for i := 0; i < 4; i++ {
+		c, err := enc.EncryptChunk(plaintext[i*1000:(i+1)*1000], i == 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed = append(sealed, c)
+	}
+
+	// Resuming at chunk 2 must reproduce the original ciphertext exactly.
+	resumed, err := NewStreamCipherAt(key, iv, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < 4; i++ {
+		c, err := resumed.EncryptChunk(plaintext[i*1000:(i+1)*1000], i == 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c, sealed[i]) {
+			t.Fatalf("chunk %d differs after resume", i)
+		}
+	}
+
+	// A decryptor positioned at chunk 2 opens the tail on its own.
+	dec, _ := NewStreamCipherAt(key, iv, 2)
+	if _, err := dec.DecryptChunk(sealed[2], false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecryptChunk(sealed[3], true); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestStreamRejectsTruncation(t *testing.T) {
 	// Drop the final chunk. The receiver, having processed fewer chunks than
 	// the sender, never sees a chunk with isFinal=true. With the v2 STREAM
@@ -182,6 +305,80 @@ func TestStreamRejectsIsFinalMismatch(t *testing.T) {
 	}
 }
 
+func TestPerChunkKeysModeMismatch(t *testing.T) {
+	// A stream sealed with per-chunk subkeys must not open under the single-key
+	// mode (and vice versa), otherwise the mode flag would be meaningless.
+	key, _ := GenerateKey(32)
+	enc, _ := NewStreamCipher(key, WithPerChunkKeys())
+	iv := append([]byte(nil), enc.IV()...)
+	ct, _ := enc.EncryptChunk([]byte("chunk"), true)
+
+	dec, _ := NewStreamDecryptor(key, iv)
+	if _, err := dec.DecryptChunk(ct, true); err == nil {
+		t.Fatal("per-chunk-key ciphertext decrypted in single-key mode")
+	}
+}
+
+func TestChunkKeyOptions(t *testing.T) {
+	key, _ := GenerateKey(32)
+	enc, _ := NewStreamCipher(key, WithPerChunkKeys())
+	iv := append([]byte(nil), enc.IV()...)
+	ct, _ := enc.EncryptChunk([]byte("chunk"), true)
+
+	opts, err := ChunkKeyOptions(ChunkKeysHKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, _ := NewStreamDecryptor(key, iv, opts...)
+	if _, err := dec.DecryptChunk(ct, true); err != nil {
+		t.Fatalf("options for %q don't open a per-chunk-key stream: %v", ChunkKeysHKDF, err)
+	}
+
+	if opts, err := ChunkKeyOptions(""); err != nil || len(opts) != 0 {
+		t.Errorf("no chunkKeys member: %d options, %v", len(opts), err)
+	}
+	if _, err := ChunkKeyOptions("argon2"); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("unknown chunkKeys accepted: %v", err)
+	}
+}
+
+func TestPerChunkKeysBeyondCounterLimit(t *testing.T) {
+	// The single-key mode refuses to go past 2^31-1 chunks; subkey mode must
+	// keep going and still round-trip.
+	key, _ := GenerateKey(16)
+
+	enc, _ := NewStreamCipher(key)
+	enc.chunkNum = uint64(streamCounterMask)
+	if _, err := enc.EncryptChunk([]byte("x"), false); err == nil {
+		t.Fatal("single-key mode did not enforce the chunk counter limit")
+	}
+
+	enc, _ = NewStreamCipher(key, WithPerChunkKeys())
+	iv := append([]byte(nil), enc.IV()...)
+	enc.chunkNum = uint64(streamCounterMask) + 1
+	ct, err := enc.EncryptChunk([]byte("past the limit"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec, _ := NewStreamDecryptor(key, iv, WithPerChunkKeys())
+	dec.chunkNum = uint64(streamCounterMask) + 1
+	pt, err := dec.DecryptChunk(ct, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "past the limit" {
+		t.Fatalf("got %q", pt)
+	}
+
+	// Same low 31 bits, different index: must not decrypt.
+	dec, _ = NewStreamDecryptor(key, iv, WithPerChunkKeys())
+	dec.chunkNum = 0
+	if _, err := dec.DecryptChunk(ct, true); err == nil {
+		t.Fatal("subkey did not bind the full 64-bit chunk index")
+	}
+}
+
 func TestHMACTokenRoundtrip(t *testing.T) {
 	key, _ := GenerateKey(16)
 	fileID := "0123456789abcdef0123456789abcdef"
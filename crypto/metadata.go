@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// MetadataVersion is the schema version stamped into new metadata as its
+// "v" member. Metadata without one predates versioning and is version 1.
+//
+// Versions only ever add optional members: a reader decodes any version,
+// ignoring members it doesn't know, and treats a member an older version
+// lacks as unset. A change that reinterprets an existing member is a
+// wire-format break and gets a new metadata AAD instead.
+const MetadataVersion = 2
+
+const metadataVersionKey = "v"
+
+// Values of the "compression" member. It names the algorithm the whole
+// plaintext was compressed with before encryption, so it is undone after
+// the last chunk is decrypted. A reader that can't decompress a file must
+// refuse it rather than save the compressed bytes.
+const (
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+)
+
+// ChunkKeysHKDF is the value of the "chunkKeys" member for data sealed
+// WithPerChunkKeys. Without the member every chunk is sealed with the file
+// key itself. A reader that doesn't know the value must refuse the file, as
+// its chunks won't decrypt.
+const ChunkKeysHKDF = "hkdf"
+
+// ChunkKeyOptions returns the StreamOptions for data whose metadata has
+// the given "chunkKeys" member.
+func ChunkKeyOptions(chunkKeys string) ([]StreamOption, error) {
+	switch chunkKeys {
+	case "":
+		return nil, nil
+	case ChunkKeysHKDF:
+		return []StreamOption{WithPerChunkKeys()}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown chunk keys %q", ErrInvalidMetadata, chunkKeys)
+}
+
+// ErrInvalidMetadata is returned for metadata that isn't a JSON object or
+// carries an unusable version.
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// MarshalMetadata encodes m, which must encode as a JSON object, and stamps
+// it with MetadataVersion.
+func MarshalMetadata(m any) ([]byte, error) {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return StampMetadata(raw)
+}
+
+// StampMetadata sets the version member of an encoded metadata object,
+// keeping every other member as it is.
+func StampMetadata(raw []byte) ([]byte, error) {
+	fields, err := metadataFields(raw)
+	if err != nil {
+		return nil, err
+	}
+	fields[metadataVersionKey] = json.RawMessage(strconv.Itoa(MetadataVersion))
+	return json.Marshal(fields)
+}
+
+// UnmarshalMetadata decodes a metadata object into m and returns its
+// schema version. Members m has no field for are skipped, so metadata from
+// a newer client decodes as far as this one understands it.
+func UnmarshalMetadata(raw []byte, m any) (int, error) {
+	fields, err := metadataFields(raw)
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	if v, ok := fields[metadataVersionKey]; ok {
+		if err := json.Unmarshal(v, &version); err != nil || version < 1 {
+			return 0, fmt.Errorf("%w: bad version %s", ErrInvalidMetadata, v)
+		}
+	}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	return version, nil
+}
+
+func metadataFields(raw []byte) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("%w: not an object", ErrInvalidMetadata)
+	}
+	return fields, nil
+}
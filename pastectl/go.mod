@@ -3,13 +3,16 @@ module github.com/jonasbg/paste/pastectl
 go 1.26
 
 require (
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/jonasbg/paste/crypto v0.0.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.51.0
+	golang.org/x/net v0.54.0
+	golang.org/x/sys v0.44.0
+	golang.org/x/term v0.43.0
 )
 
-require (
-	golang.org/x/crypto v0.51.0 // indirect
-	golang.org/x/sys v0.44.0 // indirect
-)
+require golang.org/x/text v0.37.0 // indirect
 
 replace github.com/jonasbg/paste/crypto => ../crypto
@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jonasbg/paste/pastectl/internal/metrics"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// Series the server exports through OpenTelemetry's Prometheus exporter.
+const (
+	metricRequests      = "http_server_requests_total"
+	metricLatency       = "http_server_request_duration_milliseconds"
+	metricTransfers     = "paste_transfer_operations_total"
+	metricTransferBytes = "paste_transfer_bytes_total"
+	metricUploadFiles   = "paste_upload_files_total"
+	metricUploadBytes   = "paste_upload_bytes_total"
+	metricRemoved       = "paste_storage_removed_total"
+	metricStray         = "paste_storage_stray"
+)
+
+var adminSections = []string{"activity", "storage", "requests", "security"}
+
+// adminReport is the --json form of `pastectl admin`. Counters run from
+// the server's last restart.
+type adminReport struct {
+	Activity []adminTransfer `json:"activity,omitempty"`
+	Storage  *adminStorage   `json:"storage,omitempty"`
+	Requests []adminRoute    `json:"requests,omitempty"`
+	Security *adminSecurity  `json:"security,omitempty"`
+}
+
+type adminTransfer struct {
+	Operation string `json:"operation"`
+	Succeeded int64  `json:"succeeded"`
+	Failed    int64  `json:"failed"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type adminStorage struct {
+	FilesUploaded int64 `json:"files_uploaded"`
+	BytesUploaded int64 `json:"bytes_uploaded"`
+	// Removed counts files the sweep deleted, by reason. Stray counts what
+	// the last sweep found and left: files the server didn't write and
+	// policies whose blob is missing.
+	Removed map[string]int64 `json:"removed,omitempty"`
+	Stray   map[string]int64 `json:"stray,omitempty"`
+	// Namespaces counts files uploaded with an API key, by its namespace.
+	Namespaces map[string]int64 `json:"namespaces,omitempty"`
+}
+
+type adminRoute struct {
+	Method    string  `json:"method"`
+	Route     string  `json:"route"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	AverageMS float64 `json:"avg_ms"`
+	// Percentiles are estimated from the route's own latency buckets; for
+	// WebSocket routes they span whole transfers.
+	P50MS float64 `json:"p50_ms"`
+	P95MS float64 `json:"p95_ms"`
+}
+
+type adminSecurity struct {
+	Forbidden    int64            `json:"forbidden"`
+	NotFound     int64            `json:"not_found"`
+	RateLimited  int64            `json:"rate_limited"`
+	ServerErrors int64            `json:"server_errors"`
+	ByRoute      map[string]int64 `json:"rejected_by_route,omitempty"`
+}
+
+// handleAdmin summarises a server's metrics endpoint in the terminal, for
+// operators without a dashboard.
+func (a *App) handleAdmin(args []string) error {
+	if len(args) > 0 && args[0] == "purge" {
+		return a.handleAdminPurge(args[1:])
+	}
+	adminCmd := flag.NewFlagSet("admin", flag.ExitOnError)
+	serverURL := adminCmd.String("url", a.pasteURL, "Paste server URL")
+	path := adminCmd.String("metrics-path", "/metrics", "Path of the server's Prometheus endpoint (OTEL_PROMETHEUS_PATH)")
+	// No env default here: flag.PrintDefaults would echo the secret.
+	token := adminCmd.String("token", "", "Bearer token for a protected endpoint (or set PASTE_ADMIN_TOKEN, or store one with pastectl login)")
+	jsonOut := adminCmd.Bool("json", false, "Print the summary as JSON")
+	watch := adminCmd.Duration("watch", 0, "Refresh every interval, with a request-rate sparkline")
+	network := addNetworkFlags(adminCmd)
+
+	sections := parseInterleaved(adminCmd, args)
+	for _, s := range sections {
+		if !slices.Contains(adminSections, s) {
+			return usagef("unknown section %q (choose from %s)", s, strings.Join(adminSections, ", "))
+		}
+	}
+	if len(sections) == 0 {
+		sections = adminSections
+	}
+	if *watch < 0 || (*watch > 0 && *jsonOut) {
+		return usagef("--watch needs a positive interval and can't be combined with --json")
+	}
+	if !strings.HasPrefix(*path, "/") {
+		return usagef("--metrics-path must start with '/'")
+	}
+	if err := network.apply(); err != nil {
+		return err
+	}
+	*token = adminToken(*token, *serverURL)
+	endpoint := strings.TrimRight(*serverURL, "/") + *path
+
+	if *watch == 0 {
+		set, err := metrics.Fetch(endpoint, *token)
+		if err != nil {
+			return err
+		}
+		r := newAdminReport(set, sections)
+		if *jsonOut {
+			return printJSON(r)
+		}
+		printAdminReport(os.Stdout, r, set)
+		return nil
+	}
+
+	// Each refresh adds the request rate since the previous one.
+	var rates []float64
+	var last float64
+	lastAt := time.Time{}
+	for {
+		set, err := metrics.Fetch(endpoint, *token)
+		if err != nil {
+			return err
+		}
+		total := set.Sum(metricRequests, nil)
+		if !lastAt.IsZero() {
+			rates = append(rates, max(0, total-last)/time.Since(lastAt).Seconds())
+			if len(rates) > 60 {
+				rates = rates[1:]
+			}
+		}
+		last, lastAt = total, time.Now()
+
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("%s  %s  (every %s, Ctrl-C to stop)\n", endpoint, lastAt.Format("15:04:05"), *watch)
+		if len(rates) > 0 {
+			fmt.Printf("Requests/s    %s  %.1f now\n", ui.Sparkline(rates), rates[len(rates)-1])
+		}
+		fmt.Println()
+		printAdminReport(os.Stdout, newAdminReport(set, sections), set)
+		time.Sleep(*watch)
+	}
+}
+
+// adminToken picks the bearer token for the admin routes: the --token
+// flag, then PASTE_ADMIN_TOKEN, then the one stored by pastectl login.
+func adminToken(flagValue, serverURL string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if token := os.Getenv("PASTE_ADMIN_TOKEN"); token != "" {
+		return token
+	}
+	return storedToken(serverURL)
+}
+
+func newAdminReport(set metrics.Set, sections []string) adminReport {
+	var r adminReport
+	if slices.Contains(sections, "activity") {
+		ops := set.By(metricTransfers, "paste_operation", "paste_success")
+		bytes := set.By(metricTransferBytes, "paste_operation")
+		r.Activity = []adminTransfer{}
+		names := map[string]bool{}
+		for key := range ops {
+			op, _, _ := strings.Cut(key, " ")
+			names[op] = true
+		}
+		for _, op := range sortedKeys(names) {
+			r.Activity = append(r.Activity, adminTransfer{
+				Operation: op,
+				Succeeded: int64(ops[op+" true"]),
+				Failed:    int64(ops[op+" false"]),
+				Bytes:     int64(bytes[op]),
+			})
+		}
+	}
+	if slices.Contains(sections, "storage") {
+		r.Storage = &adminStorage{
+			FilesUploaded: int64(set.Sum(metricUploadFiles, nil)),
+			BytesUploaded: int64(set.Sum(metricUploadBytes, nil)),
+			Removed:       map[string]int64{},
+			Stray:         map[string]int64{},
+			Namespaces:    map[string]int64{},
+		}
+		for ns, n := range set.By(metricUploadFiles, "paste_namespace") {
+			if ns != "" {
+				r.Storage.Namespaces[ns] = int64(n)
+			}
+		}
+		for reason, n := range set.By(metricRemoved, "paste_reason") {
+			r.Storage.Removed[reason] = int64(n)
+		}
+		for kind, n := range set.By(metricStray, "paste_kind") {
+			r.Storage.Stray[kind] = int64(n)
+		}
+	}
+	if slices.Contains(sections, "requests") {
+		requests := set.By(metricRequests, "http_request_method", "http_route")
+		r.Requests = []adminRoute{}
+		errs := map[string]float64{}
+		for key, n := range set.By(metricRequests, "http_request_method", "http_route", "http_response_status_code") {
+			i := strings.LastIndex(key, " ")
+			if code, _ := strconv.Atoi(key[i+1:]); code >= 400 {
+				errs[key[:i]] += n
+			}
+		}
+		sums := set.By(metricLatency+"_sum", "http_request_method", "http_route")
+		counts := set.By(metricLatency+"_count", "http_request_method", "http_route")
+		for _, key := range sortedKeys(requests) {
+			method, route, _ := strings.Cut(key, " ")
+			var avg float64
+			if counts[key] > 0 {
+				avg = sums[key] / counts[key]
+			}
+			buckets := set.Buckets(metricLatency, map[string]string{"http_request_method": method, "http_route": route})
+			r.Requests = append(r.Requests, adminRoute{
+				Method:    strings.ToUpper(method),
+				Route:     cmp.Or(route, "(unmatched)"),
+				Requests:  int64(requests[key]),
+				Errors:    int64(errs[key]),
+				AverageMS: avg,
+				P50MS:     routeQuantile(0.5, buckets),
+				P95MS:     routeQuantile(0.95, buckets),
+			})
+		}
+		sort.SliceStable(r.Requests, func(i, j int) bool { return r.Requests[i].Requests > r.Requests[j].Requests })
+	}
+	if slices.Contains(sections, "security") {
+		s := &adminSecurity{ByRoute: map[string]int64{}}
+		for key, n := range set.By(metricRequests, "http_response_status_code", "http_route") {
+			codeStr, route, _ := strings.Cut(key, " ")
+			code, _ := strconv.Atoi(codeStr)
+			switch {
+			case code == 403:
+				s.Forbidden += int64(n)
+			case code == 404:
+				s.NotFound += int64(n)
+			case code == 429:
+				s.RateLimited += int64(n)
+			case code >= 500:
+				s.ServerErrors += int64(n)
+			}
+			if code == 403 || code == 404 || code == 429 {
+				s.ByRoute[cmp.Or(route, "(unmatched)")] += int64(n)
+			}
+		}
+		r.Security = s
+	}
+	return r
+}
+
+func printAdminReport(w io.Writer, r adminReport, set metrics.Set) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	if r.Activity != nil {
+		fmt.Fprintln(tw, "ACTIVITY")
+		fmt.Fprintln(tw, "  Operation\tSucceeded\tFailed\tTransferred")
+		for _, t := range r.Activity {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\n", t.Operation, t.Succeeded, t.Failed, formatSize(t.Bytes))
+		}
+		fmt.Fprintln(tw)
+	}
+	if r.Storage != nil {
+		fmt.Fprintln(tw, "STORAGE")
+		fmt.Fprintf(tw, "  Files uploaded\t%d\n", r.Storage.FilesUploaded)
+		fmt.Fprintf(tw, "  Bytes uploaded\t%s\n", formatSize(r.Storage.BytesUploaded))
+		if r.Storage.FilesUploaded > 0 {
+			fmt.Fprintf(tw, "  Average size\t%s\n", formatSize(r.Storage.BytesUploaded/r.Storage.FilesUploaded))
+		}
+		for _, ns := range sortedKeys(r.Storage.Namespaces) {
+			fmt.Fprintf(tw, "    %s\t%d files\n", ns, r.Storage.Namespaces[ns])
+		}
+		for _, reason := range sortedKeys(r.Storage.Removed) {
+			fmt.Fprintf(tw, "  Removed (%s)\t%d\n", strings.ReplaceAll(reason, "_", " "), r.Storage.Removed[reason])
+		}
+		for _, kind := range sortedKeys(r.Storage.Stray) {
+			fmt.Fprintf(tw, "  Stray (%s)\t%d\n", strings.ReplaceAll(kind, "_", " "), r.Storage.Stray[kind])
+		}
+		fmt.Fprintln(tw)
+	}
+	if r.Requests != nil {
+		fmt.Fprintln(tw, "REQUESTS")
+		fmt.Fprintln(tw, "  Method\tRoute\tRequests\tErrors\tAvg ms\tp50\tp95")
+		for _, rt := range r.Requests {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\t%.1f\t%s\t%s\n", rt.Method, rt.Route, rt.Requests, rt.Errors, rt.AverageMS,
+				formatMS(rt.P50MS), formatMS(rt.P95MS))
+		}
+		if buckets := set.Buckets(metricLatency, nil); len(buckets) > 1 {
+			fmt.Fprintf(tw, "  Latency\t%s\tp50 %s, p95 %s, p99 %s\n", ui.Sparkline(bucketCounts(buckets)),
+				formatMS(metrics.Quantile(0.5, buckets)), formatMS(metrics.Quantile(0.95, buckets)), formatMS(metrics.Quantile(0.99, buckets)))
+		}
+		fmt.Fprintln(tw)
+	}
+	if r.Security != nil {
+		s := r.Security
+		fmt.Fprintln(tw, "SECURITY")
+		fmt.Fprintf(tw, "  Invalid tokens (403)\t%d\n", s.Forbidden)
+		fmt.Fprintf(tw, "  Unknown IDs (404)\t%d\n", s.NotFound)
+		fmt.Fprintf(tw, "  Rate limited (429)\t%d\n", s.RateLimited)
+		fmt.Fprintf(tw, "  Server errors (5xx)\t%d\n", s.ServerErrors)
+		for _, route := range sortedKeys(s.ByRoute) {
+			fmt.Fprintf(tw, "    %s\t%d rejected\n", route, s.ByRoute[route])
+		}
+	}
+}
+
+// bucketCounts turns cumulative histogram buckets into per-bucket counts.
+func bucketCounts(buckets []metrics.Bucket) []float64 {
+	counts := make([]float64, len(buckets))
+	prev := 0.0
+	for i, b := range buckets {
+		counts[i] = b.Count - prev
+		prev = b.Count
+	}
+	return counts
+}
+
+// routeQuantile is metrics.Quantile with 0 for routes without buckets,
+// as the JSON output can't carry NaN.
+func routeQuantile(q float64, buckets []metrics.Bucket) float64 {
+	if v := metrics.Quantile(q, buckets); !math.IsNaN(v) {
+		return v
+	}
+	return 0
+}
+
+func formatMS(ms float64) string {
+	switch {
+	case math.IsNaN(ms):
+		return "-"
+	case ms >= 1000:
+		return fmt.Sprintf("%.1fs", ms/1000)
+	default:
+		return fmt.Sprintf("%.0fms", ms)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
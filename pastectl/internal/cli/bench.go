@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/download"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+	"github.com/jonasbg/paste/pastectl/internal/upload"
+)
+
+// benchReport is the --json form of `pastectl bench`. Rates are bytes per
+// second, durations milliseconds.
+type benchReport struct {
+	Server      string  `json:"server"`
+	Bytes       int64   `json:"bytes"`
+	ChunkSize   int     `json:"chunk_size_mb"`
+	Window      int     `json:"window"`
+	EncryptRate float64 `json:"encrypt_bytes_per_second"`
+	DecryptRate float64 `json:"decrypt_bytes_per_second"`
+	Upload      struct {
+		Rate      float64 `json:"bytes_per_second"`
+		TotalMS   float64 `json:"total_ms"`
+		EncryptMS float64 `json:"encrypt_ms"`
+		AckWaitMS float64 `json:"ack_wait_ms"`
+		// Time from sending a chunk to its ack
+		Ack struct {
+			MinMS float64 `json:"min_ms"`
+			P50MS float64 `json:"p50_ms"`
+			P95MS float64 `json:"p95_ms"`
+			MaxMS float64 `json:"max_ms"`
+		} `json:"ack_latency"`
+	} `json:"upload"`
+	Download struct {
+		Rate    float64 `json:"bytes_per_second"`
+		TotalMS float64 `json:"total_ms"`
+	} `json:"download"`
+	Hints []string `json:"hints,omitempty"`
+}
+
+// handleBench uploads and downloads random data to show where a transfer's
+// time goes: sealing chunks locally, the network, or waiting for acks. The
+// file is deleted by the download, or expires soon after if that fails.
+func (a *App) handleBench(args []string) error {
+	benchCmd := flag.NewFlagSet("bench", flag.ExitOnError)
+	serverURL := benchCmd.String("url", a.pasteURL, "Paste server URL")
+	size := rateFlag(64 << 20)
+	benchCmd.Var(&size, "size", "Bytes of random data to transfer (e.g. 16M, 1G; default 64M)")
+	parallel := benchCmd.Int("parallel", 1, "Send up to N chunks ahead of the server's acks (capped by the server)")
+	jsonOut := benchCmd.Bool("json", false, "Print the results as JSON")
+	network := addNetworkFlags(benchCmd)
+	benchCmd.Parse(args)
+	if benchCmd.NArg() > 0 {
+		return usagef("usage: pastectl bench [--size <N>] [--parallel <N>] [flags]")
+	}
+	if size <= 0 {
+		return usagef("--size must be positive")
+	}
+	if err := network.apply(); err != nil {
+		return err
+	}
+
+	c := client.New(*serverURL)
+	config, err := c.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get server config: %w", err)
+	}
+	if int64(size) > config.MaxFileSizeBytes {
+		return rejectedf("--size %s is over the server's limit of %s", formatSize(int64(size)), formatSize(config.MaxFileSizeBytes))
+	}
+	if *jsonOut {
+		ui.Quiet = true
+	}
+
+	r := &benchReport{Server: *serverURL, Bytes: int64(size), ChunkSize: config.ChunkSize}
+	r.EncryptRate, r.DecryptRate, err = benchCrypto(int64(size), config.ChunkSize<<20, config.KeySize/8)
+	if err != nil {
+		return err
+	}
+
+	key, err := crypto.GenerateKey(config.KeySize / 8)
+	if err != nil {
+		return err
+	}
+	uploader := upload.NewHandler(*serverURL, config).WithWindow(*parallel)
+	if config.SupportsExpiry() {
+		// In case the download fails and leaves the file behind
+		uploader.WithExpiry(time.Duration(max(config.MinExpirySeconds, 600)) * time.Second)
+	}
+	ui.Infof("Uploading %s of random data...\n", formatSize(int64(size)))
+	data := io.LimitReader(rand.Reader, int64(size))
+	_, err = uploader.Upload(data, "bench.bin", "application/octet-stream", int64(size), key)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	up := uploader.Result()
+	r.Window = max(1, min(*parallel, config.MaxUploadWindow))
+	r.Upload.Rate = float64(up.Size) / up.Duration.Seconds()
+	r.Upload.TotalMS = ms(up.Duration)
+	r.Upload.EncryptMS = ms(up.Timing.Encrypt)
+	r.Upload.AckWaitMS = ms(up.Timing.AckWait)
+	if acks := slices.Sorted(slices.Values(up.Timing.AckLatency)); len(acks) > 0 {
+		r.Upload.Ack.MinMS = ms(acks[0])
+		r.Upload.Ack.P50MS = ms(acks[len(acks)/2])
+		r.Upload.Ack.P95MS = ms(acks[len(acks)*95/100])
+		r.Upload.Ack.MaxMS = ms(acks[len(acks)-1])
+	}
+
+	ui.Infof("Downloading...\n")
+	downloader := download.NewHandler(c, config).WithWriter(io.Discard)
+	if err := downloader.Download(up.FileID, key, ""); err != nil {
+		return err
+	}
+	down := downloader.Result()
+	r.Download.Rate = float64(down.Size) / down.Duration.Seconds()
+	r.Download.TotalMS = ms(down.Duration)
+
+	r.Hints = benchHints(r, config.MaxUploadWindow)
+	if *jsonOut {
+		return printJSON(r)
+	}
+	printBenchReport(os.Stdout, r)
+	return nil
+}
+
+// benchCrypto seals and opens size bytes in chunkSize pieces in memory,
+// reusing one chunk of random data, and returns both rates.
+func benchCrypto(size int64, chunkSize, keyBytes int) (float64, float64, error) {
+	key, err := crypto.GenerateKey(keyBytes)
+	if err != nil {
+		return 0, 0, err
+	}
+	enc, err := crypto.NewStreamCipher(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer enc.Clear()
+	dec, err := crypto.NewStreamDecryptor(key, enc.IV())
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dec.Clear()
+
+	chunk := make([]byte, chunkSize)
+	rand.Read(chunk)
+	var sealing, opening time.Duration
+	for done := int64(0); done < size; done += int64(chunkSize) {
+		final := done+int64(chunkSize) >= size
+		started := time.Now()
+		sealed, err := enc.EncryptChunk(chunk, final)
+		if err != nil {
+			return 0, 0, err
+		}
+		sealing += time.Since(started)
+		started = time.Now()
+		if _, err := dec.DecryptChunk(sealed, final); err != nil {
+			return 0, 0, err
+		}
+		opening += time.Since(started)
+	}
+	processed := float64((size + int64(chunkSize) - 1) / int64(chunkSize) * int64(chunkSize))
+	return processed / sealing.Seconds(), processed / opening.Seconds(), nil
+}
+
+// benchHints points at the setting most likely to help.
+func benchHints(r *benchReport, serverWindow int) []string {
+	var hints []string
+	if r.Upload.TotalMS > 0 && r.Upload.AckWaitMS/r.Upload.TotalMS > 0.5 {
+		if serverWindow > r.Window {
+			hints = append(hints, fmt.Sprintf("%.0f%% of the upload was spent waiting for acks; try --parallel %d", 100*r.Upload.AckWaitMS/r.Upload.TotalMS, serverWindow))
+		} else if serverWindow <= 1 {
+			hints = append(hints, "the upload mostly waited for acks and the server allows one chunk in flight; raising MAX_UPLOAD_WINDOW would hide the latency")
+		} else if r.Upload.Ack.P50MS > 0 {
+			hints = append(hints, "acks dominate even with the full window; a larger CHUNK_SIZE would need fewer round trips")
+		}
+	}
+	if r.Upload.TotalMS > 0 && r.Upload.EncryptMS/r.Upload.TotalMS > 0.5 {
+		hints = append(hints, "encryption is the bottleneck; the network is faster than this machine seals data")
+	}
+	return hints
+}
+
+func printBenchReport(w io.Writer, r *benchReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintf(tw, "Server\t%s (%d MB chunks, window %d)\n", r.Server, r.ChunkSize, r.Window)
+	fmt.Fprintf(tw, "Data\t%s\n", formatSize(r.Bytes))
+	fmt.Fprintf(tw, "Encrypt\t%s/s\t(local, in memory)\n", formatSize(int64(r.EncryptRate)))
+	fmt.Fprintf(tw, "Decrypt\t%s/s\n", formatSize(int64(r.DecryptRate)))
+	other := max(0, r.Upload.TotalMS-r.Upload.EncryptMS-r.Upload.AckWaitMS)
+	fmt.Fprintf(tw, "Upload\t%s/s\tin %s: encrypting %s, waiting for acks %s, sending and setup %s\n", formatSize(int64(r.Upload.Rate)),
+		formatMS(r.Upload.TotalMS), formatMS(r.Upload.EncryptMS), formatMS(r.Upload.AckWaitMS), formatMS(other))
+	a := r.Upload.Ack
+	fmt.Fprintf(tw, "Ack latency\tp50 %s\tmin %s, p95 %s, max %s\n", formatMS(a.P50MS), formatMS(a.MinMS), formatMS(a.P95MS), formatMS(a.MaxMS))
+	fmt.Fprintf(tw, "Download\t%s/s\tin %s\n", formatSize(int64(r.Download.Rate)), formatMS(r.Download.TotalMS))
+	for _, h := range r.Hints {
+		fmt.Fprintf(tw, "Hint\t%s\n", h)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -4,13 +4,23 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/jonasbg/paste/crypto"
 	"github.com/jonasbg/paste/pastectl/internal/client"
 	"github.com/jonasbg/paste/pastectl/internal/completion"
+	"github.com/jonasbg/paste/pastectl/internal/config"
 	"github.com/jonasbg/paste/pastectl/internal/download"
+	"github.com/jonasbg/paste/pastectl/internal/history"
+	"github.com/jonasbg/paste/pastectl/internal/types"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
 	"github.com/jonasbg/paste/pastectl/internal/upload"
 )
 
@@ -23,19 +33,40 @@ var Version = "dev"
 
 // App represents the CLI application
 type App struct {
-	pasteURL string
+	pasteURL        string
+	passphraseWords int
+	cfg             *config.Config
 }
 
 // New creates a new CLI app
 func New() *App {
-	// URL can be set via environment variable
+	// Defaults come from the config file; a broken file is reported but
+	// never blocks the command.
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring config file: %v\n", err)
+		cfg = &config.Config{}
+	}
+	ui.Enabled = cfg.ShowProgress() && ui.StderrIsTerminal()
+
+	// URL precedence: flag > PASTE_URL > config file > built-in default
 	pasteURL := DefaultURL
+	if cfg.ServerURL != "" {
+		pasteURL = cfg.ServerURL
+	}
 	if envURL := os.Getenv("PASTE_URL"); envURL != "" {
 		pasteURL = envURL
 	}
 
+	passphraseWords := 4
+	if cfg.PassphraseWords >= 4 && cfg.PassphraseWords <= 8 {
+		passphraseWords = cfg.PassphraseWords
+	}
+
 	return &App{
-		pasteURL: pasteURL,
+		pasteURL:        pasteURL,
+		passphraseWords: passphraseWords,
+		cfg:             cfg,
 	}
 }
 
@@ -49,76 +80,78 @@ func (a *App) Run(args []string) error {
 	downloadCmd := flag.NewFlagSet("download", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 
-	// Upload flags
-	uploadFile := uploadCmd.String("f", "", "File to upload (omit to read from stdin)")
-	uploadName := uploadCmd.String("n", "", "Override filename (default: uses file name or 'stdin.txt')")
-	uploadURL := uploadCmd.String("url", a.pasteURL, "Paste server URL")
-	uploadPassphrase := uploadCmd.Int("p", 4, "Number of words in passphrase (4-8, default: 4)")
-	uploadPassphraseAlt := uploadCmd.Int("passphrase", 0, "Number of words in passphrase (4-8, default: 4)")
-	uploadURLMode := uploadCmd.Bool("url-mode", false, "Use URL mode instead of passphrase")
-
-	sendFile := sendCmd.String("f", "", "File to send (omit to read from stdin)")
-	sendName := sendCmd.String("n", "", "Override filename (default: uses file name or 'stdin.txt')")
-	sendURL := sendCmd.String("url", a.pasteURL, "Paste server URL")
-	sendPassphrase := sendCmd.Int("p", 4, "Number of words in passphrase (4-8, default: 4)")
-	sendPassphraseAlt := sendCmd.Int("passphrase", 0, "Number of words in passphrase (4-8, default: 4)")
-	sendURLMode := sendCmd.Bool("url-mode", false, "Use URL mode instead of passphrase")
+	// Upload flags (shared with send)
+	uploadFlags := a.newUploadFlags(uploadCmd, "upload")
+	sendFlags := a.newUploadFlags(sendCmd, "send")
 
 	// Download flags
 	downloadLink := downloadCmd.String("l", "", "Download link (format: https://paste.torden.tech/{id}#key={key})")
 	downloadOutput := downloadCmd.String("o", "", "Output file (default: original filename or stdout)")
 	downloadURL := downloadCmd.String("url", a.pasteURL, "Paste server URL")
+	downloadJSON := downloadCmd.Bool("json", false, "Print the result as JSON (saves to a file)")
+	downloadQuiet := downloadCmd.Bool("q", false, "Quiet: no progress or status messages")
+	downloadQuietLong := downloadCmd.Bool("quiet", false, "Quiet: no progress or status messages")
+	downloadDebug := downloadCmd.Bool("v", false, "Log protocol steps and timing to stderr")
+	downloadDebugLong := downloadCmd.Bool("debug", false, "Log protocol steps and timing to stderr")
+	downloadNoPreserve := downloadCmd.Bool("no-preserve", false, "Don't restore the sender's file mode and modification time")
+	downloadLimitRate := addRateFlag(downloadCmd)
+	downloadRaw := downloadCmd.Bool("raw", false, "Save the file still encrypted, to open later with decrypt")
+	downloadID := downloadCmd.String("id", "", "File ID, or a link whose #key= fragment was lost (use with --key)")
+	downloadKey := downloadCmd.String("key", "", "Base64 key for --id")
+	downloadOverwrite := addOverwriteFlags(downloadCmd)
+	var downloadDir string
+	downloadCmd.StringVar(&downloadDir, "d", "", "Save into this directory under the original filename")
+	downloadCmd.StringVar(&downloadDir, "dir", "", "Save into this directory under the original filename")
+	downloadNetwork := addNetworkFlags(downloadCmd)
 
 	// If no args provided
 	if len(args) < 1 {
 		if stdinIsPiped {
 			// Default to upload from stdin with passphrase
-			return a.handleUpload("", "", a.pasteURL, 4)
+			return a.handleUpload(uploadOptions{
+				serverURL:       a.pasteURL,
+				passphraseWords: a.passphraseWords,
+				expire:          a.cfg.DefaultExpiry,
+			})
 		}
 		printUsage()
-		return errors.New("no command provided")
+		return usagef("no command provided")
+	}
+
+	// A bare link or share code is downloaded: `pastectl <link>`
+	if link, _ := splitLinkArgs(args, downloadValueFlags...); download.IsLink(link) || download.IsPassphrase(link) {
+		return a.Run(append([]string{"download"}, args...))
 	}
 
 	// If first arg is a flag and stdin is piped, treat as upload
 	if strings.HasPrefix(args[0], "-") && stdinIsPiped {
-		uploadCmd.Parse(args)
-		passphraseWords := *uploadPassphrase
-		if *uploadPassphraseAlt > 0 {
-			passphraseWords = *uploadPassphraseAlt
-		}
-		if *uploadURLMode {
-			passphraseWords = 0 // Use URL mode
+		paths := parseInterleaved(uploadCmd, args)
+		if err := uploadFlags.network.apply(); err != nil {
+			return err
 		}
-		return a.handleUpload(*uploadFile, *uploadName, *uploadURL, passphraseWords)
+		opts := uploadFlags.options()
+		opts.paths = paths
+		return a.handleUpload(opts)
 	}
 
 	switch args[0] {
 	case "upload":
-		uploadCmd.Parse(args[1:])
-		passphraseWords := *uploadPassphrase
-		if *uploadPassphraseAlt > 0 {
-			passphraseWords = *uploadPassphraseAlt
-		}
-		if *uploadURLMode {
-			passphraseWords = 0 // Use URL mode
+		paths := parseInterleaved(uploadCmd, args[1:])
+		if err := uploadFlags.network.apply(); err != nil {
+			return err
 		}
-		return a.handleUpload(*uploadFile, *uploadName, *uploadURL, passphraseWords)
+		opts := uploadFlags.options()
+		opts.paths = paths
+		return a.handleUpload(opts)
 
 	case "send":
-		sendCmd.Parse(args[1:])
-		if *sendFile == "" {
-			if extraArgs := sendCmd.Args(); len(extraArgs) > 0 {
-				*sendFile = extraArgs[len(extraArgs)-1]
-			}
-		}
-		passphraseWords := *sendPassphrase
-		if *sendPassphraseAlt > 0 {
-			passphraseWords = *sendPassphraseAlt
-		}
-		if *sendURLMode {
-			passphraseWords = 0 // Use URL mode
+		paths := parseInterleaved(sendCmd, args[1:])
+		if err := sendFlags.network.apply(); err != nil {
+			return err
 		}
-		return a.handleUpload(*sendFile, *sendName, *sendURL, passphraseWords)
+		opts := sendFlags.options()
+		opts.paths = paths
+		return a.handleUpload(opts)
 
 	case "download":
 		// Find passphrase/link in any position (non-flag argument)
@@ -130,7 +163,7 @@ func (a *App) Run(args []string) error {
 			if strings.HasPrefix(arg, "-") {
 				filteredArgs = append(filteredArgs, arg)
 				// If it's a flag that takes a value, include the next arg too
-				if (arg == "-l" || arg == "-o" || arg == "--url") && i+1 < len(args) {
+				if slices.Contains(downloadValueFlags, arg) && i+1 < len(args) {
 					i++
 					filteredArgs = append(filteredArgs, args[i])
 				}
@@ -147,12 +180,79 @@ func (a *App) Run(args []string) error {
 			*downloadLink = foundLink
 		}
 
-		if *downloadLink == "" {
+		if *downloadLink != "" && *downloadID != "" {
+			return usagef("give either a link or passphrase, or --id with --key, not both")
+		}
+		if (*downloadID == "") != (*downloadKey == "") {
+			return usagef("--id and --key go together")
+		}
+		if *downloadLink == "" && *downloadID == "" {
 			fmt.Fprintf(os.Stderr, "Error: download link or passphrase is required\n")
 			downloadCmd.PrintDefaults()
-			return errors.New("download link or passphrase is required")
+			return usagef("download link or passphrase is required")
 		}
-		return a.handleDownload(*downloadLink, *downloadOutput, *downloadURL)
+		if *downloadQuiet || *downloadQuietLong {
+			ui.Quiet = true
+		}
+		if *downloadDebug || *downloadDebugLong {
+			ui.Debug = true
+		}
+		if err := downloadNetwork.apply(); err != nil {
+			return err
+		}
+		overwrite, err := downloadOverwrite()
+		if err != nil {
+			return err
+		}
+		return a.handleDownload(downloadOptions{
+			link:       *downloadLink,
+			id:         *downloadID,
+			key:        *downloadKey,
+			outputPath: *downloadOutput,
+			serverURL:  *downloadURL,
+			json:       *downloadJSON,
+			noPreserve: *downloadNoPreserve,
+			limitRate:  int64(*downloadLimitRate),
+			raw:        *downloadRaw,
+			overwrite:  overwrite,
+			dir:        downloadDir,
+		})
+
+	case "watch":
+		return a.handleWatch(args[1:])
+
+	case "mirror":
+		return a.handleMirror(args[1:])
+
+	case "note":
+		return a.handleNote(args[1:])
+
+	case "decrypt":
+		return a.handleDecrypt(args[1:])
+
+	case "admin":
+		return a.handleAdmin(args[1:])
+
+	case "bench":
+		return a.handleBench(args[1:])
+
+	case "health":
+		return a.handleHealth(args[1:])
+
+	case "info":
+		return a.handleInfo(args[1:])
+
+	case "exists":
+		return a.handleExists(args[1:])
+
+	case "list", "ls":
+		return a.handleList(args[1:])
+
+	case "open":
+		return a.handleOpen(args[1:])
+
+	case "stats":
+		return a.handleStats(args[1:])
 
 	case "version", "-v", "--version":
 		fmt.Printf("pastectl v%s\n", Version)
@@ -166,76 +266,377 @@ func (a *App) Run(args []string) error {
 		if len(args) < 2 {
 			fmt.Fprintf(os.Stderr, "Error: shell type required (bash, zsh, or fish)\n")
 			fmt.Fprintf(os.Stderr, "Usage: pastectl completion <shell>\n")
-			return errors.New("shell type required")
+			return usagef("shell type required")
 		}
 		return completion.PrintCompletion(args[1])
 
+	case "login":
+		return a.handleLogin(args[1:])
+
+	case "logout":
+		return a.handleLogout(args[1:])
+
+	case "man":
+		return handleMan(args[1:])
+
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
 		printUsage()
-		return fmt.Errorf("unknown command: %s", args[0])
+		return usagef("unknown command: %s", args[0])
 	}
 }
 
-func (a *App) handleUpload(filePath, customName, serverURL string, passphraseWords int) error {
-	// Prepare input
-	reader, filename, contentType, fileSize, err := upload.PrepareInput(filePath, customName)
+func (a *App) handleUpload(opts uploadOptions) error {
+	if opts.quiet {
+		ui.Quiet = true
+	}
+	if opts.debug {
+		ui.Debug = true
+	}
+
+	if opts.followSymlinks && opts.keepSymlinks {
+		return usagef("--follow-symlinks and --preserve-symlinks are mutually exclusive")
+	}
+
+	if opts.resume && opts.separate {
+		return usagef("--resume and --separate are mutually exclusive")
+	}
+	if opts.resume && opts.compress != "" {
+		return usagef("compressed uploads can't be resumed; drop --compress")
+	}
+
+	if err := checkShareFormat(opts); err != nil {
+		return err
+	}
+	name, err := expandName(opts.customName)
+	if err != nil {
+		return err
+	}
+	opts.customName = name
+
+	inputs := opts.inputs()
+	if opts.tee && (len(inputs) > 0 || opts.resume || opts.mirror != "" || opts.note != "") {
+		return usagef("--tee only works with piped input")
+	}
+	if opts.separate && len(inputs) > 1 {
+		return a.uploadSeparately(opts, inputs)
+	}
+
+	var entry history.Entry
+	var result upload.Result
+	switch {
+	case opts.mirror != "":
+		entry, result, err = a.mirrorURL(opts)
+	case opts.note != "":
+		entry, result, err = a.uploadNote(opts)
+	case opts.resume:
+		entry, result, err = a.resumeUpload(opts, inputs)
+	default:
+		entry, result, err = a.uploadInputs(opts, inputs)
+	}
 	if err != nil {
 		return err
 	}
 
+	// With --tee, stdout carries the data itself.
+	out := io.Writer(os.Stdout)
+	if opts.tee {
+		out = os.Stderr
+	}
+	if opts.json {
+		return writeJSON(out, uploadReport(entry, result))
+	} else if opts.format != "" {
+		return writeShareFormat(out, opts.format, entry, result)
+	} else if opts.quiet {
+		// Just the link, for `LINK=$(pastectl -q < file)`
+		fmt.Fprintln(out, entry.Link)
+	} else {
+		// Print result
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(out, "On the other computer, please run:\n")
+		fmt.Fprintf(out, "  %s\n", entry.DownloadCommand())
+		if !entry.ExpiresAt.IsZero() {
+			fmt.Fprintf(out, "Expires: %s (in %s)\n", entry.ExpiresAt.Local().Format("2006-01-02 15:04 MST"),
+				time.Until(entry.ExpiresAt).Round(time.Minute))
+		}
+		if result.MaxDownloads > 1 {
+			fmt.Fprintf(out, "Downloads: %d\n", result.MaxDownloads)
+		}
+		fmt.Fprintf(out, "SHA-256: %s\n", result.SHA256)
+	}
+	return nil
+}
+
+// uploadInputs uploads stdin (no inputs), a single file or directory, or a
+// bundle of several inputs as one link, and records it in the history.
+func (a *App) uploadInputs(opts uploadOptions, inputs []string) (history.Entry, upload.Result, error) {
+	archive := upload.ArchiveOptions{
+		Exclude:        opts.exclude,
+		FollowSymlinks: opts.followSymlinks,
+	}
+
+	// Prepare input
+	var reader io.Reader
+	var filename, contentType string
+	var fileSize int64
+	var err error
+	if len(inputs) > 1 {
+		reader, filename, contentType, fileSize, err = upload.PrepareBundle(inputs, opts.customName, archive)
+	} else {
+		var filePath string
+		if len(inputs) == 1 {
+			filePath = inputs[0]
+		}
+		reader, filename, contentType, fileSize, err = upload.PrepareInput(filePath, opts.customName, archive)
+	}
+	if err != nil {
+		return history.Entry{}, upload.Result{}, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if opts.tee {
+		reader = io.TeeReader(reader, newPassThrough(os.Stdout))
+	}
+
 	// Create client and get config
-	c := client.New(serverURL)
+	c := client.New(opts.serverURL)
 	config, err := c.GetConfig()
 	if err != nil {
-		return fmt.Errorf("failed to get server config: %w", err)
+		return history.Entry{}, upload.Result{}, fmt.Errorf("failed to get server config: %w", err)
+	}
+	return a.uploadReader(opts, inputs, config, reader, filename, contentType, fileSize)
+}
+
+// uploadReader uploads prepared input to the server config came from and
+// records it in the history. inputs are the paths it was read from, if
+// any, for resume and file attributes.
+func (a *App) uploadReader(opts uploadOptions, inputs []string, config *types.Config, reader io.Reader, filename, contentType string, fileSize int64) (history.Entry, upload.Result, error) {
+	serverURL, passphraseWords := opts.serverURL, opts.passphraseWords
+	if fileSize == upload.SizeUnknown && !config.SupportsStreaming() {
+		// Older servers need the size up front; buffer on disk, not in RAM.
+		ui.Debugf("server lacks streaming uploads, spooling input to disk")
+		spool, n, err := upload.Spool(reader)
+		if err != nil {
+			return history.Entry{}, upload.Result{}, err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		reader, fileSize = spool, n
 	}
 
 	if fileSize > config.MaxFileSizeBytes {
-		return fmt.Errorf("file size (%d bytes) exceeds server limit (%d bytes)", fileSize, config.MaxFileSizeBytes)
+		return history.Entry{}, upload.Result{}, rejectedf("file size (%d bytes) exceeds server limit (%d bytes)", fileSize, config.MaxFileSizeBytes)
+	}
+
+	expiry, err := resolveExpiry(opts.expire, config)
+	if err != nil {
+		// A default from the config file must not break uploads to
+		// servers that lack expiry support.
+		if opts.expire != a.cfg.DefaultExpiry || config.SupportsExpiry() {
+			return history.Entry{}, upload.Result{}, err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: ignoring default_expiry: %v\n", err)
+	}
+
+	maxDownloads, err := resolveMaxDownloads(opts.maxDownloads, config)
+	if err != nil {
+		return history.Entry{}, upload.Result{}, err
+	}
+
+	chunkSize, err := resolveChunkSize(opts.chunkSize, config)
+	if err != nil {
+		return history.Entry{}, upload.Result{}, err
+	}
+
+	var userKey []byte
+	if opts.key != "" {
+		if userKey, err = decodeUserKey(opts.key, config); err != nil {
+			return history.Entry{}, upload.Result{}, err
+		}
 	}
 
 	// Create upload handler
-	handler := upload.NewHandler(serverURL, config)
+	handler := upload.NewHandler(serverURL, config).
+		WithExpiry(expiry).
+		WithMaxDownloads(maxDownloads).
+		WithWindow(opts.parallel).
+		WithChunkSize(chunkSize).
+		WithPerChunkKeys(opts.perChunkKeys).
+		WithRateLimit(opts.limitRate).
+		WithCompression(opts.compress).
+		WithLanguage(opts.lang).
+		WithTitle(opts.title)
+	if len(inputs) == 1 {
+		if st, err := os.Stat(inputs[0]); err == nil && st.Mode().IsRegular() {
+			handler.WithFileAttributes(st.Mode(), st.ModTime()).
+				WithResumeSource(inputs[0])
+		}
+	}
+	entry := history.Entry{
+		ServerURL:  serverURL,
+		Filename:   filename,
+		Size:       fileSize,
+		UploadedAt: time.Now(),
+	}
 
 	// Check if passphrase mode is enabled
 	if passphraseWords > 0 {
 		// Validate word count
 		if passphraseWords < 4 || passphraseWords > 8 {
-			return fmt.Errorf("passphrase word count must be between 4 and 8, got %d", passphraseWords)
+			return history.Entry{}, upload.Result{}, usagef("passphrase word count must be between 4 and 8, got %d", passphraseWords)
 		}
 
 		// Upload with passphrase
 		passphrase, err := handler.UploadWithPassphrase(reader, filename, contentType, fileSize, passphraseWords)
 		if err != nil {
-			return err
+			return history.Entry{}, upload.Result{}, interrupted(handler, inputs, err)
 		}
-
-		// Print result
-		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Printf("On the other computer, please run:\n")
-		fmt.Printf("  pastectl download %s\n", passphrase)
+		entry.Link, entry.Passphrase = passphrase, true
 	} else {
-		// Traditional URL-based mode
-		key, err := crypto.GenerateKey(config.KeySize / 8)
-		if err != nil {
-			return fmt.Errorf("failed to generate key: %w", err)
+		// Traditional URL-based mode, optionally with a caller-supplied key
+		key := userKey
+		if key == nil {
+			key, err = crypto.GenerateKey(config.KeySize / 8)
+			if err != nil {
+				return history.Entry{}, upload.Result{}, fmt.Errorf("failed to generate key: %w", err)
+			}
 		}
 
 		shareURL, err := handler.Upload(reader, filename, contentType, fileSize, key)
 		if err != nil {
-			return err
+			return history.Entry{}, upload.Result{}, interrupted(handler, inputs, err)
 		}
+		entry.Link = shareURL
+	}
 
-		// Print result
-		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Printf("On the other computer, please run:\n")
-		fmt.Printf("  pastectl download -l \"%s\"\n", shareURL)
+	result := handler.Result()
+	entry.Size = result.Size
+	entry.ExpiresAt = result.ExpiresAt
+	entry.OwnerToken = result.OwnerToken
+	a.recordHistory(entry)
+	return entry, result, nil
+}
+
+// resumeUpload continues the interrupted upload of the single input, or
+// the most recent one if no input is given.
+func (a *App) resumeUpload(opts uploadOptions, inputs []string) (history.Entry, upload.Result, error) {
+	if len(inputs) > 1 {
+		return history.Entry{}, upload.Result{}, usagef("--resume takes at most one file")
 	}
-	return nil
+	var source string
+	if len(inputs) == 1 {
+		source = inputs[0]
+	}
+	state, err := upload.FindState(source)
+	if err != nil {
+		return history.Entry{}, upload.Result{}, fmt.Errorf("failed to read resume state: %w", err)
+	}
+	if state == nil {
+		return history.Entry{}, upload.Result{}, errors.New("no interrupted upload to resume")
+	}
+
+	// The upload belongs to the server it was started on, whatever --url says.
+	c := client.New(state.ServerURL)
+	config, err := c.GetConfig()
+	if err != nil {
+		return history.Entry{}, upload.Result{}, fmt.Errorf("failed to get server config: %w", err)
+	}
+
+	handler := upload.NewHandler(state.ServerURL, config).
+		WithWindow(opts.parallel).
+		WithRateLimit(opts.limitRate)
+	link, err := handler.Resume(state)
+	if errors.Is(err, upload.ErrNotResumable) {
+		state.Remove()
+		return history.Entry{}, upload.Result{}, fmt.Errorf("%w; upload %s again without --resume", err, state.Source)
+	}
+	if err != nil {
+		return history.Entry{}, upload.Result{}, err
+	}
+
+	result := handler.Result()
+	entry := history.Entry{
+		Link:       link,
+		Passphrase: state.Passphrase != "",
+		ServerURL:  state.ServerURL,
+		Filename:   state.Filename,
+		Size:       result.Size,
+		UploadedAt: time.Now(),
+		ExpiresAt:  result.ExpiresAt,
+		OwnerToken: result.OwnerToken,
+	}
+	a.recordHistory(entry)
+	return entry, result, nil
 }
 
-func (a *App) handleDownload(link, outputPath, serverURL string) error {
+// interrupted points the user at --resume when a failed upload left state
+// to continue from.
+func interrupted(h *upload.Handler, inputs []string, err error) error {
+	if !h.Interrupted() {
+		return err
+	}
+	return fmt.Errorf("%w\nContinue with: pastectl upload --resume %s", err, inputs[0])
+}
+
+func (a *App) recordHistory(entry history.Entry) {
+	if !a.cfg.RecordHistory() {
+		return
+	}
+	var key []byte
+	if a.cfg.EncryptHistory {
+		var err error
+		if key, err = historyKey(true); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: upload not recorded in the history: %v\n", err)
+			return
+		}
+	}
+	if err := history.Append(entry, key); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record upload history: %v\n", err)
+	}
+}
+
+// splitDownloadTarget returns the file ID, key and (if the link names one)
+// server of a link, or of --id and --key, for links whose fragment a chat
+// client dropped. --id may be the bare ID or the truncated link.
+func splitDownloadTarget(opts downloadOptions) (string, []byte, string, error) {
+	if opts.id == "" {
+		return download.ParseLink(opts.link)
+	}
+	key, err := crypto.DecodeKey(strings.TrimPrefix(opts.key, "key="))
+	if err != nil {
+		return "", nil, "", usagef("invalid --key: %w", err)
+	}
+	if err := crypto.ValidateKeyLength(key); err != nil {
+		return "", nil, "", usagef("invalid --key: %w", err)
+	}
+	if !strings.Contains(opts.id, "://") {
+		return opts.id, key, "", nil
+	}
+	u, err := url.Parse(opts.id)
+	if err != nil {
+		return "", nil, "", usagef("invalid --id: %w", err)
+	}
+	id := path.Base(u.Path)
+	if id == "/" || id == "." {
+		return "", nil, "", usagef("invalid --id: the link has no file ID")
+	}
+	return id, key, u.Scheme + "://" + u.Host, nil
+}
+
+func (a *App) handleDownload(opts downloadOptions) error {
+	link, outputPath, serverURL := opts.link, opts.outputPath, opts.serverURL
+	if opts.dir != "" {
+		if err := os.MkdirAll(opts.dir, 0o755); err != nil {
+			return err
+		}
+		if outputPath != "" && !filepath.IsAbs(outputPath) {
+			outputPath = filepath.Join(opts.dir, outputPath)
+		}
+	}
+	var handler *download.Handler
+	var err error
+
 	// Check if input is a passphrase instead of a URL
 	if download.IsPassphrase(link) {
 		// Create client and get config
@@ -246,43 +647,106 @@ func (a *App) handleDownload(link, outputPath, serverURL string) error {
 		}
 
 		// Create download handler and download with passphrase
-		handler := download.NewHandler(c, config)
-		return handler.DownloadWithPassphrase(link, outputPath)
-	}
+		handler = a.newDownloadHandler(c, config, opts)
+		err = handler.DownloadWithPassphrase(link, outputPath)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Traditional URL-based download, or an ID and key given apart
+		fileID, key, linkServerURL, err := splitDownloadTarget(opts)
+		if err != nil {
+			return err
+		}
 
-	// Traditional URL-based download
-	fileID, key, linkServerURL, err := download.ParseLink(link)
-	if err != nil {
-		return err
-	}
+		// Use server URL from link if present
+		if linkServerURL != "" {
+			serverURL = linkServerURL
+		}
+
+		// Create client and get config
+		c := client.New(serverURL)
+		config, err := c.GetConfig()
+		if err != nil {
+			return fmt.Errorf("failed to get server config: %w", err)
+		}
 
-	// Use server URL from link if present
-	if linkServerURL != "" {
-		serverURL = linkServerURL
+		// Create download handler and download
+		handler = a.newDownloadHandler(c, config, opts)
+		if err = handler.Download(fileID, key, outputPath); err != nil {
+			return err
+		}
 	}
 
-	// Create client and get config
-	c := client.New(serverURL)
-	config, err := c.GetConfig()
-	if err != nil {
-		return fmt.Errorf("failed to get server config: %w", err)
+	if opts.json {
+		result := handler.Result()
+		err = printJSON(report{
+			ID:                 result.FileID,
+			Filename:           result.Metadata.Filename,
+			ContentType:        result.Metadata.ContentType,
+			Lang:               result.Metadata.Lang,
+			Size:               result.Size,
+			DurationMS:         result.Duration.Milliseconds(),
+			SHA256:             result.SHA256,
+			Output:             result.OutputPath,
+			ExpiresAt:          optionalTime(result.ExpiresAt),
+			DownloadsRemaining: result.DownloadsRemaining,
+		})
 	}
+	return err
+}
 
-	// Create download handler and download
-	handler := download.NewHandler(c, config)
-	return handler.Download(fileID, key, outputPath)
+func (a *App) newDownloadHandler(c *client.Client, config *types.Config, opts downloadOptions) *download.Handler {
+	handler := download.NewHandler(c, config).
+		WithOutputDir(a.cfg.OutputDir).
+		WithFileAttributes(!opts.noPreserve).
+		WithRateLimit(opts.limitRate).
+		WithOverwrite(opts.overwrite)
+	if opts.dir != "" {
+		handler.WithOutputDir(opts.dir)
+	}
+	if opts.json || opts.dir != "" {
+		handler.WithSaveToFile()
+	}
+	if opts.raw {
+		handler.WithRaw()
+	}
+	return handler
 }
 
 func printUsage() {
-	fmt.Fprintf(os.Stderr, `pastectl v%s - Zero-trust encrypted file sharing with memorable passphrases
+	fmt.Fprint(os.Stderr, usageText())
+}
+
+// usageText is the help text. Man pages are generated from it too, so its
+// layout (see manpage.Generate) is load-bearing.
+func usageText() string {
+	return fmt.Sprintf(`pastectl v%s - Zero-trust encrypted file sharing with memorable passphrases
 
 Usage:
 	pastectl [flags]                          Upload from stdin (when piped)
-	pastectl upload [flags]                   Upload a file or directory
-	pastectl send [flags] [file]              Alias for upload
+	pastectl upload [flags] [path...]         Upload files or directories
+	pastectl send [flags] [path...]           Alias for upload
 	pastectl download <passphrase> [flags]    Download using share code
 	pastectl download -l <url> [flags]        Download using URL
+	pastectl <passphrase|url> [flags]         Same as download
+	pastectl watch <dir> [flags]              Upload files as they appear in a directory
+	pastectl mirror <url> [flags]             Re-share content from an HTTP(S) URL
+	pastectl note [-m <text>] [flags]         Share a snippet written in $EDITOR
+	pastectl decrypt <file> <passphrase|url>  Open a file saved with download --raw
+	pastectl admin [section...] [flags]       Summarise the server's metrics endpoint
+	pastectl admin purge [flags]              Delete stored files in bulk on the server
+	pastectl login [--url <server>]           Keep the server's token in the credential store
+	pastectl logout [--url <server>]          Forget the server's stored token
+	pastectl health [flags]                   Check the server before a large transfer
+	pastectl bench [flags]                    Measure encryption and transfer speed
+	pastectl info <passphrase|url>            Show file details without downloading
+	pastectl exists <passphrase|url> [-q]     Exit 0 if the file is still available, 1 if not
+	pastectl list [flags] [filter]            Show previous uploads
+	pastectl open <n> [-c]                    Re-print (or copy) upload n from the list
+	pastectl stats <n> [--json]               Show how often upload n was downloaded
 	pastectl completion <shell>               Generate shell completion
+	pastectl man [dir]                        Print the man page, or write all pages to dir
 	pastectl version                          Show version
 	pastectl help                             Show this help
 
@@ -312,29 +776,161 @@ URL MODE (Maximum Security):
 
 	pastectl download -l "https://paste.torden.tech/a1b2c3...#key=Xk9fB2mPqR..."
 
-Upload Flags:
+Upload Flags (upload, send):
 	-f <file>          File or directory to upload (omit for stdin)
-	-n <name>          Override filename
+	-n <name>          Override filename; may use {{date}}, {{time}}, {{host}}
+	                   and {{branch}}, e.g. -n "report-{{date}}.log"
+	--exclude <glob>   Skip matching paths in a directory upload (repeatable;
+	                   also read from .pasteignore in the directory)
+	--parallel <N>     Keep up to N chunks in flight (faster on high-latency links)
+	--chunk-size <MB>  Seal in chunks of this size instead of the server's
+	                   default, within the range the server allows
+	--resume [file]    Continue an interrupted upload (default: the latest)
+	--limit-rate <N>   Cap the upload at N bytes per second (e.g. 500K, 5M)
+	--lang <name>      Syntax-highlighting hint for code, e.g. go or python
+	--compress[=alg]   Compress before encrypting with zstd (default) or gzip;
+	                   downloads decompress automatically (pastectl only)
+	--separate         With several inputs, one link per input instead of a bundle
+	--tee              Pass piped input through to stdout while uploading it
+	                   (the link goes to stderr)
+	--format <tmpl>    Print the share message from a Go template, e.g.
+	                   'Download {{.URL}} (expires {{.Expiry}})'
+	--follow-symlinks  Archive what symlinks point to instead of the links
+	--preserve-symlinks Store symlinks as links with their targets (default)
 	-p <N>             Number of words in passphrase (4-8, default: 4)
+	--expire <dur>     Delete the file after this long (e.g. 30m, 2h, 3d)
+	--burn             Delete the file after the first download
+	--downloads <N>    Delete the file after N downloads
 	--url-mode         Use URL mode with random 128-bit key (max security)
+	--key <base64>     Use a pre-provisioned key (implies URL mode)
 	--url <url>        Custom server URL
+	--json             Print the result as JSON
+	-q, --quiet        No progress output; print only the link or passphrase
+	-v, --debug        Log protocol steps, chunk counts and timing to stderr
 
 Download Flags:
 	-l <url>           URL with embedded key (from --url-mode uploads)
 	-o <file>          Output file (default: original filename)
+	-d, --dir <dir>    Save into this directory under the original filename
 	--url <url>        Custom server URL
+	--json             Print the result as JSON (always saves to a file)
+	-q, --quiet        No progress or status messages
+	--no-preserve      Don't restore the sender's file mode and mtime
+	--limit-rate <N>   Cap the download at N bytes per second (e.g. 500K, 5M)
+	--raw              Save the file still encrypted (as <name>.paste), to open
+	                   later without the server using decrypt
+	--force            Overwrite the output file if it exists
+	--auto-rename      If it exists, save as name-1.ext (name-2.ext, ...) instead
+	--id <id|url>      File ID, or a link that lost its #key= part; with --key
+	--key <base64>     The key for --id (the part after #key=)
+	-v, --debug        Log protocol steps, chunk counts and timing to stderr
+
+Decrypt Flags:
+	-f <file>          File saved with download --raw (or the first argument)
+	--key <key|url>    Base64 key or share link (or the second argument, or
+	                   PASTE_KEY); passphrases are given as the second argument
+	-o <file>          Output file (default: original filename)
+	--json, -q, -v, --no-preserve, --force, --auto-rename
+	                   As for download
+
+Watch Flags (also takes the upload flags):
+	--log <file>       Append each upload's time, filename and link to a file
+	--webhook <url>    POST each upload's JSON report to a URL
+	--settle <dur>     Upload a file once it has not changed for this long
+	                   (default: 2s)
+
+Note Flags (also takes the upload flags):
+	-m <text>          Use this text instead of opening $VISUAL or $EDITOR
+	-t <title>         Title, kept in the metadata (default: the first line);
+	                   the file is named after it unless -n is given
+
+Admin Flags (sections: activity, storage, requests, security; default all):
+	--metrics-path <p> Path of the server's Prometheus endpoint (default: /metrics)
+	--token <token>    Bearer token, if the endpoint is protected
+	                   (or set PASTE_ADMIN_TOKEN, or store it with login)
+	--watch <dur>      Refresh every interval, with a request-rate sparkline
+	--json             Print the summary as JSON
+
+Admin Purge Flags (at least one filter, or --all; filters combine):
+	--older-than <dur> Only files uploaded longer ago than this
+	--larger-than <N>  Only files larger than this (e.g. 500MB)
+	--namespace <ns>   Only files uploaded into this API key namespace
+	--all              Every stored file
+	--dry-run          Count the matching files without deleting them
+	-y, --yes          Don't ask before deleting
+	--json             Print the result as JSON
+
+Health Flags:
+	-n <N>             Requests to measure the round trip with (default: 5)
+	--no-probe         Don't send test chunks
+	--json             Print the report as JSON
+
+Bench Flags:
+	--size <N>         Bytes of random data to transfer (default: 64M)
+	--parallel <N>     Keep up to N chunks in flight, as for upload
+	--json             Print the results as JSON
+
+Network Flags (upload, send, download, watch, mirror, admin, health, bench, info, exists, stats):
+	--timeout <dur>    Give up when the server sends nothing for this long
+	                   (default: 60s, 0 disables)
+	--connect-timeout <dur>
+	                   Give up connecting after this long (default: 15s)
+	--proxy <url>      Use this http://, https:// or socks5:// proxy
+	--cacert <file>    Also trust the CAs in this PEM bundle (private PKI)
+	--insecure         Skip TLS certificate verification (testing only)
+	--api-key <key>    Upload into this API key's namespace
+
+History Flags (list):
+	-n <N>             Show at most N entries (default: 20)
+	-a                 Include expired uploads
 
 Security:
 	- All encryption happens client-side (AES-256-GCM)
 	- Server stores only encrypted blobs - cannot read your files
 	- Passphrase mode: ~57 bits entropy (4 words) to ~78 bits (8 words)
 	- URL mode: 128 bits entropy (cryptographically random)
-	- Files are deleted after first download
+	- Files are deleted after first download (unless --downloads N is used)
 
 	See: https://github.com/jonasbg/paste/blob/main/.github/docs/security.md
 
+Progress bars are hidden automatically when stderr is not a terminal.
+
+Exit Status:
+	0            Success
+	1            Any other failure; also "not available" from exists, and a
+	             failed check from health
+	2            Invalid arguments or flags
+	3            Network error: the server couldn't be reached, or the
+	             connection dropped
+	4            The server refused the request, or its limits rule it out
+	5            Decryption failed: wrong key or passphrase, or damaged data
+	6            No such file: expired, already downloaded, or never uploaded
+
 Environment Variables:
 	PASTE_URL    Default server URL (default: %s)
+	PASTE_KEY    Pre-provisioned upload key (same as --key)
+	PASTE_ADMIN_TOKEN
+	             Bearer token for admin (same as --token)
+	PASTE_CREDENTIAL_STORE
+	             Where login keeps tokens (and the encrypt_history key):
+	             keychain, secret-service or file
+	             (default: the system store if there is one, else file)
+	PASTE_CREDENTIAL_PASSPHRASE
+	             Passphrase sealing the credentials file (not needed on Windows)
+	PASTE_CONFIG Config file path (default: $XDG_CONFIG_HOME/paste/config.toml)
+	PASTE_DEBUG  Set to any value to enable --debug logging
+	PASTE_CACERT CA bundle to trust (same as --cacert)
+	PASTE_API_KEY
+	             API key for servers that have them (same as --api-key)
+	HTTP_PROXY, HTTPS_PROXY, ALL_PROXY, NO_PROXY
+	             Proxy settings, used unless --proxy is given
+
+Config File (~/.config/paste/config.toml):
+	server_url = "https://paste.example.com"
+	passphrase_words = 5
+	output_dir = "~/Downloads"
+	progress = false
+	Flags and environment variables take precedence over the config file.
 
 `, Version, DefaultURL)
 }
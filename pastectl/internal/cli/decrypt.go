@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"flag"
+	"os"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/download"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// handleDecrypt opens a blob saved with download --raw. Everything needed is
+// in the file and the key, so the server is never contacted and the file
+// may long since have expired.
+func (a *App) handleDecrypt(args []string) error {
+	decryptCmd := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	blobPath := decryptCmd.String("f", "", "Blob saved with download --raw")
+	keyArg := decryptCmd.String("key", "", "Base64 key or share link (or set PASTE_KEY)")
+	output := decryptCmd.String("o", "", "Output file (default: original filename or stdout)")
+	jsonOut := decryptCmd.Bool("json", false, "Print the result as JSON (saves to a file)")
+	quiet := decryptCmd.Bool("q", false, "Quiet: no progress or status messages")
+	quietLong := decryptCmd.Bool("quiet", false, "Quiet: no progress or status messages")
+	debug := decryptCmd.Bool("v", false, "Log each step to stderr")
+	debugLong := decryptCmd.Bool("debug", false, "Log each step to stderr")
+	noPreserve := decryptCmd.Bool("no-preserve", false, "Don't restore the sender's file mode and modification time")
+	overwriteFlags := addOverwriteFlags(decryptCmd)
+
+	positional := parseInterleaved(decryptCmd, args)
+	if *blobPath == "" && len(positional) > 0 {
+		*blobPath, positional = positional[0], positional[1:]
+	}
+	if *blobPath == "" || len(positional) > 1 {
+		return usagef("usage: pastectl decrypt <file.paste> <passphrase|link> [flags]")
+	}
+	overwrite, err := overwriteFlags()
+	if err != nil {
+		return err
+	}
+	if *quiet || *quietLong {
+		ui.Quiet = true
+	}
+	if *debug || *debugLong {
+		ui.Debug = true
+	}
+
+	// The secret is the positional passphrase or link, else --key, else
+	// PASTE_KEY.
+	secret := *keyArg
+	if len(positional) == 1 {
+		secret = positional[0]
+	}
+	if secret == "" {
+		secret = os.Getenv("PASTE_KEY")
+	}
+	if secret == "" {
+		return usagef("a passphrase, share link or --key is required")
+	}
+
+	handler := download.NewHandler(nil, nil).
+		WithOutputDir(a.cfg.OutputDir).
+		WithFileAttributes(!*noPreserve).
+		WithOverwrite(overwrite)
+	if *jsonOut {
+		handler.WithSaveToFile()
+	}
+
+	if download.IsPassphrase(secret) {
+		err = handler.DecryptWithPassphrase(*blobPath, secret, *output)
+	} else {
+		var key []byte
+		if _, key, _, err = download.ParseLink(secret); err != nil {
+			if key, err = crypto.DecodeKey(secret); err != nil {
+				return usagef("invalid key: expected a passphrase, share link or base64 key")
+			}
+		}
+		if err = crypto.ValidateKeyLength(key); err != nil {
+			return usagef("invalid key: %w", err)
+		}
+		err = handler.Decrypt(*blobPath, key, *output)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		result := handler.Result()
+		return printJSON(report{
+			Filename:    result.Metadata.Filename,
+			ContentType: result.Metadata.ContentType,
+			Lang:        result.Metadata.Lang,
+			Size:        result.Size,
+			DurationMS:  result.Duration.Milliseconds(),
+			SHA256:      result.SHA256,
+			Output:      result.OutputPath,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/gorilla/websocket"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/download"
+)
+
+// Exit statuses, so scripts can branch on why a command failed. They are
+// listed in the help text and README; don't renumber them.
+const (
+	ExitFailure  = 1 // anything not covered below
+	ExitUsage    = 2 // invalid arguments or flags
+	ExitNetwork  = 3 // the server couldn't be reached, or the connection dropped
+	ExitRejected = 4 // the server refused the request
+	ExitDecrypt  = 5 // wrong key or passphrase, or damaged data
+	ExitNotFound = 6 // no such file: expired, already downloaded, or never uploaded
+)
+
+// ExitError ends the program with Code. Err, if set, is printed first.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// usagef reports a mistake on the command line.
+func usagef(format string, args ...any) error {
+	return &ExitError{Code: ExitUsage, Err: fmt.Errorf(format, args...)}
+}
+
+// rejectedf reports a request the server's limits rule out, found before
+// sending it.
+func rejectedf(format string, args ...any) error {
+	return &ExitError{Code: ExitRejected, Err: fmt.Errorf(format, args...)}
+}
+
+// ExitCode picks the exit status for an error returned by Run.
+func ExitCode(err error) int {
+	var exitErr *ExitError
+	var netErr net.Error
+	var closeErr *websocket.CloseError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, download.ErrInvalidLink):
+		return ExitUsage
+	case errors.Is(err, client.ErrNotAvailable):
+		return ExitNotFound
+	case errors.Is(err, client.ErrDecryption):
+		return ExitDecrypt
+	case errors.Is(err, client.ErrRejected), errors.Is(err, websocket.ErrBadHandshake):
+		// A bad handshake is an HTTP answer other than the upgrade.
+		return ExitRejected
+	case errors.As(err, &netErr), errors.As(err, &closeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return ExitNetwork
+	default:
+		return ExitFailure
+	}
+}
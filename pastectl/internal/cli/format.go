@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jonasbg/paste/pastectl/internal/history"
+	"github.com/jonasbg/paste/pastectl/internal/upload"
+)
+
+// shareMessage is what a --format template can refer to.
+type shareMessage struct {
+	URL        string // share URL; empty in passphrase mode
+	Passphrase string // share code; empty in URL mode
+	Link       string // the URL or passphrase, whichever was issued
+	Command    string // what the recipient runs to download
+	Filename   string
+	Size       string // human-readable, e.g. "4.2 MB"
+	Bytes      int64
+	SHA256     string
+	Expiry     string // local time, e.g. "2026-01-02 15:04 CET"; empty if unknown
+	ExpiresAt  time.Time
+	Downloads  int // downloads allowed; 0 if the server did not say
+}
+
+func newShareMessage(entry history.Entry, result upload.Result) shareMessage {
+	m := shareMessage{
+		Link:      entry.Link,
+		Command:   entry.DownloadCommand(),
+		Filename:  entry.Filename,
+		Size:      formatSize(result.Size),
+		Bytes:     result.Size,
+		SHA256:    result.SHA256,
+		ExpiresAt: entry.ExpiresAt,
+		Downloads: result.MaxDownloads,
+	}
+	if entry.Passphrase {
+		m.Passphrase = entry.Link
+	} else {
+		m.URL = entry.Link
+	}
+	if !entry.ExpiresAt.IsZero() {
+		m.Expiry = entry.ExpiresAt.Local().Format("2006-01-02 15:04 MST")
+	}
+	return m
+}
+
+// Like curl's --write-out, \n and \t in a format stand for a newline and a
+// tab, which are awkward to type in a shell.
+var formatEscapes = strings.NewReplacer(`\n`, "\n", `\t`, "\t")
+
+func parseShareFormat(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Parse(formatEscapes.Replace(format))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format: %w", err)
+	}
+	return tmpl, nil
+}
+
+// checkShareFormat rejects a --format before anything is uploaded, including
+// one that only fails when run, such as a misspelt field.
+func checkShareFormat(opts uploadOptions) error {
+	if opts.format == "" {
+		return nil
+	}
+	if opts.json {
+		return usagef("--format and --json are mutually exclusive")
+	}
+	tmpl, err := parseShareFormat(opts.format)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(io.Discard, shareMessage{}); err != nil {
+		return fmt.Errorf("invalid --format: %w", err)
+	}
+	return nil
+}
+
+// writeShareFormat prints the share message for an upload through format,
+// ending it with a newline.
+func writeShareFormat(w io.Writer, format string, entry history.Entry, result upload.Result) error {
+	tmpl, err := parseShareFormat(format)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, newShareMessage(entry, result)); err != nil {
+		return fmt.Errorf("invalid --format: %w", err)
+	}
+	out := b.String()
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	_, err = io.WriteString(w, out)
+	return err
+}
@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/types"
+	"github.com/jonasbg/paste/pastectl/internal/upload"
+)
+
+// healthCheck is one line of the `pastectl health` report.
+type healthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // ok, warn or fail
+	Detail string `json:"detail"`
+}
+
+type healthReport struct {
+	Server  string        `json:"server"`
+	Healthy bool          `json:"healthy"`
+	Checks  []healthCheck `json:"checks"`
+	// Largest sealed chunk the server acknowledged, 0 if not probed
+	MaxChunk int64 `json:"max_chunk_bytes,omitempty"`
+	// Upload throughput measured on that chunk, in bytes per second
+	UploadRate float64 `json:"upload_bytes_per_second,omitempty"`
+}
+
+func (r *healthReport) add(name, status, format string, args ...any) {
+	r.Checks = append(r.Checks, healthCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+	if status == "fail" {
+		r.Healthy = false
+	}
+}
+
+// handleHealth checks that a server can take a transfer before one is
+// attempted: it is reachable, its config makes sense, and full-size chunks
+// get through whatever proxies sit in between. Exit status 1 means a check
+// failed.
+func (a *App) handleHealth(args []string) error {
+	healthCmd := flag.NewFlagSet("health", flag.ExitOnError)
+	serverURL := healthCmd.String("url", a.pasteURL, "Paste server URL")
+	samples := healthCmd.Int("n", 5, "Number of requests to measure the round trip with")
+	noProbe := healthCmd.Bool("no-probe", false, "Skip sending test chunks (nothing is stored, but they count as an upload attempt)")
+	jsonOut := healthCmd.Bool("json", false, "Print the report as JSON")
+	network := addNetworkFlags(healthCmd)
+	healthCmd.Parse(args)
+	if healthCmd.NArg() > 0 {
+		return usagef("usage: pastectl health [--url <server>] [flags]")
+	}
+	if *samples < 1 {
+		return usagef("-n must be at least 1")
+	}
+	if err := network.apply(); err != nil {
+		return err
+	}
+
+	r := &healthReport{Server: *serverURL, Healthy: true}
+	c := client.New(*serverURL)
+	if config := checkReachability(r, c, *samples); config != nil {
+		if checkConfig(r, config) && !*noProbe {
+			checkChunks(r, *serverURL, config)
+		}
+	}
+
+	if *jsonOut {
+		if err := printJSON(r); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Server %s\n", r.Server)
+		for _, check := range r.Checks {
+			fmt.Printf("  %-4s  %-12s %s\n", check.Status, check.Name, check.Detail)
+		}
+	}
+	if !r.Healthy {
+		return &ExitError{Code: 1}
+	}
+	return nil
+}
+
+// checkReachability fetches the config samples times, timing each request.
+// The first includes connecting; later ones reuse the connection and show
+// the round trip. Differing answers mean replicas behind a load balancer
+// disagree, which breaks uploads that land on one and download from another.
+func checkReachability(r *healthReport, c *client.Client, samples int) *types.Config {
+	started := time.Now()
+	config, err := c.GetConfig()
+	if err != nil {
+		r.add("Reachable", "fail", "%v", err)
+		return nil
+	}
+	r.add("Reachable", "ok", "config in %s (including connecting)", time.Since(started).Round(time.Millisecond))
+
+	var lo, hi, sum time.Duration
+	differs := false
+	for i := 0; i < samples; i++ {
+		started := time.Now()
+		again, err := c.GetConfig()
+		rtt := time.Since(started)
+		if err != nil {
+			r.add("Round trip", "fail", "request %d of %d failed: %v", i+1, samples, err)
+			return config
+		}
+		if !reflect.DeepEqual(again, config) {
+			differs = true
+		}
+		if i == 0 || rtt < lo {
+			lo = rtt
+		}
+		hi = max(hi, rtt)
+		sum += rtt
+	}
+	avg := sum / time.Duration(samples)
+	status := "ok"
+	if avg > 500*time.Millisecond {
+		// Each chunk waits for an ack, so latency caps throughput.
+		status = "warn"
+	}
+	r.add("Round trip", status, "min %s, avg %s, max %s over %d requests", roundDuration(lo), roundDuration(avg), roundDuration(hi), samples)
+	if differs {
+		r.add("Consistency", "warn", "the server answered with different configs; replicas may be configured differently")
+	}
+	return config
+}
+
+// checkConfig reports the server's limits and flags values pastectl can't
+// work with. It returns false if transfers can't succeed.
+func checkConfig(r *healthReport, config *types.Config) bool {
+	var problems []string
+	if config.ChunkSize <= 0 {
+		problems = append(problems, fmt.Sprintf("chunk size %d MB", config.ChunkSize))
+	}
+	if config.KeySize != 128 && config.KeySize != 192 && config.KeySize != 256 {
+		problems = append(problems, fmt.Sprintf("key size %d bits", config.KeySize))
+	}
+	if config.MaxFileSizeBytes <= 0 {
+		problems = append(problems, fmt.Sprintf("max file size %d", config.MaxFileSizeBytes))
+	}
+	if config.SupportsExpiry() && config.MinExpirySeconds > config.MaxExpirySeconds {
+		problems = append(problems, "minimum expiry above the maximum")
+	}
+	if config.MaxUploadWindow < 0 || config.MaxDownloads < 0 {
+		problems = append(problems, "negative limits")
+	}
+	if len(problems) > 0 {
+		r.add("Config", "fail", "invalid: %s", strings.Join(problems, ", "))
+		return false
+	}
+	if config.MinProtocolVersion > client.ProtocolVersion {
+		r.add("Config", "fail", "the server needs protocol version %d or newer, and this pastectl speaks %d; upgrade pastectl", config.MinProtocolVersion, client.ProtocolVersion)
+		return false
+	}
+
+	features := []string{
+		fmt.Sprintf("%d MB chunks", config.ChunkSize),
+		fmt.Sprintf("%d-bit keys", config.KeySize),
+		"up to " + formatSize(config.MaxFileSizeBytes),
+	}
+	if config.FilesRetentionDays > 0 {
+		features = append(features, fmt.Sprintf("kept %d days", config.FilesRetentionDays))
+	}
+	if config.SupportsStreaming() {
+		features = append(features, "streaming")
+	}
+	if config.ResumableUploads {
+		features = append(features, "resumable")
+	}
+	if config.MaxUploadWindow > 1 {
+		features = append(features, fmt.Sprintf("window %d", config.MaxUploadWindow))
+	}
+	r.add("Config", "ok", "%s", strings.Join(features, ", "))
+	return true
+}
+
+// checkChunks sends chunks of growing size up to the server's full sealed
+// chunk, stopping short of its size limit.
+func checkChunks(r *healthReport, serverURL string, config *types.Config) {
+	full := config.ChunkSize<<20 + crypto.GCMTagSize
+	var sizes []int
+	var total int64
+	for size := 64 << 10; ; size *= 4 {
+		if size >= config.ChunkSize<<20 {
+			size = full
+		}
+		if total+int64(size) > config.MaxFileSizeBytes {
+			break
+		}
+		sizes = append(sizes, size)
+		total += int64(size)
+		if size == full {
+			break
+		}
+	}
+	if len(sizes) == 0 {
+		r.add("Chunks", "warn", "not probed: the server's size limit is below the smallest test chunk")
+		return
+	}
+
+	results, err := upload.Probe(serverURL, config, sizes)
+	if err != nil {
+		r.add("Chunks", "fail", "%v", err)
+		return
+	}
+	var accepted []string
+	var largest upload.ProbeResult
+	for _, res := range results {
+		if res.Err != nil {
+			detail := fmt.Sprintf("%s chunks fail (%v)", formatSize(int64(res.Size)), res.Err)
+			if largest.Size > 0 {
+				detail += fmt.Sprintf("; %s get through, so a proxy may be limiting message size", formatSize(int64(largest.Size)))
+			}
+			r.add("Chunks", "fail", "%s", detail)
+			return
+		}
+		accepted = append(accepted, formatSize(int64(res.Size)))
+		largest = res
+	}
+	r.MaxChunk = int64(largest.Size)
+	if largest.Size < full {
+		r.add("Chunks", "warn", "%s accepted; full %d MB chunks weren't tried, as they would exceed the size limit", strings.Join(accepted, ", "), config.ChunkSize)
+		return
+	}
+	r.add("Chunks", "ok", "%s accepted", strings.Join(accepted, ", "))
+	if largest.Duration > 0 {
+		r.UploadRate = float64(largest.Size) / largest.Duration.Seconds()
+		r.add("Upload rate", "ok", "~%s/s (one %s chunk, including the ack)", formatSize(int64(r.UploadRate)), formatSize(int64(largest.Size)))
+	}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,254 @@
+package cli
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/credentials"
+	"github.com/jonasbg/paste/pastectl/internal/history"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+func (a *App) handleList(args []string) error {
+	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
+	limit := listCmd.Int("n", 20, "Show at most N entries")
+	all := listCmd.Bool("a", false, "Include expired uploads")
+	listCmd.Parse(args)
+	query := strings.Join(listCmd.Args(), " ")
+
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	shown := 0
+	for i, e := range entries {
+		if *limit > 0 && shown >= *limit {
+			break
+		}
+		if !e.Matches(query, *all, now) {
+			continue
+		}
+		shown++
+
+		status := ""
+		switch {
+		case e.Expired(now):
+			status = "  (expired)"
+		case !e.ExpiresAt.IsZero():
+			status = "  expires " + e.ExpiresAt.Local().Format("2006-01-02 15:04")
+		}
+		// Numbers index the full history so `open <n>` is stable under filters.
+		fmt.Printf("%3d  %s  %-32s %10s%s\n", i+1,
+			e.UploadedAt.Local().Format("2006-01-02 15:04"), e.Filename, formatSize(e.Size), status)
+	}
+
+	if shown == 0 {
+		fmt.Fprintln(os.Stderr, "No uploads in history")
+	}
+	return nil
+}
+
+func (a *App) handleOpen(args []string) error {
+	openCmd := flag.NewFlagSet("open", flag.ExitOnError)
+	copyLink := openCmd.Bool("c", false, "Copy the download command to the clipboard")
+
+	// Accept the flag before or after the number
+	var positional []string
+	var flags []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			flags = append(flags, arg)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	openCmd.Parse(flags)
+
+	if len(positional) != 1 {
+		return usagef("usage: pastectl open <n> [-c]")
+	}
+	e, err := historyEntry(positional[0])
+	if err != nil {
+		return err
+	}
+
+	if e.Expired(time.Now()) {
+		fmt.Fprintf(os.Stderr, "Warning: this upload expired on %s\n", e.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Printf("%s (%s)\n", e.Filename, formatSize(e.Size))
+	fmt.Printf("  %s\n", e.DownloadCommand())
+
+	if *copyLink {
+		if err := copyToClipboard(e.DownloadCommand()); err != nil {
+			return fmt.Errorf("failed to copy to clipboard: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, "Copied to clipboard")
+	}
+	return nil
+}
+
+// handleStats asks the server how often a previous upload was
+// downloaded, using the owner token it issued at upload.
+func (a *App) handleStats(args []string) error {
+	statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+	statsJSON := statsCmd.Bool("json", false, "Print the result as JSON")
+	network := addNetworkFlags(statsCmd)
+
+	arg, flags := splitLinkArgs(args, networkValueFlags...)
+	statsCmd.Parse(flags)
+	if arg == "" {
+		return usagef("usage: pastectl stats <n> [--json]")
+	}
+	if err := network.apply(); err != nil {
+		return err
+	}
+	e, err := historyEntry(arg)
+	if err != nil {
+		return err
+	}
+	if e.OwnerToken == "" {
+		return rejectedf("the server issued no owner token for %s, so its downloads can't be looked up", e.Filename)
+	}
+
+	c, _, fileID, key, err := resolveLink(e.Link, e.ServerURL)
+	if err != nil {
+		return err
+	}
+	token, err := crypto.GenerateHMACToken(fileID, key)
+	if err != nil {
+		return err
+	}
+	stats, err := c.Stats(fileID, token, e.OwnerToken)
+	if err != nil {
+		return err
+	}
+
+	if *statsJSON {
+		return printJSON(stats)
+	}
+	fmt.Printf("%s: downloaded %d time(s)", e.Filename, stats.Downloads)
+	if !stats.Available {
+		fmt.Println(", no longer available")
+		return nil
+	}
+	fmt.Printf(", %d download(s) left\n", stats.Remaining)
+	return nil
+}
+
+// historyEntry returns the upload numbered arg in `pastectl list`.
+func historyEntry(arg string) (history.Entry, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return history.Entry{}, usagef("invalid history number: %s", arg)
+	}
+	entries, err := loadHistory()
+	if err != nil {
+		return history.Entry{}, err
+	}
+	if n > len(entries) {
+		return history.Entry{}, usagef("no upload #%d in history (%d entries)", n, len(entries))
+	}
+	return entries[n-1], nil
+}
+
+// historyKeyName is the credential store entry holding the history key,
+// alongside the per-server tokens.
+const historyKeyName = "history"
+
+// historyKey returns the key sealing the history, kept in the credential
+// store so it is protected by the OS keychain or the store's passphrase.
+// With create, a missing key is generated and stored.
+func historyKey(create bool) ([]byte, error) {
+	store, err := credentials.Open()
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := store.Get(historyKeyName)
+	if errors.Is(err, credentials.ErrNotFound) {
+		if !create {
+			return nil, fmt.Errorf("no history key in the %s store", store.Name())
+		}
+		key, err := history.NewKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Set(historyKeyName, base64.StdEncoding.EncodeToString(key)); err != nil {
+			return nil, fmt.Errorf("failed to store the history key: %w", err)
+		}
+		ui.Debugf("stored a new history key in the %s store", store.Name())
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != history.KeySize {
+		return nil, fmt.Errorf("the history key in the %s store is corrupted", store.Name())
+	}
+	return key, nil
+}
+
+// loadHistory reads the history, fetching the key only when the file is
+// sealed, so a plaintext history never touches the credential store.
+func loadHistory() ([]history.Entry, error) {
+	entries, err := history.Load(nil)
+	if errors.Is(err, history.ErrEncrypted) {
+		var key []byte
+		if key, err = historyKey(false); err != nil {
+			err = fmt.Errorf("%w: %w", history.ErrEncrypted, err)
+		} else {
+			entries, err = history.Load(key)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return entries, nil
+}
+
+// copyToClipboard pipes text into the first available platform clipboard tool.
+func copyToClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	default:
+		candidates = [][]string{{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	}
+
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return errors.New("no clipboard tool found")
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1024*1024*1024))
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
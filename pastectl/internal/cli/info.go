@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/download"
+	"github.com/jonasbg/paste/pastectl/internal/types"
+)
+
+// resolveLink turns a share URL or passphrase into a client for the right
+// server plus the file ID and key it refers to.
+func resolveLink(link, serverURL string) (*client.Client, *types.Config, string, []byte, error) {
+	var fileID string
+	var key []byte
+
+	if !download.IsPassphrase(link) {
+		id, k, linkServerURL, err := download.ParseLink(link)
+		if err != nil {
+			return nil, nil, "", nil, err
+		}
+		fileID, key = id, k
+		if linkServerURL != "" {
+			serverURL = linkServerURL
+		}
+	}
+
+	c := client.New(serverURL)
+	config, err := c.GetConfig()
+	if err != nil {
+		return nil, nil, "", nil, fmt.Errorf("failed to get server config: %w", err)
+	}
+
+	if fileID == "" {
+		if err := crypto.ValidatePassphrase(link); err != nil {
+			return nil, nil, "", nil, usagef("invalid passphrase: %w", err)
+		}
+		fileID, key, err = crypto.DeriveFromPassphrase(link, config.KeySize/8)
+		if err != nil {
+			return nil, nil, "", nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+		}
+	}
+
+	return c, config, fileID, key, nil
+}
+
+// splitLinkArgs separates the first positional argument (link or
+// passphrase) from flags, so it may appear anywhere on the command line.
+func splitLinkArgs(args []string, valueFlags ...string) (string, []string) {
+	var link string
+	var flags []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			flags = append(flags, arg)
+			for _, vf := range valueFlags {
+				if arg == vf && i+1 < len(args) {
+					i++
+					flags = append(flags, args[i])
+				}
+			}
+		} else if link == "" {
+			link = arg
+		}
+	}
+	return link, flags
+}
+
+func (a *App) handleInfo(args []string) error {
+	infoCmd := flag.NewFlagSet("info", flag.ExitOnError)
+	infoURL := infoCmd.String("url", a.pasteURL, "Paste server URL")
+	infoJSON := infoCmd.Bool("json", false, "Print the result as JSON")
+	network := addNetworkFlags(infoCmd)
+
+	link, flags := splitLinkArgs(args, append(networkValueFlags, "-url", "--url")...)
+	infoCmd.Parse(flags)
+	if link == "" {
+		return usagef("usage: pastectl info <passphrase|url> [--url <server>]")
+	}
+	if err := network.apply(); err != nil {
+		return err
+	}
+
+	c, _, fileID, key, err := resolveLink(link, *infoURL)
+	if err != nil {
+		return err
+	}
+
+	status, err := c.Stat(fileID, key)
+	if err != nil {
+		return err
+	}
+	metadata := status.Metadata
+
+	if *infoJSON {
+		return printJSON(report{
+			ID:                 fileID,
+			Filename:           metadata.Filename,
+			ContentType:        metadata.ContentType,
+			Lang:               metadata.Lang,
+			Title:              metadata.Title,
+			Size:               metadata.Size,
+			SHA256:             metadata.SHA256,
+			ExpiresAt:          optionalTime(status.ExpiresAt),
+			DownloadsRemaining: status.DownloadsRemaining,
+		})
+	}
+
+	if metadata.Title != "" {
+		fmt.Printf("Title:        %s\n", metadata.Title)
+	}
+	fmt.Printf("Filename:     %s\n", metadata.Filename)
+	fmt.Printf("Content type: %s\n", metadata.ContentType)
+	if metadata.Lang != "" {
+		fmt.Printf("Language:     %s\n", metadata.Lang)
+	}
+	if metadata.Size > 0 || status.EncryptedSize <= 0 {
+		fmt.Printf("Size:         %s (%d bytes)\n", formatSize(metadata.Size), metadata.Size)
+	} else {
+		// Streamed uploads seal their metadata before the size is known
+		fmt.Printf("Size:         unknown (streamed, %s encrypted)\n", formatSize(status.EncryptedSize))
+	}
+	if metadata.SHA256 != "" {
+		fmt.Printf("SHA-256:      %s\n", metadata.SHA256)
+	}
+	if metadata.Compression != "" {
+		fmt.Printf("Compression:  %s (%s stored)\n", metadata.Compression, formatSize(status.EncryptedSize))
+	}
+	if !status.ExpiresAt.IsZero() {
+		fmt.Printf("Expires:      %s (in %s)\n", status.ExpiresAt.Local().Format("2006-01-02 15:04 MST"),
+			time.Until(status.ExpiresAt).Round(time.Minute))
+	}
+	if status.DownloadsRemaining > 0 {
+		fmt.Printf("Downloads:    %d remaining\n", status.DownloadsRemaining)
+	}
+	return nil
+}
+
+// handleExists probes a link for scripts: exit 0 if the file can still be
+// downloaded, 1 if it is gone. If the check itself fails, the status says
+// why, as for other commands.
+func (a *App) handleExists(args []string) error {
+	existsCmd := flag.NewFlagSet("exists", flag.ExitOnError)
+	existsURL := existsCmd.String("url", a.pasteURL, "Paste server URL")
+	quiet := existsCmd.Bool("q", false, "Print nothing; only set the exit status")
+	network := addNetworkFlags(existsCmd)
+
+	link, flags := splitLinkArgs(args, append(networkValueFlags, "-url", "--url")...)
+	existsCmd.Parse(flags)
+	if link == "" {
+		return usagef("usage: pastectl exists <passphrase|url> [-q] [--url <server>]")
+	}
+	if err := network.apply(); err != nil {
+		return err
+	}
+
+	c, _, fileID, key, err := resolveLink(link, *existsURL)
+	if err != nil {
+		return err
+	}
+
+	// Decrypting the metadata also proves the key is right, not just the ID.
+	_, _, err = c.FetchMetadata(fileID, key)
+	switch {
+	case errors.Is(err, client.ErrNotAvailable):
+		if !*quiet {
+			fmt.Println("not available")
+		}
+		return &ExitError{Code: 1}
+	case err != nil:
+		return err
+	}
+
+	if !*quiet {
+		fmt.Println("available")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jonasbg/paste/pastectl/internal/credentials"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+	"golang.org/x/term"
+)
+
+// handleLogin stores a server's token in the credential store, so it needn't
+// be passed on the command line or kept in the environment. The token is
+// read from stdin, or prompted for without echo.
+func (a *App) handleLogin(args []string) error {
+	loginCmd := flag.NewFlagSet("login", flag.ExitOnError)
+	serverURL := loginCmd.String("url", a.pasteURL, "Paste server URL")
+	loginCmd.Parse(args)
+	if loginCmd.NArg() > 0 {
+		return usagef("usage: pastectl login [--url <server>] (the token is read from stdin)")
+	}
+
+	store, err := credentials.Open()
+	if err != nil {
+		return err
+	}
+	token, err := readToken(*serverURL)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return errors.New("no token given")
+	}
+	if err := store.Set(*serverURL, token); err != nil {
+		return fmt.Errorf("failed to store the token: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Stored the token for %s in the %s store\n", credentials.Key(*serverURL), store.Name())
+	return nil
+}
+
+// handleLogout removes a server's stored token.
+func (a *App) handleLogout(args []string) error {
+	logoutCmd := flag.NewFlagSet("logout", flag.ExitOnError)
+	serverURL := logoutCmd.String("url", a.pasteURL, "Paste server URL")
+	logoutCmd.Parse(args)
+	if logoutCmd.NArg() > 0 {
+		return usagef("usage: pastectl logout [--url <server>]")
+	}
+
+	store, err := credentials.Open()
+	if err != nil {
+		return err
+	}
+	err = store.Delete(*serverURL)
+	if errors.Is(err, credentials.ErrNotFound) {
+		return fmt.Errorf("no token stored for %s", credentials.Key(*serverURL))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Removed the token for %s\n", credentials.Key(*serverURL))
+	return nil
+}
+
+func readToken(server string) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		data, err := io.ReadAll(io.LimitReader(os.Stdin, 64<<10))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	fmt.Fprintf(os.Stderr, "Token for %s: ", credentials.Key(server))
+	data, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// storedToken looks up a server's token, treating a missing or unusable
+// store as no token.
+func storedToken(server string) string {
+	store, err := credentials.Open()
+	if err != nil {
+		ui.Debugf("credential store: %v", err)
+		return ""
+	}
+	token, err := store.Get(server)
+	if err != nil {
+		if !errors.Is(err, credentials.ErrNotFound) {
+			ui.Infof("Warning: can't read the stored token for %s: %v\n", credentials.Key(server), err)
+		}
+		return ""
+	}
+	ui.Debugf("using the token stored in the %s store", store.Name())
+	return token
+}
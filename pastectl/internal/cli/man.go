@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/jonasbg/paste/pastectl/internal/manpage"
+)
+
+// handleMan prints the pastectl(1) man page, or with a directory writes it
+// there along with a page per command, for packagers.
+func handleMan(args []string) error {
+	switch len(args) {
+	case 0:
+		pages, err := manpage.Generate(usageText(), Version)
+		if err != nil {
+			return err
+		}
+		fmt.Print(pages[0].Content)
+		return nil
+	case 1:
+		paths, err := manpage.Write(args[0], usageText(), Version)
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		return err
+	default:
+		return usagef("usage: pastectl man [dir]")
+	}
+}
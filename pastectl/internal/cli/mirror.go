@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/history"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+	"github.com/jonasbg/paste/pastectl/internal/upload"
+)
+
+// handleMirror re-shares content hosted elsewhere: the URL is fetched and
+// streamed straight into the encrypted upload, never touching the disk.
+func (a *App) handleMirror(args []string) error {
+	mirrorCmd := flag.NewFlagSet("mirror", flag.ExitOnError)
+	flags := a.newUploadFlags(mirrorCmd, "upload")
+
+	positional := parseInterleaved(mirrorCmd, args)
+	if len(positional) != 1 {
+		return usagef("usage: pastectl mirror <url> [flags]")
+	}
+	opts := flags.options()
+	if opts.filePath != "" || opts.resume || opts.separate {
+		return usagef("-f, --resume and --separate can't be used with mirror")
+	}
+	u, err := url.Parse(positional[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return usagef("invalid URL %q (must be http:// or https://)", positional[0])
+	}
+	if err := flags.network.apply(); err != nil {
+		return err
+	}
+	opts.mirror = u.String()
+	return a.handleUpload(opts)
+}
+
+// mirrorURL uploads the content at opts.mirror and records it in the
+// history.
+func (a *App) mirrorURL(opts uploadOptions) (history.Entry, upload.Result, error) {
+	c := client.New(opts.serverURL)
+	config, err := c.GetConfig()
+	if err != nil {
+		return history.Entry{}, upload.Result{}, fmt.Errorf("failed to get server config: %w", err)
+	}
+
+	ui.Debugf("GET %s", opts.mirror)
+	resp, err := client.HTTPClient().Get(opts.mirror)
+	if err != nil {
+		return history.Entry{}, upload.Result{}, fmt.Errorf("failed to fetch %s: %w", opts.mirror, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return history.Entry{}, upload.Result{}, fmt.Errorf("failed to fetch %s: %s", opts.mirror, resp.Status)
+	}
+
+	// A known size is checked against the server's limit before any data
+	// is read. Without one the upload streams, and the server enforces it.
+	size := resp.ContentLength
+	if size < 0 {
+		if !config.SupportsStreaming() {
+			return history.Entry{}, upload.Result{}, errors.New("the remote server doesn't report a size, which this paste server requires; download the file and upload it instead")
+		}
+		size = upload.SizeUnknown
+	}
+
+	filename := opts.customName
+	if filename == "" {
+		filename = mirrorFilename(resp)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ui.Infof("Mirroring %s\n", opts.mirror)
+	return a.uploadReader(opts, nil, config, resp.Body, filename, contentType, size)
+}
+
+// mirrorFilename names mirrored content after the server's
+// Content-Disposition, or else the last segment of the (redirected) URL.
+func mirrorFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(params["filename"]); name != "." && name != "/" {
+			return name
+		}
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "." && name != "/" {
+		return name
+	}
+	return "download"
+}
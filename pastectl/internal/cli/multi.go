@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jonasbg/paste/pastectl/internal/history"
+	"github.com/jonasbg/paste/pastectl/internal/upload"
+)
+
+// uploadSeparately uploads each input under its own link and prints one
+// summary at the end. A failed input does not stop the rest.
+func (a *App) uploadSeparately(opts uploadOptions, inputs []string) error {
+	if opts.customName != "" {
+		return usagef("-n cannot be combined with --separate")
+	}
+
+	type uploaded struct {
+		entry  history.Entry
+		result upload.Result
+	}
+	var done []uploaded
+	failed := 0
+	for _, in := range inputs {
+		o := opts
+		o.filePath, o.paths = in, nil
+		entry, result, err := a.uploadInputs(o, []string{in})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", in, err)
+			failed++
+			continue
+		}
+		done = append(done, uploaded{entry, result})
+	}
+
+	switch {
+	case opts.json:
+		reports := make([]report, 0, len(done))
+		for _, u := range done {
+			reports = append(reports, uploadReport(u.entry, u.result))
+		}
+		if err := printJSON(reports); err != nil {
+			return err
+		}
+	case opts.format != "":
+		for _, u := range done {
+			if err := writeShareFormat(os.Stdout, opts.format, u.entry, u.result); err != nil {
+				return err
+			}
+		}
+	case opts.quiet:
+		for _, u := range done {
+			fmt.Println(u.entry.Link)
+		}
+	case len(done) > 0:
+		fmt.Fprintf(os.Stderr, "\n")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tSIZE\tDOWNLOAD")
+		for _, u := range done {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", u.entry.Filename, formatSize(u.result.Size), u.entry.DownloadCommand())
+		}
+		w.Flush()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, len(inputs))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// pathSeparators keeps a template value such as the branch "feature/x"
+// from turning the filename into a path.
+var pathSeparators = strings.NewReplacer("/", "-", `\`, "-")
+
+// expandName fills in an -n template, so piped uploads get meaningful
+// names: `-n "report-{{date}}-{{host}}.log"`. date and time take an
+// optional Go layout, e.g. {{date "2006-01"}}. A name without "{{" is
+// returned as is.
+func expandName(name string) (string, error) {
+	if !strings.Contains(name, "{{") {
+		return name, nil
+	}
+	now := time.Now()
+	layout := func(def string, given []string) string {
+		if len(given) > 0 {
+			return given[0]
+		}
+		return def
+	}
+	tmpl, err := template.New("name").Funcs(template.FuncMap{
+		"date": func(l ...string) string { return pathSeparators.Replace(now.Format(layout("2006-01-02", l))) },
+		"time": func(l ...string) string { return pathSeparators.Replace(now.Format(layout("150405", l))) },
+		"host": func() (string, error) {
+			host, err := os.Hostname()
+			host, _, _ = strings.Cut(host, ".")
+			return host, err
+		},
+		"branch": gitBranch,
+	}).Parse(name)
+	if err != nil {
+		return "", usagef("invalid -n template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", usagef("invalid -n template: %w", err)
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return "", usagef("-n %q expands to an empty name", name)
+	}
+	return b.String(), nil
+}
+
+// gitBranch names the current branch, or the short commit when detached.
+func gitBranch() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return "", errors.New("{{branch}} needs to run inside a git repository")
+	}
+	branch := strings.TrimSpace(string(out))
+	if branch == "HEAD" {
+		if out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output(); err == nil {
+			branch = strings.TrimSpace(string(out))
+		}
+	}
+	return pathSeparators.Replace(branch), nil
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/history"
+	"github.com/jonasbg/paste/pastectl/internal/upload"
+)
+
+// maxTitle caps a title taken from the note's first line.
+const maxTitle = 80
+
+// handleNote shares a snippet of text typed in $EDITOR, or given with -m,
+// as a small text paste. The title (-t, or else the first line) is kept in
+// the encrypted metadata and names the file.
+func (a *App) handleNote(args []string) error {
+	noteCmd := flag.NewFlagSet("note", flag.ExitOnError)
+	flags := a.newUploadFlags(noteCmd, "upload")
+	message := noteCmd.String("m", "", "Use this text instead of opening an editor")
+	title := noteCmd.String("t", "", "Title (default: the first line of the note)")
+
+	if positional := parseInterleaved(noteCmd, args); len(positional) > 0 {
+		return usagef("usage: pastectl note [-m <text>] [-t <title>] [flags]")
+	}
+	opts := flags.options()
+	if opts.filePath != "" || opts.resume || opts.separate || opts.tee {
+		return usagef("-f, --resume, --separate and --tee can't be used with note")
+	}
+	if err := flags.network.apply(); err != nil {
+		return err
+	}
+
+	text := *message
+	if text == "" {
+		var err error
+		if text, err = editNote(); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(text) == "" {
+		return errors.New("the note is empty; nothing was uploaded")
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	opts.note = text
+	opts.title = strings.TrimSpace(*title)
+	if opts.title == "" {
+		opts.title = noteTitle(text)
+	}
+	return a.handleUpload(opts)
+}
+
+// uploadNote uploads opts.note and records it in the history.
+func (a *App) uploadNote(opts uploadOptions) (history.Entry, upload.Result, error) {
+	c := client.New(opts.serverURL)
+	config, err := c.GetConfig()
+	if err != nil {
+		return history.Entry{}, upload.Result{}, fmt.Errorf("failed to get server config: %w", err)
+	}
+	filename := opts.customName
+	if filename == "" {
+		filename = noteFilename(opts.title)
+	}
+	return a.uploadReader(opts, nil, config, strings.NewReader(opts.note), filename, "text/plain; charset=utf-8", int64(len(opts.note)))
+}
+
+// editNote opens $VISUAL or $EDITOR on an empty temporary file and returns
+// what was saved.
+func editNote() (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	f, err := os.CreateTemp("", "pastectl-note-*.txt")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// EDITOR may carry arguments, e.g. "code --wait".
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+	data, err := os.ReadFile(f.Name())
+	return string(data), err
+}
+
+// noteTitle takes the first non-blank line, without Markdown heading marks.
+func noteTitle(text string) string {
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line == "" {
+			continue
+		}
+		if r := []rune(line); len(r) > maxTitle {
+			line = strings.TrimSpace(string(r[:maxTitle-1])) + "…"
+		}
+		return line
+	}
+	return ""
+}
+
+// noteFilename turns a title into a portable filename: "Deploy notes
+// (v2)" becomes "deploy-notes-v2.txt".
+func noteFilename(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+		if b.Len() >= 48 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "note.txt"
+	}
+	return b.String() + ".txt"
+}
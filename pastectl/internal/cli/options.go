@@ -0,0 +1,462 @@
+package cli
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/compress"
+	"github.com/jonasbg/paste/pastectl/internal/download"
+	"github.com/jonasbg/paste/pastectl/internal/ratelimit"
+	"github.com/jonasbg/paste/pastectl/internal/types"
+)
+
+// uploadOptions carries everything handleUpload needs from the command line.
+type uploadOptions struct {
+	filePath        string
+	customName      string
+	serverURL       string
+	passphraseWords int
+	expire          string
+	maxDownloads    int
+	key             string // base64; implies URL mode
+	json            bool
+	quiet           bool
+	debug           bool
+	exclude         []string
+	followSymlinks  bool
+	keepSymlinks    bool
+	paths           []string // further inputs given as arguments
+	separate        bool     // one link per input instead of a bundle
+	parallel        int      // chunks in flight before waiting for an ack
+	chunkSize       int      // MB, 0 for the server's default
+	perChunkKeys    bool     // seal every chunk with its own derived key
+	resume          bool     // continue an interrupted upload
+	limitRate       int64    // bytes per second, 0 for no limit
+	mirror          string   // URL to fetch and upload instead of local input
+	tee             bool     // copy piped input to stdout while uploading it
+	format          string   // text/template for the share message
+	compress        string   // algorithm to compress with before encrypting
+	lang            string   // syntax-highlighting hint stored in the metadata
+	note            string   // text to upload instead of local input
+	title           string   // display name stored in the metadata
+}
+
+// inputs lists the paths to upload; empty means stdin.
+func (o uploadOptions) inputs() []string {
+	if o.filePath == "" {
+		return o.paths
+	}
+	return append([]string{o.filePath}, o.paths...)
+}
+
+// downloadOptions carries everything handleDownload needs from the command line.
+type downloadOptions struct {
+	link       string
+	id         string // file ID (or a link missing its key), with key
+	key        string // base64 key for id
+	outputPath string
+	serverURL  string
+	json       bool
+	noPreserve bool  // don't restore the sender's mode and mtime
+	limitRate  int64 // bytes per second, 0 for no limit
+	raw        bool  // save the blob still encrypted
+	overwrite  download.Overwrite
+	dir        string // directory to save into under the original name
+}
+
+// addOverwriteFlags registers --force and --auto-rename; the returned
+// function reads them once parsed.
+func addOverwriteFlags(fs *flag.FlagSet) func() (download.Overwrite, error) {
+	force := fs.Bool("force", false, "Overwrite an existing output file")
+	rename := fs.Bool("auto-rename", false, "Save as name-1.ext (and so on) if the output file exists")
+	return func() (download.Overwrite, error) {
+		switch {
+		case *force && *rename:
+			return 0, usagef("--force and --auto-rename are mutually exclusive")
+		case *force:
+			return download.OverwriteForce, nil
+		case *rename:
+			return download.OverwriteRename, nil
+		}
+		return download.OverwriteAsk, nil
+	}
+}
+
+// uploadFlags are the flags shared by the upload and send commands.
+type uploadFlags struct {
+	file          *string
+	name          *string
+	url           *string
+	passphrase    *int
+	passphraseAlt *int
+	urlMode       *bool
+	expire        *string
+	burn          *bool
+	downloads     *int
+	key           *string
+	json          *bool
+	quiet         *bool
+	quietLong     *bool
+	debug         *bool
+	debugLong     *bool
+	exclude       *stringList
+	follow        *bool
+	preserve      *bool
+	separate      *bool
+	parallel      *int
+	chunkSize     *int
+	chunkKeys     *bool
+	resume        *bool
+	limitRate     *rateFlag
+	tee           *bool
+	format        *string
+	compress      *compressFlag
+	lang          *langFlag
+	network       *networkFlags
+}
+
+// networkFlags are accepted by every command that talks to a server.
+type networkFlags struct {
+	timeout        *time.Duration
+	connectTimeout *time.Duration
+	proxy          *string
+	caCert         *string
+	insecure       *bool
+	apiKey         *string
+}
+
+// networkValueFlags are the network flags that take a value, for commands
+// that pick their positional argument out by hand.
+var networkValueFlags = []string{"-timeout", "--timeout", "-connect-timeout", "--connect-timeout", "-proxy", "--proxy", "-cacert", "--cacert", "-api-key", "--api-key"}
+
+// downloadValueFlags are the download flags that take a value.
+var downloadValueFlags = append([]string{"-l", "-o", "-url", "--url", "-limit-rate", "--limit-rate", "-id", "--id", "-key", "--key", "-d", "-dir", "--dir"}, networkValueFlags...)
+
+func addNetworkFlags(fs *flag.FlagSet) *networkFlags {
+	return &networkFlags{
+		timeout:        fs.Duration("timeout", client.DefaultTimeout, "Give up when the server sends nothing for this long (0 disables)"),
+		connectTimeout: fs.Duration("connect-timeout", client.DefaultConnectTimeout, "Give up connecting to the server after this long (0 disables)"),
+		proxy:          fs.String("proxy", "", "Connect through this http://, https:// or socks5:// proxy (default: from HTTP_PROXY, HTTPS_PROXY, ALL_PROXY)"),
+		caCert:         fs.String("cacert", os.Getenv("PASTE_CACERT"), "Also trust the certificate authorities in this PEM file"),
+		insecure:       fs.Bool("insecure", false, "Don't verify the server's TLS certificate (testing only)"),
+		apiKey:         fs.String("api-key", os.Getenv("PASTE_API_KEY"), "Upload into this API key's namespace, on servers that have them"),
+	}
+}
+
+// apply configures the shared HTTP client and WebSocket dialer.
+func (f *networkFlags) apply() error {
+	if *f.timeout < 0 || *f.connectTimeout < 0 {
+		return usagef("timeouts must not be negative")
+	}
+	if *f.insecure {
+		fmt.Fprintln(os.Stderr, "Warning: TLS certificate verification is disabled (--insecure)")
+	}
+	err := client.Configure(client.NetworkOptions{
+		ConnectTimeout: *f.connectTimeout,
+		Timeout:        *f.timeout,
+		Proxy:          *f.proxy,
+		CACert:         *f.caCert,
+		Insecure:       *f.insecure,
+		APIKey:         *f.apiKey,
+	})
+	if err != nil {
+		// A bad --proxy or --cacert
+		return &ExitError{Code: ExitUsage, Err: err}
+	}
+	return nil
+}
+
+// parseInterleaved parses fs while allowing flags after positional
+// arguments (`pastectl send a.txt b.txt --separate`) and returns the
+// positionals in order.
+func parseInterleaved(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// rateFlag is a transfer rate given as bytes per second with an optional
+// K, M or G suffix.
+type rateFlag int64
+
+func (r *rateFlag) String() string {
+	if r == nil || *r == 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(*r), 10)
+}
+
+func (r *rateFlag) Set(v string) error {
+	n, err := ratelimit.ParseRate(v)
+	if err != nil {
+		return err
+	}
+	*r = rateFlag(n)
+	return nil
+}
+
+func addRateFlag(fs *flag.FlagSet) *rateFlag {
+	r := new(rateFlag)
+	fs.Var(r, "limit-rate", "Limit the transfer to this many bytes per second (e.g. 500K, 5M)")
+	return r
+}
+
+// compressFlag names a compression algorithm. Like a bool flag it may be
+// given bare (--compress, meaning zstd) or with a value (--compress=gzip).
+type compressFlag string
+
+func (c *compressFlag) String() string {
+	if c == nil {
+		return ""
+	}
+	return string(*c)
+}
+
+func (c *compressFlag) Set(v string) error {
+	switch v {
+	case "true":
+		v = compress.Zstd
+	case "false":
+		v = ""
+	default:
+		if err := compress.Check(v); err != nil {
+			return err
+		}
+	}
+	*c = compressFlag(v)
+	return nil
+}
+
+func (c *compressFlag) IsBoolFlag() bool { return true }
+
+// langFlag is a language name as highlighters know it: lowercase letters,
+// digits and a few symbols ("c++", "c#", "objective-c").
+type langFlag string
+
+var langName = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,31}$`)
+
+func (l *langFlag) String() string { return string(*l) }
+
+func (l *langFlag) Set(v string) error {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if !langName.MatchString(v) {
+		return fmt.Errorf("invalid language %q (use a name such as go, python or c++)", v)
+	}
+	*l = langFlag(v)
+	return nil
+}
+
+func (a *App) newUploadFlags(fs *flag.FlagSet, verb string) *uploadFlags {
+	exclude := &stringList{}
+	fs.Var(exclude, "exclude", "Skip matching files when uploading a directory (repeatable)")
+	compression := new(compressFlag)
+	fs.Var(compression, "compress", "Compress before encrypting: --compress (zstd) or --compress=gzip")
+	lang := new(langFlag)
+	fs.Var(lang, "lang", "Syntax-highlighting hint for viewers, e.g. go or python")
+	return &uploadFlags{
+		exclude:       exclude,
+		compress:      compression,
+		lang:          lang,
+		limitRate:     addRateFlag(fs),
+		network:       addNetworkFlags(fs),
+		file:          fs.String("f", "", fmt.Sprintf("File to %s (omit to read from stdin)", verb)),
+		name:          fs.String("n", "", "Override filename (default: uses file name or 'stdin.txt'); may use {{date}}, {{time}}, {{host}} and {{branch}}"),
+		url:           fs.String("url", a.pasteURL, "Paste server URL"),
+		passphrase:    fs.Int("p", a.passphraseWords, "Number of words in passphrase (4-8, default: 4)"),
+		passphraseAlt: fs.Int("passphrase", 0, "Number of words in passphrase (4-8, default: 4)"),
+		urlMode:       fs.Bool("url-mode", false, "Use URL mode instead of passphrase"),
+		expire:        fs.String("expire", a.cfg.DefaultExpiry, "Delete the file after this duration (e.g. 30m, 2h, 3d)"),
+		burn:          fs.Bool("burn", false, "Delete the file after the first download"),
+		downloads:     fs.Int("downloads", 0, "Delete the file after N downloads"),
+		// No env default here: flag.PrintDefaults would echo the secret.
+		key:       fs.String("key", "", "Encrypt with this base64 key instead of a random one (or set PASTE_KEY)"),
+		json:      fs.Bool("json", false, "Print the result as JSON"),
+		quiet:     fs.Bool("q", false, "Quiet: no progress, print only the share link"),
+		quietLong: fs.Bool("quiet", false, "Quiet: no progress, print only the share link"),
+		debug:     fs.Bool("v", false, "Log protocol steps and timing to stderr"),
+		debugLong: fs.Bool("debug", false, "Log protocol steps and timing to stderr"),
+		follow:    fs.Bool("follow-symlinks", false, "Archive what symlinks in a directory point to"),
+		preserve:  fs.Bool("preserve-symlinks", false, "Store symlinks in a directory as links (default)"),
+		separate:  fs.Bool("separate", false, "With several inputs, upload each one separately"),
+		parallel:  fs.Int("parallel", 1, "Send up to N chunks ahead of the server's acks (capped by the server)"),
+		chunkSize: fs.Int("chunk-size", 0, "Seal in chunks of N MB instead of the server's default (within its range)"),
+		chunkKeys: fs.Bool("per-chunk-keys", false, "Seal every chunk with its own key derived from the file key (older clients can't download it)"),
+		resume:    fs.Bool("resume", false, "Continue an interrupted upload of the given file (default: the latest)"),
+		tee:       fs.Bool("tee", false, "Pass piped input through to stdout while uploading it"),
+		format:    fs.String("format", "", "Print the share message from this template, e.g. 'Download {{.URL}} (expires {{.Expiry}})'"),
+	}
+}
+
+func (f *uploadFlags) options() uploadOptions {
+	passphraseWords := *f.passphrase
+	if *f.passphraseAlt > 0 {
+		passphraseWords = *f.passphraseAlt
+	}
+	if *f.urlMode {
+		passphraseWords = 0 // Use URL mode
+	}
+	maxDownloads := *f.downloads
+	if *f.burn {
+		maxDownloads = 1
+	}
+	key := *f.key
+	if key == "" {
+		key = os.Getenv("PASTE_KEY")
+	}
+	if key != "" {
+		passphraseWords = 0 // A fixed key only makes sense in URL mode
+	}
+	return uploadOptions{
+		filePath:        *f.file,
+		customName:      *f.name,
+		serverURL:       *f.url,
+		passphraseWords: passphraseWords,
+		expire:          *f.expire,
+		maxDownloads:    maxDownloads,
+		key:             key,
+		json:            *f.json,
+		quiet:           *f.quiet || *f.quietLong,
+		debug:           *f.debug || *f.debugLong,
+		exclude:         *f.exclude,
+		followSymlinks:  *f.follow,
+		keepSymlinks:    *f.preserve,
+		separate:        *f.separate,
+		parallel:        *f.parallel,
+		chunkSize:       *f.chunkSize,
+		perChunkKeys:    *f.chunkKeys,
+		resume:          *f.resume,
+		limitRate:       int64(*f.limitRate),
+		tee:             *f.tee,
+		format:          *f.format,
+		compress:        string(*f.compress),
+		lang:            string(*f.lang),
+	}
+}
+
+// decodeUserKey parses a pre-provisioned key in URL-safe or standard base64
+// (padding optional) and checks it matches the server's key size.
+func decodeUserKey(s string, config *types.Config) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	var key []byte
+	var err error
+	for _, enc := range []*base64.Encoding{base64.RawURLEncoding, base64.RawStdEncoding} {
+		if key, err = enc.DecodeString(strings.TrimRight(s, "=")); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, usagef("invalid key: expected base64")
+	}
+	if len(key)*8 != config.KeySize {
+		return nil, rejectedf("invalid key: got %d bits, server requires %d", len(key)*8, config.KeySize)
+	}
+	return key, nil
+}
+
+// resolveMaxDownloads validates a requested download count. Servers without
+// download limits still delete after the first download, so an unsupported
+// request only warns.
+func resolveMaxDownloads(n int, config *types.Config) (int, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if n < 0 {
+		return 0, usagef("--downloads must be positive")
+	}
+	if !config.SupportsDownloadLimit() {
+		if n == 1 {
+			fmt.Fprintln(os.Stderr, "Warning: server does not advertise download limits; relying on its default delete-after-download")
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: server does not support --downloads; the file will be deleted after the first download\n")
+		}
+		return 0, nil
+	}
+	if n > config.MaxDownloads {
+		return 0, rejectedf("--downloads %d exceeds the server maximum of %d", n, config.MaxDownloads)
+	}
+	return n, nil
+}
+
+// resolveChunkSize validates a requested chunk size in MB against the
+// server's range. Zero, or the server's own default, needs no request.
+func resolveChunkSize(n int, config *types.Config) (int, error) {
+	if n == 0 || n == config.ChunkSize {
+		return 0, nil
+	}
+	if n < 0 {
+		return 0, usagef("--chunk-size must be positive")
+	}
+	if !config.SupportsChunkSize() {
+		return 0, rejectedf("this server does not support --chunk-size (it uses %d MB chunks)", config.ChunkSize)
+	}
+	if n < config.MinChunkSize || n > config.MaxChunkSize {
+		return 0, rejectedf("--chunk-size %d is outside the server's allowed range (%d to %d MB)", n, config.MinChunkSize, config.MaxChunkSize)
+	}
+	return n, nil
+}
+
+// parseExpiry accepts Go durations plus a "d" suffix for days ("3d").
+func parseExpiry(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, usagef("invalid expiry %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, usagef("invalid expiry %q", s)
+	}
+	return d, nil
+}
+
+// resolveExpiry validates a requested expiry against the server's range.
+// An empty string means "use the server default".
+func resolveExpiry(s string, config *types.Config) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := parseExpiry(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, usagef("expiry must be positive")
+	}
+	if !config.SupportsExpiry() {
+		return 0, rejectedf("this server does not support --expire")
+	}
+
+	minExpiry := time.Duration(config.MinExpirySeconds) * time.Second
+	maxExpiry := time.Duration(config.MaxExpirySeconds) * time.Second
+	if d < minExpiry || d > maxExpiry {
+		return 0, rejectedf("expiry %s is outside the server's allowed range (%s to %s)", d, minExpiry, maxExpiry)
+	}
+	return d, nil
+}
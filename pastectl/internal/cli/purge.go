@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jonasbg/paste/pastectl/internal/client"
+)
+
+// purgeRequest is the body of POST /api/admin/purge.
+type purgeRequest struct {
+	All        bool   `json:"all,omitempty"`
+	OlderThan  string `json:"older_than,omitempty"`
+	LargerThan string `json:"larger_than,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	DryRun     bool   `json:"dry_run,omitempty"`
+}
+
+type purgeResult struct {
+	ID     string `json:"id"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"`
+	Failed int    `json:"failed,omitempty"`
+	Held   int    `json:"held,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// handleAdminPurge deletes stored files in bulk through the admin API. It
+// counts the matches first and asks before deleting them.
+func (a *App) handleAdminPurge(args []string) error {
+	purgeCmd := flag.NewFlagSet("admin purge", flag.ExitOnError)
+	serverURL := purgeCmd.String("url", a.pasteURL, "Paste server URL")
+	token := purgeCmd.String("token", "", "Admin token (or set PASTE_ADMIN_TOKEN, or store one with pastectl login)")
+	olderThan := purgeCmd.Duration("older-than", 0, "Only files uploaded longer ago than this")
+	largerThan := purgeCmd.String("larger-than", "", "Only files larger than this (e.g. 500MB)")
+	namespace := purgeCmd.String("namespace", "", "Only files uploaded into this API key namespace")
+	all := purgeCmd.Bool("all", false, "Purge every stored file")
+	dryRun := purgeCmd.Bool("dry-run", false, "Count the matching files without deleting them")
+	yes := purgeCmd.Bool("yes", false, "Don't ask for confirmation")
+	purgeCmd.BoolVar(yes, "y", false, "Same as --yes")
+	jsonOut := purgeCmd.Bool("json", false, "Print the result as JSON")
+	network := addNetworkFlags(purgeCmd)
+
+	if positional := parseInterleaved(purgeCmd, args); len(positional) > 0 {
+		return usagef("usage: pastectl admin purge [--older-than <dur>] [--larger-than <size>] [--namespace <ns>] [--all] [--dry-run] [--yes]")
+	}
+	req := purgeRequest{All: *all, LargerThan: *largerThan, Namespace: *namespace}
+	if *olderThan < 0 {
+		return usagef("--older-than must not be negative")
+	}
+	if *olderThan > 0 {
+		req.OlderThan = olderThan.String()
+	}
+	if !req.All && req.OlderThan == "" && req.LargerThan == "" && req.Namespace == "" {
+		return usagef("give at least one of --older-than, --larger-than and --namespace, or --all")
+	}
+	if err := network.apply(); err != nil {
+		return err
+	}
+	*token = adminToken(*token, *serverURL)
+	endpoint := strings.TrimRight(*serverURL, "/") + "/api/admin/purge"
+
+	if !*dryRun && !*yes {
+		req.DryRun = true
+		preview, err := postPurge(endpoint, *token, req)
+		if err != nil {
+			return err
+		}
+		if preview.Files == 0 {
+			fmt.Fprintln(os.Stderr, "No files match")
+			return nil
+		}
+		if !confirm(fmt.Sprintf("Permanently delete %d files (%s)?", preview.Files, formatSize(preview.Bytes))) {
+			return usagef("purge not confirmed (pass --yes to skip the question)")
+		}
+	}
+
+	req.DryRun = *dryRun
+	result, err := postPurge(endpoint, *token, req)
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		return printJSON(result)
+	}
+	verb := "Purged"
+	if result.DryRun {
+		verb = "Would purge"
+	}
+	fmt.Printf("%s %d files (%s), purge %s\n", verb, result.Files, formatSize(result.Bytes), result.ID)
+	if result.Held > 0 {
+		fmt.Fprintf(os.Stderr, "Left %d matching files on legal hold\n", result.Held)
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d files could not be removed; see the server log for purge %s", result.Failed, result.ID)
+	}
+	return nil
+}
+
+func postPurge(endpoint, token string, req purgeRequest) (purgeResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return purgeResult{}, err
+	}
+	httpReq, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return purgeResult{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	c := client.HTTPClient()
+	// Walking a large upload directory takes a while
+	timeoutClient := *c
+	timeoutClient.Timeout = 10 * time.Minute
+	resp, err := timeoutClient.Do(httpReq)
+	if err != nil {
+		return purgeResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		purgeResult
+		Error string `json:"error"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	switch {
+	case resp.StatusCode == http.StatusOK && decodeErr == nil:
+		return result.purgeResult, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return purgeResult{}, client.Mark(fmt.Errorf("%s refused access (%s); check the token", endpoint, resp.Status), client.ErrRejected)
+	case resp.StatusCode == http.StatusNotFound:
+		return purgeResult{}, client.Mark(fmt.Errorf("%s not found; the server has no admin API without ADMIN_TOKEN or OIDC_ISSUER", endpoint), client.ErrRejected)
+	case result.Error != "":
+		return purgeResult{}, client.Mark(fmt.Errorf("purge failed: %s", result.Error), client.ErrRejected)
+	}
+	return purgeResult{}, client.Mark(fmt.Errorf("%s returned %s", endpoint, resp.Status), client.ErrRejected)
+}
+
+// confirm asks a yes/no question on the terminal. Without a terminal to
+// ask on, the answer is no.
+func confirm(question string) bool {
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	var response string
+	fmt.Scanln(&response)
+	return response == "y" || response == "Y" || response == "yes"
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jonasbg/paste/pastectl/internal/history"
+	"github.com/jonasbg/paste/pastectl/internal/upload"
+)
+
+// report is the --json output shared by upload, download and info. Field
+// names are part of the CLI's interface: add fields, never rename them.
+type report struct {
+	URL                string     `json:"url,omitempty"`
+	Passphrase         string     `json:"passphrase,omitempty"`
+	ID                 string     `json:"id"`
+	Filename           string     `json:"filename"`
+	ContentType        string     `json:"content_type,omitempty"`
+	Lang               string     `json:"lang,omitempty"`
+	Title              string     `json:"title,omitempty"`
+	Size               int64      `json:"size"`
+	DurationMS         int64      `json:"duration_ms,omitempty"`
+	SHA256             string     `json:"sha256,omitempty"`
+	Output             string     `json:"output,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	DownloadsRemaining int        `json:"downloads_remaining,omitempty"`
+}
+
+func uploadReport(entry history.Entry, result upload.Result) report {
+	r := report{
+		ID:         result.FileID,
+		Filename:   entry.Filename,
+		Size:       result.Size,
+		DurationMS: result.Duration.Milliseconds(),
+		SHA256:     result.SHA256,
+		ExpiresAt:  optionalTime(result.ExpiresAt),
+	}
+	if entry.Passphrase {
+		r.Passphrase = entry.Link
+	} else {
+		r.URL = entry.Link
+	}
+	return r
+}
+
+func printJSON(v any) error {
+	return writeJSON(os.Stdout, v)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
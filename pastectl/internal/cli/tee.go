@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// passThrough forwards piped input to stdout for --tee. When the reader
+// downstream goes away, forwarding stops but the upload carries on.
+type passThrough struct {
+	w      io.Writer
+	closed bool
+}
+
+func newPassThrough(w io.Writer) *passThrough {
+	// Otherwise writing to a closed pipe on stdout kills the process.
+	signal.Ignore(syscall.SIGPIPE)
+	return &passThrough{w: w}
+}
+
+func (p *passThrough) Write(b []byte) (int, error) {
+	if !p.closed {
+		if _, err := p.w.Write(b); err != nil {
+			p.closed = true
+			fmt.Fprintf(os.Stderr, "Warning: no longer passing input through (%v); the upload continues\n", err)
+		}
+	}
+	return len(b), nil
+}
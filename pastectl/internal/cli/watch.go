@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// fileVersion tells whether a watched file changed since it was uploaded.
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+// handleWatch uploads files as they appear in a directory, or change, and
+// reports each link on stdout, to a log file and/or to a webhook.
+func (a *App) handleWatch(args []string) error {
+	watchCmd := flag.NewFlagSet("watch", flag.ExitOnError)
+	flags := a.newUploadFlags(watchCmd, "upload")
+	logPath := watchCmd.String("log", "", "Append each upload's time, filename and link to this file")
+	webhook := watchCmd.String("webhook", "", "POST each upload's JSON report to this URL")
+	settle := watchCmd.Duration("settle", 2*time.Second, "Upload a file once it has not changed for this long")
+
+	positional := parseInterleaved(watchCmd, args)
+	if len(positional) != 1 {
+		return usagef("usage: pastectl watch <dir> [flags]")
+	}
+	dir := positional[0]
+	opts := flags.options()
+	if opts.filePath != "" || opts.customName != "" || opts.resume || opts.separate {
+		return usagef("-f, -n, --resume and --separate can't be used with watch")
+	}
+	if err := checkShareFormat(opts); err != nil {
+		return err
+	}
+	if *settle <= 0 {
+		return usagef("--settle must be positive")
+	}
+	if err := flags.network.apply(); err != nil {
+		return err
+	}
+	if opts.quiet {
+		ui.Quiet = true
+	}
+	if opts.debug {
+		ui.Debug = true
+	}
+
+	if st, err := os.Stat(dir); err != nil {
+		return err
+	} else if !st.IsDir() {
+		return usagef("%s is not a directory", dir)
+	}
+
+	// The log may live in the watched directory; it must not upload itself.
+	var logAbs string
+	if *logPath != "" {
+		var err error
+		if logAbs, err = filepath.Abs(*logPath); err != nil {
+			return err
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watching: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+	ui.Infof("Watching %s for new files (Ctrl-C to stop)\n", dir)
+
+	// Files are written in bursts of events. Each event restarts the file's
+	// timer, and it is uploaded once the writes have settled.
+	timers := map[string]*time.Timer{}
+	ready := make(chan string)
+	go func() {
+		uploaded := map[string]fileVersion{}
+		for path := range ready {
+			st, err := os.Stat(path)
+			if err != nil || !st.Mode().IsRegular() {
+				continue
+			}
+			version := fileVersion{size: st.Size(), modTime: st.ModTime()}
+			if uploaded[path] == version {
+				continue // written to, but left as it was
+			}
+			uploaded[path] = version
+			a.watchUpload(opts, path, logAbs, *webhook)
+		}
+	}()
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			path, err := filepath.Abs(ev.Name)
+			if err != nil || path == logAbs || skipWatched(filepath.Base(path), opts.exclude) {
+				continue
+			}
+			switch {
+			case ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write):
+				if t, ok := timers[path]; ok {
+					t.Reset(*settle)
+				} else {
+					timers[path] = time.AfterFunc(*settle, func() { ready <- path })
+				}
+			case ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename):
+				if t, ok := timers[path]; ok {
+					t.Stop()
+					delete(timers, path)
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// skipWatched reports whether a file in the watched directory is left
+// alone: hidden files, which are usually editor or download leftovers, and
+// names matching an --exclude pattern.
+func skipWatched(name string, exclude []string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watchUpload uploads one file and reports its link. Failures are printed
+// and watching goes on.
+func (a *App) watchUpload(opts uploadOptions, path, logPath, webhook string) {
+	ui.Infof("Uploading %s\n", filepath.Base(path))
+	entry, result, err := a.uploadInputs(opts, []string{path})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+		return
+	}
+	r := uploadReport(entry, result)
+
+	switch {
+	case opts.json:
+		// One object per line, so the output can be consumed as it comes.
+		json.NewEncoder(os.Stdout).Encode(r)
+	case opts.format != "":
+		if err := writeShareFormat(os.Stdout, opts.format, entry, result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	case opts.quiet:
+		fmt.Println(entry.Link)
+	default:
+		fmt.Printf("%s\t%s\n", entry.Filename, entry.DownloadCommand())
+	}
+
+	if logPath != "" {
+		if err := appendWatchLog(logPath, entry.UploadedAt, entry.Filename, entry.Link); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't write %s: %v\n", logPath, err)
+		}
+	}
+	if webhook != "" {
+		if err := postWebhook(webhook, r); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: webhook failed: %v\n", err)
+		}
+	}
+}
+
+func appendWatchLog(path string, at time.Time, filename, link string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\t%s\t%s\n", at.Format(time.RFC3339), filename, link); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func postWebhook(url string, r report) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := client.HTTPClient().Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
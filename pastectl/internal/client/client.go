@@ -2,14 +2,22 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/jonasbg/paste/crypto"
 	"github.com/jonasbg/paste/pastectl/internal/types"
 )
 
+// ErrNotAvailable is returned when the server has no file for the given ID
+// and token: it never existed, has expired, or was already downloaded.
+var ErrNotAvailable = errors.New("file not found (expired, already downloaded, or wrong link)")
+
 // Client represents a paste API client
 type Client struct {
 	baseURL string
@@ -29,14 +37,23 @@ func (c *Client) BaseURL() string {
 
 // GetConfig fetches server configuration
 func (c *Client) GetConfig() (*types.Config, error) {
-	resp, err := http.Get(c.baseURL + "/api/config")
+	req, err := http.NewRequest("GET", c.baseURL+"/api/config", nil)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(req.Header, apiKeyHeader())
+
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, Mark(errors.New("the server refused the API key"), ErrRejected)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+		return nil, StatusError(resp.StatusCode)
 	}
 
 	var config types.Config
@@ -49,62 +66,125 @@ func (c *Client) GetConfig() (*types.Config, error) {
 
 // FetchMetadata retrieves and decrypts file metadata
 func (c *Client) FetchMetadata(fileID string, key []byte) (*types.Metadata, string, error) {
-	token, err := crypto.GenerateHMACToken(fileID, key)
+	status, err := c.Stat(fileID, key)
 	if err != nil {
 		return nil, "", err
 	}
+	return status.Metadata, status.Token, nil
+}
+
+// Stat retrieves and decrypts file metadata along with the server-side
+// lifecycle information reported in the response headers.
+func (c *Client) Stat(fileID string, key []byte) (*types.FileStatus, error) {
+	token, err := crypto.GenerateHMACToken(fileID, key)
+	if err != nil {
+		return nil, err
+	}
 
 	req, err := http.NewRequest("GET", c.baseURL+"/api/metadata/"+fileID, nil)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	req.Header.Set("X-HMAC-Token", token)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotAvailable
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("server returned status %d", resp.StatusCode)
+		return nil, StatusError(resp.StatusCode)
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
 	decrypted, err := crypto.DecryptMetadata(key, data)
 	if err != nil {
-		return nil, "", fmt.Errorf("decryption failed: %w", err)
+		return nil, Mark(fmt.Errorf("decryption failed: %w", err), ErrDecryption)
 	}
 
 	var metadata types.Metadata
-	if err := json.Unmarshal(decrypted, &metadata); err != nil {
-		return nil, "", err
+	if _, err := crypto.UnmarshalMetadata(decrypted, &metadata); err != nil {
+		return nil, err
 	}
 
-	return &metadata, token, nil
+	status := &types.FileStatus{
+		Metadata: &metadata,
+		Token:    token,
+	}
+	if size, err := strconv.ParseInt(resp.Header.Get("X-File-Size"), 10, 64); err == nil {
+		status.EncryptedSize = size
+	}
+	if exp, err := time.Parse(time.RFC3339, resp.Header.Get("X-Expires-At")); err == nil {
+		status.ExpiresAt = exp
+	}
+	if n, err := strconv.Atoi(resp.Header.Get("X-Downloads-Remaining")); err == nil {
+		status.DownloadsRemaining = n
+	}
+	return status, nil
+}
+
+// Stats asks how often a file was downloaded, proving ownership with the
+// owner token the server issued at upload. The server keeps answering for
+// a while after the last download removed the file.
+func (c *Client) Stats(fileID, token, ownerToken string) (*types.DownloadStats, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/stats/"+fileID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-HMAC-Token", token)
+	req.Header.Set("X-Owner-Token", ownerToken)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotAvailable
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, StatusError(resp.StatusCode)
+	}
+
+	var stats types.DownloadStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
 }
 
-// DeleteFile removes a file from the server after download completes
-func (c *Client) DeleteFile(fileID string, token string) error {
+// DeleteFile tells the server a download completed. The server removes the
+// file unless it was uploaded with a larger download allowance; the number
+// of downloads left is returned.
+func (c *Client) DeleteFile(fileID string, token string) (int, error) {
 	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/api/delete/%s", c.baseURL, fileID), nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	req.Header.Set("X-HMAC-Token", token)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status %d", resp.StatusCode)
+		return 0, StatusError(resp.StatusCode)
 	}
 
-	return nil
+	var result struct {
+		Remaining int `json:"remaining"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&result) // older servers send no count
+	return result.Remaining, nil
 }
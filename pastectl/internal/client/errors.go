@@ -0,0 +1,38 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Failures callers may want to tell apart, for instance to pick an exit
+// status. Errors marked with them keep their own messages; match them with
+// errors.Is.
+var (
+	// ErrRejected marks a request the server answered with an error.
+	ErrRejected = errors.New("rejected by the server")
+	// ErrDecryption marks data the key doesn't open, or that fails its
+	// integrity check.
+	ErrDecryption = errors.New("decryption failed")
+)
+
+// Mark returns an error with err's message for which errors.Is(_, kind)
+// also holds.
+func Mark(err, kind error) error {
+	if err == nil {
+		return nil
+	}
+	return &markedError{err: err, kind: kind}
+}
+
+type markedError struct {
+	err, kind error
+}
+
+func (e *markedError) Error() string   { return e.err.Error() }
+func (e *markedError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// StatusError reports an unexpected HTTP status from the server.
+func StatusError(code int) error {
+	return Mark(fmt.Errorf("server returned status %d", code), ErrRejected)
+}
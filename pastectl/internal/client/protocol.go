@@ -0,0 +1,19 @@
+package client
+
+import "fmt"
+
+// ProtocolVersion is the highest WebSocket protocol version pastectl
+// speaks. It is sent in init and download_init, and the server answers
+// with the version it picked; servers from before negotiation answer
+// without one and speak version 1. Version 2 adds windowed uploads with
+// cumulative acks.
+const ProtocolVersion = 2
+
+// CheckProtocol validates the version a server picked, as decoded from its
+// reply (zero when absent).
+func CheckProtocol(v int) error {
+	if v == 0 || (v >= 1 && v <= ProtocolVersion) {
+		return nil
+	}
+	return Mark(fmt.Errorf("server picked protocol version %d, which this pastectl doesn't speak", v), ErrRejected)
+}
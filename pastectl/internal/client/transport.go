@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Default network timeouts. A transfer may take hours, so there is no
+// limit on a whole request, only on how long the server may stay silent.
+const (
+	DefaultConnectTimeout = 15 * time.Second
+	DefaultTimeout        = 60 * time.Second
+)
+
+// NetworkOptions configures every connection the CLI makes to a server.
+type NetworkOptions struct {
+	// ConnectTimeout bounds establishing a connection, including the TLS
+	// handshake. Zero means no limit.
+	ConnectTimeout time.Duration
+	// Timeout is how long an open connection may go without receiving
+	// anything before the transfer is abandoned. Zero means no limit.
+	Timeout time.Duration
+	// Proxy is used for every connection instead of HTTP_PROXY,
+	// HTTPS_PROXY and ALL_PROXY. NO_PROXY still applies.
+	Proxy string
+	// CACert is a PEM bundle of extra certificate authorities to trust,
+	// for servers behind a private PKI.
+	CACert string
+	// Insecure skips TLS certificate verification entirely.
+	Insecure bool
+	// APIKey puts uploads into the key's namespace on servers with API
+	// keys. It is only sent to the paste server, never to mirrored URLs.
+	APIKey string
+}
+
+var (
+	httpClient *http.Client
+	wsDialer   *websocket.Dialer
+	apiKey     string
+)
+
+func init() {
+	Configure(NetworkOptions{ConnectTimeout: DefaultConnectTimeout, Timeout: DefaultTimeout})
+}
+
+// Configure replaces the shared HTTP client and WebSocket dialer. The CLI
+// calls it once, before any request, from the command-line flags.
+func Configure(opts NetworkOptions) error {
+	proxy, err := proxyFunc(opts.Proxy)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := tlsConfigFor(opts)
+	if err != nil {
+		return err
+	}
+
+	dial := (&net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	if opts.Timeout > 0 {
+		dial = idleTimeoutDialer(dial, opts.Timeout)
+	}
+
+	httpClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:               proxy,
+			DialContext:         dial,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: opts.ConnectTimeout,
+			IdleConnTimeout:     30 * time.Second,
+		},
+	}
+	apiKey = opts.APIKey
+	wsDialer = &websocket.Dialer{
+		Proxy:             wsProxy(proxy),
+		NetDialContext:    dial,
+		TLSClientConfig:   tlsConfig,
+		HandshakeTimeout:  opts.ConnectTimeout,
+		EnableCompression: true,
+	}
+	return nil
+}
+
+// HTTPClient returns the client for requests to the server.
+func HTTPClient() *http.Client {
+	return httpClient
+}
+
+// DialWebSocket connects to a WebSocket endpoint of the server. It offers
+// compression, which servers with WS_COMPRESSION use for their JSON
+// messages, but sends everything uncompressed itself: encrypted chunks
+// don't shrink, and its own control messages are a few bytes.
+func DialWebSocket(url string) (*websocket.Conn, error) {
+	conn, resp, err := wsDialer.Dial(url, apiKeyHeader())
+	if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+		return nil, Mark(errors.New("the server refused the API key (set one with --api-key or PASTE_API_KEY)"), ErrRejected)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.EnableWriteCompression(false)
+	return conn, nil
+}
+
+// apiKeyHeader returns the header carrying the configured API key, or nil
+// without one.
+func apiKeyHeader() http.Header {
+	if apiKey == "" {
+		return nil
+	}
+	return http.Header{"X-Api-Key": {apiKey}}
+}
+
+// tlsConfigFor returns the TLS settings for opts, or nil for the defaults.
+func tlsConfigFor(opts NetworkOptions) (*tls.Config, error) {
+	if opts.CACert == "" && !opts.Insecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		// Added to the system roots, so public servers keep working.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CACert)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// proxyFunc picks the proxy for each request: explicit if set, otherwise
+// the usual environment variables. Unlike http.ProxyFromEnvironment it
+// also honours ALL_PROXY, which is how SOCKS proxies are usually set.
+func proxyFunc(explicit string) (func(*http.Request) (*url.URL, error), error) {
+	cfg := httpproxy.Config{
+		HTTPProxy:  getenv("HTTP_PROXY", "ALL_PROXY"),
+		HTTPSProxy: getenv("HTTPS_PROXY", "ALL_PROXY"),
+		NoProxy:    getenv("NO_PROXY"),
+	}
+	if explicit != "" {
+		u, err := url.Parse(explicit)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", explicit)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https, socks5 or socks5h)", u.Scheme)
+		}
+		cfg.HTTPProxy, cfg.HTTPSProxy = explicit, explicit
+	}
+
+	proxyForURL := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyForURL(req.URL)
+	}, nil
+}
+
+// getenv returns the first of the variables that is set, checking the
+// upper- and lower-case spelling of each.
+func getenv(names ...string) string {
+	for _, name := range names {
+		for _, n := range []string{name, strings.ToLower(name)} {
+			if v := os.Getenv(n); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// wsProxy adapts proxy to the WebSocket dialer, which speaks SOCKS5 but
+// only knows it by the "socks5" scheme. Its SOCKS5 client always lets the
+// proxy resolve host names, which is what socks5h asks for.
+func wsProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if err != nil || u == nil {
+			return u, err
+		}
+		switch u.Scheme {
+		case "socks5h":
+			u = &url.URL{Scheme: "socks5", User: u.User, Host: u.Host}
+		case "https":
+			return nil, errors.New("HTTPS proxies are not supported for WebSocket connections; use an http:// or socks5:// proxy")
+		}
+		return u, nil
+	}
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func idleTimeoutDialer(dial dialFunc, timeout time.Duration) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &idleTimeoutConn{Conn: conn, timeout: timeout}, nil
+	}
+}
+
+// idleTimeoutConn fails a read that waits longer than timeout for data.
+// A deadline set by the user of the connection still applies when it is
+// sooner.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	d := time.Now().Add(c.timeout)
+	ours := true
+	if !c.deadline.IsZero() && c.deadline.Before(d) {
+		d, ours = c.deadline, false
+	}
+	c.mu.Unlock()
+
+	c.Conn.SetReadDeadline(d)
+	n, err := c.Conn.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && ours {
+		return n, &idleTimeoutError{timeout: c.timeout}
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *idleTimeoutConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// idleTimeoutError is a net.Error so callers that check for timeouts still
+// recognize it.
+type idleTimeoutError struct{ timeout time.Duration }
+
+func (e *idleTimeoutError) Error() string {
+	return fmt.Sprintf("no response from server for %s (see --timeout)", e.timeout)
+}
+func (e *idleTimeoutError) Timeout() bool   { return true }
+func (e *idleTimeoutError) Temporary() bool { return true }
@@ -15,13 +15,13 @@ _pastectl_completion() {
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
     # Main commands
-    local commands="upload send download version help completion"
+    local commands="upload send download watch mirror note decrypt admin login logout health bench info exists list open stats version help completion man"
 
     # Flags for upload
-    local upload_flags="-f -n -url"
+    local upload_flags="-f -n -p -url -url-mode -expire -burn -downloads -key -json -q -debug -exclude -follow-symlinks -preserve-symlinks -separate -parallel -resume -limit-rate -compress -lang -tee -format -timeout -connect-timeout -proxy -cacert -insecure -api-key"
 
     # Flags for download
-    local download_flags="-l -o -url"
+    local download_flags="-l -o -d -dir -url -json -q -debug -no-preserve -limit-rate -raw -id -key -force -auto-rename -timeout -connect-timeout -proxy -cacert -insecure -api-key"
 
     # Complete main command if we're on word 1
     if [ $COMP_CWORD -eq 1 ]; then
@@ -40,24 +40,33 @@ _pastectl_completion() {
                     COMPREPLY=( $(compgen -f -- ${cur}) )
                     return 0
                     ;;
-                -n|-url)
+                -n|-url|-p|-expire|-downloads|-key|-exclude|-parallel|-chunk-size|-limit-rate|-lang|-format|-timeout|-connect-timeout|-proxy)
                     # No completion for these
                     return 0
                     ;;
                 *)
-                    COMPREPLY=( $(compgen -W "${upload_flags}" -- ${cur}) )
+                    if [[ "${cur}" == -* ]]; then
+                        COMPREPLY=( $(compgen -W "${upload_flags}" -- ${cur}) )
+                    else
+                        # Inputs may also be given as arguments
+                        COMPREPLY=( $(compgen -f -- ${cur}) )
+                    fi
                     return 0
                     ;;
             esac
             ;;
         download)
             case "${prev}" in
-                -o)
+                -o|-cacert)
                     # Complete files
                     COMPREPLY=( $(compgen -f -- ${cur}) )
                     return 0
                     ;;
-                -l|-url)
+                -d|-dir)
+                    COMPREPLY=( $(compgen -d -- ${cur}) )
+                    return 0
+                    ;;
+                -l|-url|-limit-rate|-id|-key|-timeout|-connect-timeout|-proxy)
                     # No completion for these
                     return 0
                     ;;
@@ -67,10 +76,133 @@ _pastectl_completion() {
                     ;;
             esac
             ;;
+        decrypt)
+            case "${prev}" in
+                -f|-o)
+                    COMPREPLY=( $(compgen -f -- ${cur}) )
+                    return 0
+                    ;;
+                -key)
+                    return 0
+                    ;;
+                *)
+                    if [[ ${cur} == -* ]]; then
+                        COMPREPLY=( $(compgen -W "-f -key -o -json -q -debug -no-preserve -force -auto-rename" -- ${cur}) )
+                    else
+                        COMPREPLY=( $(compgen -f -- ${cur}) )
+                    fi
+                    return 0
+                    ;;
+            esac
+            ;;
+        mirror)
+            case "${prev}" in
+                -n|-url|-p|-expire|-downloads|-key|-parallel|-chunk-size|-limit-rate|-lang|-timeout|-connect-timeout|-proxy)
+                    return 0
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "-n -url -p -url-mode -expire -burn -downloads -key -json -q -debug -parallel -chunk-size -per-chunk-keys -limit-rate -compress -lang -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+                    return 0
+                    ;;
+            esac
+            ;;
+        note)
+            case "${prev}" in
+                -m|-t|-n|-url|-p|-expire|-downloads|-key|-parallel|-chunk-size|-limit-rate|-lang|-timeout|-connect-timeout|-proxy)
+                    return 0
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "-m -t -n -url -p -url-mode -expire -burn -downloads -key -json -q -debug -lang -format -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+                    return 0
+                    ;;
+            esac
+            ;;
+        watch)
+            case "${prev}" in
+                -log)
+                    COMPREPLY=( $(compgen -f -- ${cur}) )
+                    return 0
+                    ;;
+                -url|-p|-expire|-downloads|-key|-exclude|-parallel|-chunk-size|-limit-rate|-lang|-webhook|-settle|-timeout|-connect-timeout|-proxy)
+                    return 0
+                    ;;
+                *)
+                    if [[ "${cur}" == -* ]]; then
+                        COMPREPLY=( $(compgen -W "-url -p -url-mode -expire -burn -downloads -key -json -q -debug -exclude -parallel -chunk-size -per-chunk-keys -limit-rate -compress -lang -log -webhook -settle -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+                    else
+                        COMPREPLY=( $(compgen -d -- ${cur}) )
+                    fi
+                    return 0
+                    ;;
+            esac
+            ;;
+        admin)
+            case "${prev}" in
+                -url|-metrics-path|-token|-watch|-older-than|-larger-than|-namespace|-timeout|-connect-timeout|-proxy)
+                    return 0
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "activity storage requests security purge -url -metrics-path -token -json -watch -older-than -larger-than -namespace -all -dry-run -yes -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+                    return 0
+                    ;;
+            esac
+            ;;
+        health)
+            case "${prev}" in
+                -url|-n|-timeout|-connect-timeout|-proxy)
+                    return 0
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "-url -n -no-probe -json -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+                    return 0
+                    ;;
+            esac
+            ;;
+        bench)
+            case "${prev}" in
+                -url|-size|-parallel|-timeout|-connect-timeout|-proxy)
+                    return 0
+                    ;;
+                *)
+                    COMPREPLY=( $(compgen -W "-url -size -parallel -json -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+                    return 0
+                    ;;
+            esac
+            ;;
+        info)
+            COMPREPLY=( $(compgen -W "-url -json -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+            return 0
+            ;;
+        exists)
+            COMPREPLY=( $(compgen -W "-q -url -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+            return 0
+            ;;
+        list|ls)
+            COMPREPLY=( $(compgen -W "-n -a" -- ${cur}) )
+            return 0
+            ;;
+        open)
+            COMPREPLY=( $(compgen -W "-c" -- ${cur}) )
+            return 0
+            ;;
+        stats)
+            COMPREPLY=( $(compgen -W "-json -timeout -connect-timeout -proxy -cacert -insecure -api-key" -- ${cur}) )
+            return 0
+            ;;
         completion)
             COMPREPLY=( $(compgen -W "bash zsh fish" -- ${cur}) )
             return 0
             ;;
+        login|logout)
+            if [[ "${prev}" != "-url" ]]; then
+                COMPREPLY=( $(compgen -W "-url" -- ${cur}) )
+            fi
+            return 0
+            ;;
+        man)
+            COMPREPLY=( $(compgen -d -- ${cur}) )
+            return 0
+            ;;
     esac
 }
 
@@ -88,9 +220,24 @@ _pastectl() {
         'upload:Upload a file or stdin'
         'send:Send a file or stdin'
         'download:Download a file'
+        'watch:Upload files as they appear in a directory'
+        'mirror:Re-share content from an HTTP(S) URL'
+        'note:Share a snippet written in an editor'
+        'decrypt:Open a file saved with download --raw'
+        'admin:Summarise the server metrics'
+        'login:Store a server token'
+        'logout:Forget a stored server token'
+        'health:Check the server before a large transfer'
+        'bench:Measure encryption and transfer speed'
+        'info:Show file details without downloading'
+        'exists:Check whether a file is still available'
+        'list:Show previous uploads'
+        'open:Re-print a previous upload'
+        'stats:Show how often a previous upload was downloaded'
         'version:Show version'
         'help:Show help'
         'completion:Generate shell completion'
+        'man:Print or write man pages'
     )
 
     local -a upload_args
@@ -98,13 +245,63 @@ _pastectl() {
         '-f[File to upload]:file:_files'
         '-n[Override filename]:filename:'
         '-url[Paste server URL]:url:'
+        '-expire[Delete after duration]:duration:'
+        '-burn[Delete after first download]'
+        '-downloads[Delete after N downloads]:count:'
+        '-key[Pre-provisioned base64 key]:key:'
+        '-json[Print the result as JSON]'
+        '-q[Quiet]'
+        '-debug[Log protocol steps and timing]'
+        '*-exclude[Skip matching paths in a directory]:pattern:'
+        '(-preserve-symlinks)-follow-symlinks[Archive what symlinks point to]'
+        '(-follow-symlinks)-preserve-symlinks[Store symlinks as links]'
+        '-separate[One link per input instead of a bundle]'
+        '-parallel[Chunks in flight before waiting for an ack]:count:'
+        '-chunk-size[Chunk size in MB instead of the server default]:megabytes:'
+        '-per-chunk-keys[Seal every chunk with its own derived key]'
+        '(-separate)-resume[Continue an interrupted upload]'
+        '-limit-rate[Bytes per second (e.g. 500K, 5M)]:rate:'
+        '-compress=-[Compress before encrypting]::algorithm:(zstd gzip)'
+        '-lang[Syntax-highlighting hint]:language:'
+        '-tee[Pass piped input through to stdout]'
+        '-format[Template for the share message]:template:'
+        '-timeout[Give up when the server is silent this long]:duration:'
+        '-connect-timeout[Give up connecting after this long]:duration:'
+        '-proxy[Proxy URL]:url:'
+        '-cacert[Extra CA bundle]:file:_files'
+        '-insecure[Skip TLS certificate verification]'
+        '-api-key[Upload into this API key namespace]:key:'
+        '*:file:_files'
     )
 
     local -a download_args
     download_args=(
         '-l[Download link]:link:'
         '-o[Output file]:file:_files'
+        '-d[Save into this directory]:directory:_files -/'
+        '-dir[Save into this directory]:directory:_files -/'
         '-url[Paste server URL]:url:'
+        '-json[Print the result as JSON]'
+        '-q[Quiet]'
+        '-debug[Log protocol steps and timing]'
+        '-no-preserve[Do not restore file mode and mtime]'
+        '-limit-rate[Bytes per second (e.g. 500K, 5M)]:rate:'
+        '-raw[Save the file still encrypted]'
+        '-id[File ID, or a link missing its key]:id:'
+        '-key[Base64 key for -id]:key:'
+        '-force[Overwrite an existing output file]'
+        '-auto-rename[Save under a new name if the output exists]'
+        '-timeout[Give up when the server is silent this long]:duration:'
+        '-connect-timeout[Give up connecting after this long]:duration:'
+        '-proxy[Proxy URL]:url:'
+        '-cacert[Extra CA bundle]:file:_files'
+        '-insecure[Skip TLS certificate verification]'
+    )
+
+    local -a list_args
+    list_args=(
+        '-n[Maximum entries]:count:'
+        '-a[Include expired uploads]'
     )
 
     local -a completion_args
@@ -130,9 +327,111 @@ _pastectl() {
                 download)
                     _arguments $download_args
                     ;;
+                decrypt)
+                    _arguments \
+                        '-f[File saved with download --raw]:file:_files' \
+                        '-key[Base64 key or share link]:key:' \
+                        '-o[Output file]:file:_files' \
+                        '-json[Print the result as JSON]' \
+                        '-q[Quiet]' \
+                        '-debug[Log each step]' \
+                        '-no-preserve[Do not restore file mode and mtime]' \
+                        '-force[Overwrite an existing output file]' \
+                        '-auto-rename[Save under a new name if the output exists]' \
+                        '1:file:_files'
+                    ;;
+                admin)
+                    _arguments \
+                        '-url[Paste server URL]:url:' \
+                        '-metrics-path[Prometheus endpoint path]:path:' \
+                        '-token[Bearer token]:token:' \
+                        '-json[Print the summary as JSON]' \
+                        '-watch[Refresh every interval]:duration:' \
+                        '-older-than[Purge files uploaded longer ago than this]:duration:' \
+                        '-larger-than[Purge files larger than this]:size:' \
+                        '-namespace[Purge files in this namespace]:namespace:' \
+                        '-all[Purge every stored file]' \
+                        '-dry-run[Count the files a purge would remove]' \
+                        '-yes[Purge without asking]' \
+                        '*:section:(activity storage requests security purge)'
+                    ;;
+                health)
+                    _arguments \
+                        '-url[Paste server URL]:url:' \
+                        '-n[Requests to measure the round trip with]:count:' \
+                        '-no-probe[Do not send test chunks]' \
+                        '-json[Print the report as JSON]'
+                    ;;
+                bench)
+                    _arguments \
+                        '-url[Paste server URL]:url:' \
+                        '-size[Bytes of random data to transfer]:size:' \
+                        '-parallel[Chunks in flight]:count:' \
+                        '-json[Print the results as JSON]'
+                    ;;
+                mirror)
+                    _arguments \
+                        '-n[Override filename]:filename:' \
+                        '-url[Paste server URL]:url:' \
+                        '-url-mode[Use URL mode]' \
+                        '-expire[Delete after duration]:duration:' \
+                        '-burn[Delete after first download]' \
+                        '-downloads[Delete after N downloads]:count:' \
+                        '-json[Print the result as JSON]' \
+                        '-q[Quiet]' \
+                        '1:url:'
+                    ;;
+                note)
+                    _arguments \
+                        '-m[Text instead of opening an editor]:text:' \
+                        '-t[Title]:title:' \
+                        '-n[Override filename]:filename:' \
+                        '-url[Paste server URL]:url:' \
+                        '-url-mode[Use URL mode]' \
+                        '-expire[Delete after duration]:duration:' \
+                        '-burn[Delete after first download]' \
+                        '-lang[Syntax-highlighting hint]:language:' \
+                        '-json[Print the result as JSON]' \
+                        '-q[Quiet]'
+                    ;;
+                watch)
+                    _arguments \
+                        '-url[Paste server URL]:url:' \
+                        '-url-mode[Use URL mode]' \
+                        '-expire[Delete after duration]:duration:' \
+                        '-downloads[Delete after N downloads]:count:' \
+                        '*-exclude[Skip matching files]:pattern:' \
+                        '-json[Print each result as JSON]' \
+                        '-q[Quiet]' \
+                        '-log[Append links to this file]:file:_files' \
+                        '-webhook[POST each result to this URL]:url:' \
+                        '-settle[Wait for writes to stop this long]:duration:' \
+                        '1:directory:_files -/'
+                    ;;
+                info)
+                    _arguments '-url[Paste server URL]:url:' '-json[Print the result as JSON]'
+                    ;;
+                exists)
+                    _arguments '-q[Quiet]' '-url[Paste server URL]:url:'
+                    ;;
+                list)
+                    _arguments $list_args
+                    ;;
+                open)
+                    _arguments '-c[Copy to clipboard]'
+                    ;;
+                stats)
+                    _arguments '-json[Print the result as JSON]'
+                    ;;
                 completion)
                     _describe 'shell' completion_args
                     ;;
+                login|logout)
+                    _arguments '-url[Paste server URL]:url:'
+                    ;;
+                man)
+                    _arguments '1:directory:_files -/'
+                    ;;
             esac
             ;;
     esac
@@ -150,27 +449,180 @@ func FishCompletion() string {
 complete -c pastectl -f -n __fish_use_subcommand -a upload -d 'Upload a file or stdin'
 complete -c pastectl -f -n __fish_use_subcommand -a send -d 'Send a file or stdin'
 complete -c pastectl -f -n __fish_use_subcommand -a download -d 'Download a file'
+complete -c pastectl -f -n __fish_use_subcommand -a watch -d 'Upload files as they appear in a directory'
+complete -c pastectl -f -n __fish_use_subcommand -a mirror -d 'Re-share content from an HTTP(S) URL'
+complete -c pastectl -f -n __fish_use_subcommand -a note -d 'Share a snippet written in an editor'
+complete -c pastectl -f -n __fish_use_subcommand -a decrypt -d 'Open a file saved with download --raw'
+complete -c pastectl -f -n __fish_use_subcommand -a admin -d 'Summarise the server metrics'
+complete -c pastectl -f -n __fish_use_subcommand -a login -d 'Store a server token'
+complete -c pastectl -f -n __fish_use_subcommand -a logout -d 'Forget a stored server token'
+complete -c pastectl -f -n __fish_use_subcommand -a health -d 'Check the server before a large transfer'
+complete -c pastectl -f -n __fish_use_subcommand -a bench -d 'Measure encryption and transfer speed'
+complete -c pastectl -f -n __fish_use_subcommand -a info -d 'Show file details without downloading'
+complete -c pastectl -f -n __fish_use_subcommand -a exists -d 'Check whether a file is still available'
+complete -c pastectl -f -n __fish_use_subcommand -a list -d 'Show previous uploads'
+complete -c pastectl -f -n __fish_use_subcommand -a open -d 'Re-print a previous upload'
+complete -c pastectl -f -n __fish_use_subcommand -a stats -d 'Show how often a previous upload was downloaded'
 complete -c pastectl -f -n __fish_use_subcommand -a version -d 'Show version'
 complete -c pastectl -f -n __fish_use_subcommand -a help -d 'Show help'
 complete -c pastectl -f -n __fish_use_subcommand -a completion -d 'Generate shell completion'
+complete -c pastectl -f -n __fish_use_subcommand -a man -d 'Print or write man pages'
 
 # Upload command
 complete -c pastectl -n '__fish_seen_subcommand_from upload' -s f -l file -d 'File to upload' -r
 complete -c pastectl -n '__fish_seen_subcommand_from upload' -s n -l name -d 'Override filename' -r
 complete -c pastectl -n '__fish_seen_subcommand_from upload' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l json -d 'Print the result as JSON'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -s q -l quiet -d 'Quiet'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l format -r -d 'Template for the share message'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -s v -l debug -d 'Log protocol steps and timing'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l exclude -r -d 'Skip matching paths in a directory'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l follow-symlinks -d 'Archive what symlinks point to'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l preserve-symlinks -d 'Store symlinks as links'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l separate -d 'One link per input instead of a bundle'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l parallel -r -d 'Chunks in flight before waiting for an ack'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l resume -d 'Continue an interrupted upload'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l tee -d 'Pass piped input through to stdout'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l expire -d 'Delete after duration' -r
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l burn -d 'Delete after first download'
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l downloads -d 'Delete after N downloads' -r
+complete -c pastectl -n '__fish_seen_subcommand_from upload' -l key -d 'Pre-provisioned base64 key' -r
 
 # Send command (shares flags with upload)
 complete -c pastectl -n '__fish_seen_subcommand_from send' -s f -l file -d 'File to send' -r
 complete -c pastectl -n '__fish_seen_subcommand_from send' -s n -l name -d 'Override filename' -r
 complete -c pastectl -n '__fish_seen_subcommand_from send' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l json -d 'Print the result as JSON'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -s q -l quiet -d 'Quiet'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l format -r -d 'Template for the share message'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -s v -l debug -d 'Log protocol steps and timing'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l exclude -r -d 'Skip matching paths in a directory'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l follow-symlinks -d 'Archive what symlinks point to'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l preserve-symlinks -d 'Store symlinks as links'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l separate -d 'One link per input instead of a bundle'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l parallel -r -d 'Chunks in flight before waiting for an ack'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l resume -d 'Continue an interrupted upload'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l tee -d 'Pass piped input through to stdout'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l expire -d 'Delete after duration' -r
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l burn -d 'Delete after first download'
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l downloads -d 'Delete after N downloads' -r
+complete -c pastectl -n '__fish_seen_subcommand_from send' -l key -d 'Pre-provisioned base64 key' -r
 
 # Download command
 complete -c pastectl -n '__fish_seen_subcommand_from download' -s l -l link -d 'Download link' -r
 complete -c pastectl -n '__fish_seen_subcommand_from download' -s o -l output -d 'Output file' -r
+complete -c pastectl -n '__fish_seen_subcommand_from download' -s d -l dir -d 'Save into this directory' -r -a '(__fish_complete_directories)'
 complete -c pastectl -n '__fish_seen_subcommand_from download' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from download' -l json -d 'Print the result as JSON'
+complete -c pastectl -n '__fish_seen_subcommand_from download' -s q -l quiet -d 'Quiet'
+complete -c pastectl -n '__fish_seen_subcommand_from download' -s v -l debug -d 'Log protocol steps and timing'
+complete -c pastectl -n '__fish_seen_subcommand_from download' -l no-preserve -d 'Do not restore file mode and mtime'
+complete -c pastectl -n '__fish_seen_subcommand_from download' -l raw -d 'Save the file still encrypted'
+complete -c pastectl -n '__fish_seen_subcommand_from download' -l id -d 'File ID, or a link missing its key' -r
+complete -c pastectl -n '__fish_seen_subcommand_from download' -l key -d 'Base64 key for --id' -r
+complete -c pastectl -n '__fish_seen_subcommand_from download decrypt' -l force -d 'Overwrite an existing output file'
+complete -c pastectl -n '__fish_seen_subcommand_from download decrypt' -l auto-rename -d 'Save under a new name if the output exists'
+
+# Admin command
+complete -c pastectl -f -n '__fish_seen_subcommand_from admin' -a 'activity storage requests security purge'
+complete -c pastectl -n '__fish_seen_subcommand_from admin' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from admin' -l metrics-path -d 'Prometheus endpoint path' -r
+complete -c pastectl -n '__fish_seen_subcommand_from admin' -l token -d 'Bearer token' -r
+complete -c pastectl -n '__fish_seen_subcommand_from admin' -l json -d 'Print the summary as JSON'
+complete -c pastectl -f -n '__fish_seen_subcommand_from login logout' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from admin' -l watch -d 'Refresh every interval' -r
+complete -c pastectl -n '__fish_seen_subcommand_from purge' -l older-than -d 'Purge files uploaded longer ago than this' -r
+complete -c pastectl -n '__fish_seen_subcommand_from purge' -l larger-than -d 'Purge files larger than this' -r
+complete -c pastectl -n '__fish_seen_subcommand_from purge' -l namespace -d 'Purge files in this namespace' -r
+complete -c pastectl -n '__fish_seen_subcommand_from purge' -l all -d 'Purge every stored file'
+complete -c pastectl -n '__fish_seen_subcommand_from purge' -l dry-run -d 'Count the files a purge would remove'
+complete -c pastectl -n '__fish_seen_subcommand_from purge' -s y -l yes -d 'Purge without asking'
+
+# Health command
+complete -c pastectl -n '__fish_seen_subcommand_from health' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from health' -s n -d 'Requests to measure the round trip with' -r
+complete -c pastectl -n '__fish_seen_subcommand_from health' -l no-probe -d 'Do not send test chunks'
+complete -c pastectl -n '__fish_seen_subcommand_from health' -l json -d 'Print the report as JSON'
+
+# Bench command
+complete -c pastectl -n '__fish_seen_subcommand_from bench' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from bench' -l size -d 'Bytes of random data to transfer' -r
+complete -c pastectl -n '__fish_seen_subcommand_from bench' -l parallel -d 'Chunks in flight' -r
+complete -c pastectl -n '__fish_seen_subcommand_from bench' -l json -d 'Print the results as JSON'
+
+# Decrypt command
+complete -c pastectl -n '__fish_seen_subcommand_from decrypt' -s f -d 'File saved with download --raw' -r -F
+complete -c pastectl -n '__fish_seen_subcommand_from decrypt' -l key -d 'Base64 key or share link' -r
+complete -c pastectl -n '__fish_seen_subcommand_from decrypt' -s o -d 'Output file' -r -F
+complete -c pastectl -n '__fish_seen_subcommand_from decrypt' -l json -d 'Print the result as JSON'
+complete -c pastectl -n '__fish_seen_subcommand_from decrypt' -s q -l quiet -d 'Quiet'
+complete -c pastectl -n '__fish_seen_subcommand_from decrypt' -s v -l debug -d 'Log each step'
+complete -c pastectl -n '__fish_seen_subcommand_from decrypt' -l no-preserve -d 'Do not restore file mode and mtime'
+
+# Transfer flags
+complete -c pastectl -n '__fish_seen_subcommand_from upload send download watch mirror' -l limit-rate -r -d 'Bytes per second (e.g. 500K, 5M)'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send watch mirror' -l compress -a 'zstd gzip' -d 'Compress before encrypting'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send watch mirror' -l chunk-size -d 'Chunk size in MB' -r
+complete -c pastectl -n '__fish_seen_subcommand_from upload send watch mirror' -l per-chunk-keys -d 'Seal every chunk with its own derived key'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send watch mirror note' -l lang -d 'Syntax-highlighting hint' -r
+
+# Watch command
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l url-mode -d 'Use URL mode instead of passphrase'
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l expire -d 'Delete after duration' -r
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l downloads -d 'Delete after N downloads' -r
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l exclude -r -d 'Skip matching files'
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l json -d 'Print each result as JSON'
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -s q -l quiet -d 'Quiet'
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l format -r -d 'Template for the share message'
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l log -r -F -d 'Append links to this file'
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l webhook -r -d 'POST each result to this URL'
+complete -c pastectl -n '__fish_seen_subcommand_from watch' -l settle -r -d 'Wait for writes to stop this long'
+
+# Mirror command
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -s n -l name -d 'Override filename' -r
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -l url-mode -d 'Use URL mode instead of passphrase'
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -l expire -d 'Delete after duration' -r
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -l burn -d 'Delete after first download'
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -l downloads -d 'Delete after N downloads' -r
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -l json -d 'Print the result as JSON'
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -s q -l quiet -d 'Quiet'
+complete -c pastectl -n '__fish_seen_subcommand_from mirror' -l format -r -d 'Template for the share message'
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -s m -d 'Text instead of opening an editor' -r
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -s t -d 'Title' -r
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -s n -d 'Override filename' -r
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -l url -d 'Paste server URL' -r
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -l expire -d 'Delete after duration' -r
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -l burn -d 'Delete after first download'
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -l json -d 'Print the result as JSON'
+complete -c pastectl -f -n '__fish_seen_subcommand_from note' -s q -l quiet -d 'Quiet'
+
+# Info command
+complete -c pastectl -n '__fish_seen_subcommand_from info' -l url -d 'Paste server URL' -r
+complete -c pastectl -n '__fish_seen_subcommand_from info' -l json -d 'Print the result as JSON'
+
+# Exists command
+complete -c pastectl -n '__fish_seen_subcommand_from exists' -s q -d 'Quiet'
+complete -c pastectl -n '__fish_seen_subcommand_from exists' -l url -d 'Paste server URL' -r
+
+# Network flags
+complete -c pastectl -n '__fish_seen_subcommand_from upload send download watch mirror note admin health bench info exists stats' -l timeout -r -d 'Give up when the server is silent this long'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send download watch mirror note admin health bench info exists stats' -l connect-timeout -r -d 'Give up connecting after this long'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send download watch mirror note admin health bench info exists stats' -l proxy -r -d 'Proxy URL (http, https or socks5)'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send download watch mirror note admin health bench info exists stats' -l cacert -r -F -d 'Extra CA bundle (PEM)'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send download watch mirror note admin health bench info exists stats' -l insecure -d 'Skip TLS certificate verification'
+complete -c pastectl -n '__fish_seen_subcommand_from upload send download watch mirror note admin health bench info exists stats' -l api-key -r -d 'Upload into this API key namespace'
+
+# History commands
+complete -c pastectl -n '__fish_seen_subcommand_from list' -s n -d 'Maximum entries' -r
+complete -c pastectl -n '__fish_seen_subcommand_from list' -s a -d 'Include expired uploads'
+complete -c pastectl -n '__fish_seen_subcommand_from open' -s c -d 'Copy to clipboard'
+complete -c pastectl -n '__fish_seen_subcommand_from stats' -l json -d 'Print the result as JSON'
 
 # Completion command
 complete -c pastectl -n '__fish_seen_subcommand_from completion' -f -a 'bash zsh fish'
+complete -c pastectl -n '__fish_seen_subcommand_from man' -f -a '(__fish_complete_directories)'
 `
 }
 
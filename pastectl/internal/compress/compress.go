@@ -0,0 +1,138 @@
+// Package compress shrinks the plaintext before it is encrypted. Ciphertext
+// doesn't compress, so this is the only place it can happen.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithms, as recorded in the file metadata.
+const (
+	Zstd = crypto.CompressionZstd
+	Gzip = crypto.CompressionGzip
+)
+
+// Check returns an error unless algo is a known algorithm.
+func Check(algo string) error {
+	switch algo {
+	case Zstd, Gzip:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q (use zstd or gzip)", algo)
+}
+
+// Reader returns the compressed form of what r yields. Nothing is read
+// from r until the first Read; compression then runs in a goroutine, which
+// stops when r is exhausted or the result is closed.
+func Reader(r io.Reader, algo string) (io.ReadCloser, error) {
+	if err := Check(algo); err != nil {
+		return nil, err
+	}
+	return &reader{r: r, algo: algo}, nil
+}
+
+type reader struct {
+	r    io.Reader
+	algo string
+	pr   *io.PipeReader
+}
+
+func (c *reader) Read(p []byte) (int, error) {
+	if c.pr == nil {
+		var pw *io.PipeWriter
+		c.pr, pw = io.Pipe()
+		go compressTo(pw, c.r, c.algo)
+	}
+	return c.pr.Read(p)
+}
+
+func (c *reader) Close() error {
+	if c.pr != nil {
+		c.pr.Close()
+	}
+	return nil
+}
+
+func compressTo(pw *io.PipeWriter, r io.Reader, algo string) {
+	var enc io.WriteCloser
+	if algo == Zstd {
+		var err error
+		if enc, err = zstd.NewWriter(pw); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+	} else {
+		enc = gzip.NewWriter(pw)
+	}
+	_, err := io.Copy(enc, r)
+	if cerr := enc.Close(); err == nil {
+		err = cerr
+	}
+	pw.CloseWithError(err)
+}
+
+// Writer returns a writer that decompresses what is written to it into w.
+// Close must be called once all data is written; it reports whether the
+// stream was complete and valid.
+func Writer(w io.Writer, algo string) (io.WriteCloser, error) {
+	if err := Check(algo); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := decompress(w, pr, algo)
+		// Unblock the writer if decoding stopped early.
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	return &writer{pw: pw, done: done}, nil
+}
+
+func decompress(w io.Writer, r io.Reader, algo string) error {
+	var dec io.Reader
+	if algo == Zstd {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		dec = zr
+	} else {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		dec = gr
+	}
+	if _, err := io.Copy(w, dec); err != nil {
+		return fmt.Errorf("decompression failed: %w", err)
+	}
+	return nil
+}
+
+type writer struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+	err    error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *writer) Close() error {
+	if !w.closed {
+		w.closed = true
+		w.pw.Close()
+		w.err = <-w.done
+	}
+	return w.err
+}
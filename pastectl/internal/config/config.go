@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds user defaults read from the config file. Zero values mean
+// "not set"; command-line flags and environment variables always win.
+type Config struct {
+	ServerURL       string // server_url
+	DefaultExpiry   string // default_expiry, e.g. "24h"
+	OutputDir       string // output_dir, where downloads land by default
+	PassphraseWords int    // passphrase_words
+	Progress        *bool  // progress, nil means show progress bars
+	History         *bool  // history, nil means record uploads locally
+	EncryptHistory  bool   // encrypt_history, seal history entries with a stored key
+}
+
+// ShowProgress reports whether progress bars should be drawn.
+func (c *Config) ShowProgress() bool {
+	return c.Progress == nil || *c.Progress
+}
+
+// RecordHistory reports whether uploads are added to the local history.
+func (c *Config) RecordHistory() bool {
+	return c.History == nil || *c.History
+}
+
+// Path returns the config file location: $PASTE_CONFIG if set, otherwise
+// $XDG_CONFIG_HOME/paste/config.toml, falling back to ~/.config.
+func Path() (string, error) {
+	if p := os.Getenv("PASTE_CONFIG"); p != "" {
+		return p, nil
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "paste", "config.toml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "paste", "config.toml"), nil
+}
+
+// StateDir returns where local state such as the upload history is kept:
+// $XDG_STATE_HOME/paste, falling back to ~/.local/state.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "paste"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "paste"), nil
+}
+
+// Load reads the config file. A missing file is not an error.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return &Config{}, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	cfg, err := parse(bufio.NewScanner(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// parse understands the flat subset of TOML the config needs:
+// `key = value` pairs with string, integer and boolean values, and
+// `#` comments. Tables are rejected rather than silently ignored.
+func parse(sc *bufio.Scanner) (*Config, error) {
+	cfg := &Config{}
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", lineNo)
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key := strings.TrimSpace(k)
+		value := strings.TrimSpace(v)
+
+		var err error
+		switch key {
+		case "server_url":
+			cfg.ServerURL, err = parseString(value)
+			cfg.ServerURL = strings.TrimRight(cfg.ServerURL, "/")
+		case "default_expiry":
+			cfg.DefaultExpiry, err = parseString(value)
+		case "output_dir":
+			cfg.OutputDir, err = parseString(value)
+			cfg.OutputDir = expandHome(cfg.OutputDir)
+		case "passphrase_words":
+			cfg.PassphraseWords, err = strconv.Atoi(value)
+		case "progress":
+			var b bool
+			b, err = strconv.ParseBool(value)
+			cfg.Progress = &b
+		case "history":
+			var b bool
+			b, err = strconv.ParseBool(value)
+			cfg.History = &b
+		case "encrypt_history":
+			cfg.EncryptHistory, err = strconv.ParseBool(value)
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", lineNo, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value for %s: %v", lineNo, key, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// stripComment removes a trailing # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil // literal string, no escapes
+	}
+	if len(value) >= 2 && value[0] == '"' {
+		return strconv.Unquote(value)
+	}
+	return "", errors.New("expected a quoted string")
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,80 @@
+// Package credentials keeps secrets for paste servers, such as the bearer
+// token for a protected metrics endpoint, out of the plaintext config file.
+// Secrets go to the operating system's credential store where there is one
+// (the macOS Keychain, or the Secret Service on Linux desktops), otherwise
+// to an encrypted file: sealed with DPAPI on Windows, or with a passphrase
+// from PASTE_CREDENTIAL_PASSPHRASE elsewhere.
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// service names pastectl's entries in the system stores.
+const service = "pastectl"
+
+// ErrNotFound is returned by Get when nothing is stored for a server.
+var ErrNotFound = errors.New("no stored credential")
+
+// Store holds one secret per server URL.
+type Store interface {
+	Name() string
+	Get(server string) (string, error)
+	Set(server, secret string) error
+	Delete(server string) error
+}
+
+// Stores lists the names PASTE_CREDENTIAL_STORE accepts.
+var Stores = []string{"keychain", "secret-service", "file"}
+
+// Open returns the store named by PASTE_CREDENTIAL_STORE, or else the
+// platform's own store, falling back to the encrypted file.
+func Open() (Store, error) {
+	name := os.Getenv("PASTE_CREDENTIAL_STORE")
+	if name == "" {
+		name = defaultStore()
+	}
+	switch name {
+	case "keychain":
+		if _, err := exec.LookPath("security"); err != nil {
+			return nil, errors.New("the keychain store needs macOS's security command")
+		}
+		return keychain{}, nil
+	case "secret-service":
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, errors.New("the secret-service store needs secret-tool (libsecret-tools)")
+		}
+		return secretService{}, nil
+	case "file":
+		return openFile()
+	default:
+		return nil, fmt.Errorf("unknown PASTE_CREDENTIAL_STORE %q (choose from %s)", name, strings.Join(Stores, ", "))
+	}
+}
+
+func defaultStore() string {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("security"); err == nil {
+			return "keychain"
+		}
+	case "windows":
+	default:
+		// secret-tool installed on a headless box has no keyring to talk to.
+		if _, err := exec.LookPath("secret-tool"); err == nil && os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+			return "secret-service"
+		}
+	}
+	return "file"
+}
+
+// Key normalises a server URL so "https://host/" and "https://host" share
+// an entry.
+func Key(server string) string {
+	return strings.TrimRight(server, "/")
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package credentials
+
+// platformSealer returns nil: outside Windows the file is sealed with a
+// passphrase.
+func platformSealer() sealer { return nil }
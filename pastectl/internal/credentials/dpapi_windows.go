@@ -0,0 +1,44 @@
+package credentials
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapi seals entries with the Data Protection API, which ties them to the
+// Windows user account; no passphrase is needed.
+type dpapi struct{}
+
+func platformSealer() sealer { return dpapi{} }
+
+func (dpapi) name() string { return "dpapi" }
+
+func (dpapi) seal(secret []byte, server string) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(blob(secret), nil, blob([]byte(server)), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	return take(&out), nil
+}
+
+func (dpapi) open(sealed []byte, server string) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(blob(sealed), nil, blob([]byte(server)), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	return take(&out), nil
+}
+
+func blob(b []byte) *windows.DataBlob {
+	if len(b) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(b)), Data: &b[0]}
+}
+
+// take copies a blob allocated by the API and frees it.
+func take(b *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(b.Data)))
+	return append([]byte(nil), unsafe.Slice(b.Data, b.Size)...)
+}
@@ -0,0 +1,198 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jonasbg/paste/pastectl/internal/config"
+	"golang.org/x/crypto/argon2"
+)
+
+// sealer encrypts file entries. The server URL is bound to each secret so
+// entries can't be swapped between servers.
+type sealer interface {
+	name() string
+	seal(secret []byte, server string) ([]byte, error)
+	open(sealed []byte, server string) ([]byte, error)
+}
+
+// fileData is the credentials file. Server URLs are stored in the clear;
+// only the secrets are sealed.
+type fileData struct {
+	Sealer  string            `json:"sealer"`
+	Entries map[string][]byte `json:"entries"`
+}
+
+type fileStore struct {
+	path   string
+	sealer sealer
+}
+
+// FilePath returns the encrypted store's location:
+// $XDG_STATE_HOME/paste/credentials.json, falling back to ~/.local/state.
+func FilePath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "credentials.json"), nil
+}
+
+func openFile() (Store, error) {
+	path, err := FilePath()
+	if err != nil {
+		return nil, err
+	}
+	s := platformSealer()
+	if s == nil {
+		passphrase := os.Getenv("PASTE_CREDENTIAL_PASSPHRASE")
+		if passphrase == "" {
+			return nil, errors.New("no system credential store found; set PASTE_CREDENTIAL_PASSPHRASE to keep credentials in an encrypted file")
+		}
+		s = passphraseSealer(passphrase)
+	}
+	return &fileStore{path: path, sealer: s}, nil
+}
+
+func (f *fileStore) Name() string { return "file (" + f.sealer.name() + ")" }
+
+func (f *fileStore) load() (*fileData, error) {
+	data := &fileData{Sealer: f.sealer.name(), Entries: map[string][]byte{}}
+	raw, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", f.path, err)
+	}
+	if data.Sealer != f.sealer.name() {
+		return nil, fmt.Errorf("%s is sealed with %s, not %s", f.path, data.Sealer, f.sealer.name())
+	}
+	if data.Entries == nil {
+		data.Entries = map[string][]byte{}
+	}
+	return data, nil
+}
+
+// save replaces the file in one rename so a crash never leaves it half
+// written.
+func (f *fileStore) save(data *fileData) error {
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".credentials-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *fileStore) Get(server string) (string, error) {
+	data, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	sealed, ok := data.Entries[Key(server)]
+	if !ok {
+		return "", ErrNotFound
+	}
+	secret, err := f.sealer.open(sealed, Key(server))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the credential for %s: %w", Key(server), err)
+	}
+	return string(secret), nil
+}
+
+func (f *fileStore) Set(server, secret string) error {
+	data, err := f.load()
+	if err != nil {
+		return err
+	}
+	sealed, err := f.sealer.seal([]byte(secret), Key(server))
+	if err != nil {
+		return err
+	}
+	data.Entries[Key(server)] = sealed
+	return f.save(data)
+}
+
+func (f *fileStore) Delete(server string) error {
+	data, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := data.Entries[Key(server)]; !ok {
+		return ErrNotFound
+	}
+	delete(data.Entries, Key(server))
+	return f.save(data)
+}
+
+// passphraseSealer derives a key per entry with Argon2id, using the same
+// cost as passphrase shares, and seals with AES-256-GCM. Each entry is
+// [salt 16][IV 12][ciphertext].
+type passphraseSealer string
+
+const (
+	saltSize = 16
+	ivSize   = 12
+)
+
+func (passphraseSealer) name() string { return "passphrase" }
+
+func (p passphraseSealer) aead(salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(p), salt, 3, 64*1024, 4, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p passphraseSealer) seal(secret []byte, server string) ([]byte, error) {
+	out := make([]byte, saltSize+ivSize)
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	aead, err := p.aead(out[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[saltSize:], secret, []byte(server)), nil
+}
+
+func (p passphraseSealer) open(sealed []byte, server string) ([]byte, error) {
+	if len(sealed) < saltSize+ivSize {
+		return nil, errors.New("entry too short")
+	}
+	aead, err := p.aead(sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	secret, err := aead.Open(nil, sealed[saltSize:saltSize+ivSize], sealed[saltSize+ivSize:], []byte(server))
+	if err != nil {
+		return nil, errors.New("wrong PASTE_CREDENTIAL_PASSPHRASE or corrupted entry")
+	}
+	return secret, nil
+}
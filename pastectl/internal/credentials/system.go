@@ -0,0 +1,113 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// keychain stores secrets as generic passwords in the macOS login keychain
+// through the security command.
+type keychain struct{}
+
+func (keychain) Name() string { return "keychain" }
+
+func (keychain) Get(server string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", Key(server), "-w").Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 44 { // errSecItemNotFound
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", commandError("security", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// Set passes the secret hex-encoded on stdin in interactive mode, so it
+// never shows up in the process list.
+func (keychain) Set(server, secret string) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+		strconv.Quote(service), strconv.Quote(Key(server)), hex.EncodeToString([]byte(secret))))
+	if out, err := cmd.CombinedOutput(); err != nil || len(bytes.TrimSpace(out)) > 0 {
+		// security -i reports failures on its output but still exits 0.
+		return fmt.Errorf("security: %s", message(strings.TrimSpace(string(out)), err))
+	}
+	return nil
+}
+
+func (keychain) Delete(server string) error {
+	err := exec.Command("security", "delete-generic-password", "-s", service, "-a", Key(server)).Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 44 {
+		return ErrNotFound
+	}
+	return commandError("security", err)
+}
+
+// secretService stores secrets in the desktop keyring (GNOME Keyring,
+// KWallet) through libsecret's secret-tool.
+type secretService struct{}
+
+func (secretService) Name() string { return "secret-service" }
+
+func (secretService) Get(server string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "server", Key(server))
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// lookup exits 1 without a message when nothing matches.
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && stderr.Len() == 0 {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secret-tool: %s", message(strings.TrimSpace(stderr.String()), err))
+	}
+	return string(out), nil
+}
+
+func (secretService) Set(server, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "pastectl: "+Key(server), "service", service, "server", Key(server))
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %s", message(strings.TrimSpace(string(out)), err))
+	}
+	return nil
+}
+
+func (s secretService) Delete(server string) error {
+	// clear succeeds whether or not anything matched.
+	if _, err := s.Get(server); err != nil {
+		return err
+	}
+	out, err := exec.Command("secret-tool", "clear", "service", service, "server", Key(server)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("secret-tool: %s", message(strings.TrimSpace(string(out)), err))
+	}
+	return nil
+}
+
+func commandError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+		return fmt.Errorf("%s: %s", name, strings.TrimSpace(string(exit.Stderr)))
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// message prefers a tool's own message over the bare exit status.
+func message(msg string, err error) string {
+	if msg != "" || err == nil {
+		return msg
+	}
+	return err.Error()
+}
@@ -1,26 +1,71 @@
 package download
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/jonasbg/paste/crypto"
 	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/compress"
+	"github.com/jonasbg/paste/pastectl/internal/ratelimit"
 	"github.com/jonasbg/paste/pastectl/internal/types"
 	"github.com/jonasbg/paste/pastectl/internal/ui"
 )
 
+// ErrInvalidLink marks a link, key or passphrase that can't be parsed, as
+// opposed to one the server doesn't know.
+var ErrInvalidLink = errors.New("invalid link")
+
 // Handler handles file downloads
 type Handler struct {
-	client *client.Client
-	config *types.Config
+	client     *client.Client
+	config     *types.Config
+	outputDir  string
+	saveToFile bool
+	result     Result
+	keepAttrs  bool
+	limiter    *ratelimit.Limiter
+	raw        bool
+	writer     io.Writer
+	overwrite  Overwrite
+	stream     *wsStream // the download in progress, when over WebSocket
+}
+
+// Overwrite says what to do when the output file already exists.
+type Overwrite int
+
+const (
+	// OverwriteAsk asks on a terminal and refuses otherwise.
+	OverwriteAsk Overwrite = iota
+	// OverwriteForce replaces the file.
+	OverwriteForce
+	// OverwriteRename writes to name-1.ext, name-2.ext, ... instead.
+	OverwriteRename
+)
+
+// Result describes the last completed download.
+type Result struct {
+	FileID             string
+	Metadata           *types.Metadata
+	OutputPath         string // empty when written to stdout
+	Size               int64  // plaintext bytes written
+	SHA256             string // hex digest of the plaintext
+	Duration           time.Duration
+	DownloadsRemaining int
+	ExpiresAt          time.Time // when the server deletes the file, if it was kept and said
 }
 
 // NewHandler creates a new download handler
@@ -31,76 +76,436 @@ func NewHandler(c *client.Client, config *types.Config) *Handler {
 	}
 }
 
+// WithOutputDir sets the directory used when no output path is given and
+// the file is saved under its original name.
+func (h *Handler) WithOutputDir(dir string) *Handler {
+	h.outputDir = dir
+	return h
+}
+
+// WithSaveToFile always writes to a file (the original filename when no
+// output path is given), keeping stdout free for machine-readable output.
+func (h *Handler) WithSaveToFile() *Handler {
+	h.saveToFile = true
+	return h
+}
+
+// WithFileAttributes controls whether the sender's permission bits and
+// modification time are applied to the output file.
+func (h *Handler) WithFileAttributes(keep bool) *Handler {
+	h.keepAttrs = keep
+	return h
+}
+
+// WithRaw saves the blob as the server stores it, still encrypted, for
+// decrypting later with Decrypt. The default output name gets a .paste
+// suffix.
+func (h *Handler) WithRaw() *Handler {
+	h.raw = true
+	return h
+}
+
+// WithOverwrite sets what happens when the output file already exists.
+func (h *Handler) WithOverwrite(o Overwrite) *Handler {
+	h.overwrite = o
+	return h
+}
+
+// WithWriter sends the plaintext to w instead of a file or stdout.
+func (h *Handler) WithWriter(w io.Writer) *Handler {
+	h.writer = w
+	return h
+}
+
+// WithRateLimit caps the download at bytesPerSecond. Zero means no limit.
+func (h *Handler) WithRateLimit(bytesPerSecond int64) *Handler {
+	h.limiter = nil
+	if bytesPerSecond > 0 {
+		h.limiter = ratelimit.New(bytesPerSecond)
+	}
+	return h
+}
+
+// Result returns details of the last completed download.
+func (h *Handler) Result() Result {
+	return h.result
+}
+
 // Download downloads and decrypts a file
 func (h *Handler) Download(fileID string, key []byte, outputPath string) error {
+	started := time.Now()
+
 	// Fetch metadata
-	metadata, token, err := h.client.FetchMetadata(fileID, key)
+	ui.Debugf("fetching metadata for %s", fileID)
+	status, err := h.client.Stat(fileID, key)
 	if err != nil {
 		return fmt.Errorf("failed to fetch metadata: %w", err)
 	}
+	metadata, token := status.Metadata, status.Token
+	ui.Debugf("metadata: %q, %d bytes, %s", metadata.Filename, metadata.Size, metadata.ContentType)
+	opts, err := streamOptions(metadata)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if h.stream != nil {
+			h.stream.Close()
+			h.stream = nil
+		}
+	}()
 
 	// Determine output
-	var writer io.Writer
-	if outputPath == "" {
-		// Check if stdout is a terminal
-		stat, _ := os.Stdout.Stat()
-		if (stat.Mode() & os.ModeCharDevice) != 0 {
-			// Terminal - use original filename
-			outputPath = metadata.Filename
+	writer := h.writer
+	if writer != nil {
+		outputPath = ""
+	} else if outputPath == "" {
+		outputPath = h.defaultOutput(metadata)
+		if outputPath != "" && h.raw {
+			outputPath += BlobSuffix
 		}
 	}
 
-	if outputPath != "" {
-		// Check if file exists and prompt for overwrite
-		if _, err := os.Stat(outputPath); err == nil {
-			fmt.Fprintf(os.Stderr, "File '%s' already exists. Overwrite? [y/N]: ", outputPath)
-			var response string
-			fmt.Scanln(&response)
-			if response != "y" && response != "Y" && response != "yes" {
-				return fmt.Errorf("download cancelled")
+	// Downloads into a file can be resumed: progress is recorded as chunks
+	// are written, and an earlier interrupted attempt is picked up here.
+	// Compressed files can't be, as chunks don't line up with the output.
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	chunkMB := h.config.FileChunkSize(metadata)
+	var st *state
+	if outputPath != "" && !h.raw && metadata.Compression == "" {
+		if abs, err := filepath.Abs(outputPath); err == nil {
+			st = loadState(fileID, abs)
+			if st != nil && st.ChunkSize != chunkMB {
+				st.remove()
+				st = nil
+			}
+			if st == nil {
+				st = &state{FileID: fileID, Output: abs, ChunkSize: chunkMB}
 			}
 		}
+	}
 
-		file, err := os.Create(outputPath)
+	if st != nil && st.IV != nil {
+		file, prefixHash, done, err := resumeOutput(st)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// The partial output is gone; start over.
+			st.remove()
+			st = &state{FileID: fileID, Output: st.Output, ChunkSize: chunkMB}
+		case err != nil:
+			return fmt.Errorf("failed to resume download: %w", err)
+		default:
+			defer file.Close()
+			writer, hasher, counter.n = file, prefixHash, done
+			ui.Infof("Resuming download after %.1f MB into: %s\n", float64(done)/(1024*1024), outputPath)
+		}
+	}
+
+	if writer == nil && outputPath != "" {
+		file, path, err := h.createOutput(outputPath, metadata, "Receiving file")
 		if err != nil {
-			return fmt.Errorf("failed to create output file: %w", err)
+			return err
 		}
 		defer file.Close()
-		writer = file
-
-		// Show receiving message with file size
-		fileSizeMB := float64(metadata.Size) / (1024 * 1024)
-		if fileSizeMB >= 0.1 {
-			fmt.Fprintf(os.Stderr, "Receiving file (%.1f MB) into: %s\n", fileSizeMB, outputPath)
-		} else {
-			fileSizeKB := float64(metadata.Size) / 1024
-			fmt.Fprintf(os.Stderr, "Receiving file (%.1f KB) into: %s\n", fileSizeKB, outputPath)
+		writer, outputPath = file, path
+		if abs, err := filepath.Abs(path); err == nil && st != nil {
+			st.Output = abs
 		}
-	} else {
+	} else if writer == nil {
 		writer = os.Stdout
 	}
 
-	// Download and decrypt with streaming
-	if err := h.downloadAndDecryptStreaming(fileID, token, key, writer); err != nil {
+	if h.raw {
+		if err := h.downloadRaw(fileID, token, io.MultiWriter(writer, counter)); err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+		if outputPath != "" {
+			ui.Infof("\nSaved still encrypted; open it with: pastectl decrypt %s <passphrase or link>\n", outputPath)
+		}
+		return h.confirm(fileID, status, outputPath, "", counter.n, started)
+	}
+
+	// Download and decrypt with streaming, hashing the plaintext on the way
+	out, err := decompressor(io.MultiWriter(writer, hasher, counter), metadata)
+	if err != nil {
+		return err
+	}
+	if err := h.downloadAndDecryptStreaming(fileID, token, key, chunkMB<<20, opts, out, st); err != nil {
+		out.Close()
+		if errors.Is(err, errStaleState) {
+			st.remove()
+		} else if st != nil && st.Chunks > 0 {
+			return fmt.Errorf("download failed: %w\nRun the same command again to resume", err)
+		}
+		return fmt.Errorf("download failed: %w", err)
+	}
+	if err := out.Close(); err != nil {
 		return fmt.Errorf("download failed: %w", err)
 	}
+	if st != nil {
+		st.remove()
+	}
+
+	// The file stays on the server when the check fails, so the download
+	// is not used up.
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if metadata.SHA256 != "" && sum != metadata.SHA256 {
+		return client.Mark(fmt.Errorf("checksum mismatch: the sender's file has SHA-256 %s, but %s was received", metadata.SHA256, sum), client.ErrDecryption)
+	}
 
 	if outputPath != "" {
-		fmt.Fprintf(os.Stderr, "\n")
+		ui.Infof("\n")
+		if h.keepAttrs {
+			applyAttributes(outputPath, metadata)
+		}
+	}
+	if metadata.SHA256 != "" {
+		ui.Infof("SHA-256: %s (verified)\n", sum)
+	} else {
+		ui.Infof("SHA-256: %s\n", sum)
+	}
+
+	return h.confirm(fileID, status, outputPath, sum, counter.n, started)
+}
+
+// decompressor returns w, or for a file compressed before encryption, a
+// writer that decompresses into w. Close reports whether the data was
+// complete.
+func decompressor(w io.Writer, metadata *types.Metadata) (io.WriteCloser, error) {
+	if metadata.Compression == "" {
+		return nopCloser{w}, nil
+	}
+	ui.Debugf("decompressing %s", metadata.Compression)
+	dw, err := compress.Writer(w, metadata.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("%w; a newer pastectl may be needed", err)
+	}
+	return dw, nil
+}
+
+// streamOptions returns the options the file's chunks were sealed with.
+func streamOptions(metadata *types.Metadata) ([]crypto.StreamOption, error) {
+	opts, err := crypto.ChunkKeyOptions(metadata.ChunkKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w; a newer pastectl may be needed", err)
 	}
+	return opts, nil
+}
+
+type nopCloser struct{ io.Writer }
 
-	if err := h.client.DeleteFile(fileID, token); err != nil {
+func (nopCloser) Close() error { return nil }
+
+// defaultOutput is where a file goes when no output path is given: its
+// original name when stdout is a terminal or saving to a file was asked
+// for, otherwise stdout ("").
+func (h *Handler) defaultOutput(metadata *types.Metadata) string {
+	stat, _ := os.Stdout.Stat()
+	if (stat.Mode()&os.ModeCharDevice) == 0 && !h.saveToFile {
+		return ""
+	}
+	name := SafeFilename(metadata.Filename)
+	if h.outputDir != "" {
+		return filepath.Join(h.outputDir, name)
+	}
+	return name
+}
+
+// SafeFilename reduces a sender-chosen filename to a plain name in the
+// current directory: directories (with either slash), control characters
+// and names like ".." are dropped, so a crafted upload can't write
+// elsewhere. What is left over, if anything, is the last path element.
+func SafeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Trim(name, ".") == "" {
+		return "download"
+	}
+	return name
+}
+
+// createOutput creates the output file and announces it with verb
+// ("Receiving file") and the size from metadata. An existing file is
+// handled as the overwrite policy says; the path actually written is
+// returned, as renaming may change it.
+func (h *Handler) createOutput(outputPath string, metadata *types.Metadata, verb string) (*os.File, string, error) {
+	file, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if errors.Is(err, fs.ErrExist) {
+		switch h.overwrite {
+		case OverwriteForce:
+			file, err = os.Create(outputPath)
+		case OverwriteRename:
+			file, outputPath, err = createNumbered(outputPath)
+		default:
+			if !askOverwrite(outputPath) {
+				return nil, "", fmt.Errorf("%s already exists; use --force to overwrite it or --auto-rename to keep both", outputPath)
+			}
+			file, err = os.Create(outputPath)
+		}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	// Show the message with the file size
+	fileSizeMB := float64(metadata.Size) / (1024 * 1024)
+	if metadata.Size == 0 {
+		ui.Infof("%s into: %s\n", verb, outputPath)
+	} else if fileSizeMB >= 0.1 {
+		ui.Infof("%s (%.1f MB) into: %s\n", verb, fileSizeMB, outputPath)
+	} else {
+		fileSizeKB := float64(metadata.Size) / 1024
+		ui.Infof("%s (%.1f KB) into: %s\n", verb, fileSizeKB, outputPath)
+	}
+	return file, outputPath, nil
+}
+
+// askOverwrite asks on the terminal whether to replace path. Without a
+// terminal to ask on, the answer is no.
+func askOverwrite(path string) bool {
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "File '%s' already exists. Overwrite? [y/N]: ", path)
+	var response string
+	fmt.Scanln(&response)
+	return response == "y" || response == "Y" || response == "yes"
+}
+
+// createNumbered creates the first free "name-N.ext" next to path.
+func createNumbered(path string) (*os.File, string, error) {
+	ext := filepath.Ext(path)
+	if base := strings.TrimSuffix(path, ext); filepath.Ext(base) == ".tar" {
+		ext = ".tar" + ext
+	}
+	base := strings.TrimSuffix(path, ext)
+	for n := 1; n < 10000; n++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, n, ext)
+		file, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return file, candidate, err
+	}
+	return nil, "", fmt.Errorf("no free name for %s", path)
+}
+
+// confirm tells the server the download completed, which counts it against
+// the file's download limit, and records the result.
+func (h *Handler) confirm(fileID string, status *types.FileStatus, outputPath, sum string, size int64, started time.Time) error {
+	ui.Debugf("confirming download with server")
+	var remaining int
+	var err error
+	if h.stream != nil {
+		remaining, err = h.stream.finish()
+	} else {
+		remaining, err = h.client.DeleteFile(fileID, status.Token)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to delete file after download: %w", err)
 	}
+	ui.Debugf("done in %s, %d download(s) remaining", time.Since(started).Round(time.Millisecond), remaining)
+
+	// The expiry only matters to whoever downloads it next.
+	var expiresAt time.Time
+	if remaining > 0 {
+		expiresAt = status.ExpiresAt
+		if expiresAt.IsZero() {
+			ui.Infof("File kept on server: %d download(s) remaining\n", remaining)
+		} else {
+			ui.Infof("File kept on server: %d download(s) remaining until %s\n", remaining, expiresAt.Local().Format("2006-01-02 15:04 MST"))
+		}
+	}
+
+	h.result = Result{
+		FileID:             fileID,
+		Metadata:           status.Metadata,
+		OutputPath:         outputPath,
+		Size:               size,
+		SHA256:             sum,
+		Duration:           time.Since(started),
+		DownloadsRemaining: remaining,
+		ExpiresAt:          expiresAt,
+	}
+	return nil
+}
+
+// downloadRaw copies the stored blob to writer without decrypting it.
+func (h *Handler) downloadRaw(fileID, token string, writer io.Writer) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/download/%s", h.client.BaseURL(), fileID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-HMAC-Token", token)
 
+	ui.Debugf("GET %s (raw)", req.URL)
+	resp, err := client.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return client.StatusError(resp.StatusCode)
+	}
+
+	bar := ui.NewProgressBar(resp.ContentLength, "Downloading")
+	progress := &progressWriter{bar: bar}
+	if _, err := io.Copy(io.MultiWriter(writer, progress), ratelimit.Reader(resp.Body, h.limiter)); err != nil {
+		return err
+	}
+	bar.Finish()
 	return nil
 }
 
+type progressWriter struct {
+	bar *ui.ProgressBar
+	n   int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.bar.Update(w.n)
+	return len(p), nil
+}
+
+// applyAttributes restores the mode and mtime recorded by the sender. Only
+// permission bits are ever applied, and failures are reported but not fatal:
+// the data itself was received intact.
+func applyAttributes(path string, metadata *types.Metadata) {
+	if metadata.Mode != 0 {
+		if err := os.Chmod(path, os.FileMode(metadata.Mode).Perm()); err != nil {
+			ui.Infof("Warning: could not set file mode: %v\n", err)
+		}
+	}
+	if metadata.ModTime != 0 {
+		mtime := time.Unix(metadata.ModTime, 0)
+		if err := os.Chtimes(path, time.Time{}, mtime); err != nil {
+			ui.Infof("Warning: could not set modification time: %v\n", err)
+		}
+	}
+	ui.Debugf("applied attributes mode=%o mtime=%d", metadata.Mode, metadata.ModTime)
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
 // DownloadWithPassphrase downloads a file using a passphrase
 func (h *Handler) DownloadWithPassphrase(passphrase string, outputPath string) error {
 	// Validate passphrase
 	if err := crypto.ValidatePassphrase(passphrase); err != nil {
-		return fmt.Errorf("invalid passphrase: %w", err)
+		return client.Mark(fmt.Errorf("invalid passphrase: %w", err), ErrInvalidLink)
 	}
 
 	// Derive fileID and key from passphrase
@@ -158,66 +563,170 @@ func IsPassphrase(input string) bool {
 	return true
 }
 
-func (h *Handler) downloadAndDecryptStreaming(fileID string, token string, key []byte, writer io.Writer) error {
-	// Get base URL from client
+// IsLink reports whether input is a share link with the key in its
+// fragment, as URL-mode uploads print.
+func IsLink(input string) bool {
+	if !strings.Contains(input, "://") {
+		return false
+	}
+	_, _, _, err := ParseLink(input)
+	return err == nil
+}
+
+// downloadAndDecryptStreaming fetches the blob and writes the plaintext to
+// writer. With a state that already has chunks, only the rest of the blob
+// is requested and decryption continues at the next chunk index; the state
+// is updated as chunks are written. chunkSize is the plaintext chunk size
+// in bytes the file was sealed with, and opts the stream options.
+func (h *Handler) downloadAndDecryptStreaming(fileID string, token string, key []byte, chunkSize int, opts []crypto.StreamOption, writer io.Writer, st *state) error {
+	sealedChunk := int64(chunkSize + crypto.GCMTagSize)
+
+	resuming := st != nil && st.IV != nil
+	var offset int64
+	if resuming {
+		offset = st.DataStart + st.Chunks*sealedChunk
+	}
+
+	// A fresh download goes over the WebSocket endpoint, which paces the
+	// server with acks; servers or proxies without it get plain HTTP. A
+	// resumed one needs a byte range, which only HTTP has.
 	baseURL := h.client.BaseURL()
+	if !resuming {
+		stream, err := openWS(baseURL, fileID, token)
+		switch {
+		case err == nil:
+			h.stream = stream
+			body := ratelimit.Reader(stream, h.limiter)
+			iv, dataStart, err := readHeader(body)
+			if err != nil {
+				return err
+			}
+			if st != nil {
+				st.IV, st.DataStart = iv, dataStart
+			}
+			return decryptChunks(body, key, iv, chunkSize, opts, writer, st, false, dataStart, stream.size)
+		case errors.Is(err, client.ErrRejected), errors.Is(err, client.ErrNotAvailable):
+			return err
+		default:
+			ui.Debugf("WebSocket download unavailable, using HTTP: %v", err)
+		}
+	}
+
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/download/%s", baseURL, fileID), nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("X-HMAC-Token", token)
+	if resuming {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	ui.Debugf("GET %s (offset %d)", req.URL, offset)
+	resp, err := client.HTTPClient().Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	body := ratelimit.Reader(resp.Body, h.limiter)
+	ui.Debugf("<- %s, content-length %d", resp.Status, resp.ContentLength)
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	// Total blob size, for the progress bar
+	contentLength := resp.ContentLength
+	var iv []byte
+
+	switch {
+	case resuming && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Every chunk was written before the interruption; only the
+		// confirmation was lost.
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return nil
+		}
+		return errStaleState
+
+	case resuming && resp.StatusCode == http.StatusPartialContent:
+		var start, end, total int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil || start != offset {
+			return errStaleState
+		}
+		iv = st.IV
+		contentLength = total
+
+	case resp.StatusCode == http.StatusOK:
+		var dataStart int64
+		if iv, dataStart, err = readHeader(body); err != nil {
+			return err
+		}
+
+		if resuming {
+			// The server ignored the range; skip what we already have.
+			if !bytes.Equal(iv, st.IV) || dataStart != st.DataStart {
+				return errStaleState
+			}
+			if _, err := io.CopyN(io.Discard, body, offset-dataStart); err != nil {
+				return fmt.Errorf("failed to skip to offset %d: %w", offset, err)
+			}
+		} else if st != nil {
+			st.IV, st.DataStart = iv, dataStart
+		}
+
+	default:
+		return client.StatusError(resp.StatusCode)
 	}
+	return decryptChunks(body, key, iv, chunkSize, opts, writer, st, resuming, offset, contentLength)
+}
 
+// readHeader reads the blob's header up to the first chunk: the metadata,
+// which was already fetched separately and is skipped, and the IV. It
+// returns the IV and the offset the chunks start at.
+func readHeader(body io.Reader) ([]byte, int64, error) {
 	// Read metadata header (16 bytes)
 	metadataHeader := make([]byte, 16)
-	if _, err := io.ReadFull(resp.Body, metadataHeader); err != nil {
-		return fmt.Errorf("failed to read metadata header: %w", err)
+	if _, err := io.ReadFull(body, metadataHeader); err != nil {
+		return nil, 0, fmt.Errorf("failed to read metadata header: %w", err)
 	}
 
 	// Parse metadata length
 	metadataLen := binary.LittleEndian.Uint32(metadataHeader[12:16])
 
 	// Skip encrypted metadata (we already fetched it separately)
-	if _, err := io.CopyN(io.Discard, resp.Body, int64(metadataLen)); err != nil {
-		return fmt.Errorf("failed to skip metadata: %w", err)
+	if _, err := io.CopyN(io.Discard, body, int64(metadataLen)); err != nil {
+		return nil, 0, fmt.Errorf("failed to skip metadata: %w", err)
 	}
 
 	// Read IV
 	iv := make([]byte, crypto.IVSize)
-	if _, err := io.ReadFull(resp.Body, iv); err != nil {
-		return fmt.Errorf("failed to read IV: %w", err)
+	if _, err := io.ReadFull(body, iv); err != nil {
+		return nil, 0, fmt.Errorf("failed to read IV: %w", err)
 	}
+	return iv, int64(16+metadataLen) + crypto.IVSize, nil
+}
 
-	// Create stream decryptor
-	streamCipher, err := crypto.NewStreamDecryptor(key, iv)
+// decryptChunks decrypts the chunks read from body, which starts at offset
+// into a blob of contentLength bytes, and writes the plaintext to writer.
+func decryptChunks(body io.Reader, key, iv []byte, chunkSize int, opts []crypto.StreamOption, writer io.Writer, st *state, resuming bool, offset, contentLength int64) error {
+
+	// Create stream decryptor, positioned at the first chunk still missing
+	// so the nonces line up with how the chunks were sealed.
+	var next uint64
+	if resuming {
+		next = uint64(st.Chunks)
+	}
+	streamCipher, err := crypto.NewStreamCipherAt(key, iv, next, opts...)
 	if err != nil {
 		return err
 	}
 	defer streamCipher.Clear()
 
-	// Get content length for progress bar
-	contentLength := resp.ContentLength
-
-	// Use the chunk size from server config (in MB)
-	chunkSize := h.config.ChunkSize * 1024 * 1024
 	buffer := make([]byte, chunkSize+crypto.GCMTagSize)
 
 	// Create progress bar
 	var bar *ui.ProgressBar
 	if contentLength > 0 {
 		bar = ui.NewProgressBar(contentLength, "Downloading")
+		bar.Update(offset)
 	}
 
-	var totalRead int64
+	totalRead := offset
 
 	// One-chunk lookahead: a full ReadFull may still be the final chunk if the
 	// file size is an exact multiple of chunkSize. The v2 STREAM nonce binds
@@ -225,14 +734,26 @@ func (h *Handler) downloadAndDecryptStreaming(fileID string, token string, key [
 	var pending []byte
 	hasPending := false
 
+	chunks := 0
 	decryptAndWrite := func(data []byte, isFinal bool) error {
+		chunks++
+		ui.Debugf("chunk %d: %d bytes final=%t", chunks, len(data), isFinal)
 		decrypted, err := streamCipher.DecryptChunk(data, isFinal)
 		if err != nil {
-			return fmt.Errorf("decryption failed: %w", err)
+			if resuming && chunks == 1 {
+				return errStaleState
+			}
+			return client.Mark(fmt.Errorf("decryption failed: %w", err), client.ErrDecryption)
 		}
 		if _, err := writer.Write(decrypted); err != nil {
 			return err
 		}
+		if st != nil {
+			st.Chunks++
+			if err := st.save(); err != nil {
+				ui.Debugf("can't save download state: %v", err)
+			}
+		}
 		totalRead += int64(len(data))
 		if bar != nil {
 			bar.Update(totalRead)
@@ -241,7 +762,7 @@ func (h *Handler) downloadAndDecryptStreaming(fileID string, token string, key [
 	}
 
 	for {
-		n, err := io.ReadFull(resp.Body, buffer)
+		n, err := io.ReadFull(body, buffer)
 
 		if err == io.EOF {
 			if hasPending {
@@ -290,7 +811,7 @@ func (h *Handler) downloadAndDecryptStreaming(fileID string, token string, key [
 func ParseLink(link string) (fileID string, key []byte, serverURL string, error error) {
 	parsedURL, err := url.Parse(link)
 	if err != nil {
-		return "", nil, "", fmt.Errorf("invalid URL: %w", err)
+		return "", nil, "", client.Mark(fmt.Errorf("invalid URL: %w", err), ErrInvalidLink)
 	}
 
 	// Extract server URL
@@ -299,14 +820,14 @@ func ParseLink(link string) (fileID string, key []byte, serverURL string, error
 	// Extract file ID from path
 	pathParts := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
 	if len(pathParts) == 0 || pathParts[len(pathParts)-1] == "" {
-		return "", nil, "", errors.New("invalid link: missing file ID")
+		return "", nil, "", client.Mark(errors.New("invalid link: missing file ID"), ErrInvalidLink)
 	}
 	fileID = pathParts[len(pathParts)-1]
 
 	// Extract key from fragment
 	fragment := parsedURL.Fragment
 	if !strings.HasPrefix(fragment, "key=") {
-		return "", nil, "", errors.New("invalid link: missing encryption key")
+		return "", nil, "", client.Mark(errors.New("invalid link: missing encryption key"), ErrInvalidLink)
 	}
 	keyBase64 := strings.TrimPrefix(fragment, "key=")
 
@@ -317,7 +838,7 @@ func ParseLink(link string) (fileID string, key []byte, serverURL string, error
 
 	key, err = base64.URLEncoding.DecodeString(keyBase64)
 	if err != nil {
-		return "", nil, "", fmt.Errorf("invalid key: %w", err)
+		return "", nil, "", client.Mark(fmt.Errorf("invalid key: %w", err), ErrInvalidLink)
 	}
 
 	return fileID, key, serverURL, nil
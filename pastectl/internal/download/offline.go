@@ -0,0 +1,238 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/types"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// BlobSuffix names files saved with WithRaw.
+const BlobSuffix = ".paste"
+
+// Blobs uploaded before the metadata recorded the chunk size don't say what
+// it was, so Decrypt finds it by trying each size a server can be
+// configured with, starting from the default.
+const (
+	defaultChunkMB = 4
+	maxChunkMB     = 64
+)
+
+// ErrWrongKey is returned by Decrypt when the key does not open the blob.
+var ErrWrongKey = client.Mark(errors.New("the key or passphrase does not match this file"), client.ErrDecryption)
+
+// Decrypt opens a blob saved with WithRaw, without contacting the server,
+// and writes the plaintext to outputPath (or, when empty, the original
+// filename or stdout, as Download does).
+func (h *Handler) Decrypt(blobPath string, key []byte, outputPath string) error {
+	started := time.Now()
+
+	blob, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	st, err := blob.Stat()
+	if err != nil {
+		return err
+	}
+
+	metadata, dataStart, iv, err := readBlobHeader(blob, key)
+	if err != nil {
+		return err
+	}
+	ui.Debugf("metadata: %q, %d bytes, %s", metadata.Filename, metadata.Size, metadata.ContentType)
+	opts, err := streamOptions(metadata)
+	if err != nil {
+		return err
+	}
+	dataLen := st.Size() - dataStart
+	if dataLen < crypto.GCMTagSize {
+		return client.Mark(errors.New("the file is truncated"), client.ErrDecryption)
+	}
+
+	sealedChunk := int64(metadata.ChunkSize)<<20 + crypto.GCMTagSize
+	if metadata.ChunkSize == 0 {
+		if sealedChunk, err = detectChunkSize(blob, key, iv, dataStart, dataLen); err != nil {
+			return err
+		}
+	}
+	ui.Debugf("chunk size %d MB", (sealedChunk-crypto.GCMTagSize)>>20)
+
+	if outputPath == "" {
+		outputPath = h.defaultOutput(metadata)
+	}
+	var writer io.Writer = os.Stdout
+	if outputPath != "" {
+		file, path, err := h.createOutput(outputPath, metadata, "Decrypting file")
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer, outputPath = file, path
+	}
+
+	streamCipher, err := crypto.NewStreamDecryptor(key, iv, opts...)
+	if err != nil {
+		return err
+	}
+	defer streamCipher.Clear()
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	out, err := decompressor(io.MultiWriter(writer, hasher, counter), metadata)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bar := ui.NewProgressBar(dataLen, "Decrypting")
+	buffer := make([]byte, min(sealedChunk, dataLen))
+	for offset := int64(0); offset < dataLen; offset += sealedChunk {
+		n := min(sealedChunk, dataLen-offset)
+		if _, err := blob.ReadAt(buffer[:n], dataStart+offset); err != nil {
+			return fmt.Errorf("failed to read chunk: %w", err)
+		}
+		decrypted, err := streamCipher.DecryptChunk(buffer[:n], offset+n == dataLen)
+		if err != nil {
+			return client.Mark(fmt.Errorf("decryption failed: %w", err), client.ErrDecryption)
+		}
+		if _, err := out.Write(decrypted); err != nil {
+			return err
+		}
+		bar.Update(offset + n)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	bar.Finish()
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if metadata.SHA256 != "" && sum != metadata.SHA256 {
+		return client.Mark(fmt.Errorf("checksum mismatch: the sender's file has SHA-256 %s, but %s was decrypted", metadata.SHA256, sum), client.ErrDecryption)
+	}
+	if outputPath != "" {
+		ui.Infof("\n")
+		if h.keepAttrs {
+			applyAttributes(outputPath, metadata)
+		}
+	}
+	if metadata.SHA256 != "" {
+		ui.Infof("SHA-256: %s (verified)\n", sum)
+	} else {
+		ui.Infof("SHA-256: %s\n", sum)
+	}
+
+	h.result = Result{
+		Metadata:   metadata,
+		OutputPath: outputPath,
+		Size:       counter.n,
+		SHA256:     sum,
+		Duration:   time.Since(started),
+	}
+	return nil
+}
+
+// DecryptWithPassphrase opens a blob saved with WithRaw using the share
+// passphrase. The key size the sender's server used is not known offline,
+// so each one is tried.
+func (h *Handler) DecryptWithPassphrase(blobPath, passphrase, outputPath string) error {
+	if err := crypto.ValidatePassphrase(passphrase); err != nil {
+		return client.Mark(fmt.Errorf("invalid passphrase: %w", err), ErrInvalidLink)
+	}
+
+	blob, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	for _, keyBytes := range []int{32, 16, 24} {
+		_, key, err := crypto.DeriveFromPassphrase(passphrase, keyBytes)
+		if err != nil {
+			return fmt.Errorf("failed to derive key from passphrase: %w", err)
+		}
+		if _, _, _, err := readBlobHeader(blob, key); errors.Is(err, ErrWrongKey) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		return h.Decrypt(blobPath, key, outputPath)
+	}
+	return ErrWrongKey
+}
+
+// readBlobHeader decrypts the metadata at the start of a blob and returns it
+// with the stream IV and the offset of the first chunk.
+func readBlobHeader(blob io.ReaderAt, key []byte) (*types.Metadata, int64, []byte, error) {
+	header := make([]byte, 16)
+	if _, err := blob.ReadAt(header, 0); err != nil {
+		return nil, 0, nil, fmt.Errorf("not a paste file: %w", err)
+	}
+	metadataLen := binary.LittleEndian.Uint32(header[12:16])
+	if metadataLen > 1<<20 {
+		return nil, 0, nil, errors.New("not a paste file: metadata header is too large")
+	}
+
+	data := make([]byte, 16+int(metadataLen)+crypto.IVSize)
+	if _, err := blob.ReadAt(data, 0); err != nil {
+		return nil, 0, nil, fmt.Errorf("not a paste file: %w", err)
+	}
+	decrypted, err := crypto.DecryptMetadata(key, data)
+	if err != nil {
+		return nil, 0, nil, ErrWrongKey
+	}
+	var metadata types.Metadata
+	if _, err := crypto.UnmarshalMetadata(decrypted, &metadata); err != nil {
+		return nil, 0, nil, err
+	}
+
+	dataStart := int64(len(data))
+	return &metadata, dataStart, data[dataStart-crypto.IVSize:], nil
+}
+
+// detectChunkSize returns the sealed chunk size (plaintext chunk plus tag)
+// that the first chunk of the stream authenticates with.
+func detectChunkSize(blob io.ReaderAt, key, iv []byte, dataStart, dataLen int64) (int64, error) {
+	first := make([]byte, min(dataLen, maxChunkMB<<20+crypto.GCMTagSize))
+	if _, err := blob.ReadAt(first, dataStart); err != nil {
+		return 0, fmt.Errorf("failed to read chunk: %w", err)
+	}
+
+	candidates := []int64{defaultChunkMB}
+	for mb := int64(1); mb <= maxChunkMB; mb++ {
+		if mb != defaultChunkMB {
+			candidates = append(candidates, mb)
+		}
+	}
+	triedWhole := false
+	for _, mb := range candidates {
+		sealed := mb<<20 + crypto.GCMTagSize
+		n := min(sealed, dataLen)
+		if n == dataLen {
+			// Every size this large reads the stream as a single chunk.
+			if triedWhole {
+				continue
+			}
+			triedWhole = true
+		}
+		sc, err := crypto.NewStreamDecryptor(key, iv)
+		if err != nil {
+			return 0, err
+		}
+		_, err = sc.DecryptChunk(first[:n], n == dataLen)
+		sc.Clear()
+		if err == nil {
+			return sealed, nil
+		}
+	}
+	return 0, client.Mark(errors.New("decryption failed: the file is damaged or was not saved with download --raw"), client.ErrDecryption)
+}
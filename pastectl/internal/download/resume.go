@@ -0,0 +1,116 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jonasbg/paste/pastectl/internal/config"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// state tracks a download into a file so it can continue after the
+// connection drops. It holds no key material: resuming needs the same link
+// or passphrase, which supplies the key again.
+type state struct {
+	FileID    string    `json:"file_id"`
+	Output    string    `json:"output"` // absolute path of the file being written
+	IV        []byte    `json:"iv"`
+	DataStart int64     `json:"data_start"` // offset of the first chunk in the stored blob
+	ChunkSize int       `json:"chunk_size"` // MB
+	Chunks    int64     `json:"chunks"`     // chunks decrypted and written to Output
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func statePath(fileID string) (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "downloads", fileID+".json"), nil
+}
+
+// loadState returns the saved state of an interrupted download of fileID
+// into output, or nil if there is none.
+func loadState(fileID, output string) *state {
+	path, err := statePath(fileID)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil || s.Output != output {
+		return nil
+	}
+	return &s
+}
+
+func (s *state) save() error {
+	path, err := statePath(s.FileID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	s.UpdatedAt = time.Now()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *state) remove() {
+	if path, err := statePath(s.FileID); err == nil {
+		os.Remove(path)
+	}
+}
+
+// resumeOutput reopens the output of an interrupted download. It is cut
+// back to the last whole chunk the state accounts for, and that prefix is
+// hashed so the final checksum still covers the entire file.
+func resumeOutput(s *state) (*os.File, hash.Hash, int64, error) {
+	file, err := os.OpenFile(s.Output, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, 0, err
+	}
+
+	chunkBytes := int64(s.ChunkSize) * 1024 * 1024
+	s.Chunks = min(s.Chunks, info.Size()/chunkBytes)
+	done := s.Chunks * chunkBytes
+	if err := file.Truncate(done); err != nil {
+		file.Close()
+		return nil, nil, 0, err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.CopyN(hasher, file, done); err != nil {
+		file.Close()
+		return nil, nil, 0, fmt.Errorf("failed to read partial download: %w", err)
+	}
+	ui.Debugf("resuming %s at chunk %d (%d bytes)", s.Output, s.Chunks, done)
+	return file, hasher, done, nil
+}
+
+// errStaleState means a resume point no longer matches what the server
+// holds, so decryption would not line up.
+var errStaleState = errors.New("partial download does not match the file on the server; run the command again to start over")
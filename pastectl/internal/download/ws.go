@@ -0,0 +1,174 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// ackInterval matches the server's batchAckInterval: it waits for an ack
+// after this many chunks, and once more after the last one.
+const ackInterval = 8
+
+// consumedWait bounds the wait for the server to report the remaining
+// downloads. Servers that predate the message never send it.
+const consumedWait = 5 * time.Second
+
+// wsStream reads a stored blob over /api/ws/download. It is an io.Reader
+// over the blob, the same bytes GET /api/download returns, acking chunks
+// as they arrive. The server counts the download once finish is called,
+// so the caller does that only after the data checked out.
+type wsStream struct {
+	conn     *websocket.Conn
+	size     int64
+	received int64
+	chunks   int
+	buf      []byte
+	done     bool
+}
+
+// openWS connects and asks for fileID. Errors before the server has
+// accepted the request are returned unmarked, so the caller can fall back
+// to HTTP; a refusal by the server is marked.
+func openWS(baseURL, fileID, token string) (*wsStream, error) {
+	wsURL := strings.Replace(baseURL, "https://", "wss://", 1)
+	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
+	wsURL += "/api/ws/download"
+
+	ui.Debugf("connecting to %s", wsURL)
+	conn, err := client.DialWebSocket(wsURL)
+	if err != nil {
+		return nil, err
+	}
+	s := &wsStream{conn: conn}
+
+	if err := conn.WriteJSON(map[string]any{"type": "download_init", "fileId": fileID, "token": token, "protocolVersion": client.ProtocolVersion}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var info struct {
+		Type            string `json:"type"`
+		Size            int64  `json:"size"`
+		Error           string `json:"error"`
+		ProtocolVersion int    `json:"protocolVersion"`
+	}
+	if err := conn.ReadJSON(&info); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	switch info.Type {
+	case "file_info":
+	case "error":
+		conn.Close()
+		return nil, wsError(info.Error)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unexpected %q message", info.Type)
+	}
+	ui.Debugf("<- file_info size=%d protocol=%d", info.Size, info.ProtocolVersion)
+	if err := client.CheckProtocol(info.ProtocolVersion); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.size = info.Size
+
+	if err := conn.WriteJSON(map[string]any{"type": "ready", "ready": true}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func wsError(message string) error {
+	if message == "Access denied" {
+		return client.ErrNotAvailable
+	}
+	return client.Mark(fmt.Errorf("server rejected download: %s", message), client.ErrRejected)
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// next receives one message: a chunk of the blob, or the closing
+// "complete", which ends the stream.
+func (s *wsStream) next() error {
+	kind, msg, err := s.conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if kind == websocket.TextMessage {
+		var m struct {
+			Type  string `json:"type"`
+			Size  int64  `json:"size"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(msg, &m); err != nil {
+			return fmt.Errorf("invalid message from server: %w", err)
+		}
+		switch m.Type {
+		case "complete":
+			ui.Debugf("<- complete size=%d", m.Size)
+			if m.Size != s.received {
+				return fmt.Errorf("server sent %d bytes but reported %d", s.received, m.Size)
+			}
+			s.done = true
+			return nil
+		case "error":
+			return wsError(m.Error)
+		default:
+			return fmt.Errorf("unexpected %q message", m.Type)
+		}
+	}
+
+	s.received += int64(len(msg))
+	s.chunks++
+	s.buf = msg
+	if s.chunks%ackInterval == 0 || s.received >= s.size {
+		return s.conn.WriteJSON(map[string]any{"type": "ack", "size": len(msg)})
+	}
+	return nil
+}
+
+// finish confirms the whole blob arrived, which counts the download
+// against its limit, and returns how many downloads remain. Older servers
+// don't report that, and it comes back as zero.
+func (s *wsStream) finish() (int, error) {
+	if !s.done {
+		return 0, errors.New("download not complete")
+	}
+	if err := s.conn.WriteJSON(map[string]any{"type": "complete_ack", "complete": true}); err != nil {
+		return 0, err
+	}
+	s.conn.SetReadDeadline(time.Now().Add(consumedWait))
+	var consumed struct {
+		Type      string `json:"type"`
+		Remaining int    `json:"remaining"`
+	}
+	if err := s.conn.ReadJSON(&consumed); err != nil || consumed.Type != "consumed" {
+		ui.Debugf("no download count from server: %v", err)
+		return 0, nil
+	}
+	return consumed.Remaining, nil
+}
+
+func (s *wsStream) Close() error {
+	return s.conn.Close()
+}
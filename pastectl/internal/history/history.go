@@ -0,0 +1,206 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jonasbg/paste/pastectl/internal/config"
+)
+
+// Entry is one successful upload. Link is either a share URL or a
+// passphrase, so the history file is as sensitive as the links themselves
+// and is written with owner-only permissions, optionally sealed (see
+// seal.go).
+type Entry struct {
+	Link       string    `json:"link"`
+	Passphrase bool      `json:"passphrase,omitempty"`
+	ServerURL  string    `json:"server_url"`
+	Filename   string    `json:"filename"`
+	Size       int64     `json:"size"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	// OwnerToken lets `pastectl stats` ask how often the file was
+	// downloaded.
+	OwnerToken string `json:"owner_token,omitempty"`
+}
+
+// Expired reports whether the server-side expiry (if known) has passed.
+func (e Entry) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// DownloadCommand returns the command a recipient runs to fetch the file.
+func (e Entry) DownloadCommand() string {
+	if e.Passphrase {
+		return "pastectl download " + e.Link
+	}
+	return fmt.Sprintf("pastectl download -l %q", e.Link)
+}
+
+// Path returns the history file location: $XDG_STATE_HOME/paste/history.jsonl,
+// falling back to ~/.local/state.
+func Path() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// Append records an entry at the end of the history file. With a key the
+// entry is sealed, and any plaintext entries already in the file are sealed
+// along with it, so turning encryption on protects the existing history too.
+func Append(e Entry, key []byte) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	line, err := encodeLine(e, key)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		lines, err := readLines(path)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(lines, isPlaintext) {
+			return rewrite(path, append(lines, line), key)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Load returns all entries, newest first. Unparseable lines are skipped so
+// a partially written record never hides the rest of the history. Sealed
+// entries need the key they were sealed with: without one Load returns
+// ErrEncrypted, and if none of them open, ErrWrongKey.
+func Load(key []byte) ([]Entry, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	sealed, opened := 0, 0
+	for _, line := range lines {
+		if !isPlaintext(line) {
+			if key == nil {
+				return nil, ErrEncrypted
+			}
+			sealed++
+			var err error
+			if line, err = open(line, key); err != nil {
+				continue
+			}
+			opened++
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	// One bad line is a partial write; none opening is the wrong key.
+	if sealed > 0 && opened == 0 {
+		return nil, ErrWrongKey
+	}
+
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// readLines returns the history file's non-empty lines; a missing file has
+// none.
+func readLines(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) > 0 {
+			lines = append(lines, bytes.Clone(sc.Bytes()))
+		}
+	}
+	return lines, sc.Err()
+}
+
+// rewrite replaces the file with lines, sealing the plaintext ones, in one
+// rename so a crash never loses the history.
+func rewrite(path string, lines [][]byte, key []byte) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if isPlaintext(line) {
+			var err error
+			if line, err = seal(line, key); err != nil {
+				return err
+			}
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func encodeLine(e Entry, key []byte) ([]byte, error) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return line, nil
+	}
+	return seal(line, key)
+}
+
+// Matches reports whether e's filename contains query (case-insensitive).
+// Expired entries only match when includeExpired is set.
+func (e Entry) Matches(query string, includeExpired bool, now time.Time) bool {
+	if !includeExpired && e.Expired(now) {
+		return false
+	}
+	return strings.Contains(strings.ToLower(e.Filename), strings.ToLower(query))
+}
@@ -0,0 +1,79 @@
+package history
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the length of a history key.
+const KeySize = 32
+
+// sealedPrefix marks a sealed line. Plaintext lines are JSON objects, so the
+// two can share a file while encryption is being turned on.
+const sealedPrefix = "sealed:"
+
+var (
+	// ErrEncrypted is returned when the history holds sealed entries but no
+	// key was given.
+	ErrEncrypted = errors.New("the history is encrypted")
+	// ErrWrongKey is returned when no sealed entry opens with the key.
+	ErrWrongKey = errors.New("the history key doesn't match the sealed entries")
+)
+
+// NewKey returns a random history key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func isPlaintext(line []byte) bool {
+	return !bytes.HasPrefix(line, []byte(sealedPrefix))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("history key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts one JSON line with AES-256-GCM into
+// "sealed:" + base64([IV 12][ciphertext]), keeping the file line-oriented
+// so entries can still be appended without reading it.
+func seal(line, key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	raw := aead.Seal(nonce, nonce, line, nil)
+	return append([]byte(sealedPrefix), base64.StdEncoding.EncodeToString(raw)...), nil
+}
+
+// open reverses seal.
+func open(line, key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(line[len(sealedPrefix):]))
+	if err != nil || len(raw) < aead.NonceSize() {
+		return nil, errors.New("truncated sealed entry")
+	}
+	return aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+}
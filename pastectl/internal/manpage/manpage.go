@@ -0,0 +1,415 @@
+// Package manpage renders pastectl's help text as roff man pages, so the
+// pages can't drift from `pastectl help`.
+package manpage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page is one man page in section 1.
+type Page struct {
+	Name    string // e.g. "pastectl-upload"
+	Content string
+}
+
+// Filename is the page's name on disk, e.g. "pastectl-upload.1".
+func (p Page) Filename() string {
+	return p.Name + ".1"
+}
+
+// usageLine is one line of the help text's Usage block.
+type usageLine struct {
+	command  string // "" for forms without a subcommand, e.g. `pastectl [flags]`
+	synopsis string
+	summary  string
+}
+
+// section is a block of the help text under an unindented heading.
+type section struct {
+	title string   // heading without the colon, e.g. "Upload Flags"
+	note  string   // parenthesised part of the heading, if any
+	lines []string // body lines, without the leading tab
+}
+
+// termLine splits a definition line into its term and description: the
+// term ends at the first run of two or more spaces.
+var termLine = regexp.MustCompile(`^(\S.*?)(?: {2,}(.*))?$`)
+
+// commandName matches a subcommand, as opposed to a placeholder such as
+// "[flags]" or "<url>" after the program name.
+var commandName = regexp.MustCompile(`^[a-z]+$`)
+
+// configLine matches a config file example, key = value.
+var configLine = regexp.MustCompile(`^[a-z_]+ = `)
+
+// Generate turns the help text into a page for pastectl itself and one per
+// command. The help text's layout is relied upon: a first line of the form
+// "pastectl vX - summary", a "Usage:" block, and "<Command> Flags:"
+// sections whose parenthesised note may list the commands they apply to.
+func Generate(help, version string) ([]Page, error) {
+	head, body, _ := strings.Cut(help, "\n")
+	_, summary, ok := strings.Cut(head, " - ")
+	if !ok {
+		return nil, fmt.Errorf("unexpected help header %q", head)
+	}
+	sections := parseSections(body)
+	var usage []usageLine
+	var rest []section
+	for _, s := range sections {
+		if s.title == "Usage" {
+			usage = parseUsage(s.lines)
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	if len(usage) == 0 {
+		return nil, fmt.Errorf("no Usage section in the help text")
+	}
+
+	var commands []string
+	summaries := map[string]string{}
+	for _, u := range usage {
+		if u.command == "" {
+			continue
+		}
+		if _, seen := summaries[u.command]; !seen {
+			commands = append(commands, u.command)
+			summaries[u.command] = u.summary
+		}
+	}
+	date := buildDate()
+
+	var pages []Page
+	pages = append(pages, Page{Name: "pastectl", Content: mainPage(summary, version, date, usage, rest, commands)})
+	for _, cmd := range commands {
+		pages = append(pages, Page{
+			Name:    "pastectl-" + cmd,
+			Content: commandPage(cmd, summaries[cmd], version, date, usage, rest),
+		})
+	}
+	return pages, nil
+}
+
+// Write generates the pages into dir, creating it if needed, and returns
+// the paths written.
+func Write(dir, help, version string) ([]string, error) {
+	pages, err := Generate(help, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, p := range pages {
+		path := filepath.Join(dir, p.Filename())
+		if err := os.WriteFile(path, []byte(p.Content), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func parseSections(body string) []section {
+	var sections []section
+	for _, line := range strings.Split(body, "\n") {
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(sections) > 0 {
+				sections[len(sections)-1].lines = append(sections[len(sections)-1].lines, "")
+			}
+		case !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":"):
+			title := strings.TrimSuffix(line, ":")
+			s := section{title: title}
+			if i := strings.Index(title, " ("); i > 0 && strings.HasSuffix(title, ")") {
+				s.title, s.note = title[:i], title[i+2:len(title)-1]
+			}
+			sections = append(sections, s)
+		case !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " "):
+			// A closing remark outside any section
+			sections = append(sections, section{title: "Notes", lines: []string{line}})
+		case len(sections) > 0:
+			sections[len(sections)-1].lines = append(sections[len(sections)-1].lines, strings.TrimPrefix(line, "\t"))
+		}
+	}
+	for i, s := range sections {
+		for len(s.lines) > 0 && s.lines[len(s.lines)-1] == "" {
+			s.lines = s.lines[:len(s.lines)-1]
+		}
+		sections[i] = s
+	}
+	return sections
+}
+
+func parseUsage(lines []string) []usageLine {
+	var usage []usageLine
+	for _, line := range lines {
+		m := termLine.FindStringSubmatch(line)
+		if m == nil || !strings.HasPrefix(m[1], "pastectl") {
+			continue
+		}
+		u := usageLine{synopsis: m[1], summary: m[2]}
+		if fields := strings.Fields(m[1]); len(fields) > 1 && commandName.MatchString(fields[1]) {
+			u.command = fields[1]
+		}
+		usage = append(usage, u)
+	}
+	return usage
+}
+
+// isDefinitions reports whether a section is a list of terms, rendered as
+// tagged paragraphs.
+func (s section) isDefinitions() bool {
+	return strings.HasSuffix(s.title, " Flags") || s.title == "Environment Variables" || s.title == "Exit Status"
+}
+
+// appliesTo reports whether a flags section documents cmd: "<Cmd> Flags",
+// or a note listing commands, e.g. "Network Flags (upload, download)".
+func (s section) appliesTo(cmd string, known []string) bool {
+	if !strings.HasSuffix(s.title, " Flags") {
+		return false
+	}
+	if listed := s.commandList(known); listed != nil {
+		return slices.Contains(listed, cmd)
+	}
+	return strings.EqualFold(strings.TrimSuffix(s.title, " Flags"), cmd)
+}
+
+// commandList returns the commands named in the note, or nil if the note
+// is something else.
+func (s section) commandList(known []string) []string {
+	if s.note == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(s.note, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(known, name) {
+			return nil
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func mainPage(summary, version, date string, usage []usageLine, sections []section, commands []string) string {
+	var b strings.Builder
+	header(&b, "PASTECTL", version, date)
+	fmt.Fprintf(&b, ".SH NAME\npastectl \\- %s\n", escape(summary))
+	b.WriteString(".SH SYNOPSIS\n")
+	for _, u := range usage {
+		fmt.Fprintf(&b, ".B %s\n.br\n", escape(u.synopsis))
+	}
+	b.WriteString(".SH COMMANDS\n")
+	for _, u := range usage {
+		fmt.Fprintf(&b, ".TP\n.B %s\n%s\n", escape(u.synopsis), escape(u.summary))
+	}
+	for _, s := range sections {
+		if s.isDefinitions() {
+			fmt.Fprintf(&b, ".SH %s\n", escape(strings.ToUpper(s.title)))
+			if s.note != "" && s.commandList(commands) == nil {
+				fmt.Fprintf(&b, "%s\n", escape(sentence(s.note)))
+			} else if listed := s.commandList(commands); listed != nil {
+				fmt.Fprintf(&b, "Accepted by %s.\n", escape(strings.Join(listed, ", ")))
+			}
+			definitions(&b, s.lines)
+			continue
+		}
+		title := strings.ToUpper(s.title)
+		if s.note != "" {
+			title += " (" + s.note + ")"
+		}
+		fmt.Fprintf(&b, ".SH %s\n", escape(title))
+		text(&b, s.lines)
+	}
+	b.WriteString(".SH SEE ALSO\n")
+	for i, cmd := range commands {
+		sep := ","
+		if i == len(commands)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&b, ".BR pastectl\\-%s (1)%s\n", escape(cmd), sep)
+	}
+	return b.String()
+}
+
+func commandPage(cmd, summary, version, date string, usage []usageLine, sections []section) string {
+	var known []string
+	for _, u := range usage {
+		if u.command != "" {
+			known = append(known, u.command)
+		}
+	}
+	var b strings.Builder
+	header(&b, "PASTECTL-"+strings.ToUpper(cmd), version, date)
+	fmt.Fprintf(&b, ".SH NAME\npastectl\\-%s \\- %s\n", escape(cmd), escape(summary))
+	b.WriteString(".SH SYNOPSIS\n")
+	for _, u := range usage {
+		if u.command == cmd {
+			fmt.Fprintf(&b, ".B %s\n.br\n", escape(u.synopsis))
+		}
+	}
+	if len(usage) > 0 {
+		var descriptions []string
+		for _, u := range usage {
+			if u.command == cmd && !slices.Contains(descriptions, u.summary) {
+				descriptions = append(descriptions, u.summary)
+			}
+		}
+		fmt.Fprintf(&b, ".SH DESCRIPTION\n%s\n", escape(sentence(strings.Join(descriptions, "; "))))
+	}
+	wroteOptions := false
+	for _, s := range sections {
+		if !s.appliesTo(cmd, known) {
+			continue
+		}
+		if !wroteOptions {
+			b.WriteString(".SH OPTIONS\n")
+			wroteOptions = true
+		}
+		if !strings.EqualFold(strings.TrimSuffix(s.title, " Flags"), cmd) {
+			fmt.Fprintf(&b, ".SS %s\n", escape(s.title))
+		}
+		if s.note != "" && s.commandList(known) == nil {
+			fmt.Fprintf(&b, "%s\n", escape(sentence(s.note)))
+		}
+		definitions(&b, s.lines)
+	}
+	b.WriteString(".SH SEE ALSO\n.BR pastectl (1)\n")
+	return b.String()
+}
+
+func header(b *strings.Builder, title, version, date string) {
+	fmt.Fprintf(b, ".TH %s 1 %q %q \"User Commands\"\n", title, date, "pastectl "+version)
+}
+
+// definitions renders "term  description" lines, with descriptions that
+// continue on further indented lines, as tagged paragraphs. A term as wide
+// as the column leaves one space before its description, so lines are also
+// split at a space next to the column descriptions start in.
+func definitions(b *strings.Builder, lines []string) {
+	var term, desc string
+	column := 0
+	for _, line := range lines {
+		if m := termLine.FindStringSubmatch(line); m != nil && m[2] != "" && !strings.HasPrefix(line, " ") {
+			column = strings.LastIndex(line, m[2])
+			break
+		}
+	}
+	flush := func() {
+		if term != "" {
+			fmt.Fprintf(b, ".TP\n.B %s\n%s\n", escapeTerm(term), escape(desc))
+		}
+		term, desc = "", ""
+	}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, " ") {
+			desc = strings.TrimSpace(desc + " " + strings.TrimSpace(line))
+			continue
+		}
+		flush()
+		m := termLine.FindStringSubmatch(line)
+		term, desc = m[1], m[2]
+		if desc == "" && column > 0 && len(line) > column {
+			if i := strings.IndexByte(line[column-1:], ' '); i >= 0 && i <= 2 {
+				term, desc = line[:column-1+i], strings.TrimSpace(line[column-1+i:])
+			}
+		}
+	}
+	flush()
+}
+
+// text renders prose as filled paragraphs, "- " lines as bullets, and
+// command examples as unfilled blocks.
+func text(b *strings.Builder, lines []string) {
+	inExample := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		example := isExample(trimmed)
+		switch {
+		case trimmed == "":
+			if inExample {
+				b.WriteString(".fi\n.RE\n")
+				inExample = false
+			}
+			b.WriteString(".PP\n")
+			continue
+		case example && !inExample:
+			b.WriteString(".RS 4\n.nf\n")
+			inExample = true
+		case !example && inExample:
+			b.WriteString(".fi\n.RE\n")
+			inExample = false
+		}
+		switch {
+		case inExample:
+			fmt.Fprintf(b, "%s\n", escape(line))
+		case strings.HasPrefix(trimmed, "- "):
+			fmt.Fprintf(b, ".IP \\(bu 2\n%s\n", escape(trimmed[2:]))
+		default:
+			fmt.Fprintf(b, "%s\n", escape(trimmed))
+		}
+	}
+	if inExample {
+		b.WriteString(".fi\n.RE\n")
+	}
+}
+
+func isExample(line string) bool {
+	for _, prefix := range []string{"pastectl ", "echo ", "→ ", "$ "} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return configLine.MatchString(line)
+}
+
+// escape makes text safe for roff: backslashes are doubled and lines that
+// would start with a control character are protected.
+func escape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// escapeTerm also turns hyphens into minus signs, so options can be
+// searched for and copied.
+func escapeTerm(s string) string {
+	return strings.ReplaceAll(escape(s), "-", `\-`)
+}
+
+// sentence capitalises s and ends it with a full stop.
+func sentence(s string) string {
+	if s == "" {
+		return s
+	}
+	s = strings.ToUpper(s[:1]) + s[1:]
+	if !strings.HasSuffix(s, ".") {
+		s += "."
+	}
+	return s
+}
+
+// buildDate honours SOURCE_DATE_EPOCH, so packaged pages are reproducible;
+// without it the pages carry no date.
+func buildDate() string {
+	epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		return ""
+	}
+	return time.Unix(epoch, 0).UTC().Format("2006-01-02")
+}
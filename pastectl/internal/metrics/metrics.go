@@ -0,0 +1,240 @@
+// Package metrics reads the Prometheus text exposition the paste server
+// publishes (OTEL_PROMETHEUS_PATH, /metrics by default).
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jonasbg/paste/pastectl/internal/client"
+)
+
+// Sample is one line of the exposition: a series and its current value.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Set is every sample from one scrape.
+type Set []Sample
+
+// Bucket is one bound of a histogram with the number of observations at or
+// below it.
+type Bucket struct {
+	UpperBound float64 // +Inf for the last bucket
+	Count      float64
+}
+
+// Fetch scrapes url. A non-empty token is sent as a bearer token, for
+// endpoints kept behind an authenticating proxy.
+func Fetch(url, token string) (Set, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	// Servers before the metrics path was excluded from response
+	// compression gzip it twice when asked for gzip.
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := client.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, client.Mark(fmt.Errorf("%s refused access (%s); check the token", url, resp.Status), client.ErrRejected)
+	default:
+		return nil, client.Mark(fmt.Errorf("%s returned %s", url, resp.Status), client.ErrRejected)
+	}
+	if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/html") {
+		// The web app answers every unknown path.
+		return nil, fmt.Errorf("%s is not a metrics endpoint; is OTEL_PROMETHEUS_ENABLED off on the server?", url)
+	}
+	return Parse(resp.Body)
+}
+
+// Parse reads the Prometheus text format. Comments and type hints are
+// skipped; timestamps after the value are ignored.
+func Parse(r io.Reader) (Set, error) {
+	var set Set
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		s, err := parseLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("metrics line %d: %w", line, err)
+		}
+		set = append(set, s)
+	}
+	return set, scanner.Err()
+}
+
+func parseLine(text string) (Sample, error) {
+	s := Sample{Labels: map[string]string{}}
+	end := strings.IndexAny(text, "{ \t")
+	if end <= 0 {
+		return s, errors.New("missing value")
+	}
+	s.Name, text = text[:end], text[end:]
+
+	if strings.HasPrefix(text, "{") {
+		text = text[1:]
+		for {
+			text = strings.TrimLeft(text, " \t,")
+			if strings.HasPrefix(text, "}") {
+				text = text[1:]
+				break
+			}
+			eq := strings.Index(text, `="`)
+			if eq <= 0 {
+				return s, errors.New("malformed labels")
+			}
+			name := strings.TrimSpace(text[:eq])
+			value, rest, err := unquote(text[eq+2:])
+			if err != nil {
+				return s, err
+			}
+			s.Labels[name], text = value, rest
+		}
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return s, errors.New("missing value")
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value %q", fields[0])
+	}
+	s.Value = v
+	return s, nil
+}
+
+// unquote reads a label value up to its closing quote and returns it with
+// the rest of the line.
+func unquote(text string) (string, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"':
+			return b.String(), text[i+1:], nil
+		case '\\':
+			i++
+			if i == len(text) {
+				return "", "", errors.New("unterminated label value")
+			}
+			if text[i] == 'n' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated label value")
+}
+
+// Sum adds up the series called name whose labels include every pair in
+// match.
+func (set Set) Sum(name string, match map[string]string) float64 {
+	var total float64
+	for _, s := range set {
+		if s.Name == name && s.matches(match) {
+			total += s.Value
+		}
+	}
+	return total
+}
+
+// By sums the series called name grouped by the values of the given labels,
+// joined with a space.
+func (set Set) By(name string, labels ...string) map[string]float64 {
+	groups := map[string]float64{}
+	for _, s := range set {
+		if s.Name != name {
+			continue
+		}
+		key := make([]string, len(labels))
+		for i, l := range labels {
+			key[i] = s.Labels[l]
+		}
+		groups[strings.Join(key, " ")] += s.Value
+	}
+	return groups
+}
+
+// Buckets merges the buckets of histogram name across its series whose
+// labels include every pair in match, in ascending order of bound.
+func (set Set) Buckets(name string, match map[string]string) []Bucket {
+	counts := map[float64]float64{}
+	for _, s := range set {
+		if s.Name != name+"_bucket" || !s.matches(match) {
+			continue
+		}
+		le, err := strconv.ParseFloat(s.Labels["le"], 64)
+		if err != nil {
+			continue
+		}
+		counts[le] += s.Value
+	}
+	buckets := make([]Bucket, 0, len(counts))
+	for le, n := range counts {
+		buckets = append(buckets, Bucket{UpperBound: le, Count: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].UpperBound < buckets[j].UpperBound })
+	return buckets
+}
+
+// Quantile estimates the q-quantile (0..1) from cumulative buckets, by
+// linear interpolation within the bucket it falls in, as PromQL's
+// histogram_quantile does.
+func Quantile(q float64, buckets []Bucket) float64 {
+	if len(buckets) == 0 {
+		return math.NaN()
+	}
+	total := buckets[len(buckets)-1].Count
+	if total == 0 {
+		return math.NaN()
+	}
+	rank := q * total
+	lower, below := 0.0, 0.0
+	for _, b := range buckets {
+		if b.Count >= rank {
+			if math.IsInf(b.UpperBound, 1) {
+				return lower
+			}
+			if b.Count == below {
+				return b.UpperBound
+			}
+			return lower + (b.UpperBound-lower)*(rank-below)/(b.Count-below)
+		}
+		lower, below = b.UpperBound, b.Count
+	}
+	return lower
+}
+
+func (s Sample) matches(match map[string]string) bool {
+	for k, v := range match {
+		if s.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
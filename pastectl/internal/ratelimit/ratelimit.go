@@ -0,0 +1,92 @@
+// Package ratelimit caps transfer speed with a token bucket.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRead bounds each read so data flows evenly instead of in bursts the
+// size of a whole chunk.
+const maxRead = 32 * 1024
+
+// Limiter hands out bytes at a steady rate, allowing bursts of up to one
+// second's worth.
+type Limiter struct {
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter for bytesPerSecond.
+func New(bytesPerSecond int64) *Limiter {
+	return &Limiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// Wait blocks until n more bytes fit within the rate.
+func (l *Limiter) Wait(n int) {
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
+
+// Reader returns r throttled by l. A nil limiter leaves r unchanged.
+func Reader(r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{r: r, l: l}
+}
+
+type reader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxRead {
+		p = p[:maxRead]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.Wait(n)
+	}
+	return n, err
+}
+
+// ParseRate reads a rate such as "500K", "5M" or "1.5G" (bytes per second,
+// binary multiples, as curl's --limit-rate). A plain number is bytes.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	num, mult := s, 1.0
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			num = s[:len(s)-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q (e.g. 500K, 5M)", s)
+	}
+	if v*mult < 1 {
+		return 0, errors.New("rate must be at least one byte per second")
+	}
+	return int64(v * mult), nil
+}
@@ -1,10 +1,38 @@
 package types
 
-// Metadata represents file metadata
+import "time"
+
+// Metadata represents file metadata. It is encoded with
+// crypto.MarshalMetadata, which stamps the schema version, and fields added
+// here must stay optional so older clients can skip them.
 type Metadata struct {
 	Filename    string `json:"filename"`
 	ContentType string `json:"contentType"`
 	Size        int64  `json:"size"`
+	// Mode and ModTime (Unix seconds) are set by pastectl for regular
+	// files so downloads can restore them; zero means unknown.
+	Mode    uint32 `json:"mode,omitempty"`
+	ModTime int64  `json:"mtime,omitempty"`
+	// SHA256 is the hex digest of the plaintext, set by pastectl when the
+	// input can be read ahead of the upload. Downloads verify against it.
+	SHA256 string `json:"sha256,omitempty"`
+	// Compression names the algorithm (crypto.CompressionZstd or
+	// crypto.CompressionGzip) the plaintext was compressed with before
+	// encryption; Size and SHA256 describe the
+	// uncompressed data.
+	Compression string `json:"compression,omitempty"`
+	// Lang is a syntax-highlighting hint for code snippets, e.g. "go",
+	// given with --lang. Viewers fall back to the filename when unset.
+	Lang string `json:"lang,omitempty"`
+	// Title is a display name for text shared with `pastectl note`.
+	Title string `json:"title,omitempty"`
+	// ChunkSize is the plaintext chunk size in MB the data was sealed
+	// with. Uploads from before it was recorded used the server's default.
+	ChunkSize int `json:"chunkSize,omitempty"`
+	// ChunkKeys is crypto.ChunkKeysHKDF when every chunk was sealed with
+	// its own derived key (--per-chunk-keys), and empty when all were
+	// sealed with the file key.
+	ChunkKeys string `json:"chunkKeys,omitempty"`
 }
 
 // Config represents server configuration
@@ -12,4 +40,75 @@ type Config struct {
 	MaxFileSizeBytes int64 `json:"max_file_size_bytes"`
 	ChunkSize        int   `json:"chunk_size"`
 	KeySize          int   `json:"key_size"`
+	MinExpirySeconds int64 `json:"min_expiry_seconds"`
+	MaxExpirySeconds int64 `json:"max_expiry_seconds"`
+	MaxDownloads     int   `json:"max_downloads"`
+	StreamingUploads bool  `json:"streaming_uploads"`
+	MaxUploadWindow  int   `json:"max_upload_window"`
+	ResumableUploads bool  `json:"resumable_uploads"`
+	MinChunkSize     int   `json:"min_chunk_size"`
+	MaxChunkSize     int   `json:"max_chunk_size"`
+	// MinProtocolVersion and ProtocolVersion bound the WebSocket protocol
+	// versions the server speaks; both are zero for servers from before
+	// versions were negotiated, which speak version 1.
+	MinProtocolVersion int `json:"min_protocol_version"`
+	ProtocolVersion    int `json:"protocol_version"`
+	// FilesRetentionDays, ExpiryOptions (seconds), BurnAfterDownload and
+	// Cipher describe what happens to uploads; servers from before they
+	// were reported leave them zero.
+	FilesRetentionDays int     `json:"files_retention_days"`
+	ExpiryOptions      []int64 `json:"expiry_options"`
+	BurnAfterDownload  bool    `json:"burn_after_download"`
+	Cipher             string  `json:"cipher"`
+	// MaxMetadataSize bounds the sealed metadata of an upload, after the
+	// 16-byte header prefix. Older servers leave it zero and take 64 KB.
+	MaxMetadataSize int `json:"max_metadata_size"`
+}
+
+// SupportsExpiry reports whether the server accepts a per-upload lifetime.
+func (c *Config) SupportsExpiry() bool {
+	return c.MaxExpirySeconds > 0
+}
+
+// SupportsDownloadLimit reports whether the server accepts a per-upload
+// download count.
+func (c *Config) SupportsDownloadLimit() bool {
+	return c.MaxDownloads > 0
+}
+
+// SupportsStreaming reports whether the server accepts uploads whose size
+// is not known in advance.
+func (c *Config) SupportsStreaming() bool {
+	return c.StreamingUploads
+}
+
+// SupportsChunkSize reports whether uploads may choose their own chunk
+// size, between MinChunkSize and MaxChunkSize.
+func (c *Config) SupportsChunkSize() bool {
+	return c.MaxChunkSize > 0
+}
+
+// FileChunkSize returns the chunk size in MB that m's data was sealed with.
+func (c *Config) FileChunkSize(m *Metadata) int {
+	if m.ChunkSize > 0 {
+		return m.ChunkSize
+	}
+	return c.ChunkSize
+}
+
+// DownloadStats is what the server tells an upload's owner about it.
+type DownloadStats struct {
+	Downloads int  `json:"downloads"`
+	Remaining int  `json:"remaining"`
+	Available bool `json:"available"`
+}
+
+// FileStatus describes a remote file as seen through the metadata endpoint.
+type FileStatus struct {
+	Metadata      *Metadata
+	Token         string
+	EncryptedSize int64
+	ExpiresAt     time.Time // zero when the server reports no expiry
+	// DownloadsRemaining is 0 when the server does not report it.
+	DownloadsRemaining int
 }
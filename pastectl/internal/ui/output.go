@@ -0,0 +1,44 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Quiet suppresses progress bars and informational messages. Warnings and
+// errors are still printed.
+var Quiet bool
+
+// Infof prints an informational message to stderr unless Quiet is set.
+func Infof(format string, args ...any) {
+	if Quiet {
+		return
+	}
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+// StderrIsTerminal reports whether stderr is attached to a terminal. When it
+// is not (cron, CI logs), carriage-return progress frames are just noise.
+func StderrIsTerminal() bool {
+	stat, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return (stat.Mode() & os.ModeCharDevice) != 0
+}
+
+// Debug enables step-by-step protocol logging on stderr (-v/--debug or
+// PASTE_DEBUG=1).
+var Debug = os.Getenv("PASTE_DEBUG") != ""
+
+var debugStart = time.Now()
+
+// Debugf logs a timestamped diagnostic line to stderr when Debug is set.
+func Debugf(format string, args ...any) {
+	if !Debug {
+		return
+	}
+	elapsed := time.Since(debugStart).Milliseconds()
+	fmt.Fprintf(os.Stderr, "[debug +%dms] %s\n", elapsed, fmt.Sprintf(format, args...))
+}
@@ -6,6 +6,10 @@ import (
 	"time"
 )
 
+// Enabled turns progress rendering on or off (config file "progress" key,
+// and off automatically when stderr is not a terminal).
+var Enabled = true
+
 var spinnerChars = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 
 // ProgressBar represents a simple terminal progress bar
@@ -19,7 +23,8 @@ type ProgressBar struct {
 	spinnerIdx  int
 }
 
-// NewProgressBar creates a new progress bar
+// NewProgressBar creates a new progress bar. A total <= 0 means the size is
+// unknown (streamed input): the bar then shows bytes and speed only.
 func NewProgressBar(total int64, description string) *ProgressBar {
 	return &ProgressBar{
 		total:       total,
@@ -35,10 +40,13 @@ func NewProgressBar(total int64, description string) *ProgressBar {
 // Update updates the progress bar
 func (pb *ProgressBar) Update(current int64) {
 	pb.current = current
+	if !Enabled || Quiet {
+		return
+	}
 
 	// Throttle updates to every 100ms
 	now := time.Now()
-	if now.Sub(pb.lastUpdate) < 100*time.Millisecond && current < pb.total {
+	if now.Sub(pb.lastUpdate) < 100*time.Millisecond && (pb.total <= 0 || current < pb.total) {
 		return
 	}
 	pb.lastUpdate = now
@@ -49,12 +57,22 @@ func (pb *ProgressBar) Update(current int64) {
 
 // Finish completes the progress bar
 func (pb *ProgressBar) Finish() {
-	pb.current = pb.total
+	if pb.total > 0 {
+		pb.current = pb.total
+	}
+	if !Enabled || Quiet {
+		return
+	}
 	pb.render()
 	fmt.Fprint(os.Stderr, "\n")
 }
 
 func (pb *ProgressBar) render() {
+	if pb.total <= 0 {
+		pb.renderUnbounded()
+		return
+	}
+
 	percentage := float64(pb.current) / float64(pb.total) * 100
 	filled := int(float64(pb.width) * float64(pb.current) / float64(pb.total))
 
@@ -128,3 +146,14 @@ func (pb *ProgressBar) render() {
 		}
 	}
 }
+
+// renderUnbounded draws a spinner with the transferred bytes and speed for
+// transfers whose total size is not known up front.
+func (pb *ProgressBar) renderUnbounded() {
+	var speed float64
+	if elapsed := time.Since(pb.startTime).Seconds(); elapsed > 0 {
+		speed = float64(pb.current) / elapsed
+	}
+	fmt.Fprintf(os.Stderr, "\r%s %s %.2f MB   %.2f MB/s   ", spinnerChars[pb.spinnerIdx], pb.description,
+		float64(pb.current)/(1024*1024), speed/(1024*1024))
+}
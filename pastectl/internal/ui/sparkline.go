@@ -0,0 +1,23 @@
+package ui
+
+import "strings"
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline draws values as a row of block characters scaled to the
+// largest one. Zero stays at the lowest block, so gaps remain visible.
+func Sparkline(values []float64) string {
+	var peak float64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if peak > 0 && v > 0 {
+			i = min(len(sparks)-1, int(v/peak*float64(len(sparks)-1)+0.5))
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
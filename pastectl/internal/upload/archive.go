@@ -0,0 +1,266 @@
+package upload
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// IgnoreFile is read from the root of an uploaded directory; each line is
+// an exclude pattern, as if passed with --exclude.
+const IgnoreFile = ".pasteignore"
+
+// ArchiveOptions controls how directories are packed before upload.
+type ArchiveOptions struct {
+	// Exclude holds glob patterns (path.Match syntax). A pattern without
+	// a slash matches a file or directory name at any depth; one with a
+	// slash matches the path relative to the uploaded directory. A
+	// trailing slash restricts the pattern to directories.
+	Exclude []string
+
+	// FollowSymlinks archives what symlinks point to instead of the links
+	// themselves. By default links are stored with their targets.
+	FollowSymlinks bool
+}
+
+// excludeRule is one parsed exclude pattern.
+type excludeRule struct {
+	pattern  string
+	anchored bool // contains a slash: match the whole relative path
+	dirOnly  bool
+}
+
+func parseExcludes(patterns []string) ([]excludeRule, error) {
+	var rules []excludeRule
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || strings.HasPrefix(p, "#") {
+			continue
+		}
+		r := excludeRule{}
+		if strings.HasSuffix(p, "/") {
+			r.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		r.anchored = strings.Contains(p, "/")
+		r.pattern = strings.TrimPrefix(p, "/")
+		if _, err := path.Match(r.pattern, ""); err != nil {
+			return nil, errors.New("invalid exclude pattern: " + p)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// excluded reports whether the slash-separated relative path rel matches
+// any rule.
+func excluded(rules []excludeRule, rel string, isDir bool) bool {
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		name := path.Base(rel)
+		if r.anchored {
+			name = rel
+		}
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readIgnoreFile returns the patterns in dirPath/.pasteignore, if present.
+func readIgnoreFile(dirPath string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dirPath, IgnoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		patterns = append(patterns, sc.Text())
+	}
+	return patterns, sc.Err()
+}
+
+// streamTarGz archives the given files and directories on the fly, each
+// under its base name. The tar.gz is produced by a goroutine as the
+// returned reader is consumed, so inputs of any size are uploaded without
+// being held in memory; archiving errors surface as read errors.
+func streamTarGz(roots []string, opts ArchiveOptions) (io.Reader, error) {
+	patterns := opts.Exclude
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(root)
+		if seen[base] {
+			return nil, fmt.Errorf("two inputs are named %q", base)
+		}
+		seen[base] = true
+
+		if info.IsDir() {
+			ignored, err := readIgnoreFile(root)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, ignored...)
+		}
+	}
+	rules, err := parseExcludes(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTarGz(pw, roots, rules, opts.FollowSymlinks))
+	}()
+	return pr, nil
+}
+
+// tarWalker writes a directory tree into a tar stream.
+type tarWalker struct {
+	tw      *tar.Writer
+	rules   []excludeRule
+	follow  bool
+	visited map[string]bool // real paths of directories entered, to stop symlink loops
+}
+
+func writeTarGz(w io.Writer, roots []string, rules []excludeRule, follow bool) error {
+	gzWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzWriter)
+
+	t := &tarWalker{tw: tarWriter, rules: rules, follow: follow, visited: map[string]bool{}}
+	for _, root := range roots {
+		// Get the base name for the archive
+		if err := t.walk(root, "", filepath.Base(root)); err != nil {
+			return err
+		}
+	}
+
+	// Close writers
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzWriter.Close()
+}
+
+// walk archives root under the archive name prefix. relBase is root's path
+// relative to the uploaded directory, used for exclude matching.
+func (t *tarWalker) walk(root, relBase, prefix string) error {
+	// Walk the resolved path: filepath.Walk does not descend into a root
+	// that is itself a symlink.
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	if t.visited[real] {
+		ui.Infof("Warning: skipping symlink loop at %s\n", root)
+		return nil
+	}
+	t.visited[real] = true
+	defer delete(t.visited, real)
+	root = real
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Update the name to be relative to the base directory
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel := filepath.ToSlash(filepath.Join(relBase, relPath))
+		if rel != "." && excluded(t.rules, rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := filepath.ToSlash(filepath.Join(prefix, relPath))
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return t.writeSymlink(path, rel, name, info)
+		}
+		return t.writeEntry(path, name, info)
+	})
+}
+
+// writeSymlink stores a link with its real target, or in follow mode
+// archives what it points to under the link's name.
+func (t *tarWalker) writeSymlink(path, rel, name string, info os.FileInfo) error {
+	if t.follow {
+		target, err := os.Stat(path)
+		switch {
+		case err != nil:
+			ui.Infof("Warning: keeping dangling symlink %s as a link\n", path)
+		case target.IsDir():
+			if excluded(t.rules, rel, true) {
+				return nil
+			}
+			return t.walk(path, rel, name)
+		default:
+			return t.writeEntry(path, name, target)
+		}
+	}
+
+	linkTarget, err := os.Readlink(path)
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, linkTarget)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	return t.tw.WriteHeader(header)
+}
+
+// writeEntry writes the header for info and, for regular files, the
+// contents of path.
+func (t *tarWalker) writeEntry(path, name string, info os.FileInfo) error {
+	// Create tar header
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	// Write header
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	// If it's a file, write its contents
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(t.tw, file)
+	return err
+}
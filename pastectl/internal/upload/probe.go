@@ -0,0 +1,125 @@
+package upload
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/types"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// ProbeResult is one chunk sent by Probe.
+type ProbeResult struct {
+	Size     int // sealed bytes, as sent
+	Duration time.Duration
+	Err      error // why the chunk was not acknowledged, if it wasn't
+}
+
+// Probe checks which chunk sizes make it through to the server, which
+// proxies with frame or buffer limits can quietly cap. It starts a streamed
+// upload under a throwaway key and sends one chunk of each size in turn,
+// timing each acknowledgement, then drops the connection without the end
+// marker so the server discards what it received. Probing stops at the
+// first size that fails.
+func Probe(serverURL string, config *types.Config, sizes []int) ([]ProbeResult, error) {
+	key, err := crypto.GenerateKey(config.KeySize / 8)
+	if err != nil {
+		return nil, err
+	}
+
+	wsURL := strings.Replace(serverURL, "https://", "wss://", 1)
+	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
+	wsURL += "/api/ws/upload"
+
+	ui.Debugf("probing %s", wsURL)
+	conn, err := client.DialWebSocket(wsURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	expect := func(step string) (map[string]interface{}, error) {
+		var resp map[string]interface{}
+		if err := conn.ReadJSON(&resp); err != nil {
+			return nil, fmt.Errorf("failed to read %s response: %w", step, err)
+		}
+		if resp["type"] == "error" {
+			return nil, client.Mark(fmt.Errorf("server rejected %s: %v", step, resp["error"]), client.ErrRejected)
+		}
+		return resp, nil
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{"type": "init", "streaming": true, "protocolVersion": client.ProtocolVersion}); err != nil {
+		return nil, fmt.Errorf("failed to send init: %w", err)
+	}
+	initResp, err := expect("init")
+	if err != nil {
+		return nil, err
+	}
+	fileID, ok := initResp["id"].(string)
+	if !ok {
+		return nil, errors.New("invalid init response")
+	}
+	token, err := crypto.GenerateHMACToken(fileID, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"type": "token", "token": token}); err != nil {
+		return nil, fmt.Errorf("failed to send token: %w", err)
+	}
+	if _, err := expect("token"); err != nil {
+		return nil, err
+	}
+	metadataJSON, err := crypto.MarshalMetadata(types.Metadata{Filename: "probe", ContentType: "application/octet-stream"})
+	if err != nil {
+		return nil, err
+	}
+	header, err := crypto.EncryptMetadata(key, metadataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, header); err != nil {
+		return nil, fmt.Errorf("failed to send metadata: %w", err)
+	}
+	if _, err := expect("metadata"); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, crypto.IVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, iv); err != nil {
+		return nil, fmt.Errorf("failed to send IV: %w", err)
+	}
+
+	// The chunks are never decrypted, so random bytes of the sealed size
+	// stand in for real ciphertext.
+	var results []ProbeResult
+	for _, size := range sizes {
+		chunk := make([]byte, size)
+		rand.Read(chunk)
+		started := time.Now()
+		r := ProbeResult{Size: size}
+		if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+			r.Err = err
+			if reason := serverError(conn); reason != "" {
+				r.Err = errors.New(reason)
+			}
+		} else {
+			_, r.Err = expect("chunk")
+		}
+		r.Duration = time.Since(started)
+		ui.Debugf("probe %d bytes: %s, err=%v", size, r.Duration.Round(time.Millisecond), r.Err)
+		results = append(results, r)
+		if r.Err != nil {
+			break
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,311 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/config"
+	"github.com/jonasbg/paste/pastectl/internal/ui"
+)
+
+// ErrNotResumable is returned by Resume when the upload can't be continued:
+// the source file changed, or the server no longer holds the partial
+// upload, e.g. because it was cleaned up as stale.
+var ErrNotResumable = errors.New("upload can no longer be resumed")
+
+// State is what it takes to continue an interrupted upload: the file ID
+// and token that own the partial file on the server, and the key and IV
+// the stream was encrypted with. It is written with owner-only permissions
+// and holds the key, so it is as sensitive as the share link itself.
+type State struct {
+	FileID       string    `json:"file_id"`
+	Token        string    `json:"token"`
+	Key          []byte    `json:"key"`
+	IV           []byte    `json:"iv"`
+	Passphrase   string    `json:"passphrase,omitempty"` // share code, if the key was derived from one
+	ServerURL    string    `json:"server_url"`
+	ChunkSize    int       `json:"chunk_size"` // MB
+	ChunkKeys    string    `json:"chunk_keys,omitempty"`
+	Chunks       int64     `json:"chunks"` // chunks acknowledged by the server
+	Source       string    `json:"source"` // absolute path of the file being uploaded
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"mtime"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	ExpiresIn    int64     `json:"expires_in,omitempty"` // seconds
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Mode         uint32    `json:"mode,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Link returns the share URL or passphrase of the upload.
+func (s *State) Link() string {
+	if s.Passphrase != "" {
+		return s.Passphrase
+	}
+	return fmt.Sprintf("%s/%s#key=%s", s.ServerURL, s.FileID, base64.URLEncoding.EncodeToString(s.Key))
+}
+
+// StateDir returns where resume state is kept:
+// $XDG_STATE_HOME/paste/uploads, falling back to ~/.local/state.
+func StateDir() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "uploads"), nil
+}
+
+// Save writes the state to <StateDir>/<file ID>.json.
+func (s *State) Save() error {
+	dir, err := StateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	s.UpdatedAt = time.Now()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so a crash mid-write never leaves a truncated file.
+	path := filepath.Join(dir, s.FileID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes the state file.
+func (s *State) Remove() error {
+	dir, err := StateDir()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, s.FileID+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// FindState returns the most recently updated state for the file at
+// source, or for any file if source is empty. It returns nil if there is
+// none. Unreadable state files are skipped.
+func FindState(source string) (*State, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	if source != "" {
+		if source, err = filepath.Abs(source); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *State
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var s State
+		if err := json.Unmarshal(data, &s); err != nil {
+			continue
+		}
+		if source != "" && s.Source != source {
+			continue
+		}
+		if latest == nil || s.UpdatedAt.After(latest.UpdatedAt) {
+			latest = &s
+		}
+	}
+	return latest, nil
+}
+
+// newState records the upload just started so it can be resumed. A state
+// that can't be saved only costs the ability to resume, so the upload goes
+// on without it.
+func (h *Handler) newState(fileID, token string, key, iv []byte, filename, contentType string, fileSize int64) *State {
+	source, err := filepath.Abs(h.source)
+	if err != nil {
+		return nil
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil
+	}
+	s := &State{
+		FileID:       fileID,
+		Token:        token,
+		Key:          key,
+		IV:           append([]byte(nil), iv...),
+		Passphrase:   h.passphrase,
+		ServerURL:    h.serverURL,
+		ChunkSize:    h.chunkMB(),
+		ChunkKeys:    h.chunkKeys,
+		Source:       source,
+		Size:         fileSize,
+		ModTime:      info.ModTime(),
+		Filename:     filename,
+		ContentType:  contentType,
+		ExpiresIn:    int64(h.expiresIn / time.Second),
+		MaxDownloads: h.maxDownloads,
+		Mode:         uint32(h.mode),
+	}
+	if err := s.Save(); err != nil {
+		ui.Infof("Warning: can't save resume state: %v\n", err)
+		return nil
+	}
+	ui.Debugf("resume state saved for %s", fileID)
+	return s
+}
+
+// saveState persists progress after an ack. On failure the state is
+// dropped rather than left pointing at fewer chunks than the server has;
+// that is harmless anyway, as the server reports its own count on resume.
+func (h *Handler) saveState() {
+	if err := h.state.Save(); err != nil {
+		ui.Infof("Warning: can't save resume state: %v\n", err)
+		h.state.Remove()
+		h.state = nil
+	}
+}
+
+// Resume continues the interrupted upload described by s and returns its
+// share URL or passphrase. The server reports how many chunks it kept; the
+// source file is re-read up to that point for the checksum, and the stream
+// continues from there under the original key and IV.
+func (h *Handler) Resume(s *State) (string, error) {
+	started := time.Now()
+
+	file, err := os.Open(s.Source)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.Size() != s.Size || !info.ModTime().Equal(s.ModTime) {
+		return "", fmt.Errorf("%w: %s has changed since it started", ErrNotResumable, s.Source)
+	}
+	if s.ChunkSize != h.config.ChunkSize && !h.config.SupportsChunkSize() {
+		return "", fmt.Errorf("%w: server chunk size changed from %d MB to %d MB", ErrNotResumable, s.ChunkSize, h.config.ChunkSize)
+	}
+	h.chunkSize = s.ChunkSize
+
+	wsURL := strings.Replace(h.serverURL, "https://", "wss://", 1)
+	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
+	wsURL += "/api/ws/upload"
+
+	ui.Debugf("connecting to %s", wsURL)
+	conn, err := client.DialWebSocket(wsURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	initMsg := map[string]interface{}{
+		"type":            "init",
+		"resume":          true,
+		"fileId":          s.FileID,
+		"token":           s.Token,
+		"protocolVersion": client.ProtocolVersion,
+		"window":          max(1, h.window),
+	}
+	if s.ExpiresIn > 0 {
+		initMsg["expiresIn"] = s.ExpiresIn
+	}
+	if s.MaxDownloads > 0 {
+		initMsg["maxDownloads"] = s.MaxDownloads
+	}
+	if h.config.SupportsChunkSize() {
+		// The server cuts the partial file back to whole chunks of this size.
+		initMsg["chunkSize"] = s.ChunkSize
+	}
+	ui.Debugf("-> init resume id=%s (%d chunks acked locally)", s.FileID, s.Chunks)
+	if err := conn.WriteJSON(initMsg); err != nil {
+		return "", fmt.Errorf("failed to send init: %w", err)
+	}
+
+	var resp map[string]interface{}
+	if err := conn.ReadJSON(&resp); err != nil {
+		return "", fmt.Errorf("failed to read init response: %w", err)
+	}
+	if resp["type"] == "error" {
+		ui.Debugf("<- resume error code=%v: %v", resp["code"], resp["error"])
+		switch resp["code"] {
+		case "not_resumable":
+			return "", fmt.Errorf("%w: the server no longer has it", ErrNotResumable)
+		case "id_in_use":
+			return "", errors.New("the server is still receiving this upload; try again in a minute")
+		}
+		return "", client.Mark(fmt.Errorf("server rejected resume: %v", resp["error"]), client.ErrRejected)
+	}
+	n, ok := resp["chunks"].(float64)
+	if resp["type"] != "resume" || !ok {
+		return "", errors.New("invalid resume response")
+	}
+	if err := h.negotiate(resp); err != nil {
+		return "", err
+	}
+	chunks := int64(n)
+
+	// Hash what the server already has, then continue the stream at the
+	// first chunk it is missing.
+	offset := min(chunks*int64(s.ChunkSize)*1024*1024, s.Size)
+	hasher := sha256.New()
+	if _, err := io.CopyN(hasher, file, offset); err != nil {
+		return "", fmt.Errorf("failed to read data: %w", err)
+	}
+	ui.Debugf("<- resume at chunk %d (%d bytes)", chunks, offset)
+	ui.Infof("Resuming upload of %s at %d%%\n", s.Filename, offset*100/max(s.Size, 1))
+
+	opts, err := crypto.ChunkKeyOptions(s.ChunkKeys)
+	if err != nil {
+		return "", err
+	}
+	streamCipher, err := crypto.NewStreamCipherAt(s.Key, s.IV, uint64(chunks), opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cipher: %w", err)
+	}
+	defer streamCipher.Clear()
+
+	h.state = s
+	h.state.Chunks = chunks
+	h.saveState()
+
+	total, finalResp, err := h.sendChunks(conn, io.TeeReader(file, hasher), streamCipher, s.Size, offset)
+	if err != nil {
+		return "", err
+	}
+	h.finish(s.FileID, total, hasher, started, finalResp)
+	return s.Link(), nil
+}
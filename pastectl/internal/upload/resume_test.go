@@ -0,0 +1,48 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindState(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	if s, err := FindState(""); s != nil || err != nil {
+		t.Fatalf("FindState with no state dir: %v, %v", s, err)
+	}
+
+	src := t.TempDir()
+	older := &State{FileID: "a1", Token: "tok", ChunkSize: 4, Chunks: 3, Source: filepath.Join(src, "a")}
+	newer := &State{FileID: "b2", Token: "tok", ChunkSize: 1, Source: filepath.Join(src, "b")}
+	for _, s := range []*State{older, newer} {
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // distinct UpdatedAt
+	}
+	dir, _ := StateDir()
+	if err := os.WriteFile(filepath.Join(dir, "junk.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := FindState("")
+	if err != nil || s == nil || s.FileID != newer.FileID {
+		t.Fatalf("FindState(\"\") = %+v, %v, want the latest state", s, err)
+	}
+	s, err = FindState(older.Source)
+	if err != nil || s == nil || s.FileID != older.FileID || s.Chunks != 3 || s.ChunkSize != 4 {
+		t.Fatalf("FindState(%s) = %+v, %v", older.Source, s, err)
+	}
+
+	if err := older.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := older.Remove(); err != nil {
+		t.Errorf("removing twice: %v", err)
+	}
+	if s, _ := FindState(older.Source); s != nil {
+		t.Errorf("state still found after Remove: %+v", s)
+	}
+}
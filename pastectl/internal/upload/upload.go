@@ -1,29 +1,86 @@
 package upload
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
+	"bufio"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jonasbg/paste/crypto"
+	"github.com/jonasbg/paste/pastectl/internal/client"
+	"github.com/jonasbg/paste/pastectl/internal/compress"
+	"github.com/jonasbg/paste/pastectl/internal/ratelimit"
 	"github.com/jonasbg/paste/pastectl/internal/types"
 	"github.com/jonasbg/paste/pastectl/internal/ui"
-	"github.com/jonasbg/paste/crypto"
 )
 
+// maxPassphraseAttempts bounds retries when a generated share code collides
+// with one already in use on the server.
+const maxPassphraseAttempts = 3
+
+// SizeUnknown is the size PrepareInput reports for piped input and
+// directory archives, which are uploaded in streaming mode (or spooled to disk for older servers).
+const SizeUnknown = -1
+
+// ErrIDInUse is returned when the server refuses a passphrase-derived file
+// ID because it is already stored or being uploaded.
+var ErrIDInUse = client.Mark(errors.New("share code already in use"), client.ErrRejected)
+
 // Handler handles file uploads
 type Handler struct {
-	serverURL string
-	config    *types.Config
+	serverURL    string
+	config       *types.Config
+	expiresIn    time.Duration
+	maxDownloads int
+	mode         os.FileMode
+	modTime      time.Time
+	window       int
+	protocol     int    // negotiated WebSocket protocol version, 0 for 1
+	granted      int    // window the server granted, with protocol 2
+	source       string // regular file being uploaded, for resume state
+	passphrase   string // share code of the current attempt, for resume state
+	state        *State // resume state of an unfinished upload
+	limiter      *ratelimit.Limiter
+	compression  string // algorithm applied before encryption, if any
+	lang         string // syntax-highlighting hint, if any
+	title        string // display name for a note, if any
+	chunkSize    int    // MB, zero for the server's default
+	chunkKeys    string // crypto.ChunkKeysHKDF for per-chunk keys, if any
+	timing       Timing
+	result       Result
+}
+
+// Result describes the last completed upload.
+type Result struct {
+	FileID    string
+	Size      int64  // plaintext bytes sent
+	SHA256    string // hex digest of the plaintext
+	Duration  time.Duration
+	ExpiresAt time.Time // zero if no expiry was requested
+	// MaxDownloads is the limit the server applied (0 if it did not say)
+	MaxDownloads int
+	// OwnerToken reads the file's download count back from the server
+	// (empty if it did not issue one)
+	OwnerToken string
+	Timing     Timing
+}
+
+// Timing breaks down where an upload's chunk loop spent its time.
+type Timing struct {
+	Encrypt time.Duration // sealing chunks
+	AckWait time.Duration // blocked waiting for acks
+	// AckLatency is, per chunk, the time from sending it to its ack
+	AckLatency []time.Duration
 }
 
 // NewHandler creates a new upload handler
@@ -34,6 +91,139 @@ func NewHandler(serverURL string, config *types.Config) *Handler {
 	}
 }
 
+// WithExpiry asks the server to delete the file after d. Zero keeps the
+// server's default retention.
+func (h *Handler) WithExpiry(d time.Duration) *Handler {
+	h.expiresIn = d
+	return h
+}
+
+// WithMaxDownloads lets the file survive n completed downloads. Zero keeps
+// the server default of one.
+func (h *Handler) WithMaxDownloads(n int) *Handler {
+	h.maxDownloads = n
+	return h
+}
+
+// WithFileAttributes records the source file's permission bits and
+// modification time in the encrypted metadata.
+func (h *Handler) WithFileAttributes(mode os.FileMode, modTime time.Time) *Handler {
+	h.mode = mode.Perm()
+	h.modTime = modTime
+	return h
+}
+
+// WithWindow lets up to n chunks be sent before waiting for an ack, which
+// speeds up uploads over high-latency links. It is capped by the server's
+// advertised maximum; servers that advertise none get one chunk at a time.
+func (h *Handler) WithWindow(n int) *Handler {
+	h.window = n
+	return h
+}
+
+// WithResumeSource marks the upload as the regular file at path. Against
+// servers that keep partial uploads, progress is then saved in a local
+// state file so an interrupted upload can be continued with Resume.
+func (h *Handler) WithResumeSource(path string) *Handler {
+	h.source = path
+	return h
+}
+
+// WithRateLimit caps the upload at bytesPerSecond. Zero means no limit.
+func (h *Handler) WithRateLimit(bytesPerSecond int64) *Handler {
+	h.limiter = nil
+	if bytesPerSecond > 0 {
+		h.limiter = ratelimit.New(bytesPerSecond)
+	}
+	return h
+}
+
+// WithCompression compresses the data with algo (compress.Zstd or
+// compress.Gzip) before it is encrypted, and records it in the metadata so
+// downloads decompress it. The compressed size isn't known up front, so the
+// upload streams (which the server must support) and can't be resumed.
+func (h *Handler) WithCompression(algo string) *Handler {
+	h.compression = algo
+	return h
+}
+
+// WithPerChunkKeys seals every chunk with its own key derived from the
+// file key, and records it in the metadata so downloads do the same.
+// Clients from before the mode was recorded can't download the file.
+func (h *Handler) WithPerChunkKeys(on bool) *Handler {
+	h.chunkKeys = ""
+	if on {
+		h.chunkKeys = crypto.ChunkKeysHKDF
+	}
+	return h
+}
+
+// WithLanguage records a syntax-highlighting hint such as "go" in the
+// metadata, for viewers rendering code snippets.
+func (h *Handler) WithLanguage(lang string) *Handler {
+	h.lang = lang
+	return h
+}
+
+// WithTitle records a display name for the upload in the metadata.
+func (h *Handler) WithTitle(title string) *Handler {
+	h.title = title
+	return h
+}
+
+// WithChunkSize seals the data in chunks of mb megabytes instead of the
+// server's default. The server must support choosing it (see
+// types.Config.SupportsChunkSize); zero keeps the default.
+func (h *Handler) WithChunkSize(mb int) *Handler {
+	h.chunkSize = mb
+	return h
+}
+
+// chunkMB returns the chunk size in MB this upload is sealed with.
+func (h *Handler) chunkMB() int {
+	if h.chunkSize > 0 {
+		return h.chunkSize
+	}
+	return h.config.ChunkSize
+}
+
+// Interrupted reports whether the last upload failed after saving resume
+// state, i.e. whether it can be continued with Resume.
+func (h *Handler) Interrupted() bool {
+	return h.state != nil
+}
+
+func (h *Handler) uploadWindow() int {
+	if h.cumulativeAcks() {
+		return max(1, h.granted)
+	}
+	return max(1, min(h.window, h.config.MaxUploadWindow))
+}
+
+// cumulativeAcks reports whether the server acks a batch of chunks at a
+// time, counting all chunks received so far, rather than each chunk.
+func (h *Handler) cumulativeAcks() bool {
+	return h.protocol >= 2
+}
+
+// negotiate records the protocol version, and the window it grants, from
+// the reply that accepted the upload.
+func (h *Handler) negotiate(reply map[string]interface{}) error {
+	protocol, _ := reply["protocolVersion"].(float64)
+	if err := client.CheckProtocol(int(protocol)); err != nil {
+		return err
+	}
+	granted, _ := reply["window"].(float64)
+	h.protocol, h.granted = int(protocol), int(granted)
+	ui.Debugf("<- protocol %d, window %d", h.protocol, h.granted)
+	return nil
+}
+
+// Result returns details of the last completed upload.
+func (h *Handler) Result() Result {
+	return h.result
+}
+
 // Upload uploads a file or stdin data
 func (h *Handler) Upload(reader io.Reader, filename string, contentType string, fileSize int64, key []byte) (string, error) {
 	fileID, err := h.uploadFile(reader, filename, contentType, fileSize, key)
@@ -50,30 +240,39 @@ func (h *Handler) Upload(reader io.Reader, filename string, contentType string,
 
 // UploadWithPassphrase uploads a file using passphrase-based key derivation
 func (h *Handler) UploadWithPassphrase(reader io.Reader, filename string, contentType string, fileSize int64, numWords int) (string, error) {
-	// Generate passphrase
-	passphrase, err := crypto.GeneratePassphrase(numWords)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate passphrase: %w", err)
-	}
+	for attempt := 1; ; attempt++ {
+		// Generate passphrase
+		passphrase, err := crypto.GeneratePassphrase(numWords)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate passphrase: %w", err)
+		}
 
-	// Derive fileID and encryption key from passphrase
-	fileID, key, err := crypto.DeriveFromPassphrase(passphrase, h.config.KeySize/8)
-	if err != nil {
-		return "", fmt.Errorf("failed to derive key from passphrase: %w", err)
-	}
+		// Derive fileID and encryption key from passphrase
+		fileID, key, err := crypto.DeriveFromPassphrase(passphrase, h.config.KeySize/8)
+		if err != nil {
+			return "", fmt.Errorf("failed to derive key from passphrase: %w", err)
+		}
+		h.passphrase = passphrase
+
+		// Upload file with derived fileID and key. The server reserves the
+		// ID before any data is read, so on a collision the reader is still
+		// untouched and we can retry with a fresh passphrase.
+		actualFileID, err := h.uploadFileWithID(reader, filename, contentType, fileSize, key, fileID)
+		if errors.Is(err, ErrIDInUse) && attempt < maxPassphraseAttempts {
+			ui.Debugf("share code collided (attempt %d/%d), retrying", attempt, maxPassphraseAttempts)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
 
-	// Upload file with derived fileID and key
-	actualFileID, err := h.uploadFileWithID(reader, filename, contentType, fileSize, key, fileID)
-	if err != nil {
-		return "", err
-	}
+		// Verify the derived fileID matches what server accepted
+		if actualFileID != fileID {
+			return "", client.Mark(fmt.Errorf("server rejected custom fileID (got %s, expected %s)", actualFileID, fileID), client.ErrRejected)
+		}
 
-	// Verify the derived fileID matches what server accepted
-	if actualFileID != fileID {
-		return "", fmt.Errorf("server rejected custom fileID (got %s, expected %s)", actualFileID, fileID)
+		return passphrase, nil
 	}
-
-	return passphrase, nil
 }
 
 func (h *Handler) uploadFile(reader io.Reader, filename string, contentType string, fileSize int64, key []byte) (string, error) {
@@ -82,26 +281,77 @@ func (h *Handler) uploadFile(reader io.Reader, filename string, contentType stri
 
 // uploadFileWithID uploads a file with an optional custom fileID
 func (h *Handler) uploadFileWithID(reader io.Reader, filename string, contentType string, fileSize int64, key []byte, customFileID string) (string, error) {
+	started := time.Now()
+
+	// Files are hashed up front so the digest can travel in the sealed
+	// metadata for the receiver to check. Streams can't be; they still get
+	// an integrity receipt from hashing the plaintext as it is read.
+	digest, err := digestOf(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash input: %w", err)
+	}
+	hasher := sha256.New()
+	plain := &countingWriter{}
+	reader = io.TeeReader(reader, io.MultiWriter(hasher, plain))
+
+	// The metadata keeps the original size for the receiver; what is sent
+	// is the compressed stream, whose size is only known at the end.
+	plainSize := fileSize
+	if h.compression != "" {
+		if !h.config.SupportsStreaming() {
+			return "", errors.New("this server doesn't accept uploads of unknown size, which compression needs")
+		}
+		compressed, err := compress.Reader(reader, h.compression)
+		if err != nil {
+			return "", err
+		}
+		defer compressed.Close()
+		reader, fileSize = compressed, SizeUnknown
+	}
+
 	// Convert HTTP URL to WebSocket URL
 	wsURL := strings.Replace(h.serverURL, "https://", "wss://", 1)
 	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
 	wsURL += "/api/ws/upload"
 
 	// Connect to WebSocket
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	ui.Debugf("connecting to %s", wsURL)
+	conn, err := client.DialWebSocket(wsURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to connect: %w", err)
 	}
 	defer conn.Close()
+	ui.Debugf("connected in %s", time.Since(started).Round(time.Millisecond))
 
 	// Step 1: Initialize upload with optional custom fileID
+	streaming := fileSize < 0
+	resumable := h.source != "" && !streaming && h.compression == "" && h.config.ResumableUploads
 	initMsg := map[string]interface{}{
-		"type": "init",
-		"size": fileSize,
+		"type":            "init",
+		"protocolVersion": client.ProtocolVersion,
+		"window":          max(1, h.window),
+	}
+	if streaming {
+		initMsg["streaming"] = true
+	} else {
+		initMsg["size"] = fileSize
 	}
 	if customFileID != "" {
 		initMsg["fileId"] = customFileID
 	}
+	if h.expiresIn > 0 {
+		initMsg["expiresIn"] = int64(h.expiresIn / time.Second)
+	}
+	if h.maxDownloads > 0 {
+		initMsg["maxDownloads"] = h.maxDownloads
+	}
+	if resumable {
+		initMsg["resumable"] = true
+	}
+	if h.chunkSize > 0 {
+		initMsg["chunkSize"] = h.chunkSize
+	}
+	ui.Debugf("-> init size=%d streaming=%t resumable=%t custom_id=%t expires_in=%v max_downloads=%d", fileSize, streaming, resumable, customFileID != "", h.expiresIn, h.maxDownloads)
 	if err := conn.WriteJSON(initMsg); err != nil {
 		return "", fmt.Errorf("failed to send init: %w", err)
 	}
@@ -111,10 +361,21 @@ func (h *Handler) uploadFileWithID(reader io.Reader, filename string, contentTyp
 		return "", fmt.Errorf("failed to read init response: %w", err)
 	}
 
+	if initResp["type"] == "error" {
+		ui.Debugf("<- init error code=%v: %v", initResp["code"], initResp["error"])
+		if initResp["code"] == "id_in_use" {
+			return "", ErrIDInUse
+		}
+		return "", client.Mark(fmt.Errorf("server rejected upload: %v", initResp["error"]), client.ErrRejected)
+	}
 	fileID, ok := initResp["id"].(string)
 	if !ok {
 		return "", errors.New("invalid init response")
 	}
+	if err := h.negotiate(initResp); err != nil {
+		return "", err
+	}
+	ui.Debugf("<- init accepted, id=%s", fileID)
 
 	// Step 2: Generate and send HMAC token
 	token, err := crypto.GenerateHMACToken(fileID, key)
@@ -134,19 +395,37 @@ func (h *Handler) uploadFileWithID(reader io.Reader, filename string, contentTyp
 	if err := conn.ReadJSON(&tokenResp); err != nil {
 		return "", fmt.Errorf("failed to read token response: %w", err)
 	}
+	ui.Debugf("<- token %v", tokenResp["type"])
 
-	// Step 3: Encrypt and send metadata
+	// Step 3: Encrypt and send metadata. The metadata is sealed before any
+	// data is read, so a streamed upload records size 0 (unknown).
 	metadata := types.Metadata{
 		Filename:    filename,
 		ContentType: contentType,
-		Size:        fileSize,
+		Size:        max(plainSize, 0),
+		Mode:        uint32(h.mode),
+		SHA256:      digest,
+		Compression: h.compression,
+		Lang:        h.lang,
+		Title:       h.title,
+		ChunkSize:   h.chunkMB(),
+		ChunkKeys:   h.chunkKeys,
+	}
+	if !h.modTime.IsZero() {
+		metadata.ModTime = h.modTime.Unix()
+	}
+	metadataJSON, err := crypto.MarshalMetadata(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata: %w", err)
 	}
-	metadataJSON, _ := json.Marshal(metadata)
 
 	encryptedMetadataHeader, err := crypto.EncryptMetadata(key, metadataJSON)
 	if err != nil {
 		return "", fmt.Errorf("failed to encrypt metadata: %w", err)
 	}
+	if limit := h.config.MaxMetadataSize; limit > 0 && len(encryptedMetadataHeader)-16 > limit {
+		return "", client.Mark(fmt.Errorf("metadata is %d bytes, above the server's limit of %d", len(encryptedMetadataHeader)-16, limit), client.ErrRejected)
+	}
 
 	if err := conn.WriteMessage(websocket.BinaryMessage, encryptedMetadataHeader); err != nil {
 		return "", fmt.Errorf("failed to send metadata: %w", err)
@@ -156,9 +435,14 @@ func (h *Handler) uploadFileWithID(reader io.Reader, filename string, contentTyp
 	if err := conn.ReadJSON(&metadataResp); err != nil {
 		return "", fmt.Errorf("failed to read metadata response: %w", err)
 	}
+	ui.Debugf("<- metadata %v (%d bytes sealed)", metadataResp["type"], len(encryptedMetadataHeader))
 
 	// Step 4: Create streaming cipher and send IV
-	streamCipher, err := crypto.NewStreamCipher(key)
+	opts, err := crypto.ChunkKeyOptions(h.chunkKeys)
+	if err != nil {
+		return "", err
+	}
+	streamCipher, err := crypto.NewStreamCipher(key, opts...)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -167,41 +451,153 @@ func (h *Handler) uploadFileWithID(reader io.Reader, filename string, contentTyp
 	if err := conn.WriteMessage(websocket.BinaryMessage, streamCipher.IV()); err != nil {
 		return "", fmt.Errorf("failed to send IV: %w", err)
 	}
+	ui.Debugf("-> IV sent, streaming with %d MB chunks, window %d", h.chunkMB(), h.uploadWindow())
+
+	if resumable {
+		h.state = h.newState(fileID, token, key, streamCipher.IV(), filename, contentType, fileSize)
+	}
+
+	totalRead, finalResp, err := h.sendChunks(conn, reader, streamCipher, fileSize, 0)
+	if err != nil {
+		return "", err
+	}
+	if h.compression != "" {
+		ui.Debugf("compressed %d bytes to %d with %s", plain.n, totalRead, h.compression)
+	}
+	h.finish(fileID, plain.n, hasher, started, finalResp)
+	if digest != "" && h.result.SHA256 != digest {
+		return "", fmt.Errorf("%s changed while it was being uploaded; the download will fail its checksum check", filename)
+	}
+
+	return fileID, nil
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
 
+// digestOf returns the hex SHA-256 of what is left to read from r when r
+// can seek, leaving r where it was; otherwise it returns "".
+func digestOf(r io.Reader) (string, error) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return "", nil
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", nil
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, rs); err != nil {
+		return "", err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// sendChunks encrypts reader chunk by chunk, sends it followed by the end
+// marker, and returns the completion message. sent is the number of
+// plaintext bytes the server already holds, non-zero when resuming; the
+// returned total includes them.
+func (h *Handler) sendChunks(conn *websocket.Conn, reader io.Reader, streamCipher *crypto.StreamCipher, fileSize, sent int64) (int64, map[string]interface{}, error) {
 	// Step 5: Stream encrypted chunks. We keep one chunk buffered ahead so we
 	// can mark the final chunk's isFinal=true at encrypt time — required by
 	// the v2 STREAM nonce construction. Without lookahead, a file whose size
 	// is an exact multiple of chunkSize would have its last chunk encrypted
 	// with isFinal=false and the receiver would (correctly) reject it.
-	chunkSize := h.config.ChunkSize * 1024 * 1024
+	chunkSize := h.chunkMB() * 1024 * 1024
 	buffer := make([]byte, chunkSize)
+	reader = ratelimit.Reader(reader, h.limiter)
 	var pending []byte
 	hasPending := false
 
 	bar := ui.NewProgressBar(fileSize, "Uploading")
-
+	bar.Update(sent)
+
+	// Up to `window` chunks may be in flight before we wait for an ack.
+	// The server processes frames strictly in order, so pipelining only
+	// hides round-trip latency; it never reorders data.
+	window := h.uploadWindow()
+	cumulative := h.cumulativeAcks()
+	h.timing = Timing{}
+	var sentAt []time.Time // send times of unacked chunks, oldest first
+	chunks, acked := 0, 0
+	// handleAck takes acked chunks off the in-flight list. A cumulative
+	// ack covers every chunk up to the count it carries.
+	handleAck := func(ackResp map[string]interface{}) error {
+		n := acked + 1
+		if cumulative {
+			count, _ := ackResp["chunks"].(float64)
+			n = int(count)
+		}
+		if n <= acked || n > chunks {
+			return fmt.Errorf("invalid ack from server: %v", ackResp)
+		}
+		// The server shrinks the window while its disk falls behind
+		if w, ok := ackResp["window"].(float64); ok && cumulative && int(w) >= 1 && int(w) != window {
+			ui.Debugf("<- window %d", int(w))
+			window = int(w)
+		}
+		latency := time.Since(sentAt[n-acked-1])
+		h.timing.AckLatency = append(h.timing.AckLatency, latency)
+		ui.Debugf("chunk %d acked in %s (%d in flight)", n, latency.Round(time.Millisecond), chunks-n)
+		sentAt = sentAt[n-acked:]
+		if h.state != nil {
+			h.state.Chunks += int64(n - acked)
+			h.saveState()
+		}
+		acked = n
+		return nil
+	}
+	readAck := func() error {
+		var ackResp map[string]interface{}
+		waitStarted := time.Now()
+		if err := conn.ReadJSON(&ackResp); err != nil {
+			return fmt.Errorf("failed to read ack: %w", err)
+		}
+		h.timing.AckWait += time.Since(waitStarted)
+		if ackResp["type"] == "error" {
+			// e.g. a streamed upload crossing the server's size limit
+			return client.Mark(fmt.Errorf("server rejected upload: %v", ackResp["error"]), client.ErrRejected)
+		}
+		return handleAck(ackResp)
+	}
 	sendChunk := func(data []byte, isFinal bool) error {
+		encryptStarted := time.Now()
 		encryptedChunk, err := streamCipher.EncryptChunk(data, isFinal)
 		if err != nil {
 			return fmt.Errorf("failed to encrypt chunk: %w", err)
 		}
+		h.timing.Encrypt += time.Since(encryptStarted)
 		if err := conn.WriteMessage(websocket.BinaryMessage, encryptedChunk); err != nil {
+			if reason := serverError(conn); reason != "" {
+				return client.Mark(fmt.Errorf("server rejected upload: %s", reason), client.ErrRejected)
+			}
 			return fmt.Errorf("failed to send chunk: %w", err)
 		}
-		var ackResp map[string]interface{}
-		if err := conn.ReadJSON(&ackResp); err != nil {
-			return fmt.Errorf("failed to read ack: %w", err)
+		chunks++
+		sentAt = append(sentAt, time.Now())
+		ui.Debugf("chunk %d: %d bytes final=%t sent", chunks, len(data), isFinal)
+		for len(sentAt) >= window {
+			if err := readAck(); err != nil {
+				return err
+			}
 		}
 		return nil
 	}
 
-	var totalRead int64
+	totalRead := sent
 	for {
 		n, err := io.ReadFull(reader, buffer)
 		if err == io.EOF {
 			if hasPending {
 				if sendErr := sendChunk(pending, true); sendErr != nil {
-					return "", sendErr
+					return 0, nil, sendErr
 				}
 				totalRead += int64(len(pending))
 				bar.Update(totalRead)
@@ -211,25 +607,25 @@ func (h *Handler) uploadFileWithID(reader io.Reader, filename string, contentTyp
 		if err == io.ErrUnexpectedEOF {
 			if hasPending {
 				if sendErr := sendChunk(pending, false); sendErr != nil {
-					return "", sendErr
+					return 0, nil, sendErr
 				}
 				totalRead += int64(len(pending))
 				bar.Update(totalRead)
 			}
 			if sendErr := sendChunk(buffer[:n], true); sendErr != nil {
-				return "", sendErr
+				return 0, nil, sendErr
 			}
 			totalRead += int64(n)
 			bar.Update(totalRead)
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("failed to read data: %w", err)
+			return 0, nil, fmt.Errorf("failed to read data: %w", err)
 		}
 
 		if hasPending {
 			if sendErr := sendChunk(pending, false); sendErr != nil {
-				return "", sendErr
+				return 0, nil, sendErr
 			}
 			totalRead += int64(len(pending))
 			bar.Update(totalRead)
@@ -237,23 +633,81 @@ func (h *Handler) uploadFileWithID(reader io.Reader, filename string, contentTyp
 		pending = append(pending[:0], buffer[:n]...)
 		hasPending = true
 	}
+	// Cumulative acks leave the last chunks to the completion message.
+	for !cumulative && len(sentAt) > 0 {
+		if err := readAck(); err != nil {
+			return 0, nil, err
+		}
+	}
 	bar.Finish()
+	ui.Debugf("sent %d chunks, %d bytes", chunks, totalRead-sent)
 
 	// Step 6: Send end-of-upload marker
 	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{0x00}); err != nil {
-		return "", fmt.Errorf("failed to send end marker: %w", err)
+		return 0, nil, fmt.Errorf("failed to send end marker: %w", err)
 	}
 
 	var finalResp map[string]interface{}
-	if err := conn.ReadJSON(&finalResp); err != nil {
-		return "", fmt.Errorf("failed to read final response: %w", err)
+	for {
+		finalResp = nil
+		if err := conn.ReadJSON(&finalResp); err != nil {
+			return 0, nil, fmt.Errorf("failed to read final response: %w", err)
+		}
+		if finalResp["type"] != "ack" {
+			break
+		}
+		if err := handleAck(finalResp); err != nil {
+			return 0, nil, err
+		}
+	}
+	if finalResp["type"] == "error" {
+		return 0, nil, client.Mark(fmt.Errorf("server rejected upload: %v", finalResp["error"]), client.ErrRejected)
 	}
+	return totalRead, finalResp, nil
+}
 
-	return fileID, nil
+// finish records the result of a completed upload and drops its resume
+// state.
+func (h *Handler) finish(fileID string, size int64, hasher hash.Hash, started time.Time, finalResp map[string]interface{}) {
+	ui.Debugf("<- %v after %s", finalResp["type"], time.Since(started).Round(time.Millisecond))
+	h.result = Result{
+		FileID:   fileID,
+		Size:     size,
+		SHA256:   hex.EncodeToString(hasher.Sum(nil)),
+		Duration: time.Since(started),
+		Timing:   h.timing,
+	}
+	if exp, ok := finalResp["expiresAt"].(string); ok {
+		h.result.ExpiresAt, _ = time.Parse(time.RFC3339, exp)
+	}
+	if n, ok := finalResp["maxDownloads"].(float64); ok {
+		h.result.MaxDownloads = int(n)
+	}
+	h.result.OwnerToken, _ = finalResp["ownerToken"].(string)
+	if h.state != nil {
+		h.state.Remove()
+		h.state = nil
+	}
+}
+
+// serverError looks for an error frame the server sent before closing the
+// connection. With chunks in flight the close races our writes, so the
+// write fails first while the reason is still waiting in the read buffer.
+func serverError(conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return ""
+		}
+		if msg["type"] == "error" {
+			return fmt.Sprint(msg["error"])
+		}
+	}
 }
 
 // PrepareInput prepares the input for upload (file or stdin)
-func PrepareInput(filePath, customName string) (io.Reader, string, string, int64, error) {
+func PrepareInput(filePath, customName string, archive ArchiveOptions) (io.Reader, string, string, int64, error) {
 	var reader io.Reader
 	var fileSize int64
 	var filename string
@@ -266,19 +720,20 @@ func PrepareInput(filePath, customName string) (io.Reader, string, string, int64
 		if (stat.Mode() & os.ModeCharDevice) != 0 {
 			return nil, "", "", 0, errors.New("no input provided (use -f or pipe data to stdin)")
 		}
-		reader = os.Stdin
 		filename = "stdin.txt"
 
-		// For stdin, we need to buffer to determine size
-		data, err := io.ReadAll(reader)
-		if err != nil {
+		// Stream stdin instead of buffering it: peek only as much as
+		// content sniffing needs and leave the size unknown.
+		br := bufio.NewReaderSize(os.Stdin, 512)
+		head, err := br.Peek(512)
+		if err != nil && err != io.EOF {
 			return nil, "", "", 0, fmt.Errorf("failed to read stdin: %w", err)
 		}
-		fileSize = int64(len(data))
-		reader = strings.NewReader(string(data))
+		reader = br
+		fileSize = SizeUnknown
 
 		// Detect content type from data
-		contentType = http.DetectContentType(data)
+		contentType = http.DetectContentType(head)
 
 		// If we detected a content type and no custom name, update filename extension
 		if contentType != "application/octet-stream" && customName == "" {
@@ -295,16 +750,14 @@ func PrepareInput(filePath, customName string) (io.Reader, string, string, int64
 
 		if stat.IsDir() {
 			// Directory - create tar.gz archive
-			fmt.Fprintf(os.Stderr, "Compressing directory: %s\n", filePath)
-			archiveData, err := createTarGz(filePath)
+			ui.Infof("Compressing directory: %s\n", filePath)
+			fileSize = SizeUnknown
+			filename = filepath.Base(filePath) + ".tar.gz"
+			contentType = "application/gzip"
+			reader, err = streamTarGz([]string{filePath}, archive)
 			if err != nil {
 				return nil, "", "", 0, fmt.Errorf("failed to create archive: %w", err)
 			}
-
-			fileSize = int64(len(archiveData))
-			filename = filepath.Base(filePath) + ".tar.gz"
-			contentType = "application/gzip"
-			reader = bytes.NewReader(archiveData)
 		} else {
 			// Regular file
 			file, err := os.Open(filePath)
@@ -339,98 +792,70 @@ func PrepareInput(filePath, customName string) (io.Reader, string, string, int64
 	return reader, filename, contentType, fileSize, nil
 }
 
-func getExtensionFromContentType(contentType string) string {
-	contentTypeMap := map[string]string{
-		"image/jpeg":           ".jpg",
-		"image/jpg":            ".jpg",
-		"image/png":            ".png",
-		"image/gif":            ".gif",
-		"image/webp":           ".webp",
-		"image/svg+xml":        ".svg",
-		"video/mp4":            ".mp4",
-		"video/mpeg":           ".mpeg",
-		"video/webm":           ".webm",
-		"video/quicktime":      ".mov",
-		"audio/mpeg":           ".mp3",
-		"audio/wav":            ".wav",
-		"audio/ogg":            ".ogg",
-		"application/pdf":      ".pdf",
-		"application/zip":      ".zip",
-		"application/x-gzip":   ".gz",
-		"application/x-tar":    ".tar",
-		"text/plain":           ".txt",
-		"text/html":            ".html",
-		"text/css":             ".css",
-		"text/javascript":      ".js",
-		"application/json":     ".json",
-		"application/xml":      ".xml",
+// PrepareBundle packs several files and directories into one streamed
+// tar.gz, each stored under its base name.
+func PrepareBundle(paths []string, customName string, archive ArchiveOptions) (io.Reader, string, string, int64, error) {
+	ui.Infof("Bundling %d inputs\n", len(paths))
+	reader, err := streamTarGz(paths, archive)
+	if err != nil {
+		return nil, "", "", 0, fmt.Errorf("failed to create archive: %w", err)
 	}
-
-	if ext, ok := contentTypeMap[contentType]; ok {
-		return ext
+	filename := "bundle.tar.gz"
+	if customName != "" {
+		filename = customName
 	}
-	return ""
+	return reader, filename, "application/gzip", SizeUnknown, nil
 }
 
-// createTarGz creates a tar.gz archive of a directory
-func createTarGz(dirPath string) ([]byte, error) {
-	var buf bytes.Buffer
-	gzWriter := gzip.NewWriter(&buf)
-	tarWriter := tar.NewWriter(gzWriter)
-
-	// Get the base directory name for the archive
-	baseDir := filepath.Base(dirPath)
-
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
-		}
-
-		// Update the name to be relative to the base directory
-		relPath, err := filepath.Rel(dirPath, path)
-		if err != nil {
-			return err
-		}
-		header.Name = filepath.Join(baseDir, relPath)
-
-		// Write header
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
-		}
-
-		// If it's a file, write its contents
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			if _, err := io.Copy(tarWriter, file); err != nil {
-				return err
-			}
-		}
-
-		return nil
-	})
-
+// Spool copies a stream of unknown size to a temporary file so it can be
+// uploaded to servers without streaming support. The caller must close and
+// remove the returned file.
+func Spool(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "pastectl-spool-*")
 	if err != nil {
-		return nil, err
+		return nil, 0, fmt.Errorf("failed to create spool file: %w", err)
 	}
-
-	// Close writers
-	if err := tarWriter.Close(); err != nil {
-		return nil, err
+	n, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, fmt.Errorf("failed to spool input: %w", err)
 	}
-	if err := gzWriter.Close(); err != nil {
-		return nil, err
+	return f, n, nil
+}
+
+func getExtensionFromContentType(contentType string) string {
+	contentTypeMap := map[string]string{
+		"image/jpeg":         ".jpg",
+		"image/jpg":          ".jpg",
+		"image/png":          ".png",
+		"image/gif":          ".gif",
+		"image/webp":         ".webp",
+		"image/svg+xml":      ".svg",
+		"video/mp4":          ".mp4",
+		"video/mpeg":         ".mpeg",
+		"video/webm":         ".webm",
+		"video/quicktime":    ".mov",
+		"audio/mpeg":         ".mp3",
+		"audio/wav":          ".wav",
+		"audio/ogg":          ".ogg",
+		"application/pdf":    ".pdf",
+		"application/zip":    ".zip",
+		"application/x-gzip": ".gz",
+		"application/x-tar":  ".tar",
+		"text/plain":         ".txt",
+		"text/html":          ".html",
+		"text/css":           ".css",
+		"text/javascript":    ".js",
+		"application/json":   ".json",
+		"application/xml":    ".xml",
 	}
 
-	return buf.Bytes(), nil
+	if ext, ok := contentTypeMap[contentType]; ok {
+		return ext
+	}
+	return ""
 }
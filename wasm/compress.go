@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"syscall/js"
+
+	pastecrypto "github.com/jonasbg/paste/crypto"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Whole-stream compression.
+//
+// A file whose metadata names a "compression" algorithm was compressed as
+// one stream before it was chunked, the way pastectl does it, so its
+// chunks are pieces of that stream. Uploads feed the plaintext to a
+// compression stream and chunk what comes out; downloads feed the
+// decrypted chunks to a decompression stream in order. What comes back for
+// a call is whatever output the codec has released so far, which may be
+// empty, and the last call returns the rest.
+
+var (
+	errInvalidCodecID   = newError(ErrCodeStreamState, "invalid compression stream ID")
+	errWrongDirection   = newError(ErrCodeStreamState, "compression stream used in the wrong direction")
+	errUnknownAlgorithm = newError(ErrCodeBadArgs, "unknown compression algorithm")
+)
+
+// codec is one direction of a compression stream.
+type codec interface {
+	// feed passes the next piece of input and returns the output it
+	// released; isLast ends the stream.
+	feed(data []byte, isLast bool) ([]byte, error)
+	// abort stops a stream that won't be finished.
+	abort()
+}
+
+// CodecRegistry holds compression streams keyed by a numeric handle,
+// mirroring CipherRegistry.
+type CodecRegistry struct {
+	mu      sync.Mutex
+	streams map[int]*codecStream
+	nextID  int
+}
+
+type codecStream struct {
+	codec
+	compress bool
+	busy     bool
+}
+
+var codecRegistry = &CodecRegistry{
+	streams: make(map[int]*codecStream),
+	nextID:  1,
+}
+
+func (r *CodecRegistry) add(c codec, compress bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.nextID
+	r.nextID++
+	r.streams[id] = &codecStream{codec: c, compress: compress}
+	return id
+}
+
+// take marks the stream busy for one feed, so calls can't overlap.
+func (r *CodecRegistry) take(id int, compress bool) (*codecStream, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.streams[id]
+	if !ok {
+		return nil, errInvalidCodecID
+	}
+	if s.compress != compress {
+		return nil, errWrongDirection
+	}
+	if s.busy {
+		return nil, errChunkInFlight
+	}
+	s.busy = true
+	return s, nil
+}
+
+func (r *CodecRegistry) remove(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, id)
+}
+
+// algorithmArg reads the algorithm argument of the create functions.
+func algorithmArg(args []js.Value) (string, error) {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return "", errInvalidArgs
+	}
+	algo := args[0].String()
+	switch algo {
+	case pastecrypto.CompressionZstd, pastecrypto.CompressionGzip:
+		return algo, nil
+	}
+	return "", errUnknownAlgorithm.withDetails(map[string]interface{}{"algorithm": algo})
+}
+
+// createCompressStream(algo) -> id
+func createCompressStream(_ js.Value, args []js.Value) interface{} {
+	algo, err := algorithmArg(args)
+	if err != nil {
+		return handleError(err)
+	}
+	c, err := newCompressor(algo)
+	if err != nil {
+		return handleError(err)
+	}
+	return js.ValueOf(codecRegistry.add(c, true))
+}
+
+// createDecompressStream(algo) -> id
+func createDecompressStream(_ js.Value, args []js.Value) interface{} {
+	algo, err := algorithmArg(args)
+	if err != nil {
+		return handleError(err)
+	}
+	return js.ValueOf(codecRegistry.add(newDecompressor(algo), false))
+}
+
+// compressChunk(id, data, isLast) -> Promise<Uint8Array>. The stream is
+// released after the last chunk or a failure.
+func compressChunk(_ js.Value, args []js.Value) interface{} {
+	return feedCodec(args, true)
+}
+
+// decompressChunk(id, data, isLast) -> Promise<Uint8Array>. The stream is
+// released after the last chunk or a failure.
+func decompressChunk(_ js.Value, args []js.Value) interface{} {
+	return feedCodec(args, false)
+}
+
+func feedCodec(args []js.Value, compress bool) interface{} {
+	if len(args) != 3 || args[1].Type() != js.TypeObject {
+		return rejected(errInvalidArgs)
+	}
+	id := args[0].Int()
+	s, err := codecRegistry.take(id, compress)
+	if err != nil {
+		return rejected(err)
+	}
+	data := make([]byte, args[1].Length())
+	js.CopyBytesToGo(data, args[1])
+	isLast := args[2].Bool()
+
+	return newPromise(func() (js.Value, error) {
+		defer zero(data)
+		out, err := s.feed(data, isLast)
+		defer zero(out)
+		if err != nil || isLast {
+			if err != nil {
+				s.abort()
+			}
+			codecRegistry.remove(id)
+		} else {
+			s.busy = false
+		}
+		if err != nil {
+			return js.Undefined(), err
+		}
+		arr := js.Global().Get("Uint8Array").New(len(out))
+		js.CopyBytesToJS(arr, out)
+		return arr, nil
+	})
+}
+
+// disposeCodec releases a compression stream that won't be finished, such
+// as a cancelled download's.
+func disposeCodec(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return handleError(errInvalidArgs)
+	}
+	id := args[0].Int()
+	codecRegistry.mu.Lock()
+	s, ok := codecRegistry.streams[id]
+	delete(codecRegistry.streams, id)
+	codecRegistry.mu.Unlock()
+	if ok {
+		s.abort()
+	}
+	return js.ValueOf(ok)
+}
+
+// compressor writes through the encoder into a buffer; encoding needs no
+// goroutine of its own.
+type compressor struct {
+	enc io.WriteCloser
+	out bytes.Buffer
+}
+
+func newCompressor(algo string) (*compressor, error) {
+	c := &compressor{}
+	if algo == pastecrypto.CompressionZstd {
+		enc, err := zstd.NewWriter(&c.out, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.enc = enc
+	} else {
+		c.enc = gzip.NewWriter(&c.out)
+	}
+	return c, nil
+}
+
+func (c *compressor) feed(data []byte, isLast bool) ([]byte, error) {
+	if _, err := c.enc.Write(data); err != nil {
+		return nil, err
+	}
+	if isLast {
+		if err := c.enc.Close(); err != nil {
+			return nil, err
+		}
+	}
+	out := bytes.Clone(c.out.Bytes())
+	zero(c.out.Bytes())
+	c.out.Reset()
+	return out, nil
+}
+
+func (c *compressor) abort() {
+	c.enc.Close()
+	zero(c.out.Bytes())
+	c.out.Reset()
+}
+
+// decompressor runs the decoder on its own goroutine, reading the input
+// feed writes into a pipe: gzip and zstd pull their input, and this is how
+// they are given it a chunk at a time. Chunk calls run off the event loop
+// (see newPromise), so blocking on the pipe is fine.
+type decompressor struct {
+	pw   *io.PipeWriter
+	done chan error
+	out  lockedBuffer
+}
+
+func newDecompressor(algo string) *decompressor {
+	pr, pw := io.Pipe()
+	d := &decompressor{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := decompress(&d.out, pr, algo)
+		// Unblock feed if decoding stopped early.
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+	return d
+}
+
+func (d *decompressor) feed(data []byte, isLast bool) ([]byte, error) {
+	if len(data) > 0 {
+		if _, err := d.pw.Write(data); err != nil {
+			if derr := <-d.done; derr != nil {
+				return nil, derr
+			}
+			// The decoder reached the end of the stream before the input did.
+			return nil, newError(ErrCodeBadFormat, "data after the end of the compressed stream")
+		}
+	}
+	if isLast {
+		d.pw.Close()
+		if err := <-d.done; err != nil {
+			return nil, err
+		}
+	}
+	return d.out.take(), nil
+}
+
+func (d *decompressor) abort() {
+	d.pw.CloseWithError(errCancelled)
+	zero(d.out.take())
+}
+
+func decompress(w io.Writer, r io.Reader, algo string) error {
+	var dec io.Reader
+	if algo == pastecrypto.CompressionZstd {
+		// One goroutine: the codec's own workers buy nothing in WASM.
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		dec = zr
+	} else {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return corrupt(err)
+		}
+		defer gr.Close()
+		dec = gr
+	}
+	if _, err := io.Copy(w, dec); err != nil {
+		return corrupt(err)
+	}
+	return nil
+}
+
+// corrupt reports a decoder failure, keeping cancellation as it is.
+func corrupt(err error) error {
+	if errors.Is(err, errCancelled) {
+		return errCancelled
+	}
+	return newError(ErrCodeBadFormat, "corrupt compressed data: "+err.Error())
+}
+
+// lockedBuffer collects output written on one goroutine and taken on another.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// take returns the output so far and empties the buffer.
+func (b *lockedBuffer) take() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := bytes.Clone(b.buf.Bytes())
+	zero(b.buf.Bytes())
+	b.buf.Reset()
+	return out
+}
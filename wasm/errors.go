@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"syscall/js"
+)
+
+// Stable error codes surfaced to JavaScript as Error.code. The frontend
+// branches on these, so they must not change once published.
+const (
+	ErrCodeBadArgs     = "ERR_BAD_ARGS"
+	ErrCodeBadKey      = "ERR_BAD_KEY"
+	ErrCodeAuthFail    = "ERR_AUTH_FAIL"
+	ErrCodeStreamState = "ERR_STREAM_STATE"
+	ErrCodeBadFormat   = "ERR_BAD_FORMAT"
+	ErrCodeCancelled   = "ERR_CANCELLED"
+	ErrCodeInternal    = "ERR_INTERNAL"
+)
+
+// codedError carries a stable code plus optional structured details.
+type codedError struct {
+	code    string
+	msg     string
+	details map[string]interface{}
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+func newError(code, msg string) *codedError {
+	return &codedError{code: code, msg: msg}
+}
+
+// withDetails returns a copy of e with details attached.
+func (e *codedError) withDetails(details map[string]interface{}) *codedError {
+	return &codedError{code: e.code, msg: e.msg, details: details}
+}
+
+var (
+	errInvalidArgs      = newError(ErrCodeBadArgs, "invalid arguments")
+	errInvalidCipherID  = newError(ErrCodeStreamState, "invalid cipher ID")
+	errInvalidHashID    = newError(ErrCodeStreamState, "invalid hash ID")
+	errCounterExhausted = newError(ErrCodeStreamState, "chunk counter exhausted")
+	errChunkInFlight    = newError(ErrCodeStreamState, "previous chunk still in progress")
+	errAuthFailed       = newError(ErrCodeAuthFail, "authentication failed: wrong key or corrupted data")
+)
+
+// classify maps an arbitrary error onto a coded error.
+func classify(err error) *codedError {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce
+	}
+
+	var b64Err base64.CorruptInputError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	// crypto/cipher does not export its auth failure sentinel.
+	case err.Error() == "cipher: message authentication failed":
+		return errAuthFailed
+	case errors.As(err, &b64Err):
+		return newError(ErrCodeBadKey, "invalid key encoding")
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return newError(ErrCodeBadFormat, err.Error())
+	default:
+		return newError(ErrCodeInternal, err.Error())
+	}
+}
+
+// handleError converts err into a JS Error with `code` and `details`
+// properties so the UI can branch without string matching.
+func handleError(err error) interface{} {
+	ce := classify(err)
+	jsErr := js.Global().Get("Error").New(ce.msg)
+	jsErr.Set("code", ce.code)
+	if ce.details != nil {
+		jsErr.Set("details", js.ValueOf(ce.details))
+	} else {
+		jsErr.Set("details", js.ValueOf(map[string]interface{}{}))
+	}
+	return jsErr
+}
@@ -1,7 +1,13 @@
 module paste-wasm
 
-go 1.25.9
+go 1.26
 
-require golang.org/x/crypto v0.51.0
+require (
+	github.com/jonasbg/paste/crypto v0.0.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.51.0
+)
 
 require golang.org/x/sys v0.44.0 // indirect
+
+replace github.com/jonasbg/paste/crypto => ../crypto
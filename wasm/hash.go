@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"hash"
+	"sync"
+	"syscall/js"
+)
+
+// HashRegistry holds in-progress SHA-256 digests keyed by a numeric handle,
+// mirroring CipherRegistry so JS can hash plaintext chunk-by-chunk while it
+// encrypts, without reading the file twice.
+type HashRegistry struct {
+	mu     sync.Mutex
+	hashes map[int]hash.Hash
+	nextID int
+	buf    []byte
+}
+
+var hashRegistry = &HashRegistry{
+	hashes: make(map[int]hash.Hash),
+	nextID: 1,
+}
+
+// createHashStream starts a new SHA-256 digest and returns its handle.
+func createHashStream(_ js.Value, _ []js.Value) interface{} {
+	hashRegistry.mu.Lock()
+	defer hashRegistry.mu.Unlock()
+	id := hashRegistry.nextID
+	hashRegistry.nextID++
+	hashRegistry.hashes[id] = sha256.New()
+	return js.ValueOf(id)
+}
+
+// updateHash feeds a Uint8Array into the digest identified by hashId.
+// Arguments: (hashId, data).
+func updateHash(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return handleError(errInvalidArgs)
+	}
+
+	hashRegistry.mu.Lock()
+	defer hashRegistry.mu.Unlock()
+	h, ok := hashRegistry.hashes[args[0].Int()]
+	if !ok {
+		return handleError(errInvalidHashID)
+	}
+
+	n := args[1].Length()
+	if n > len(hashRegistry.buf) {
+		hashRegistry.buf = make([]byte, n)
+	}
+	data := hashRegistry.buf[:n]
+	js.CopyBytesToGo(data, args[1])
+	h.Write(data)
+	zero(data)
+	return js.ValueOf(true)
+}
+
+// finalizeHash returns the lowercase hex SHA-256 digest and releases the handle.
+func finalizeHash(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return handleError(errInvalidArgs)
+	}
+
+	hashRegistry.mu.Lock()
+	defer hashRegistry.mu.Unlock()
+	id := args[0].Int()
+	h, ok := hashRegistry.hashes[id]
+	if !ok {
+		return handleError(errInvalidHashID)
+	}
+	delete(hashRegistry.hashes, id)
+	return js.ValueOf(hex(h.Sum(nil)))
+}
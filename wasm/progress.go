@@ -0,0 +1,79 @@
+package main
+
+import (
+	"syscall/js"
+)
+
+var errCancelled = newError(ErrCodeCancelled, "operation cancelled")
+
+// progressState tracks per-stream progress reporting and cancellation for
+// long encrypt/decrypt operations driven from JavaScript.
+type progressState struct {
+	callback  js.Value // function(processed, total) or undefined
+	signal    js.Value // AbortSignal or undefined
+	total     int64
+	processed int64
+	cancelled bool
+}
+
+// check reports errCancelled once the stream was cancelled, either through
+// cancelStream or the AbortSignal passed to watchStream.
+func (p *progressState) check() error {
+	if p.cancelled {
+		return errCancelled
+	}
+	if p.signal.Truthy() && p.signal.Get("aborted").Bool() {
+		p.cancelled = true
+		return errCancelled
+	}
+	return nil
+}
+
+// advance records n processed input bytes and notifies the callback.
+func (p *progressState) advance(n int) {
+	p.processed += int64(n)
+	if p.callback.Type() == js.TypeFunction {
+		p.callback.Invoke(p.processed, p.total)
+	}
+}
+
+// watchStream registers a progress callback and an optional cancel token.
+// Arguments: (cipherId, { onProgress?, total?, signal? }) where onProgress is
+// called as onProgress(processedBytes, total) after every chunk and signal is
+// an AbortSignal; aborting it fails the next chunk call and disposes the stream.
+func watchStream(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 2 || args[1].Type() != js.TypeObject {
+		return handleError(errInvalidArgs)
+	}
+
+	registry.mu.Lock()
+	sc, exists := registry.ciphers[args[0].Int()]
+	registry.mu.Unlock()
+	if !exists {
+		return handleError(errInvalidCipherID)
+	}
+
+	opts := args[1]
+	sc.progress.callback = opts.Get("onProgress")
+	sc.progress.signal = opts.Get("signal")
+	if total := opts.Get("total"); total.Type() == js.TypeNumber {
+		sc.progress.total = int64(total.Float())
+	}
+	return js.ValueOf(true)
+}
+
+// cancelStream aborts a stream so that the next chunk call fails with
+// "operation cancelled". Unknown or already-finished streams are a no-op.
+func cancelStream(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return handleError(errInvalidArgs)
+	}
+
+	registry.mu.Lock()
+	sc, exists := registry.ciphers[args[0].Int()]
+	registry.mu.Unlock()
+	if exists {
+		sc.progress.cancelled = true
+	}
+	return js.ValueOf(exists)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/cipher"
+	"errors"
+	"syscall/js"
+)
+
+// Web Crypto fast path.
+//
+// AES-GCM in Go-compiled WASM runs several times slower than the browser's
+// native SubtleCrypto. The async chunk functions below hand bulk chunk
+// encryption to crypto.subtle when it is available and fall back to the Go
+// AEAD otherwise (old browsers, insecure contexts). Nonces, AAD, and the
+// ciphertext||tag layout are identical on both paths, so a stream may mix them.
+
+// subtleCrypto returns crypto.subtle, or undefined when unavailable.
+func subtleCrypto() js.Value {
+	c := js.Global().Get("crypto")
+	if c.IsUndefined() || c.IsNull() {
+		return js.Undefined()
+	}
+	s := c.Get("subtle")
+	if s.IsNull() {
+		return js.Undefined()
+	}
+	return s
+}
+
+func hasWebCrypto(_ js.Value, _ []js.Value) interface{} {
+	return js.ValueOf(!subtleCrypto().IsUndefined())
+}
+
+// importSubtleKey starts importing key as a non-extractable AES-GCM CryptoKey.
+// The returned Promise is awaited lazily on the first async chunk.
+func importSubtleKey(key []byte) js.Value {
+	subtle := subtleCrypto()
+	if subtle.IsUndefined() {
+		return js.Undefined()
+	}
+	raw := js.Global().Get("Uint8Array").New(len(key))
+	js.CopyBytesToJS(raw, key)
+	p := subtle.Call("importKey", "raw", raw,
+		map[string]interface{}{"name": "AES-GCM"}, false,
+		[]interface{}{"encrypt", "decrypt"})
+	// Wipe the JS-side copy once the import has consumed it.
+	zeroJS(raw)
+	return p
+}
+
+func zeroJS(arr js.Value) {
+	arr.Call("fill", 0)
+}
+
+// newPromise runs fn on its own goroutine and settles a JS Promise with the
+// result. Blocking on other Promises is only allowed off the event-loop
+// goroutine, hence the indirection.
+func newPromise(fn func() (js.Value, error)) js.Value {
+	executor := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go func() {
+			v, err := fn()
+			if err != nil {
+				reject.Invoke(handleError(err))
+				return
+			}
+			resolve.Invoke(v)
+		}()
+		return nil
+	})
+	// The Promise constructor calls the executor synchronously.
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
+
+// await blocks the calling goroutine until p settles.
+func await(p js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	ch := make(chan result, 1)
+	onFulfilled := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		ch <- result{v: args[0]}
+		return nil
+	})
+	onRejected := js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+		msg := "promise rejected"
+		if len(args) > 0 && args[0].Type() == js.TypeObject && !args[0].Get("message").IsUndefined() {
+			msg = args[0].Get("message").String()
+		}
+		ch <- result{err: errors.New(msg)}
+		return nil
+	})
+	defer onFulfilled.Release()
+	defer onRejected.Release()
+
+	p.Call("then", onFulfilled, onRejected)
+	r := <-ch
+	return r.v, r.err
+}
+
+// chunkJob is the state captured synchronously when an async chunk call is
+// made. Like the sync functions, it only advances the stream once the chunk
+// has been sealed or opened, so a chunk that fails to decrypt can be retried
+// at the same position. A stream runs one job at a time: the nonce of the
+// next chunk isn't known until this one succeeds.
+type chunkJob struct {
+	sc       *StreamingCipher
+	cipherID int
+	gcm      cipher.AEAD
+	subtle   js.Value // Promise<CryptoKey>, or undefined
+	nonce    []byte
+	data     []byte // Go copy for the fallback path
+	jsData   js.Value
+	encrypt  bool
+	isLast   bool
+}
+
+func prepareChunkJob(args []js.Value, encrypt bool) (*chunkJob, error) {
+	if len(args) != 3 {
+		return nil, errInvalidArgs
+	}
+
+	cipherID := args[0].Int()
+	isLast := args[2].Bool()
+
+	registry.mu.Lock()
+	sc, exists := registry.ciphers[cipherID]
+	registry.mu.Unlock()
+	if !exists {
+		return nil, errInvalidCipherID.withDetails(map[string]interface{}{"cipherId": cipherID})
+	}
+	if sc.busy {
+		return nil, errChunkInFlight.withDetails(map[string]interface{}{"cipherId": cipherID})
+	}
+	if sc.chunk >= streamCounterMask {
+		return nil, errCounterExhausted.withDetails(map[string]interface{}{"cipherId": cipherID, "chunk": sc.chunk})
+	}
+	if err := sc.progress.check(); err != nil {
+		disposeByID(cipherID)
+		return nil, err
+	}
+
+	gcm, subtle, err := sc.chunkCipher(true)
+	if err != nil {
+		return nil, err
+	}
+	job := &chunkJob{sc: sc, cipherID: cipherID, gcm: gcm, subtle: subtle, nonce: make([]byte, 12), encrypt: encrypt, isLast: isLast}
+	buildChunkNonce(job.nonce, sc.iv, sc.chunk, isLast)
+	sc.busy = true
+
+	// Snapshot the input: the caller may reuse its buffer before we run.
+	job.data = make([]byte, args[1].Length())
+	js.CopyBytesToGo(job.data, args[1])
+	if !job.subtle.IsUndefined() {
+		job.jsData = js.Global().Get("Uint8Array").New(len(job.data))
+		js.CopyBytesToJS(job.jsData, job.data)
+	}
+	return job, nil
+}
+
+func (j *chunkJob) run() (js.Value, error) {
+	defer zero(j.data)
+	defer func() { j.sc.busy = false }()
+
+	out, err := j.seal()
+	if err != nil {
+		return out, err
+	}
+	j.sc.chunk++
+	j.sc.progress.advance(len(j.data))
+	if j.isLast {
+		disposeByID(j.cipherID)
+	}
+	return out, nil
+}
+
+func (j *chunkJob) seal() (js.Value, error) {
+	if !j.subtle.IsUndefined() {
+		if out, err := j.runSubtle(); err == nil {
+			return out, nil
+		} else if !j.encrypt && err == errSubtleAuth {
+			return js.Undefined(), errAuthFailed
+		}
+		// Any other Web Crypto failure (e.g. importKey rejected): use Go.
+	}
+
+	var out []byte
+	var err error
+	if j.encrypt {
+		out = j.gcm.Seal(nil, j.nonce, j.data, []byte(chunkAAD))
+	} else {
+		out, err = j.gcm.Open(nil, j.nonce, j.data, []byte(chunkAAD))
+		if err != nil {
+			return js.Undefined(), err
+		}
+	}
+	arr := js.Global().Get("Uint8Array").New(len(out))
+	js.CopyBytesToJS(arr, out)
+	zero(out)
+	return arr, nil
+}
+
+var errSubtleAuth = errors.New("subtle: authentication failed")
+
+func (j *chunkJob) runSubtle() (js.Value, error) {
+	defer zeroJS(j.jsData)
+
+	key, err := await(j.subtle)
+	if err != nil {
+		return js.Undefined(), err
+	}
+
+	nonce := js.Global().Get("Uint8Array").New(len(j.nonce))
+	js.CopyBytesToJS(nonce, j.nonce)
+	aad := js.Global().Get("Uint8Array").New(len(chunkAAD))
+	js.CopyBytesToJS(aad, []byte(chunkAAD))
+	params := map[string]interface{}{
+		"name":           "AES-GCM",
+		"iv":             nonce,
+		"additionalData": aad,
+		"tagLength":      128,
+	}
+
+	op := "encrypt"
+	if !j.encrypt {
+		op = "decrypt"
+	}
+	buf, err := await(subtleCrypto().Call(op, params, key, j.jsData))
+	if err != nil {
+		if !j.encrypt {
+			// SubtleCrypto reports a bad tag as a generic OperationError.
+			return js.Undefined(), errSubtleAuth
+		}
+		return js.Undefined(), err
+	}
+	return js.Global().Get("Uint8Array").New(buf), nil
+}
+
+// encryptChunkAsync is the Promise-returning variant of encryptChunk.
+// Arguments: (cipherId, data, isLast) → Promise<Uint8Array>.
+func encryptChunkAsync(_ js.Value, args []js.Value) interface{} {
+	job, err := prepareChunkJob(args, true)
+	if err != nil {
+		return rejected(err)
+	}
+	return newPromise(job.run)
+}
+
+// decryptChunkAsync is the Promise-returning variant of decryptChunk.
+// Arguments: (cipherId, data, isLast) → Promise<Uint8Array>.
+func decryptChunkAsync(_ js.Value, args []js.Value) interface{} {
+	job, err := prepareChunkJob(args, false)
+	if err != nil {
+		return rejected(err)
+	}
+	return newPromise(job.run)
+}
+
+func rejected(err error) js.Value {
+	return js.Global().Get("Promise").Call("reject", handleError(err))
+}
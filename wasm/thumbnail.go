@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"syscall/js"
+
+	pastecrypto "github.com/jonasbg/paste/crypto"
+)
+
+// Encrypted thumbnails.
+//
+// createThumbnail downsizes an image in the browser, re-encodes it as JPEG
+// and seals it with the file key (thumbnail AAD, metadata header layout).
+// The server only ever sees the sealed blob, so previews do not weaken E2E
+// encryption; recipients open it with decryptThumbnail.
+const (
+	defaultThumbnailSize    = 256
+	maxThumbnailSize        = 1024
+	defaultThumbnailQuality = 75
+
+	// maxThumbnailSourcePixels guards against decompression bombs: a tiny
+	// PNG can declare a huge canvas that would exhaust WASM memory.
+	maxThumbnailSourcePixels = 40_000_000
+)
+
+// createThumbnail(key, image, {maxSize?, quality?}) -> {data, width, height, contentType}
+func createThumbnail(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 || len(args) > 3 {
+		return handleError(errInvalidArgs)
+	}
+
+	key, err := decodeKey(args[0].String())
+	if err != nil {
+		return handleError(err)
+	}
+	defer zero(key)
+
+	maxSize, quality := defaultThumbnailSize, defaultThumbnailQuality
+	if len(args) == 3 && args[2].Type() == js.TypeObject {
+		if v := args[2].Get("maxSize"); v.Type() == js.TypeNumber {
+			maxSize = v.Int()
+		}
+		if v := args[2].Get("quality"); v.Type() == js.TypeNumber {
+			quality = v.Int()
+		}
+	}
+	if maxSize < 1 || maxSize > maxThumbnailSize {
+		return handleError(newError(ErrCodeBadArgs, "thumbnail maxSize out of range"))
+	}
+	if quality < 1 || quality > 100 {
+		return handleError(newError(ErrCodeBadArgs, "thumbnail quality out of range"))
+	}
+
+	src := make([]byte, args[1].Length())
+	js.CopyBytesToGo(src, args[1])
+	defer zero(src)
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return handleError(newError(ErrCodeBadFormat, "unsupported image: "+err.Error()))
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailSourcePixels {
+		return handleError(newError(ErrCodeBadFormat, "image dimensions too large for a thumbnail"))
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return handleError(newError(ErrCodeBadFormat, "unsupported image: "+err.Error()))
+	}
+
+	thumb := downscale(img, maxSize)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: quality}); err != nil {
+		return handleError(err)
+	}
+	plain := buf.Bytes()
+	defer zero(plain)
+
+	sealed, err := pastecrypto.EncryptThumbnail(key, plain)
+	if err != nil {
+		return handleError(err)
+	}
+
+	out := js.Global().Get("Uint8Array").New(len(sealed))
+	js.CopyBytesToJS(out, sealed)
+
+	bounds := thumb.Bounds()
+	return js.ValueOf(map[string]interface{}{
+		"data":        out,
+		"width":       bounds.Dx(),
+		"height":      bounds.Dy(),
+		"contentType": "image/jpeg",
+	})
+}
+
+// decryptThumbnail(key, data) -> Uint8Array (JPEG)
+func decryptThumbnail(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return handleError(errInvalidArgs)
+	}
+
+	key, err := decodeKey(args[0].String())
+	if err != nil {
+		return handleError(err)
+	}
+	defer zero(key)
+
+	data := make([]byte, args[1].Length())
+	js.CopyBytesToGo(data, args[1])
+
+	plain, err := pastecrypto.DecryptThumbnail(key, data)
+	if err != nil {
+		return handleError(err)
+	}
+	defer zero(plain)
+
+	out := js.Global().Get("Uint8Array").New(len(plain))
+	js.CopyBytesToJS(out, plain)
+	return out
+}
+
+// downscale box-filters img so its longest side is at most maxSize,
+// flattening transparency onto white since JPEG has no alpha channel.
+// Images already within bounds are only flattened.
+func downscale(img image.Image, maxSize int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	dw, dh := sw, sh
+	if sw > maxSize || sh > maxSize {
+		if sw >= sh {
+			dw, dh = maxSize, max(1, sh*maxSize/sw)
+		} else {
+			dw, dh = max(1, sw*maxSize/sh), maxSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := b.Min.Y + y*sh/dh
+		y1 := max(y0+1, b.Min.Y+(y+1)*sh/dh)
+		for x := 0; x < dw; x++ {
+			x0 := b.Min.X + x*sw/dw
+			x1 := max(x0+1, b.Min.X+(x+1)*sw/dw)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			// Premultiplied average composited over white.
+			white := n*0xffff - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((bl + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
@@ -9,7 +9,6 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"io"
 	"strconv"
@@ -17,7 +16,7 @@ import (
 	"sync"
 	"syscall/js"
 
-	"golang.org/x/crypto/argon2"
+	pastecrypto "github.com/jonasbg/paste/crypto"
 	"golang.org/x/crypto/hkdf"
 )
 
@@ -26,28 +25,16 @@ const (
 	chunkAAD    = "paste-v2-chunk"
 	metadataAAD = "paste-v2-metadata"
 
-	argon2Salt   = "paste-v2-argon2id"
-	argon2Time   = 3
-	argon2Memory = 64 * 1024 // 64 MiB
-	argon2Par    = 4
-	argon2Out    = 32
-
-	hkdfFileIDInfo = "paste-v2-file-id"
-	hkdfKeyInfo    = "paste-v2-encryption-key"
-	hkdfHMACInfo   = "paste:hmac-token"
+	// Passphrase derivation (Argon2id + HKDF labels) lives in the shared
+	// crypto package; see deriveFromPassphrase.
+	hkdfHMACInfo = "paste:hmac-token"
 
 	// streamFinalBit marks the final chunk in the STREAM nonce counter.
 	// 31-bit counter + 1 final-marker bit gives 2^31 chunks per file.
-	streamFinalBit uint32 = 0x80000000
+	streamFinalBit    uint32 = 0x80000000
 	streamCounterMask uint32 = 0x7FFFFFFF
 )
 
-type Metadata struct {
-	Filename    string `json:"filename"`
-	ContentType string `json:"contentType"`
-	Size        int64  `json:"size"`
-}
-
 type StreamingCipher struct {
 	gcm      cipher.AEAD
 	iv       []byte
@@ -56,6 +43,15 @@ type StreamingCipher struct {
 	plainBuf []byte
 	sealBuf  []byte
 	openBuf  []byte
+	// subtleKey is a Promise<CryptoKey> for the Web Crypto fast path, or
+	// undefined when SubtleCrypto is unavailable.
+	subtleKey js.Value
+	// key is the file key of a stream whose chunks each have their own
+	// derived key (metadata "chunkKeys"), and nil otherwise.
+	key      []byte
+	progress progressState
+	// busy is set while an async chunk of this stream is in flight.
+	busy bool
 }
 
 type CipherRegistry struct {
@@ -76,12 +72,30 @@ func main() {
 		"createDecryptionStream": js.FuncOf(createDecryptionStream),
 		"encryptChunk":           js.FuncOf(encryptChunk),
 		"decryptChunk":           js.FuncOf(decryptChunk),
+		"encryptChunkAsync":      js.FuncOf(encryptChunkAsync),
+		"decryptChunkAsync":      js.FuncOf(decryptChunkAsync),
+		"hasWebCrypto":           js.FuncOf(hasWebCrypto),
+		"watchStream":            js.FuncOf(watchStream),
+		"cancelStream":           js.FuncOf(cancelStream),
+		"createHashStream":       js.FuncOf(createHashStream),
+		"updateHash":             js.FuncOf(updateHash),
+		"finalizeHash":           js.FuncOf(finalizeHash),
+		"createCompressStream":   js.FuncOf(createCompressStream),
+		"compressChunk":          js.FuncOf(compressChunk),
+		"createDecompressStream": js.FuncOf(createDecompressStream),
+		"decompressChunk":        js.FuncOf(decompressChunk),
+		"disposeCodec":           js.FuncOf(disposeCodec),
 		"disposeCipher":          js.FuncOf(disposeCipher),
 		"generateKey":            js.FuncOf(generateKey),
 		"decryptMetadata":        js.FuncOf(decryptMetadata),
+		"encryptMetadata":        js.FuncOf(encryptMetadata),
 		"encrypt":                js.FuncOf(encrypt),
+		"createThumbnail":        js.FuncOf(createThumbnail),
+		"decryptThumbnail":       js.FuncOf(decryptThumbnail),
 		"generateHmacToken":      js.FuncOf(generateHmacToken),
 		"deriveFromPassphrase":   js.FuncOf(deriveFromPassphrase),
+		"generatePassphrase":     js.FuncOf(generatePassphrase),
+		"validatePassphrase":     js.FuncOf(validatePassphrase),
 	})
 	<-c
 }
@@ -106,7 +120,7 @@ func decodeKey(s string) ([]byte, error) {
 
 func newAEAD(key []byte) (cipher.AEAD, error) {
 	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-		return nil, errors.New("invalid key length")
+		return nil, newError(ErrCodeBadKey, "invalid key length")
 	}
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -117,7 +131,7 @@ func newAEAD(key []byte) (cipher.AEAD, error) {
 
 func deriveFromPassphrase(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 1 {
-		return handleError(errors.New("passphrase required"))
+		return handleError(newError(ErrCodeBadArgs, "passphrase required"))
 	}
 	passphrase := args[0].String()
 	keySizeBits := 128
@@ -133,28 +147,14 @@ func deriveFromPassphrase(_ js.Value, args []js.Value) interface{} {
 	case 128:
 		keySize = 16
 	default:
-		return handleError(errors.New("invalid key size, must be 128, 192, or 256"))
-	}
-
-	// Argon2id provides the work factor; HKDF provides labeled domain separation
-	// for the file ID and the encryption key.
-	stretched := argon2.IDKey([]byte(passphrase), []byte(argon2Salt),
-		argon2Time, argon2Memory, argon2Par, argon2Out)
-	defer zero(stretched)
-
-	fileIDReader := hkdf.New(sha256.New, stretched, nil, []byte(hkdfFileIDInfo))
-	fileIDBytes := make([]byte, 16)
-	if _, err := io.ReadFull(fileIDReader, fileIDBytes); err != nil {
-		return handleError(err)
+		return handleError(newError(ErrCodeBadKey, "invalid key size, must be 128, 192, or 256"))
 	}
 
-	keyReader := hkdf.New(sha256.New, stretched, nil, []byte(hkdfKeyInfo))
-	key := make([]byte, keySize)
-	if _, err := io.ReadFull(keyReader, key); err != nil {
+	// Shared with the CLI so both derive the identical fileID and key.
+	fileID, key, err := pastecrypto.DeriveFromPassphrase(passphrase, keySize)
+	if err != nil {
 		return handleError(err)
 	}
-
-	fileID := hex(fileIDBytes)
 	keyBase64 := base64.RawURLEncoding.EncodeToString(key)
 	zero(key)
 
@@ -164,6 +164,32 @@ func deriveFromPassphrase(_ js.Value, args []js.Value) interface{} {
 	})
 }
 
+// generatePassphrase returns a word-word-word-word-x7k3 style passphrase
+// drawn from the shared Go wordlist. Argument: optional word count (4-8).
+func generatePassphrase(_ js.Value, args []js.Value) interface{} {
+	numWords := 4
+	if len(args) >= 1 && args[0].Type() == js.TypeNumber {
+		numWords = args[0].Int()
+	}
+	passphrase, err := pastecrypto.GeneratePassphrase(numWords)
+	if err != nil {
+		return handleError(err)
+	}
+	return js.ValueOf(passphrase)
+}
+
+// validatePassphrase returns true for a well-formed passphrase, or an Error
+// describing why it is not.
+func validatePassphrase(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return handleError(errInvalidArgs)
+	}
+	if err := pastecrypto.ValidatePassphrase(args[0].String()); err != nil {
+		return handleError(err)
+	}
+	return js.ValueOf(true)
+}
+
 // hex avoids fmt.Sprintf to keep WASM size down and avoid allocator pressure.
 func hex(b []byte) string {
 	const digits = "0123456789abcdef"
@@ -177,7 +203,7 @@ func hex(b []byte) string {
 
 func generateHmacToken(_ js.Value, args []js.Value) interface{} {
 	if len(args) != 2 {
-		return handleError(errors.New("invalid arguments"))
+		return handleError(errInvalidArgs)
 	}
 
 	fileId := args[0].String()
@@ -188,7 +214,7 @@ func generateHmacToken(_ js.Value, args []js.Value) interface{} {
 	defer zero(key)
 
 	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
-		return handleError(errors.New("invalid key length"))
+		return handleError(newError(ErrCodeBadKey, "invalid key length"))
 	}
 
 	hmacKey, err := deriveHMACKey(key, fileId)
@@ -228,7 +254,7 @@ func deriveHMACKey(baseKey []byte, fileID string) ([]byte, error) {
 
 func encrypt(_ js.Value, args []js.Value) interface{} {
 	if len(args) != 2 {
-		return handleError(errors.New("invalid arguments"))
+		return handleError(errInvalidArgs)
 	}
 
 	key, err := decodeKey(args[0].String())
@@ -261,7 +287,7 @@ func encrypt(_ js.Value, args []js.Value) interface{} {
 
 func createEncryptionStream(_ js.Value, args []js.Value) interface{} {
 	if len(args) != 1 {
-		return handleError(errors.New("invalid arguments"))
+		return handleError(errInvalidArgs)
 	}
 
 	key, err := decodeKey(args[0].String())
@@ -280,7 +306,7 @@ func createEncryptionStream(_ js.Value, args []js.Value) interface{} {
 		return handleError(err)
 	}
 
-	sc := &StreamingCipher{gcm: aead, iv: iv}
+	sc := &StreamingCipher{gcm: aead, iv: iv, subtleKey: importSubtleKey(key)}
 
 	registry.mu.Lock()
 	cipherID := registry.nextID
@@ -297,13 +323,19 @@ func createEncryptionStream(_ js.Value, args []js.Value) interface{} {
 	})
 }
 
+// createDecryptionStream(key, iv, chunkKeys?) -> id. chunkKeys is the
+// metadata member of that name, if the file has one.
 func createDecryptionStream(_ js.Value, args []js.Value) interface{} {
-	if len(args) != 2 {
-		return handleError(errors.New("invalid arguments"))
+	if len(args) != 2 && len(args) != 3 {
+		return handleError(errInvalidArgs)
+	}
+	perChunk, err := chunkKeysArg(args[2:])
+	if err != nil {
+		return handleError(err)
 	}
 
 	if args[1].Length() != 12 {
-		return handleError(errors.New("invalid IV size"))
+		return handleError(newError(ErrCodeBadArgs, "invalid IV size"))
 	}
 	iv := make([]byte, 12)
 	js.CopyBytesToGo(iv, args[1])
@@ -320,6 +352,12 @@ func createDecryptionStream(_ js.Value, args []js.Value) interface{} {
 	}
 
 	sc := &StreamingCipher{gcm: aead, iv: iv}
+	if perChunk {
+		sc.key = bytes.Clone(key)
+		sc.subtleKey = js.Undefined()
+	} else {
+		sc.subtleKey = importSubtleKey(key)
+	}
 
 	registry.mu.Lock()
 	cipherID := registry.nextID
@@ -330,6 +368,48 @@ func createDecryptionStream(_ js.Value, args []js.Value) interface{} {
 	return js.ValueOf(cipherID)
 }
 
+// chunkKeysArg reads the optional chunkKeys argument and reports whether
+// every chunk has its own key.
+func chunkKeysArg(args []js.Value) (bool, error) {
+	if len(args) == 0 || args[0].IsUndefined() || args[0].IsNull() {
+		return false, nil
+	}
+	if args[0].Type() != js.TypeString {
+		return false, errInvalidArgs
+	}
+	switch v := args[0].String(); v {
+	case "":
+		return false, nil
+	case pastecrypto.ChunkKeysHKDF:
+		return true, nil
+	default:
+		return false, newError(ErrCodeBadFormat, "unknown chunk keys: "+v)
+	}
+}
+
+// chunkCipher returns the AEAD for the stream's current chunk and, if
+// withSubtle, a Promise of its Web Crypto key (undefined without
+// SubtleCrypto). Streams with per-chunk keys derive both for every chunk.
+func (sc *StreamingCipher) chunkCipher(withSubtle bool) (cipher.AEAD, js.Value, error) {
+	if sc.key == nil {
+		return sc.gcm, sc.subtleKey, nil
+	}
+	subkey, err := pastecrypto.ChunkKey(sc.key, sc.iv, uint64(sc.chunk))
+	if err != nil {
+		return nil, js.Undefined(), err
+	}
+	defer zero(subkey)
+	aead, err := newAEAD(subkey)
+	if err != nil {
+		return nil, js.Undefined(), err
+	}
+	subtle := js.Undefined()
+	if withSubtle {
+		subtle = importSubtleKey(subkey)
+	}
+	return aead, subtle, nil
+}
+
 // buildChunkNonce writes the STREAM nonce for chunkIdx into dst.
 // dst must be 12 bytes. The high bit of the 32-bit counter encodes isFinal,
 // so reordering, truncation, or extension all fail GCM authentication.
@@ -344,7 +424,7 @@ func buildChunkNonce(dst []byte, iv []byte, chunkIdx uint32, isFinal bool) {
 
 func encryptChunk(_ js.Value, args []js.Value) interface{} {
 	if len(args) != 3 {
-		return handleError(errors.New("invalid arguments"))
+		return handleError(errInvalidArgs)
 	}
 
 	cipherID := args[0].Int()
@@ -355,11 +435,20 @@ func encryptChunk(_ js.Value, args []js.Value) interface{} {
 	registry.mu.Unlock()
 
 	if !exists {
-		return handleError(errors.New("invalid cipher ID"))
+		return handleError(errInvalidCipherID.withDetails(map[string]interface{}{"cipherId": cipherID}))
+	}
+
+	if sc.busy {
+		return handleError(errChunkInFlight.withDetails(map[string]interface{}{"cipherId": cipherID}))
 	}
 
 	if sc.chunk&streamFinalBit != 0 || sc.chunk >= streamCounterMask {
-		return handleError(errors.New("chunk counter exhausted"))
+		return handleError(errCounterExhausted.withDetails(map[string]interface{}{"cipherId": cipherID, "chunk": sc.chunk}))
+	}
+
+	if err := sc.progress.check(); err != nil {
+		disposeByID(cipherID)
+		return handleError(err)
 	}
 
 	n := args[1].Length()
@@ -378,6 +467,7 @@ func encryptChunk(_ js.Value, args []js.Value) interface{} {
 	uint8Array := js.Global().Get("Uint8Array").New(len(sc.sealBuf))
 	js.CopyBytesToJS(uint8Array, sc.sealBuf)
 
+	sc.progress.advance(n)
 	if isLast {
 		disposeByID(cipherID)
 	}
@@ -387,7 +477,7 @@ func encryptChunk(_ js.Value, args []js.Value) interface{} {
 
 func decryptChunk(_ js.Value, args []js.Value) interface{} {
 	if len(args) != 3 {
-		return handleError(errors.New("invalid arguments"))
+		return handleError(errInvalidArgs)
 	}
 
 	cipherID := args[0].Int()
@@ -398,11 +488,20 @@ func decryptChunk(_ js.Value, args []js.Value) interface{} {
 	registry.mu.Unlock()
 
 	if !exists {
-		return handleError(errors.New("invalid cipher ID"))
+		return handleError(errInvalidCipherID.withDetails(map[string]interface{}{"cipherId": cipherID}))
+	}
+
+	if sc.busy {
+		return handleError(errChunkInFlight.withDetails(map[string]interface{}{"cipherId": cipherID}))
 	}
 
 	if sc.chunk >= streamCounterMask {
-		return handleError(errors.New("chunk counter exhausted"))
+		return handleError(errCounterExhausted.withDetails(map[string]interface{}{"cipherId": cipherID, "chunk": sc.chunk}))
+	}
+
+	if err := sc.progress.check(); err != nil {
+		disposeByID(cipherID)
+		return handleError(err)
 	}
 
 	n := args[1].Length()
@@ -412,10 +511,14 @@ func decryptChunk(_ js.Value, args []js.Value) interface{} {
 	data := sc.plainBuf[:n]
 	js.CopyBytesToGo(data, args[1])
 
+	aead, _, err := sc.chunkCipher(false)
+	if err != nil {
+		zero(data)
+		return handleError(err)
+	}
 	buildChunkNonce(sc.nonce[:], sc.iv, sc.chunk, isLast)
 
-	var err error
-	sc.openBuf, err = sc.gcm.Open(sc.openBuf[:0], sc.nonce[:], data, []byte(chunkAAD))
+	sc.openBuf, err = aead.Open(sc.openBuf[:0], sc.nonce[:], data, []byte(chunkAAD))
 	zero(data)
 	if err != nil {
 		return handleError(err)
@@ -426,6 +529,7 @@ func decryptChunk(_ js.Value, args []js.Value) interface{} {
 	uint8Array := js.Global().Get("Uint8Array").New(len(sc.openBuf))
 	js.CopyBytesToJS(uint8Array, sc.openBuf)
 
+	sc.progress.advance(n)
 	if isLast {
 		disposeByID(cipherID)
 	}
@@ -445,12 +549,13 @@ func disposeByID(cipherID int) {
 	zero(c.plainBuf)
 	zero(c.sealBuf)
 	zero(c.openBuf)
+	zero(c.key)
 	delete(registry.ciphers, cipherID)
 }
 
 func disposeCipher(_ js.Value, args []js.Value) interface{} {
 	if len(args) != 1 {
-		return handleError(errors.New("invalid arguments"))
+		return handleError(errInvalidArgs)
 	}
 	disposeByID(args[0].Int())
 	return js.ValueOf(true)
@@ -466,13 +571,13 @@ func generateKey(_ js.Value, args []js.Value) interface{} {
 		case js.TypeString:
 			parsed, err := strconv.Atoi(args[0].String())
 			if err != nil {
-				return handleError(errors.New("invalid key size: not a number"))
+				return handleError(newError(ErrCodeBadKey, "invalid key size: not a number"))
 			}
 			keySizeBits = parsed
 		case js.TypeUndefined, js.TypeNull:
 			// keep default
 		default:
-			return handleError(errors.New("invalid key size: must be number or numeric string"))
+			return handleError(newError(ErrCodeBadKey, "invalid key size: must be number or numeric string"))
 		}
 	}
 
@@ -485,7 +590,7 @@ func generateKey(_ js.Value, args []js.Value) interface{} {
 	case 256:
 		keySize = 32
 	default:
-		return handleError(errors.New("invalid key size: must be 128, 192, or 256"))
+		return handleError(newError(ErrCodeBadKey, "invalid key size: must be 128, 192, or 256"))
 	}
 
 	key := make([]byte, keySize)
@@ -497,9 +602,15 @@ func generateKey(_ js.Value, args []js.Value) interface{} {
 	return encoded
 }
 
-func decryptMetadata(_ js.Value, args []js.Value) interface{} {
+// encryptMetadata seals metadata into the v2 header format
+// [IV(12)][Length(4 LE)][AES-GCM(metadata)] using the shared crypto package,
+// so browser and CLI headers are byte-for-byte the same construction. The
+// object is stamped with the current schema version before sealing.
+// Arguments: (key, metadata) where metadata is a Uint8Array of JSON bytes or
+// a plain object that is JSON-encoded first.
+func encryptMetadata(_ js.Value, args []js.Value) interface{} {
 	if len(args) != 2 {
-		return handleError(errors.New("invalid arguments"))
+		return handleError(errInvalidArgs)
 	}
 
 	key, err := decodeKey(args[0].String())
@@ -508,44 +619,67 @@ func decryptMetadata(_ js.Value, args []js.Value) interface{} {
 	}
 	defer zero(key)
 
-	data := make([]byte, args[1].Length())
-	js.CopyBytesToGo(data, args[1])
-
-	if len(data) < 16 {
-		return handleError(errors.New("invalid metadata format"))
+	input := args[1]
+	if !input.InstanceOf(js.Global().Get("Uint8Array")) {
+		if input.Type() != js.TypeObject {
+			return handleError(newError(ErrCodeBadArgs, "metadata must be an object or Uint8Array"))
+		}
+		input = js.Global().Get("TextEncoder").New().Call("encode",
+			js.Global().Get("JSON").Call("stringify", input))
 	}
+	data := make([]byte, input.Length())
+	js.CopyBytesToGo(data, input)
+	defer zero(data)
 
-	iv := data[:12]
-	metadataLen := binary.LittleEndian.Uint32(data[12:16])
-	if uint64(len(data)) < uint64(16)+uint64(metadataLen) {
-		return handleError(errors.New("incomplete metadata"))
+	stamped, err := pastecrypto.StampMetadata(data)
+	if err != nil {
+		return handleError(newError(ErrCodeBadArgs, err.Error()))
 	}
-	encryptedMetadata := data[16 : 16+metadataLen]
+	defer zero(stamped)
 
-	aead, err := newAEAD(key)
+	header, err := pastecrypto.EncryptMetadata(key, stamped)
 	if err != nil {
 		return handleError(err)
 	}
 
-	decrypted, err := aead.Open(nil, iv, encryptedMetadata, []byte(metadataAAD))
+	uint8Array := js.Global().Get("Uint8Array").New(len(header))
+	js.CopyBytesToJS(uint8Array, header)
+	return uint8Array
+}
+
+// decryptMetadata opens a metadata header and returns every member it
+// holds, including ones this build doesn't know about, so the page can use
+// fields newer clients add without a wasm rebuild. The schema version is
+// in "v" (1 for metadata written before versioning).
+func decryptMetadata(_ js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return handleError(errInvalidArgs)
+	}
+
+	key, err := decodeKey(args[0].String())
 	if err != nil {
 		return handleError(err)
 	}
-	defer zero(decrypted)
+	defer zero(key)
 
-	var metadata Metadata
-	if err := json.NewDecoder(bytes.NewReader(decrypted)).Decode(&metadata); err != nil {
-		return handleError(err)
+	data := make([]byte, args[1].Length())
+	js.CopyBytesToGo(data, args[1])
+
+	if len(data) < 16 {
+		return handleError(newError(ErrCodeBadFormat, "invalid metadata format"))
 	}
 
-	result := make(map[string]interface{})
-	result["filename"] = metadata.Filename
-	result["contentType"] = metadata.ContentType
-	result["size"] = metadata.Size
-	return js.ValueOf(result)
-}
+	decrypted, err := pastecrypto.DecryptMetadata(key, data)
+	if err != nil {
+		return handleError(err)
+	}
+	defer zero(decrypted)
 
-func handleError(err error) interface{} {
-	errorConstructor := js.Global().Get("Error")
-	return errorConstructor.New(err.Error())
+	var metadata map[string]interface{}
+	version, err := pastecrypto.UnmarshalMetadata(decrypted, &metadata)
+	if err != nil {
+		return handleError(newError(ErrCodeBadFormat, err.Error()))
+	}
+	metadata["v"] = version
+	return js.ValueOf(metadata)
 }
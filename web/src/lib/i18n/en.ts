@@ -106,7 +106,11 @@ const en = {
 		downloading: 'Downloading...',
 		downloadComplete: 'Download complete',
 		metadataFetchError: 'Could not fetch file information',
-		fileNotFound: "The file doesn't exist or has expired"
+		fileNotFound: "The file doesn't exist or has expired",
+		checksumMismatch:
+			"The downloaded file doesn't match the checksum the sender recorded. It may have been altered or damaged.",
+		compressedFile:
+			"This file was compressed with {algo}, which the browser can't decompress. Download it with pastectl instead."
 	}
 };
 
@@ -106,7 +106,11 @@ const no = {
 		downloading: 'Laster ned...',
 		downloadComplete: 'Nedlasting fullført',
 		metadataFetchError: 'Kunne ikke hente filinformasjon',
-		fileNotFound: 'Filen finnes ikke eller har utløpt'
+		fileNotFound: 'Filen finnes ikke eller har utløpt',
+		checksumMismatch:
+			'Den nedlastede filen stemmer ikke med sjekksummen avsenderen registrerte. Den kan være endret eller skadet.',
+		compressedFile:
+			'Denne filen ble komprimert med {algo}, som nettleseren ikke kan pakke ut. Last den ned med pastectl i stedet.'
 	}
 };
 
@@ -1,12 +1,26 @@
 import { generateHmacToken } from '$lib/utils/hmacUtils';
 import { getWasmInstance } from '$lib/utils/wasm-loader';
-import { normalizeMimeType } from '$lib/utils/mimeType';
+import { isTextBased, normalizeMimeType } from '$lib/utils/mimeType';
+import { bytesToBase64 } from '$lib/utils/base64';
 import { FileProcessor } from './fileProcessor';
 import type { ProgressCallback } from './fileProcessor';
 import { configStore } from '$lib/stores/config';
 import { get } from 'svelte/store';
 import { tr } from '$lib/i18n';
 
+// WebSocket protocol version this page speaks; the server answers init
+// with the version it picked, and older servers answer without one.
+const PROTOCOL_VERSION = 1;
+
+// Image types the WASM thumbnailer decodes, and the largest source it is
+// given; decoding happens on the main thread.
+const THUMBNAIL_TYPES = new Set(['image/jpeg', 'image/png', 'image/gif']);
+const THUMBNAIL_SOURCE_MAX_BYTES = 25 * 1024 * 1024;
+
+// Text files from this size on are compressed before they are encrypted,
+// since the ciphertext can't be; smaller ones don't gain enough to bother.
+const COMPRESS_MIN_BYTES = 4 * 1024;
+
 function requireWasmMethod<T>(method: T | undefined, name: string): NonNullable<T> {
     if (!method) {
         throw new Error(`${name} is unavailable`);
@@ -35,11 +49,83 @@ export function generateKey(): string | null {
     return generateKeyMethod(keySize);
 }
 
+// sealThumbnail returns a downsized copy of an image upload, sealed with the
+// file key and base64-encoded for the metadata, so recipients can preview
+// an image too large to download for it. A thumbnail is optional: when the
+// image can't be decoded there is none.
+async function sealThumbnail(file: File, key: string): Promise<string | undefined> {
+    const createThumbnail = getWasmInstance()?.createThumbnail;
+    const type = normalizeMimeType(file);
+    if (!createThumbnail || !THUMBNAIL_TYPES.has(type) || file.size > THUMBNAIL_SOURCE_MAX_BYTES) {
+        return undefined;
+    }
+
+    const result: unknown = createThumbnail(key, new Uint8Array(await file.arrayBuffer()));
+    if (result instanceof Error) {
+        console.warn('No thumbnail for upload:', result.message);
+        return undefined;
+    }
+    return bytesToBase64((result as { data: Uint8Array }).data);
+}
+
+// A plaintext chunk ready for encryption. consumed is how far into the
+// file it reaches, which is what progress is measured in.
+type PlainChunk = { data: Uint8Array; isLast: boolean; consumed: number };
+
+// fileChunks yields the file in chunkSize pieces, or null once it's done.
+function fileChunks(file: File, chunkSize: number): () => Promise<PlainChunk | null> {
+    let offset = 0;
+    return async () => {
+        if (offset >= file.size) return null;
+        const data = new Uint8Array(await file.slice(offset, offset + chunkSize).arrayBuffer());
+        offset += data.length;
+        return { data, isLast: offset >= file.size, consumed: offset };
+    };
+}
+
+// compressedChunks compresses the file as one stream, the way pastectl
+// does, and yields the output in chunkSize pieces. Which piece is the last
+// is only known once the compressor has seen the end of the file.
+function compressedChunks(
+    file: File,
+    chunkSize: number,
+    codecId: number,
+    compressChunk: (codecId: number, data: Uint8Array, isLast: boolean) => Promise<Uint8Array>
+): () => Promise<PlainChunk | null> {
+    let offset = 0;
+    let pending = new Uint8Array(0);
+    let finished = false; // the compressor was given the end of the file
+    let done = false;
+    return async () => {
+        if (done) return null;
+        while (pending.length < chunkSize && !finished) {
+            const data = new Uint8Array(await file.slice(offset, offset + chunkSize).arrayBuffer());
+            offset += data.length;
+            finished = offset >= file.size;
+            const out = await compressChunk(codecId, data, finished);
+            const joined = new Uint8Array(pending.length + out.length);
+            joined.set(pending);
+            joined.set(out, pending.length);
+            pending = joined;
+        }
+        // Closing the compressor always writes a trailer, so a full piece
+        // taken before that can't be the last.
+        if (finished && pending.length <= chunkSize) {
+            done = true;
+            return { data: pending, isLast: true, consumed: offset };
+        }
+        const data = pending.slice(0, chunkSize);
+        pending = pending.slice(chunkSize);
+        return { data, isLast: false, consumed: offset };
+    };
+}
+
 export async function uploadEncryptedFile(
     file: File,
     key: string,
     onProgress: ProgressCallback,
-    customFileId?: string
+    customFileId?: string,
+    signal?: AbortSignal
 ): Promise<{ fileId: string; token: string }> {
     const fileProcessor = new FileProcessor();
     const config = get(configStore);
@@ -68,25 +154,43 @@ export async function uploadEncryptedFile(
         wasmInstance.createEncryptionStream,
         'createEncryptionStream'
     );
-    const encryptChunk = requireWasmMethod(wasmInstance.encryptChunk, 'encryptChunk');
+    // Chunks go through Web Crypto where the browser has it
+    const encryptChunk = requireWasmMethod(wasmInstance.encryptChunkAsync, 'encryptChunkAsync');
+    const watchStream = requireWasmMethod(wasmInstance.watchStream, 'watchStream');
     const disposeCipher = wasmInstance.disposeCipher;
+    const contentType = normalizeMimeType(file);
+    const thumbnail = await sealThumbnail(file, key);
+    signal?.throwIfAborted();
+
+    // The compressed size isn't known up front, so compression needs a
+    // server that takes uploads of unknown size.
+    const compression =
+        isTextBased(contentType) &&
+        file.size >= COMPRESS_MIN_BYTES &&
+        config.data?.streaming_uploads &&
+        wasmInstance.createCompressStream &&
+        wasmInstance.compressChunk
+            ? 'zstd'
+            : undefined;
 
     return new Promise((resolve, reject) => {
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
         const ws = new WebSocket(`${protocol}//${window.location.host}/api/ws/upload`);
         const chunkSize = config.chunkSize * 1024 * 1024;
-        let fileOffset = 0;
+        let fileOffset = 0; // plaintext bytes read so far
         let currentFileId: string | null = null;
         let cachedToken: string | null = null;
         let cipherId: number | null = null;
+        let codecId: number | null = null;
+        let nextChunk = fileChunks(file, chunkSize);
+        let sentLast = false;
         let settled = false; // guard: resolve/reject only once
 
         // Prefetch state: the next chunk's file read + WASM encryption is kicked
         // off immediately after the current chunk is sent, so it overlaps with the
         // network round-trip for the current chunk's ACK.
-        type PrefetchResult = { encrypted: Uint8Array; plaintextSize: number };
-        let prefetchForOffset = -1;
-        let prefetchPromise: Promise<PrefetchResult | null> | null = null;
+        type SealedChunk = { encrypted: Uint8Array; isLast: boolean; consumed: number };
+        let prefetchPromise: Promise<SealedChunk | null> | null = null;
 
         // Smooth intra-chunk progress interpolation
         let lastChunkDuration = 0; // ms it took to upload+ack the previous chunk
@@ -106,10 +210,16 @@ export async function uploadEncryptedFile(
 
         const cleanup = () => {
             stopProgressTimer();
+            signal?.removeEventListener('abort', abort);
             if (cipherId !== null && disposeCipher) {
                 disposeCipher(cipherId);
                 cipherId = null;
             }
+            if (codecId !== null) {
+                // A finished stream is already released; this covers failures
+                wasmInstance.disposeCodec?.(codecId);
+                codecId = null;
+            }
         };
 
         const settle = (fn: () => void) => {
@@ -119,6 +229,29 @@ export async function uploadEncryptedFile(
             fn();
         };
 
+        // Cancelling closes the connection, which makes the server drop the
+        // partial upload.
+        function abort() {
+            settle(() => reject(signal!.reason));
+            ws.close();
+        }
+        signal?.addEventListener('abort', abort, { once: true });
+
+        // sealMetadata builds the metadata header: IV, length and sealed JSON.
+        const sealMetadata = (metadata: Record<string, unknown>): Uint8Array => {
+            const metadataBytes = new TextEncoder().encode(JSON.stringify(metadata));
+            if (wasmInstance.encryptMetadata) {
+                // Same header construction as the CLI (crypto.EncryptMetadata)
+                return wasmInstance.encryptMetadata(key, metadataBytes);
+            }
+            const encryptedMetadata = encrypt(key, metadataBytes);
+            const header = new Uint8Array(16 + encryptedMetadata.length - 12);
+            header.set(encryptedMetadata.slice(0, 12), 0);
+            new DataView(header.buffer).setUint32(12, encryptedMetadata.length - 12, true);
+            header.set(encryptedMetadata.slice(12), 16);
+            return header;
+        };
+
         // ── Serial message queue ─────────────────────────────────────────────
         // ws.onmessage is NOT declared async so we never have two concurrent
         // handlers running. All async work goes through the queue below, which
@@ -131,7 +264,12 @@ export async function uploadEncryptedFile(
             queueRunning = true;
             while (msgQueue.length > 0) {
                 const msg = msgQueue.shift()!;
-                await handleMessage(msg);
+                try {
+                    await handleMessage(msg);
+                } catch (error) {
+                    settle(() => reject(error instanceof Error ? error : new Error(String(error))));
+                    ws.close();
+                }
             }
             queueRunning = false;
         }
@@ -149,7 +287,15 @@ export async function uploadEncryptedFile(
         };
 
         ws.onopen = () => {
-            const initMsg: Record<string, unknown> = { type: 'init', size: file.size };
+            const initMsg: Record<string, unknown> = {
+                type: 'init',
+                protocolVersion: PROTOCOL_VERSION
+            };
+            if (compression) {
+                initMsg.streaming = true;
+            } else {
+                initMsg.size = file.size;
+            }
             if (customFileId) initMsg.fileId = customFileId;
             ws.send(JSON.stringify(initMsg));
         };
@@ -196,6 +342,12 @@ export async function uploadEncryptedFile(
 
             // Step 1 → server assigned an ID, send HMAC token
             if (msgType === 'id' && !currentFileId) {
+                const protocol = (response.protocolVersion as number | undefined) ?? 1;
+                if (protocol !== PROTOCOL_VERSION) {
+                    settle(() => reject(new Error(`Unsupported protocol version ${protocol}`)));
+                    ws.close();
+                    return;
+                }
                 currentFileId = response.id as string;
                 cachedToken = await generateHmacToken(currentFileId, key);
                 ws.send(JSON.stringify({ type: 'token', token: cachedToken }));
@@ -204,17 +356,21 @@ export async function uploadEncryptedFile(
 
             // Step 2 → token accepted, send encrypted metadata header
             if (msgType === 'token_accepted') {
+                // size is the original's; compression names the algorithm
+                // the receiver undoes
                 const metadata = {
                     filename: file.name,
-                    contentType: normalizeMimeType(file),
-                    size: file.size
+                    contentType,
+                    size: file.size,
+                    compression
                 };
-                const metadataBytes = new TextEncoder().encode(JSON.stringify(metadata));
-                const encryptedMetadata = encrypt(key, metadataBytes);
-                const header = new Uint8Array(16 + encryptedMetadata.length - 12);
-                header.set(encryptedMetadata.slice(0, 12), 0);
-                new DataView(header.buffer).setUint32(12, encryptedMetadata.length - 12, true);
-                header.set(encryptedMetadata.slice(12), 16);
+                let header = sealMetadata({ ...metadata, thumbnail });
+                // The thumbnail is left out when it would take the metadata
+                // past the server's limit.
+                const limit = config.data?.max_metadata_size ?? 0;
+                if (thumbnail && limit > 0 && header.length - 16 > limit) {
+                    header = sealMetadata(metadata);
+                }
                 ws.send(header);
                 return;
             }
@@ -234,6 +390,17 @@ export async function uploadEncryptedFile(
                     return;
                 }
                 cipherId = streamResult.id;
+                // A chunk still being encrypted when the upload is cancelled
+                // fails instead of finishing.
+                watchStream(cipherId, { signal });
+                if (compression) {
+                    const id: unknown = wasmInstance.createCompressStream!(compression);
+                    if (typeof id !== 'number') {
+                        throw id instanceof Error ? id : new Error('Failed to start compression');
+                    }
+                    codecId = id;
+                    nextChunk = compressedChunks(file, chunkSize, id, wasmInstance.compressChunk!);
+                }
                 ws.send(streamResult.iv);
                 await sendNextChunk();
                 return;
@@ -266,22 +433,14 @@ export async function uploadEncryptedFile(
             }
         }
 
-        // Start reading + encrypting the chunk at `forOffset` in the background.
-        // Called immediately after ws.send() so the work overlaps with the ACK RTT.
-        // Returns null on failure; sendNextChunk falls back to a synchronous path.
-        async function prefetchNextChunk(forOffset: number): Promise<PrefetchResult | null> {
-            if (cipherId === null || forOffset >= file.size) return null;
-            try {
-                const slice = await file.slice(forOffset, forOffset + chunkSize).arrayBuffer();
-                // Re-check after the async file read — cleanup may have run.
-                if (cipherId === null) return null;
-                const isLast = forOffset + chunkSize >= file.size;
-                const encrypted = encryptChunk(cipherId, new Uint8Array(slice), isLast);
-                if (!encrypted || encrypted instanceof Error) return null;
-                return { encrypted: encrypted as Uint8Array, plaintextSize: slice.byteLength };
-            } catch {
-                return null;
-            }
+        // Read and encrypt the next chunk; null once the file is done. Called
+        // right after a chunk is sent, so the work overlaps with the ACK RTT.
+        async function sealNextChunk(): Promise<SealedChunk | null> {
+            const chunk = await nextChunk();
+            // Re-check after the async file read — cleanup may have run.
+            if (chunk === null || cipherId === null) return null;
+            const encrypted = await encryptChunk(cipherId, chunk.data, chunk.isLast);
+            return { encrypted, isLast: chunk.isLast, consumed: chunk.consumed };
         }
 
         async function sendNextChunk() {
@@ -291,48 +450,27 @@ export async function uploadEncryptedFile(
                 return;
             }
 
-            if (fileOffset < file.size) {
-                let encryptedChunk: Uint8Array;
-                let plaintextSize: number;
-
-                if (prefetchPromise !== null && prefetchForOffset === fileOffset) {
-                    // A prefetch was started for exactly this offset — await it.
-                    // If it was already resolved this is effectively free; if it's
-                    // still in flight we overlap the wait with nothing useful anyway.
-                    const result = await prefetchPromise;
-                    prefetchPromise = null;
-                    if (result !== null) {
-                        encryptedChunk = result.encrypted;
-                        plaintextSize = result.plaintextSize;
-                    } else {
-                        // Prefetch failed — read and encrypt now.
-                        const slice = await file.slice(fileOffset, fileOffset + chunkSize).arrayBuffer();
-                        const isLast = fileOffset + chunkSize >= file.size;
-                        encryptedChunk = encryptChunk(cipherId, new Uint8Array(slice), isLast) as Uint8Array;
-                        plaintextSize = slice.byteLength;
-                    }
-                } else {
-                    // No prefetch in flight for this offset (first chunk, or prefetch
-                    // was not started because the previous chunk was the last).
-                    const slice = await file.slice(fileOffset, fileOffset + chunkSize).arrayBuffer();
-                    const isLast = fileOffset + chunkSize >= file.size;
-                    encryptedChunk = encryptChunk(cipherId, new Uint8Array(slice), isLast) as Uint8Array;
-                    plaintextSize = slice.byteLength;
-                }
+            // Chunks come from one stream, so a failed prefetch can't be
+            // retried; its error ends the upload when it is awaited here.
+            const next = sentLast ? null : await (prefetchPromise ?? sealNextChunk());
+            prefetchPromise = null;
 
+            if (next !== null) {
                 chunkSendTime = Date.now();
                 chunkStartBytes = fileOffset;       // plaintext bytes before this chunk
-                chunkByteSize = plaintextSize;      // plaintext size of this chunk
-                fileOffset += plaintextSize;
+                chunkByteSize = next.consumed - fileOffset; // plaintext read for this chunk
+                fileOffset = next.consumed;
 
-                const isLastChunk = fileOffset >= file.size;
-                ws.send(encryptedChunk);
+                const isLastChunk = next.isLast;
+                sentLast = isLastChunk;
+                ws.send(next.encrypted);
 
                 // Immediately kick off the next chunk's file read + encryption so it
                 // is ready (or nearly ready) when the server ACK arrives.
                 if (!isLastChunk) {
-                    prefetchForOffset = fileOffset;
-                    prefetchPromise = prefetchNextChunk(fileOffset);
+                    prefetchPromise = sealNextChunk();
+                    // Handled when awaited; don't report it as unhandled meanwhile
+                    prefetchPromise.catch(() => {});
                 }
 
                 // Interpolate progress within this chunk every 100ms using the previous
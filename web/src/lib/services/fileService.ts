@@ -3,6 +3,7 @@ import { getWasmInstance } from '$lib/utils/wasm-loader';
 import type { ProgressCallback } from './fileProcessor';
 import { get } from 'svelte/store';
 import { tr } from '$lib/i18n';
+import { base64ToBytes } from '$lib/utils/base64';
 
 function requireWasmMethod<T>(
 	method: T | undefined,
@@ -15,11 +16,113 @@ function requireWasmMethod<T>(
 	return method as NonNullable<T>;
 }
 
+// pastectl can compress the plaintext before encrypting it and records the
+// algorithm in the metadata. The decrypted chunks are then one compressed
+// stream, which is undone here as they arrive; for other files this passes
+// them through. An algorithm the WASM doesn't know refuses the download
+// rather than saving compressed bytes under the original filename.
+function decompression(metadata: any) {
+	const algo = metadata?.compression;
+	const wasmInstance = getWasmInstance();
+	if (!algo || !wasmInstance) {
+		return { feed: async (data: Uint8Array, _isLast: boolean) => data, dispose: () => {} };
+	}
+
+	const createDecompressStream = requireWasmMethod(
+		wasmInstance.createDecompressStream,
+		'createDecompressStream'
+	);
+	const decompressChunk = requireWasmMethod(wasmInstance.decompressChunk, 'decompressChunk');
+	const id: unknown = createDecompressStream(String(algo));
+	if (typeof id !== 'number') {
+		throw new Error(tr('service.compressedFile', { algo: String(algo) }));
+	}
+	let open = true;
+
+	return {
+		async feed(data: Uint8Array, isLast: boolean): Promise<Uint8Array> {
+			// The last chunk or a failure releases the stream in WASM
+			open = !isLast;
+			try {
+				return await decompressChunk(id, data, isLast);
+			} catch (error) {
+				open = false;
+				throw error;
+			}
+		},
+		// dispose releases the stream of a download that didn't finish
+		dispose() {
+			if (open) {
+				wasmInstance.disposeCodec?.(id);
+				open = false;
+			}
+		}
+	};
+}
+
+// pastectl records the SHA-256 of the plaintext in the metadata. The digest
+// is taken while the chunks are decrypted, and a download that doesn't match
+// it is refused. Without a recorded digest this does nothing.
+function digestCheck(metadata: any) {
+	const expected = typeof metadata?.sha256 === 'string' ? metadata.sha256.toLowerCase() : '';
+	const wasmInstance = getWasmInstance();
+	if (!expected || !wasmInstance) {
+		return { add: (data: Uint8Array) => data, verify: () => {}, release: () => {} };
+	}
+
+	const createHashStream = requireWasmMethod(wasmInstance.createHashStream, 'createHashStream');
+	const updateHash = requireWasmMethod(wasmInstance.updateHash, 'updateHash');
+	const finalizeHash = requireWasmMethod(wasmInstance.finalizeHash, 'finalizeHash');
+	let hashId: number | null = createHashStream();
+
+	return {
+		// add hashes a decrypted chunk and passes it through
+		add(data: Uint8Array): Uint8Array {
+			const result: unknown = updateHash(hashId!, data);
+			if (result instanceof Error) throw result;
+			return data;
+		},
+		verify() {
+			const digest: unknown = finalizeHash(hashId!);
+			hashId = null;
+			if (digest instanceof Error) throw digest;
+			if (digest !== expected) {
+				throw new Error(tr('service.checksumMismatch'));
+			}
+		},
+		// release frees the digest of a download that didn't finish
+		release() {
+			if (hashId !== null) {
+				finalizeHash(hashId);
+				hashId = null;
+			}
+		}
+	};
+}
+
+// openThumbnail decrypts the preview the browser sealed into the metadata
+// of an image upload. It returns null when there is none or it can't be
+// opened; previews are best effort.
+export function openThumbnail(key: string, metadata: any): Blob | null {
+	const decryptThumbnail = getWasmInstance()?.decryptThumbnail;
+	if (!decryptThumbnail || typeof metadata?.thumbnail !== 'string') return null;
+
+	try {
+		const image: unknown = decryptThumbnail(key, base64ToBytes(metadata.thumbnail));
+		if (image instanceof Error) throw image;
+		return new Blob([image as Uint8Array], { type: 'image/jpeg' });
+	} catch (error) {
+		console.warn('Could not open thumbnail:', error);
+		return null;
+	}
+}
+
 export async function downloadAndDecryptFile(
 	fileId: string,
 	key: string,
 	token: string,
-	onProgress: ProgressCallback
+	onProgress: ProgressCallback,
+	signal?: AbortSignal
 ): Promise<{ decrypted: Blob; metadata: any }> {
 	const wasmInstance = getWasmInstance();
 	const config = get(configStore);
@@ -29,7 +132,9 @@ export async function downloadAndDecryptFile(
 		wasmInstance.createDecryptionStream,
 		'createDecryptionStream'
 	);
-	const decryptChunk = requireWasmMethod(wasmInstance.decryptChunk, 'decryptChunk');
+	// Chunks go through Web Crypto where the browser has it
+	const decryptChunk = requireWasmMethod(wasmInstance.decryptChunkAsync, 'decryptChunkAsync');
+	const watchStream = requireWasmMethod(wasmInstance.watchStream, 'watchStream');
 
 	await onProgress(0, tr('service.downloading'));
 
@@ -37,7 +142,8 @@ export async function downloadAndDecryptFile(
 	const headerResponse = await fetch(`/api/metadata/${fileId}`, {
 		headers: {
 			'X-HMAC-Token': token
-		}
+		},
+		signal
 	});
 	if (!headerResponse.ok) {
 		throw new Error('Failed to fetch file metadata');
@@ -45,12 +151,18 @@ export async function downloadAndDecryptFile(
 
 	const headerData = new Uint8Array(await headerResponse.arrayBuffer());
 	const metadata = await decryptMetadata(key, headerData);
+	const inflate = decompression(metadata);
+	const digest = digestCheck(metadata);
+	// Decrypted chunks are decompressed, then hashed
+	const plaintext = async (data: Uint8Array, isLast: boolean) =>
+		digest.add(await inflate.feed(data, isLast));
 
 	// Now start streaming the full file
 	const response = await fetch(`/api/download/${fileId}`, {
 		headers: {
 			'X-HMAC-Token': token
-		}
+		},
+		signal
 	});
 
 	if (!response.ok) {
@@ -64,80 +176,87 @@ export async function downloadAndDecryptFile(
 	const contentLength = +(response.headers.get('Content-Length') || 0);
 	const decryptedChunks: BlobPart[] = [];
 
-	let receivedLength = 0;
-	let headerProcessed = false;
+	let headerLength = 0;
 	let decryptionInitialized = false;
 	let cipherId: number | null = null;
 	let bufferedData = new Uint8Array(0);
 
-	// Process the stream
-	while (true) {
-		const { done, value } = await reader.read();
-		if (done) break;
-
-		receivedLength += value.length;
-
-		// Combine buffered data with new chunk
-		const newBufferedData = new Uint8Array(bufferedData.length + value.length);
-		newBufferedData.set(bufferedData);
-		newBufferedData.set(value, bufferedData.length);
-		bufferedData = newBufferedData;
+	try {
+		// Process the stream
+		while (true) {
+			const { done, value } = await reader.read();
+			if (done) break;
 
-		if (!headerProcessed) {
-			// Need at least 16 bytes to read metadata length
-			if (bufferedData.length < 16) continue;
+			// Combine buffered data with new chunk
+			const newBufferedData = new Uint8Array(bufferedData.length + value.length);
+			newBufferedData.set(bufferedData);
+			newBufferedData.set(value, bufferedData.length);
+			bufferedData = newBufferedData;
 
-			const metadataLength = new DataView(bufferedData.buffer).getUint32(12, true);
-			const headerLength = 16 + metadataLength;
+			if (!headerLength) {
+				// Need at least 16 bytes to read metadata length
+				if (bufferedData.length < 16) continue;
 
-			// Wait until we have the full header
-			if (bufferedData.length < headerLength) continue;
+				const metadataLength = new DataView(bufferedData.buffer).getUint32(12, true);
 
-			// Process header and remove it from buffer
-			headerProcessed = true;
-			bufferedData = bufferedData.slice(headerLength);
-		}
+				// Wait until we have the full header
+				if (bufferedData.length < 16 + metadataLength) continue;
 
-		if (!decryptionInitialized && bufferedData.length >= 12) {
-			// Initialize decryption with IV
-			const iv = bufferedData.slice(0, 12);
-			cipherId = createDecryptionStream(key, iv);
-			if (typeof cipherId !== 'number') {
-				throw new Error('Failed to initialize decryption stream');
+				// Process header and remove it from buffer
+				headerLength = 16 + metadataLength;
+				bufferedData = bufferedData.slice(headerLength);
 			}
 
-			decryptionInitialized = true;
-			bufferedData = bufferedData.slice(12);
-		}
-
-		if (decryptionInitialized && bufferedData.length > 0 && cipherId !== null) {
-			// Use strict `>` so the last chunk always reaches the final-chunk
-			// branch below. The v2 STREAM nonce binds isFinal, so a chunk
-			// encrypted as final must be decrypted as final.
-			const chunkSize = config.chunkSize * 1024 * 1024 + 16; // plaintext + GCM tag
-			while (bufferedData.length > chunkSize) {
-				const chunk = bufferedData.slice(0, chunkSize);
-				const decrypted = decryptChunk(cipherId, chunk, false);
-				if (!decrypted) {
-					throw new Error('Failed to decrypt chunk');
+			if (!decryptionInitialized && bufferedData.length >= 12) {
+				// Initialize decryption with IV
+				const iv = bufferedData.slice(0, 12);
+				// Uploads sealed with per-chunk keys say so in the metadata
+				cipherId = createDecryptionStream(key, iv, metadata.chunkKeys);
+				if (typeof cipherId !== 'number') {
+					throw new Error('Failed to initialize decryption stream');
 				}
 
-				decryptedChunks.push(new Uint8Array(decrypted));
-
-				const progress = Math.round(((decryptedChunks.length * chunkSize) / contentLength) * 100);
-				await onProgress(progress, tr('service.downloading'));
+				// Progress is the share of the chunks decrypted so far
+				const total = contentLength - headerLength - 12;
+				watchStream(cipherId, {
+					total,
+					signal,
+					onProgress: (processed) => {
+						if (total <= 0) return;
+						const progress = Math.min(Math.round((processed / total) * 100), 99);
+						void onProgress(progress, tr('service.downloading'));
+					}
+				});
+
+				decryptionInitialized = true;
+				bufferedData = bufferedData.slice(12);
+			}
 
-				bufferedData = bufferedData.slice(chunkSize);
+			if (decryptionInitialized && bufferedData.length > 0 && cipherId !== null) {
+				// Use strict `>` so the last chunk always reaches the final-chunk
+				// branch below. The v2 STREAM nonce binds isFinal, so a chunk
+				// encrypted as final must be decrypted as final.
+				// Uploads that chose their own chunk size record it in the metadata.
+				const chunkSize = (metadata.chunkSize || config.chunkSize) * 1024 * 1024 + 16; // plaintext + GCM tag
+				while (bufferedData.length > chunkSize) {
+					const chunk = bufferedData.slice(0, chunkSize);
+					decryptedChunks.push(await plaintext(await decryptChunk(cipherId, chunk, false), false));
+					bufferedData = bufferedData.slice(chunkSize);
+				}
 			}
 		}
-	}
 
-	if (bufferedData.length > 0 && cipherId !== null) {
-		const decrypted = decryptChunk(cipherId, bufferedData, true);
-		if (!decrypted) {
-			throw new Error('Failed to decrypt final chunk');
+		if (bufferedData.length > 0 && cipherId !== null) {
+			decryptedChunks.push(await plaintext(await decryptChunk(cipherId, bufferedData, true), true));
 		}
-		decryptedChunks.push(new Uint8Array(decrypted));
+		digest.verify();
+	} finally {
+		// The final chunk disposes the stream; this covers errors and cancels.
+		if (cipherId !== null && wasmInstance.disposeCipher) {
+			wasmInstance.disposeCipher(cipherId);
+		}
+		inflate.dispose();
+		digest.release();
 	}
 
 	// Create a blob from all decrypted chunks
@@ -210,7 +329,8 @@ export async function streamDownloadAndDecrypt(
   fileId: string,
   key: string,
   token: string,
-  onProgress: ProgressCallback
+  onProgress: ProgressCallback,
+  signal?: AbortSignal
 ): Promise<{ stream: ReadableStream<Uint8Array>; metadata: any }> {
   const wasmInstance = getWasmInstance();
   const config = get(configStore);
@@ -220,7 +340,9 @@ export async function streamDownloadAndDecrypt(
     wasmInstance.createDecryptionStream,
     'createDecryptionStream'
   );
-  const decryptChunk = requireWasmMethod(wasmInstance.decryptChunk, 'decryptChunk');
+  // Chunks go through Web Crypto where the browser has it
+  const decryptChunk = requireWasmMethod(wasmInstance.decryptChunkAsync, 'decryptChunkAsync');
+  const watchStream = requireWasmMethod(wasmInstance.watchStream, 'watchStream');
 
   await onProgress(0, tr('service.startingDownload'));
 
@@ -228,22 +350,28 @@ export async function streamDownloadAndDecrypt(
   const headerResponse = await fetch(`/api/metadata/${fileId}`, {
     headers: {
       'X-HMAC-Token': token
-    }
+    },
+    signal
   });
 
   if (!headerResponse.ok) {
     throw new Error('Failed to fetch file metadata');
   }
 
-	
   const headerData = new Uint8Array(await headerResponse.arrayBuffer());
   const metadata = await decryptMetadata(key, headerData);
+  const inflate = decompression(metadata);
+  const digest = digestCheck(metadata);
+  // Decrypted chunks are decompressed, then hashed
+  const plaintext = async (data: Uint8Array, isLast: boolean) =>
+    digest.add(await inflate.feed(data, isLast));
 
-  // Now start streaming the full file
+  // Now start streaming the full file; aborting the signal cancels the body
   const response = await fetch(`/api/download/${fileId}`, {
     headers: {
       'X-HMAC-Token': token
-    }
+    },
+    signal
   });
 
   if (!response.ok) {
@@ -257,12 +385,9 @@ export async function streamDownloadAndDecrypt(
     throw new Error('Response body is null');
   }
 
-  // Parse content length correctly ensuring it's a number
-  const contentLengthHeader = metadata.size || response.headers.get('Content-Length');
-  const contentLength = contentLengthHeader ? parseInt(contentLengthHeader, 10) : 0;
-    
-  let receivedLength = 0;
-  let headerProcessed = false;
+  const contentLength = parseInt(response.headers.get('Content-Length') || '0', 10);
+
+  let headerLength = 0;
   let decryptionInitialized = false;
   let cipherId: number | null = null; // Track cipher ID
   let bufferedData = new Uint8Array(0);
@@ -272,64 +397,73 @@ export async function streamDownloadAndDecrypt(
   let lastProgressValue = 0;
   const PROGRESS_THROTTLE_MS = 100; // Update progress at most every 100ms
 
-  // Function to report progress with throttling
-  const reportProgress = async (bytesReceived: number) => {
+  // Reports the WASM stream's progress: the share of the ciphertext it has
+  // decrypted, with throttling
+  const reportProgress = (processed: number, total: number) => {
     const currentTime = Date.now();
-    // Ensure we have valid numbers for calculation
-    const progressValue = contentLength > 0
-      ? Math.min(Math.round((bytesReceived / contentLength) * 100), 99) // Cap at 99% until complete
+    const progressValue = total > 0
+      ? Math.min(Math.round((processed / total) * 100), 99) // Cap at 99% until complete
       : 0;
 
     // Only update if it's been long enough since the last update
     // or if the progress has changed significantly (at least 1%)
     if (
       currentTime - lastProgressUpdate > PROGRESS_THROTTLE_MS ||
-      Math.abs(progressValue - lastProgressValue) >= 1 ||
-      progressValue === 100
+      Math.abs(progressValue - lastProgressValue) >= 1
     ) {
       lastProgressUpdate = currentTime;
       lastProgressValue = progressValue;
-      
-      await onProgress(progressValue, tr('service.downloading'));
+
+      void onProgress(progressValue, tr('service.downloading'));
+    }
+  };
+
+  const dispose = () => {
+    // decryptChunk(isLast=true) auto-disposes in WASM; this covers errors and cancels.
+    if (cipherId !== null && wasmInstance.disposeCipher) {
+      wasmInstance.disposeCipher(cipherId);
     }
+    inflate.dispose();
+    digest.release();
   };
 
   // Create a transform stream that will process and decrypt the data
   const decryptionStream = new TransformStream<Uint8Array, Uint8Array>({
     transform: async (chunk, controller) => {
-      // Update total received bytes and report progress
-      receivedLength += chunk.length;
-      await reportProgress(receivedLength); // Wait for progress update to complete
-      
       // Combine buffered data with new chunk
       const newBufferedData = new Uint8Array(bufferedData.length + chunk.length);
       newBufferedData.set(bufferedData);
       newBufferedData.set(chunk, bufferedData.length);
       bufferedData = newBufferedData;
 
-      if (!headerProcessed) {
+      if (!headerLength) {
         // Need at least 16 bytes to read metadata length
         if (bufferedData.length < 16) return;
 
         const metadataLength = new DataView(bufferedData.buffer).getUint32(12, true);
-        const headerLength = 16 + metadataLength;
 
         // Wait until we have the full header
-        if (bufferedData.length < headerLength) return;
+        if (bufferedData.length < 16 + metadataLength) return;
 
         // Process header and remove it from buffer
-        headerProcessed = true;
+        headerLength = 16 + metadataLength;
         bufferedData = bufferedData.slice(headerLength);
       }
 
       if (!decryptionInitialized && bufferedData.length >= 12) {
         // Initialize decryption with IV
         const iv = bufferedData.slice(0, 12);
-        cipherId = createDecryptionStream(key, iv);
+        // Uploads sealed with per-chunk keys say so in the metadata
+        cipherId = createDecryptionStream(key, iv, metadata.chunkKeys);
         if (typeof cipherId !== 'number') {
           controller.error(new Error('Failed to initialize decryption stream'));
           return;
         }
+        watchStream(cipherId, {
+          total: contentLength - headerLength - 12,
+          signal,
+          onProgress: reportProgress
+        });
 
         decryptionInitialized = true;
         bufferedData = bufferedData.slice(12);
@@ -339,42 +473,37 @@ export async function streamDownloadAndDecrypt(
         // Use strict `>` so the last chunk always reaches the flush branch.
         // The v2 STREAM nonce binds isFinal; decrypting the final chunk with
         // isFinal=false would fail GCM authentication.
-        const chunkSize =  config.chunkSize*1024 * 1024 + 16; // plaintext + GCM tag
-        while (bufferedData.length > chunkSize) {
-          const dataChunk = bufferedData.slice(0, chunkSize);
-          const decrypted = decryptChunk(cipherId, dataChunk, false);
-          if (!decrypted) {
-            controller.error(new Error('Failed to decrypt chunk'));
-            return;
+        const chunkSize = (metadata.chunkSize || config.chunkSize) * 1024 * 1024 + 16; // plaintext + GCM tag
+        try {
+          while (bufferedData.length > chunkSize) {
+            const dataChunk = bufferedData.slice(0, chunkSize);
+            controller.enqueue(await plaintext(await decryptChunk(cipherId, dataChunk, false), false));
+            bufferedData = bufferedData.slice(chunkSize);
           }
-
-          controller.enqueue(decrypted);
-          bufferedData = bufferedData.slice(chunkSize);
+        } catch (error) {
+          dispose();
+          throw error;
         }
       }
     },
 
     flush: async (controller) => {
-      await onProgress(100, tr('service.downloadComplete'));
-
-      if (bufferedData.length > 0 && decryptionInitialized && cipherId !== null) {
-        const decrypted = decryptChunk(cipherId, bufferedData, true);
-        if (!decrypted) {
-          controller.error(new Error('Failed to decrypt final chunk'));
-          return;
+      try {
+        if (bufferedData.length > 0 && decryptionInitialized && cipherId !== null) {
+          controller.enqueue(await plaintext(await decryptChunk(cipherId, bufferedData, true), true));
         }
-        controller.enqueue(decrypted);
+        // The reader sees the stream error instead of its end on a mismatch
+        digest.verify();
+      } finally {
+        dispose();
       }
 
-      // decryptChunk(isLast=true) auto-disposes in WASM; this is belt-and-suspenders.
-      if (cipherId !== null && wasmInstance.disposeCipher) {
-        wasmInstance.disposeCipher(cipherId);
-      }
+      await onProgress(100, tr('service.downloadComplete'));
     }
   });
 
   // Pipe the response through our decryption transform
-  const decryptedStream = response.body.pipeThrough(decryptionStream);
+  const decryptedStream = response.body.pipeThrough(decryptionStream, { signal });
 
   return { stream: decryptedStream, metadata };
 }
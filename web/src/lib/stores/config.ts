@@ -6,6 +6,15 @@ interface Config {
     key_size: string;
     chunk_size: number;
     passphrase_words: number;
+    min_expiry_seconds?: number;
+    max_expiry_seconds?: number;
+    max_downloads?: number;
+    files_retention_days?: number;
+    expiry_options?: number[];
+    burn_after_download?: boolean;
+    cipher?: string;
+    max_metadata_size?: number;
+    streaming_uploads?: boolean;
 }
 
 interface ConfigStore {
@@ -0,0 +1,21 @@
+// Standard base64 for binary that travels inside JSON, such as the sealed
+// thumbnail in the upload metadata.
+
+export const bytesToBase64 = (bytes: Uint8Array): string => {
+	let binary = '';
+	// String.fromCharCode takes its bytes as arguments; go in slices so a large
+	// array doesn't exceed the engine's argument limit.
+	for (let i = 0; i < bytes.length; i += 0x8000) {
+		binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+	}
+	return btoa(binary);
+};
+
+export const base64ToBytes = (text: string): Uint8Array => {
+	const binary = atob(text);
+	const bytes = new Uint8Array(binary.length);
+	for (let i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes;
+};
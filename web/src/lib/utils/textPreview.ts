@@ -1,6 +1,7 @@
 type FileMetadata = {
 	filename?: string;
 	contentType?: string;
+	lang?: string;
 };
 
 export type RenderedTextPreview = {
@@ -37,6 +38,20 @@ const CODE_EXTENSIONS = new Set([
 	'sql'
 ]);
 
+// Language names `pastectl --lang` may store, mapped to the extension the
+// preview is chosen by. Names that already are extensions need no entry.
+const LANG_EXTENSIONS: Record<string, string> = {
+	javascript: 'js',
+	typescript: 'ts',
+	python: 'py',
+	ruby: 'rb',
+	golang: 'go',
+	rust: 'rs',
+	'c++': 'cpp',
+	shell: 'sh',
+	powershell: 'ps1'
+};
+
 function escapeHtml(value: string): string {
 	return value
 		.replace(/&/g, '&amp;')
@@ -52,7 +67,8 @@ function getFileExtension(filename: string | undefined): string {
 }
 
 function detectPreviewKind(fileMetadata: FileMetadata): string {
-	const ext = getFileExtension(fileMetadata.filename);
+	const lang = fileMetadata.lang?.toLowerCase();
+	const ext = lang ? LANG_EXTENSIONS[lang] || lang : getFileExtension(fileMetadata.filename);
 	const contentType = fileMetadata.contentType?.toLowerCase() || '';
 
 	if (ext === 'csv' || contentType.includes('csv')) return 'csv';
@@ -1,19 +1,67 @@
 import { browser } from '$lib/env';
 
 // Type definitions
+
+// Errors thrown/returned by the WASM module carry a stable code.
+export type WasmErrorCode =
+	| 'ERR_BAD_ARGS'
+	| 'ERR_BAD_KEY'
+	| 'ERR_AUTH_FAIL'
+	| 'ERR_STREAM_STATE'
+	| 'ERR_BAD_FORMAT'
+	| 'ERR_CANCELLED'
+	| 'ERR_INTERNAL';
+
+export interface WasmError extends Error {
+	code: WasmErrorCode;
+	details: Record<string, unknown>;
+}
+
 interface GoEncryption {
 	// Cipher management
 	createEncryptionStream?: (key: string) => { id: number; iv: Uint8Array };
-	createDecryptionStream?: (key: string, iv: Uint8Array) => number;
+	// chunkKeys is the metadata member of that name, if any
+	createDecryptionStream?: (key: string, iv: Uint8Array, chunkKeys?: string) => number;
 	encryptChunk?: (cipherId: number, data: Uint8Array, isLastChunk: boolean) => Uint8Array;
 	decryptChunk?: (cipherId: number, data: Uint8Array, isLastChunk: boolean) => Uint8Array;
+	// Web Crypto–accelerated variants (fall back to Go AES-GCM when unavailable)
+	encryptChunkAsync?: (cipherId: number, data: Uint8Array, isLastChunk: boolean) => Promise<Uint8Array>;
+	decryptChunkAsync?: (cipherId: number, data: Uint8Array, isLastChunk: boolean) => Promise<Uint8Array>;
+	hasWebCrypto?: () => boolean;
+	// Progress reporting and cancellation for long-running streams
+	watchStream?: (
+		cipherId: number,
+		opts: { onProgress?: (processed: number, total: number) => void; total?: number; signal?: AbortSignal }
+	) => boolean;
+	cancelStream?: (cipherId: number) => boolean;
+	// Streaming SHA-256 of plaintext (hex digest)
+	createHashStream?: () => number;
+	updateHash?: (hashId: number, data: Uint8Array) => boolean;
+	finalizeHash?: (hashId: number) => string;
+	// Whole-stream zstd/gzip compression of the plaintext before it is
+	// chunked, and decompression of decrypted chunks, fed in order
+	createCompressStream?: (algo: string) => number;
+	compressChunk?: (codecId: number, data: Uint8Array, isLast: boolean) => Promise<Uint8Array>;
+	createDecompressStream?: (algo: string) => number;
+	decompressChunk?: (codecId: number, data: Uint8Array, isLast: boolean) => Promise<Uint8Array>;
+	disposeCodec?: (codecId: number) => boolean;
 	disposeCipher?: (cipherId: number) => boolean;
 	// Standalone operations
 	encrypt?: (key: string, data: Uint8Array) => Uint8Array;
 	decryptMetadata?: (key: string, data: Uint8Array) => any;
+	encryptMetadata?: (key: string, metadata: Uint8Array | Record<string, unknown>) => Uint8Array;
+	// Encrypted JPEG preview for image uploads, sealed with the file key
+	createThumbnail?: (
+		key: string,
+		image: Uint8Array,
+		opts?: { maxSize?: number; quality?: number }
+	) => { data: Uint8Array; width: number; height: number; contentType: string };
+	decryptThumbnail?: (key: string, data: Uint8Array) => Uint8Array;
 	generateKey?: (keySize: number) => string;
 	generateHmacToken?: (fileId: string, key: string) => string;
 	deriveFromPassphrase?: (passphrase: string, keySizeBits: number) => { fileId: string; key: string } | Error;
+	generatePassphrase?: (numWords?: number) => string | Error;
+	validatePassphrase?: (passphrase: string) => true | Error;
 }
 
 declare global {
@@ -28,7 +76,7 @@ const WASM_CACHE_NAME = 'paste-wasm-cache-v2';
 const WASM_PATH = '/encryption.wasm';
 const WASM_VERSION_KEY = 'wasm-version';
 // Update this when your WASM file changes
-const CURRENT_WASM_VERSION = '2.0.0-v2-format-go1.25.9';
+const CURRENT_WASM_VERSION = '2.4.0-v2-format-go1.26';
 
 let wasmInstance: GoEncryption | null = null;
 let wasmInitPromise: Promise<GoEncryption> | null = null;